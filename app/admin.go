@@ -0,0 +1,63 @@
+package chatter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/putto11262002/chatter/core"
+)
+
+const adminUsage = `usage: chatter admin <command> USERNAME
+
+commands:
+  grant    make the user an administrator
+  revoke   take the administrator role away from the user
+
+Administrators manage the instance through /api/admin and can grant the role to others.
+Use this command to appoint the first one.
+`
+
+// Admin runs the admin subcommand, which grants and revokes the administrator role.
+func Admin(ctx context.Context, config *Config, args []string, out io.Writer) error {
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %s", FormatValidationErrors(err))
+	}
+	if len(args) != 2 || (args[0] != "grant" && args[0] != "revoke") {
+		fmt.Fprint(out, adminUsage)
+		return errors.New("expected grant or revoke and a username")
+	}
+
+	db, migrator, err := openDatabase(config)
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	defer db.Close()
+	if _, _, err := migrator.Up(ctx); err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+
+	admin, username := args[0] == "grant", args[1]
+	if err := core.NewSQLUserStore(db).SetAdmin(ctx, username, admin); err != nil {
+		if errors.Is(err, core.ErrInvalidUser) {
+			return fmt.Errorf("user %q not found", username)
+		}
+		return err
+	}
+	action := core.AuditAdminRevoked
+	if admin {
+		action = core.AuditAdminGranted
+	}
+	if err := core.NewSQLAuditLog(db, config.Audit.Chain).Record(ctx, core.AuditEntry{
+		Action: action, Target: username, Details: map[string]string{"via": "cli"},
+	}); err != nil {
+		return fmt.Errorf("record audit entry: %w", err)
+	}
+	if admin {
+		fmt.Fprintf(out, "%s is now an administrator\n", username)
+	} else {
+		fmt.Fprintf(out, "%s is no longer an administrator\n", username)
+	}
+	return nil
+}
@@ -0,0 +1,452 @@
+package chatter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/putto11262002/chatter/core"
+	"github.com/putto11262002/chatter/pkg/router"
+)
+
+// defaultAdminHistory is how far back lockouts and purges are listed without a since parameter.
+const defaultAdminHistory = 7 * 24 * time.Hour
+
+// AdminHandler serves the API for administrators of the instance.
+// Its routes must be protected by JWTMiddleware and AdminMiddleware.
+type AdminHandler struct {
+	userStore   core.UserStore
+	chatStore   core.ChatStore
+	authStore   core.AuthStore
+	eventRouter *core.EventRouter
+	blobStore   core.BlobStore
+	auditLog    core.AuditLog
+}
+
+func NewAdminHandler(userStore core.UserStore, chatStore core.ChatStore, authStore core.AuthStore,
+	eventRouter *core.EventRouter, blobStore core.BlobStore, auditLog core.AuditLog,
+) *AdminHandler {
+	return &AdminHandler{
+		userStore:   userStore,
+		chatStore:   chatStore,
+		authStore:   authStore,
+		eventRouter: eventRouter,
+		blobStore:   blobStore,
+		auditLog:    auditLog,
+	}
+}
+
+func (h *AdminHandler) GetUsersHandler(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	offset, _ := strconv.Atoi(query.Get("offset"))
+
+	users, err := h.userStore.GetUsers(r.Context(), &core.GetUsersOptions{
+		Query: query.Get("q"), Limit: limit, Offset: offset,
+	})
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(users)
+}
+
+func (h *AdminHandler) GetUserHandler(w http.ResponseWriter, r *http.Request) error {
+	user, err := h.userFromRequest(r)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(user)
+}
+
+// DeactivateUserHandler stops the user from signing in and signs them out everywhere.
+func (h *AdminHandler) DeactivateUserHandler(w http.ResponseWriter, r *http.Request) error {
+	user, err := h.otherUserFromRequest(r)
+	if err != nil {
+		return err
+	}
+	if err := h.userStore.DeactivateUser(r.Context(), user.Username); err != nil {
+		return userError(err)
+	}
+	if err := h.record(r, core.AuditEntry{Action: core.AuditUserDeactivated, Target: user.Username}); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *AdminHandler) ReactivateUserHandler(w http.ResponseWriter, r *http.Request) error {
+	user, err := h.userFromRequest(r)
+	if err != nil {
+		return err
+	}
+	if err := h.userStore.ReactivateUser(r.Context(), user.Username); err != nil {
+		return userError(err)
+	}
+	if err := h.record(r, core.AuditEntry{Action: core.AuditUserReactivated, Target: user.Username}); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type SetPasswordPayload struct {
+	Password string `json:"password" validate:"required,min=8"`
+}
+
+// SetPasswordHandler replaces the password of the user and signs them out everywhere.
+func (h *AdminHandler) SetPasswordHandler(w http.ResponseWriter, r *http.Request) error {
+	user, err := h.userFromRequest(r)
+	if err != nil {
+		return err
+	}
+	var payload SetPasswordPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return fmt.Errorf("Decode: %w", err)
+	}
+	defer r.Body.Close()
+	if err := validate.Struct(payload); err != nil {
+		return router.NewJsonError(http.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.userStore.SetPassword(r.Context(), user.Username, payload.Password); err != nil {
+		return userError(err)
+	}
+	if err := h.record(r, core.AuditEntry{Action: core.AuditPasswordSet, Target: user.Username}); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// SignOutUserHandler revokes every session of the user.
+func (h *AdminHandler) SignOutUserHandler(w http.ResponseWriter, r *http.Request) error {
+	user, err := h.userFromRequest(r)
+	if err != nil {
+		return err
+	}
+	if err := h.authStore.RevokeSessions(r.Context(), user.Username); err != nil {
+		return err
+	}
+	if err := h.record(r, core.AuditEntry{Action: core.AuditSessionsRevoked, Target: user.Username}); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// ResetTOTPHandler turns off two-factor authentication for a user that lost their device.
+func (h *AdminHandler) ResetTOTPHandler(w http.ResponseWriter, r *http.Request) error {
+	user, err := h.userFromRequest(r)
+	if err != nil {
+		return err
+	}
+	if err := h.authStore.ResetTOTP(r.Context(), user.Username); err != nil {
+		return err
+	}
+	if err := h.record(r, core.AuditEntry{Action: core.AuditTOTPReset, Target: user.Username}); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type SetAdminPayload struct {
+	Admin bool `json:"admin"`
+}
+
+// SetAdminHandler grants or revokes the administrator role.
+// Administrators cannot revoke their own role, so that the instance is never left without one by accident.
+func (h *AdminHandler) SetAdminHandler(w http.ResponseWriter, r *http.Request) error {
+	user, err := h.otherUserFromRequest(r)
+	if err != nil {
+		return err
+	}
+	var payload SetAdminPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return fmt.Errorf("Decode: %w", err)
+	}
+	defer r.Body.Close()
+
+	if err := h.userStore.SetAdmin(r.Context(), user.Username, payload.Admin); err != nil {
+		return userError(err)
+	}
+	action := core.AuditAdminRevoked
+	if payload.Admin {
+		action = core.AuditAdminGranted
+	}
+	if err := h.record(r, core.AuditEntry{Action: action, Target: user.Username}); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *AdminHandler) GetRoomsHandler(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	offset, _ := strconv.Atoi(query.Get("offset"))
+
+	rooms, err := h.chatStore.GetRooms(r.Context(), query.Get("q"), offset, limit)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(rooms)
+}
+
+// GetRoomHandler returns any room with its members, whether the administrator is a member or not.
+func (h *AdminHandler) GetRoomHandler(w http.ResponseWriter, r *http.Request) error {
+	room, err := h.chatStore.GetRoomByID(r.Context(), r.PathValue("roomID"))
+	if err != nil {
+		return err
+	}
+	if room == nil {
+		return router.NewJsonError(http.StatusNotFound, "room not found")
+	}
+	return json.NewEncoder(w).Encode(room)
+}
+
+// DeleteRoomHandler deletes any room and its avatar, then tells the former members.
+func (h *AdminHandler) DeleteRoomHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+
+	room, err := h.chatStore.GetRoomByID(r.Context(), roomID)
+	if err != nil {
+		return err
+	}
+	if room == nil {
+		return router.NewJsonError(http.StatusNotFound, "room not found")
+	}
+
+	if err := h.chatStore.ForceDeleteRoom(r.Context(), roomID); err != nil {
+		if errors.Is(err, core.ErrInvalidRoom) {
+			return router.NewJsonError(http.StatusNotFound, "room not found")
+		}
+		return err
+	}
+
+	if room.Avatar != "" {
+		h.blobStore.Delete(r.Context(), room.Avatar)
+	}
+
+	usernames := make([]string, 0, len(room.Members))
+	for _, member := range room.Members {
+		usernames = append(usernames, member.Username)
+	}
+	h.eventRouter.EmitTo(RoomDeletedEvent, RoomEventPayload{RoomID: roomID, Actor: session.Username}, usernames...)
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *AdminHandler) GetRoomRetentionHandler(w http.ResponseWriter, r *http.Request) error {
+	retention, err := h.chatStore.GetRoomRetention(r.Context(), r.PathValue("roomID"))
+	if err != nil {
+		return retentionError(err)
+	}
+	return json.NewEncoder(w).Encode(retention)
+}
+
+// SetRoomRetentionHandler overrides the global retention policy for the room.
+// A null body makes the room use the global policy again.
+func (h *AdminHandler) SetRoomRetentionHandler(w http.ResponseWriter, r *http.Request) error {
+	var policy *core.RetentionPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		return fmt.Errorf("Decode: %w", err)
+	}
+	defer r.Body.Close()
+
+	if err := h.chatStore.SetRoomRetention(r.Context(), r.PathValue("roomID"), policy); err != nil {
+		return retentionError(err)
+	}
+	details := map[string]string{"policy": "global"}
+	if policy != nil {
+		details = map[string]string{"days": strconv.Itoa(policy.Days), "messages": strconv.Itoa(policy.Messages)}
+	}
+	if err := h.record(r, core.AuditEntry{
+		Action: core.AuditRetentionChanged, RoomID: r.PathValue("roomID"), Details: details,
+	}); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *AdminHandler) SetLegalHoldHandler(w http.ResponseWriter, r *http.Request) error {
+	var payload SetLegalHoldPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return fmt.Errorf("Decode: %w", err)
+	}
+	defer r.Body.Close()
+
+	if err := h.chatStore.SetLegalHold(r.Context(), r.PathValue("roomID"), payload.Hold); err != nil {
+		return retentionError(err)
+	}
+	if err := h.record(r, core.AuditEntry{
+		Action: core.AuditLegalHoldChanged, RoomID: r.PathValue("roomID"),
+		Details: map[string]string{"hold": strconv.FormatBool(payload.Hold)},
+	}); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *AdminHandler) GetPurgesHandler(w http.ResponseWriter, r *http.Request) error {
+	since, err := sinceFromRequest(r)
+	if err != nil {
+		return err
+	}
+	purges, err := h.chatStore.GetPurges(r.Context(), since)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(purges)
+}
+
+func (h *AdminHandler) GetLockoutsHandler(w http.ResponseWriter, r *http.Request) error {
+	since, err := sinceFromRequest(r)
+	if err != nil {
+		return err
+	}
+	lockouts, err := h.authStore.GetLockouts(r.Context(), since)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(lockouts)
+}
+
+// UnlockHandler lifts the block on a username or IP address.
+func (h *AdminHandler) UnlockHandler(w http.ResponseWriter, r *http.Request) error {
+	scope := r.PathValue("scope")
+	if scope != core.LockoutScopeUsername && scope != core.LockoutScopeIP {
+		return router.NewJsonError(http.StatusBadRequest, "invalid scope")
+	}
+	subject := r.PathValue("subject")
+	if err := h.authStore.Unlock(r.Context(), scope, subject); err != nil {
+		return err
+	}
+	entry := core.AuditEntry{Action: core.AuditUnlocked, Details: map[string]string{"scope": scope, "subject": subject}}
+	if scope == core.LockoutScopeUsername {
+		entry.Target = subject
+	}
+	if err := h.record(r, entry); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *AdminHandler) GetStatsHandler(w http.ResponseWriter, r *http.Request) error {
+	stats, err := h.chatStore.GetInstanceStats(r.Context())
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(stats)
+}
+
+// GetAuditLogHandler returns the entries of the audit log, most recent first.
+// They are filtered by the actor, action, target, room_id, ip, since and until parameters,
+// where since and until are RFC 3339 times.
+func (h *AdminHandler) GetAuditLogHandler(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	filter := core.AuditFilter{
+		Actor:  query.Get("actor"),
+		Action: core.AuditAction(query.Get("action")),
+		Target: query.Get("target"),
+		RoomID: query.Get("room_id"),
+		IP:     query.Get("ip"),
+		Limit:  limit,
+		Offset: offset,
+	}
+	for _, param := range []struct {
+		name string
+		dest *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		if value := query.Get(param.name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return router.NewJsonError(http.StatusBadRequest, "invalid "+param.name)
+			}
+			*param.dest = t
+		}
+	}
+
+	entries, err := h.auditLog.Query(r.Context(), filter)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(entries)
+}
+
+// VerifyAuditLogHandler checks the hash chain of the audit log.
+func (h *AdminHandler) VerifyAuditLogHandler(w http.ResponseWriter, r *http.Request) error {
+	verification, err := h.auditLog.Verify(r.Context())
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(verification)
+}
+
+// record appends an action taken by the administrator to the audit log.
+func (h *AdminHandler) record(r *http.Request, entry core.AuditEntry) error {
+	if err := h.auditLog.Record(r.Context(), entry); err != nil {
+		return fmt.Errorf("Record: %w", err)
+	}
+	return nil
+}
+
+// userFromRequest returns the account of the user in the path.
+func (h *AdminHandler) userFromRequest(r *http.Request) (*core.UserAccount, error) {
+	user, err := h.userStore.GetUserAccount(r.Context(), r.PathValue("username"))
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, router.NewJsonError(http.StatusNotFound, "user not found")
+	}
+	return user, nil
+}
+
+// otherUserFromRequest is userFromRequest for changes that administrators cannot make to themselves.
+func (h *AdminHandler) otherUserFromRequest(r *http.Request) (*core.UserAccount, error) {
+	if r.PathValue("username") == SessionFromRequest(r).Username {
+		return nil, router.NewJsonError(http.StatusBadRequest, "cannot change your own account")
+	}
+	return h.userFromRequest(r)
+}
+
+func userError(err error) error {
+	if errors.Is(err, core.ErrInvalidUser) {
+		return router.NewJsonError(http.StatusNotFound, "user not found")
+	}
+	return err
+}
+
+func retentionError(err error) error {
+	switch {
+	case errors.Is(err, core.ErrInvalidRoom):
+		return router.NewJsonError(http.StatusNotFound, "room not found")
+	case errors.Is(err, core.ErrInvalidRetention):
+		return router.NewJsonError(http.StatusBadRequest, "invalid retention policy")
+	}
+	return err
+}
+
+// sinceFromRequest parses the since parameter, an RFC 3339 time that defaults to a week ago.
+func sinceFromRequest(r *http.Request) (time.Time, error) {
+	value := r.URL.Query().Get("since")
+	if value == "" {
+		return time.Now().Add(-defaultAdminHistory), nil
+	}
+	since, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, router.NewJsonError(http.StatusBadRequest, "invalid since")
+	}
+	return since, nil
+}
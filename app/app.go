@@ -2,6 +2,7 @@ package chatter
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log/slog"
 	"net"
@@ -20,23 +21,30 @@ import (
 
 type App struct {
 	config      *Config
-	db          *core.SQLiteDB
+	db          *core.DB
 	context     context.Context
 	server      *http.Server
 	logger      *slog.Logger
 	router      *router.Router
 	eventRouter *core.EventRouter
 	wsManager   *core.ConnManager
+	retention   *core.RetentionWorker
+	exporter    *core.Exporter
 
 	exit chan int
 
 	userStore core.UserStore
 	chatStore core.ChatStore
 	authStore core.AuthStore
+	blobStore core.BlobStore
+	auditLog  core.AuditLog
 
-	userHandler *UserHandler
-	chatHandler *ChatHandler
-	authhandler *AuthHandler
+	userHandler   *UserHandler
+	chatHandler   *ChatHandler
+	authhandler   *AuthHandler
+	oidcHandler   *OIDCHandler
+	exportHandler *ExportHandler
+	adminHandler  *AdminHandler
 
 	cleanupFuncs []func(context.Context)
 
@@ -84,25 +92,79 @@ func New(ctx context.Context, config *Config, staticFS *StaticFS) *App {
 		},
 	}))
 
-	sqliteOptions := &core.SQLiteDBOption{
-		Mode:        "rwc",
-		Cache:       "shared",
-		JournalMode: "WAL",
-	}
-	app.db, err = core.NewSQLiteDB(app.config.SQLite.File, app.config.SQLite.Migrations, sqliteOptions)
+	var migrator *core.Migrator
+	app.db, migrator, err = openDatabase(app.config)
 	if err != nil {
 		failed(1, "failed to open database: %v\n", err)
 	}
 	app.AddCleanupFunc(func(ctx context.Context) {
 		app.db.Close()
 	})
-	if err := app.db.Migrate(); err != nil {
+	_, backup, err := migrator.Up(app.context)
+	if err != nil {
 		failed(1, "failed to migrate database: %v\n", err)
 	}
+	if backup != "" {
+		app.logger.Info(fmt.Sprintf("backed up the database to %s before migrating it", backup))
+	}
+
+	app.blobStore, err = core.NewLocalBlobStore(app.config.Blob.Dir, app.config.Blob.MaxSize)
+	if err != nil {
+		failed(1, "failed to open blob store: %v\n", err)
+	}
+
+	auditLog := core.NewSQLAuditLog(app.db, app.config.Audit.Chain)
+	app.auditLog = auditLog
+	app.userStore = core.NewSQLUserStore(app.db)
+	app.chatStore = core.NewSQLChatStore(app.db, app.userStore, core.WithChatAuditLog(auditLog))
+
+	authenticators := []core.Authenticator{}
+	if ldapConfig := app.config.Auth.LDAP; ldapConfig.Enabled {
+		authenticators = append(authenticators, core.NewLDAPAuthenticator(core.LDAPConfig{
+			URL:               ldapConfig.URL,
+			StartTLS:          ldapConfig.StartTLS,
+			TLSConfig:         &tls.Config{InsecureSkipVerify: ldapConfig.InsecureSkipVerify},
+			BindDN:            ldapConfig.BindDN,
+			BindPassword:      ldapConfig.BindPassword,
+			UserBaseDN:        ldapConfig.UserBaseDN,
+			UserFilter:        ldapConfig.UserFilter,
+			NameAttribute:     ldapConfig.NameAttribute,
+			GroupBaseDN:       ldapConfig.GroupBaseDN,
+			GroupFilter:       ldapConfig.GroupFilter,
+			GroupAttribute:    ldapConfig.GroupAttribute,
+			GroupRooms:        ldapConfig.GroupRooms,
+			LinkExistingUsers: ldapConfig.LinkExistingUsers,
+			Logger:            app.logger,
+		}, app.userStore, app.chatStore))
+	}
+	authenticators = append(authenticators, core.NewPasswordAuthenticator(app.userStore))
+	lockoutConfig := app.config.Auth.Lockout
+	userLockout, ipLockout := core.DefaultLockoutPolicy, core.DefaultIPLockoutPolicy
+	userLockout.MaxAttempts, userLockout.LockoutDuration = lockoutConfig.MaxAttempts, lockoutConfig.Duration
+	ipLockout.MaxAttempts, ipLockout.LockoutDuration = lockoutConfig.IPMaxAttempts, lockoutConfig.Duration
+	keyring, err := loadKeyring(app.config)
+	if err != nil {
+		failed(1, "failed to load signing keys: %v\n", err)
+	}
+	secret, err := authSecret(app.config)
+	if err != nil {
+		failed(1, "failed to load secret: %v\n", err)
+	}
+	app.authStore = core.NewSQLAuthStore(app.db, app.userStore, secret,
+		core.WithAuthenticators(authenticators...), core.WithLockoutPolicy(userLockout, ipLockout),
+		core.WithKeyring(keyring), core.WithAuditLog(auditLog))
+
+	retentionConfig := app.config.Retention
+	app.retention = core.NewRetentionWorker(app.chatStore,
+		core.RetentionPolicy{Days: retentionConfig.Days, Messages: retentionConfig.Messages},
+		retentionConfig.Interval, retentionConfig.BatchSize, app.logger)
 
-	app.userStore = core.NewSqlieUserStore(app.db.DB)
-	app.authStore = core.NewSQLiteAuthStore(app.db.DB, app.userStore, []byte(app.config.Auth.Secret))
-	app.chatStore = core.NewSQLiteChatStore(app.db.DB, app.userStore)
+	exportFiles, err := core.NewLocalBlobStore(app.config.Export.Dir, 0)
+	if err != nil {
+		failed(1, "failed to open export store: %v\n", err)
+	}
+	app.exporter = core.NewExporter(app.context, &app.wg, app.chatStore, app.userStore,
+		exportFiles, app.config.Export.TTL, app.logger)
 
 	app.wsManager = core.NewConnManager(app.context, &app.wg, app.logger)
 	app.wsManager.OnUserConnected(app.onUserConnect)
@@ -111,19 +173,49 @@ func New(ctx context.Context, config *Config, staticFS *StaticFS) *App {
 	app.eventRouter = core.NewEventRouter(app.context, app.logger, app.wsManager)
 	app.eventRouter.On(MessageEvent, app.MessageEventHandler)
 	app.eventRouter.On(ReadMessageEvent, app.ReadMessageHandler)
+	app.eventRouter.On(DeleteMessageEvent, app.DeleteMessageEventHandler)
 	app.eventRouter.On(TypingEvent, app.TypingHandler)
 	app.eventRouter.On(IsOnlineEvent, app.IsOnlineHandler)
 
-	app.userHandler = NewUserHandler(app.userStore)
-	app.chatHandler = NewChatHandler(app.chatStore)
+	var notifier core.Notifier
+	switch app.config.Notifier.Type {
+	case "file":
+		notifier = core.NewFileNotifier(app.config.Notifier.File)
+	default:
+		notifier = core.NewLogNotifier(app.logger)
+	}
+	resetter := core.NewPasswordResetter(app.userStore, notifier,
+		app.config.Auth.PasswordReset.TokenExp, app.config.Auth.PasswordReset.URL)
+
+	app.userHandler = NewUserHandler(app.userStore, app.authStore, resetter, app.exporter)
+	app.chatHandler = NewChatHandler(app.chatStore, app.eventRouter, app.blobStore)
 	app.authhandler = NewAuthHandler(app.authStore)
+	app.exportHandler = NewExportHandler(app.exporter)
+	app.adminHandler = NewAdminHandler(app.userStore, app.chatStore, app.authStore, app.eventRouter, app.blobStore,
+		app.auditLog)
+	if oidcConfig := app.config.Auth.OIDC; oidcConfig.Enabled {
+		provider, err := core.NewOIDCProvider(app.context, core.OIDCConfig{
+			Name:              oidcConfig.Name,
+			Issuer:            oidcConfig.Issuer,
+			ClientID:          oidcConfig.ClientID,
+			ClientSecret:      oidcConfig.ClientSecret,
+			RedirectURL:       oidcConfig.RedirectURL,
+			Scopes:            oidcConfig.Scopes,
+			UsernameClaim:     oidcConfig.UsernameClaim,
+			LinkExistingUsers: oidcConfig.LinkExistingUsers,
+		}, app.userStore)
+		if err != nil {
+			failed(1, "failed to set up oidc provider: %v\n", err)
+		}
+		app.oidcHandler = NewOIDCHandler(provider, app.authStore, app.auditLog)
+	}
 	authMiddleware := JWTMiddleware(app.authStore)
 
 	app.router = router.New(router.WithLogger(app.logger))
 
 	app.router.Router.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   app.config.AllowedOrigins,
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
 		AllowCredentials: true,
 	}))
@@ -136,10 +228,21 @@ func New(ctx context.Context, config *Config, staticFS *StaticFS) *App {
 		}
 	})
 
+	app.router.Get("/.well-known/jwks.json", JWKSHandler(keyring))
+
 	api := router.New(router.WithLogger(app.logger))
+	api.Use(ClientIPMiddleware(app.config.Auth.Lockout.TrustForwardedFor))
+	api.Use(UserAgentMiddleware)
 
 	api.Route("/users", func(r *router.Router) {
 		r.With(authMiddleware).Get("/me", app.userHandler.MeHandler)
+		r.With(authMiddleware).Put("/me", app.userHandler.UpdateMeHandler)
+		r.With(authMiddleware).Delete("/me", app.userHandler.DeleteMeHandler)
+		r.With(authMiddleware).Put("/me/password", app.userHandler.ChangePasswordHandler)
+		r.With(authMiddleware).Post("/me/deactivate", app.userHandler.DeactivateMeHandler)
+		r.With(authMiddleware).Post("/me/totp", app.authhandler.EnrollTOTPHandler)
+		r.With(authMiddleware).Post("/me/totp/confirm", app.authhandler.ConfirmTOTPHandler)
+		r.With(authMiddleware).Delete("/me/totp", app.authhandler.DisableTOTPHandler)
 		r.Post("/", app.userHandler.RegisterUserHandler)
 		r.Get("/{username}", app.userHandler.GetUserByUsernameHandler)
 	})
@@ -147,16 +250,82 @@ func New(ctx context.Context, config *Config, staticFS *StaticFS) *App {
 	api.Group(func(r *router.Router) {
 		r.Use(authMiddleware)
 		r.Get("/users/me/rooms", app.chatHandler.GetMyRoomsHandler)
+		r.Get("/users/me/bookmarks", app.chatHandler.GetMyBookmarksHandler)
+		r.Put("/users/me/bookmarks/{messageID}", app.chatHandler.BookmarkMessageHandler)
+		r.Delete("/users/me/bookmarks/{messageID}", app.chatHandler.RemoveBookmarkHandler)
 		r.Get("/rooms/{roomID}", app.chatHandler.GetRoomByIDHandler)
+		r.Put("/rooms/{roomID}", app.chatHandler.UpdateRoomHandler)
+		r.Delete("/rooms/{roomID}", app.chatHandler.DeleteRoomHandler)
+		r.Post("/rooms/{roomID}/archive", app.chatHandler.ArchiveRoomHandler)
+		r.Delete("/rooms/{roomID}/archive", app.chatHandler.UnarchiveRoomHandler)
+		r.Get("/rooms/{roomID}/avatar", app.chatHandler.GetRoomAvatarHandler)
+		r.Put("/rooms/{roomID}/avatar", app.chatHandler.UploadRoomAvatarHandler)
+		r.Delete("/rooms/{roomID}/avatar", app.chatHandler.DeleteRoomAvatarHandler)
 		r.Post("/rooms", app.chatHandler.CreateRoomHandler)
+		r.Get("/rooms/directory", app.chatHandler.GetRoomDirectoryHandler)
+		r.Post("/rooms/{roomID}/join", app.chatHandler.JoinRoomHandler)
 		r.Get("/rooms/{roomID}/messages", app.chatHandler.GetRoomMessagesHandler)
+		r.Delete("/rooms/{roomID}/messages/{messageID}", app.chatHandler.DeleteMessageHandler)
+		r.Get("/rooms/{roomID}/pins", app.chatHandler.GetRoomPinsHandler)
+		r.Post("/rooms/{roomID}/export", app.exportHandler.CreateExportHandler)
+		r.Get("/rooms/{roomID}/export/{jobID}", app.exportHandler.GetExportHandler)
+		r.Get("/rooms/{roomID}/export/{jobID}/download", app.exportHandler.DownloadExportHandler)
+		r.Put("/rooms/{roomID}/pins/{messageID}", app.chatHandler.PinMessageHandler)
+		r.Delete("/rooms/{roomID}/pins/{messageID}", app.chatHandler.UnpinMessageHandler)
 		r.Post("/rooms/{roomID}/members", app.chatHandler.AddRoomMemberHandler)
 		r.Delete("/rooms/{roomID}/members/{userID}", app.chatHandler.RemoveRoomMemberHandler)
+		r.Put("/rooms/{roomID}/members/{userID}/role", app.chatHandler.UpdateMemberRoleHandler)
+		r.Post("/rooms/{roomID}/transfer", app.chatHandler.TransferOwnershipHandler)
+		r.Post("/rooms/{roomID}/leave", app.chatHandler.LeaveRoomHandler)
+		r.Get("/rooms/{roomID}/me", app.chatHandler.GetRoomPreferencesHandler)
+		r.Patch("/rooms/{roomID}/me", app.chatHandler.UpdateRoomPreferencesHandler)
+		r.Get("/rooms/{roomID}/permissions", app.chatHandler.GetRoomPermissionsHandler)
+		r.Put("/rooms/{roomID}/permissions", app.chatHandler.SetRoomPermissionHandler)
+		r.Get("/rooms/{roomID}/retention", app.chatHandler.GetRoomRetentionHandler)
+		r.Put("/rooms/{roomID}/retention", app.chatHandler.SetRoomRetentionHandler)
+		r.Put("/rooms/{roomID}/legal-hold", app.chatHandler.SetLegalHoldHandler)
+		r.Post("/rooms/{roomID}/invites", app.chatHandler.CreateInviteHandler)
+		r.Get("/rooms/{roomID}/invites", app.chatHandler.GetRoomInvitesHandler)
+		r.Delete("/rooms/{roomID}/invites/{code}", app.chatHandler.RevokeInviteHandler)
+		r.Get("/rooms/{roomID}/invites/{code}/uses", app.chatHandler.GetInviteUsesHandler)
+		r.Post("/invites/{code}/accept", app.chatHandler.AcceptInviteHandler)
+	})
+
+	api.Route("/admin", func(r *router.Router) {
+		r.Use(authMiddleware)
+		r.Use(AdminMiddleware(app.userStore))
+		r.Get("/stats", app.adminHandler.GetStatsHandler)
+		r.Get("/users", app.adminHandler.GetUsersHandler)
+		r.Get("/users/{username}", app.adminHandler.GetUserHandler)
+		r.Post("/users/{username}/deactivate", app.adminHandler.DeactivateUserHandler)
+		r.Post("/users/{username}/reactivate", app.adminHandler.ReactivateUserHandler)
+		r.Put("/users/{username}/password", app.adminHandler.SetPasswordHandler)
+		r.Post("/users/{username}/signout", app.adminHandler.SignOutUserHandler)
+		r.Delete("/users/{username}/totp", app.adminHandler.ResetTOTPHandler)
+		r.Put("/users/{username}/admin", app.adminHandler.SetAdminHandler)
+		r.Get("/rooms", app.adminHandler.GetRoomsHandler)
+		r.Get("/rooms/{roomID}", app.adminHandler.GetRoomHandler)
+		r.Delete("/rooms/{roomID}", app.adminHandler.DeleteRoomHandler)
+		r.Get("/rooms/{roomID}/retention", app.adminHandler.GetRoomRetentionHandler)
+		r.Put("/rooms/{roomID}/retention", app.adminHandler.SetRoomRetentionHandler)
+		r.Put("/rooms/{roomID}/legal-hold", app.adminHandler.SetLegalHoldHandler)
+		r.Get("/purges", app.adminHandler.GetPurgesHandler)
+		r.Get("/lockouts", app.adminHandler.GetLockoutsHandler)
+		r.Delete("/lockouts/{scope}/{subject}", app.adminHandler.UnlockHandler)
+		r.Get("/audit", app.adminHandler.GetAuditLogHandler)
+		r.Get("/audit/verify", app.adminHandler.VerifyAuditLogHandler)
 	})
 
 	api.Route("/auth", func(r *router.Router) {
 		r.Post("/signin", app.authhandler.SigninHandler)
+		r.Post("/signin/totp", app.authhandler.SigninTOTPHandler)
 		r.Post("/signout", app.authhandler.SignoutHandler)
+		r.Post("/password-reset", app.userHandler.RequestPasswordResetHandler)
+		r.Post("/password-reset/confirm", app.userHandler.ResetPasswordHandler)
+		if app.oidcHandler != nil {
+			r.Get("/oidc/login", app.oidcHandler.LoginHandler)
+			r.Get("/oidc/callback", app.oidcHandler.CallbackHandler)
+		}
 	})
 
 	app.router.Mount("/api", api)
@@ -184,6 +353,15 @@ func (app *App) Start() {
 	app.AddCleanupFunc(func(ctx context.Context) {
 		app.eventRouter.Close(ctx)
 	})
+	app.scheduleBackups()
+	app.scheduleAuditPurge()
+	app.retention.Start(app.context)
+	if err := app.exporter.Start(); err != nil {
+		app.logger.Error(fmt.Sprintf("resume export jobs: %v", err))
+	}
+	app.AddCleanupFunc(func(ctx context.Context) {
+		app.retention.Stop(ctx)
+	})
 
 	// listen for shutdown signal
 	go func() {
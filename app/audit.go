@@ -0,0 +1,38 @@
+package chatter
+
+import (
+	"fmt"
+	"time"
+)
+
+// auditPurgeInterval is how often entries older than the audit retention are purged.
+const auditPurgeInterval = time.Hour
+
+// scheduleAuditPurge purges expired audit log entries every auditPurgeInterval until the app is shut down.
+func (app *App) scheduleAuditPurge() {
+	retention := app.config.Audit.Retention
+	if retention <= 0 {
+		return
+	}
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		ticker := time.NewTicker(auditPurgeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-app.context.Done():
+				return
+			case <-ticker.C:
+				n, err := app.auditLog.Purge(app.context, time.Now().Add(-retention))
+				if err != nil {
+					app.logger.Error(fmt.Sprintf("purge audit log: %v", err))
+					continue
+				}
+				if n > 0 {
+					app.logger.Info(fmt.Sprintf("purged %d audit log entries", n))
+				}
+			}
+		}
+	}()
+}
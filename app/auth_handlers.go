@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/putto11262002/chatter/core"
@@ -37,18 +39,65 @@ func (h *AuthHandler) SigninHandler(w http.ResponseWriter, r *http.Request) erro
 		if errors.Is(err, core.ErrBadCredentials) {
 			return router.NewJsonError(http.StatusUnauthorized, err.Error())
 		}
+		if errors.Is(err, core.ErrDeactivatedUser) {
+			return router.NewJsonError(http.StatusForbidden, err.Error())
+		}
+		if errors.Is(err, core.ErrTooManyAttempts) {
+			return tooManyAttempts(w, err)
+		}
+		var challenge *core.TOTPChallenge
+		if errors.As(err, &challenge) {
+			w.WriteHeader(http.StatusAccepted)
+			return json.NewEncoder(w).Encode(SigninChallengeResponse{TOTPRequired: true, TOTPChallenge: challenge})
+		}
 		return err
 	}
 
-	cookie := http.Cookie{
-		Name:     AuthCookieName,
-		Value:    session.Token,
-		Expires:  session.ExpiresAt,
-		HttpOnly: true,
-		Path:     "/",
+	setSessionCookie(w, session)
+
+	if err := json.NewEncoder(w).Encode(session); err != nil {
+		return fmt.Errorf("Encode: %w", err)
 	}
+	return nil
+}
+
+// SigninChallengeResponse is returned with 202 Accepted when the password is correct
+// but a TOTP code is still needed to complete sign in.
+type SigninChallengeResponse struct {
+	TOTPRequired bool `json:"totp_required"`
+	*core.TOTPChallenge
+}
 
-	http.SetCookie(w, &cookie)
+type SigninTOTPPayload struct {
+	Challenge string `json:"challenge" validate:"required"`
+	Code      string `json:"code" validate:"required"`
+}
+
+// SigninTOTPHandler completes a sign in that was answered with a challenge.
+// The code can be a TOTP code or one of the recovery codes.
+func (h *AuthHandler) SigninTOTPHandler(w http.ResponseWriter, r *http.Request) error {
+	var payload SigninTOTPPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return fmt.Errorf("Decode: %w", err)
+	}
+	defer r.Body.Close()
+
+	if err := validate.Struct(payload); err != nil {
+		return router.NewJsonError(http.StatusBadRequest, "invalid input")
+	}
+
+	session, err := h.store.VerifyTOTPChallenge(r.Context(), payload.Challenge, payload.Code)
+	if err != nil {
+		if errors.Is(err, core.ErrUnauthenticated) || errors.Is(err, core.ErrInvalidTOTPCode) {
+			return router.NewJsonError(http.StatusUnauthorized, err.Error())
+		}
+		if errors.Is(err, core.ErrTooManyAttempts) {
+			return tooManyAttempts(w, err)
+		}
+		return err
+	}
+
+	setSessionCookie(w, session)
 
 	if err := json.NewEncoder(w).Encode(session); err != nil {
 		return fmt.Errorf("Encode: %w", err)
@@ -56,11 +105,106 @@ func (h *AuthHandler) SigninHandler(w http.ResponseWriter, r *http.Request) erro
 	return nil
 }
 
+type TOTPCodePayload struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// EnrollTOTPHandler starts two-factor enrollment for the signed in user.
+// The secret and recovery codes are only ever shown in this response.
+func (h *AuthHandler) EnrollTOTPHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	enrollment, err := h.store.EnrollTOTP(r.Context(), session.Username)
+	if err != nil {
+		if errors.Is(err, core.ErrTOTPEnabled) {
+			return router.NewJsonError(http.StatusConflict, err.Error())
+		}
+		return err
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	return json.NewEncoder(w).Encode(enrollment)
+}
+
+func (h *AuthHandler) ConfirmTOTPHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	var payload TOTPCodePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return fmt.Errorf("Decode: %w", err)
+	}
+	defer r.Body.Close()
+
+	if err := validate.Struct(payload); err != nil {
+		return router.NewJsonError(http.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.store.ConfirmTOTP(r.Context(), session.Username, payload.Code); err != nil {
+		switch {
+		case errors.Is(err, core.ErrInvalidTOTPCode), errors.Is(err, core.ErrTOTPNotEnrolled):
+			return router.NewJsonError(http.StatusBadRequest, err.Error())
+		case errors.Is(err, core.ErrTOTPEnabled):
+			return router.NewJsonError(http.StatusConflict, err.Error())
+		}
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *AuthHandler) DisableTOTPHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	var payload TOTPCodePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return fmt.Errorf("Decode: %w", err)
+	}
+	defer r.Body.Close()
+
+	if err := validate.Struct(payload); err != nil {
+		return router.NewJsonError(http.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.store.DisableTOTP(r.Context(), session.Username, payload.Code); err != nil {
+		if errors.Is(err, core.ErrInvalidTOTPCode) || errors.Is(err, core.ErrTOTPNotEnrolled) {
+			return router.NewJsonError(http.StatusBadRequest, err.Error())
+		}
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
 func (h *AuthHandler) SignoutHandler(w http.ResponseWriter, r *http.Request) error {
 	session := SessionFromRequest(r)
 	if err := h.store.DestroySession(r.Context(), session); err != nil {
 		return err
 	}
+	clearSessionCookie(w)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// tooManyAttempts answers a blocked sign in with 429 and the number of seconds to wait in Retry-After.
+func tooManyAttempts(w http.ResponseWriter, err error) error {
+	var locked *core.LockedError
+	if errors.As(err, &locked) {
+		retryAfter := int(math.Ceil(time.Until(locked.RetryAfter).Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+	}
+	return router.NewJsonError(http.StatusTooManyRequests, core.ErrTooManyAttempts.Error())
+}
+
+func setSessionCookie(w http.ResponseWriter, session *core.Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     AuthCookieName,
+		Value:    session.Token,
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Path:     "/",
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     AuthCookieName,
 		Value:    "",
@@ -68,6 +212,4 @@ func (h *AuthHandler) SignoutHandler(w http.ResponseWriter, r *http.Request) err
 		HttpOnly: true,
 		Path:     "/",
 	})
-	w.WriteHeader(http.StatusOK)
-	return nil
 }
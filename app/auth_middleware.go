@@ -3,7 +3,9 @@ package chatter
 import (
 	"context"
 	"errors"
+	"net"
 	"net/http"
+	"strings"
 
 	"github.com/putto11262002/chatter/core"
 	"github.com/putto11262002/chatter/pkg/router"
@@ -70,6 +72,7 @@ func JWTMiddleware(a core.AuthStore) router.Middleware {
 			}
 
 			newCtx := contextWithSession(ctx, *session)
+			newCtx = core.ContextWithActor(newCtx, session.Username)
 
 			next.ServeHTTP(w, r.WithContext(newCtx))
 
@@ -78,3 +81,50 @@ func JWTMiddleware(a core.AuthStore) router.Middleware {
 		}))
 	}
 }
+
+// AdminMiddleware only lets administrators through. It must come after JWTMiddleware.
+// The role is looked up on every request, so revoking it or deactivating the user takes effect right away.
+func AdminMiddleware(userStore core.UserStore) router.Middleware {
+	return router.Authorize(func(r *http.Request) (bool, error) {
+		user, err := userStore.GetUserAccount(r.Context(), SessionFromRequest(r).Username)
+		if err != nil {
+			return false, err
+		}
+		return user != nil && user.Admin && user.DeactivatedAt == nil, nil
+	})
+}
+
+// ClientIPMiddleware attaches the IP address of the client to the request context with core.ContextWithClientIP.
+// If trustForwardedFor is true, the first address in X-Forwarded-For is used instead of the peer address.
+// Only enable it behind a reverse proxy that sets the header, otherwise clients can spoof their address.
+func ClientIPMiddleware(trustForwardedFor bool) router.Middleware {
+	return func(next http.Handler) router.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) error {
+			next.ServeHTTP(w, r.WithContext(core.ContextWithClientIP(r.Context(), clientIP(r, trustForwardedFor))))
+			return nil
+		}
+	}
+}
+
+// UserAgentMiddleware attaches the User-Agent header to the request context with core.ContextWithUserAgent,
+// so that it is recorded in the audit log.
+func UserAgentMiddleware(next http.Handler) router.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		next.ServeHTTP(w, r.WithContext(core.ContextWithUserAgent(r.Context(), r.UserAgent())))
+		return nil
+	}
+}
+
+func clientIP(r *http.Request, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,140 @@
+package chatter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/putto11262002/chatter/core"
+	"github.com/putto11262002/chatter/migrations"
+)
+
+const (
+	backupUsage  = "usage: chatter backup [FILE]\n\nWithout FILE, the backup is written to backup.dir and old backups are pruned.\n"
+	restoreUsage = "usage: chatter restore FILE\n\nStop the server first. The database is backed up next to its file before it is replaced.\n"
+)
+
+var errPostgresBackup = errors.New("PostgreSQL databases are backed up with pg_dump and restored with pg_restore")
+
+// Backup runs the backup subcommand, which backs up the SQLite database while it is in use.
+func Backup(ctx context.Context, config *Config, args []string, out io.Writer) error {
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %s", FormatValidationErrors(err))
+	}
+	if config.Database == "postgres" {
+		return errPostgresBackup
+	}
+	if len(args) > 1 {
+		fmt.Fprint(out, backupUsage)
+		return errors.New("too many arguments")
+	}
+	// opening a missing database would create an empty one
+	if _, err := os.Stat(config.SQLite.File); err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	db, _, err := openDatabase(config)
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	defer db.Close()
+
+	var dest string
+	if len(args) == 1 {
+		dest = args[0]
+		err = core.BackupSQLite(ctx, db.DB, dest)
+	} else {
+		dest, err = backupToDir(ctx, db, config.Backup.Dir, config.Backup.Keep)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "backed up the database to %s\n", dest)
+	return nil
+}
+
+// Restore runs the restore subcommand, which replaces the SQLite database with a backup.
+func Restore(ctx context.Context, config *Config, args []string, out io.Writer) error {
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %s", FormatValidationErrors(err))
+	}
+	if config.Database == "postgres" {
+		return errPostgresBackup
+	}
+	if len(args) != 1 {
+		fmt.Fprint(out, restoreUsage)
+		return errors.New("restore takes the backup file")
+	}
+	previous, err := core.RestoreSQLite(ctx, config.SQLite.File, args[0],
+		migrationsFS(config.SQLite.Migrations, migrations.SQLite))
+	if previous != "" {
+		fmt.Fprintf(out, "backed up the database to %s\n", previous)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "restored the database from %s\n", args[0])
+	return nil
+}
+
+// backupToDir backs up the database to a new file in dir and deletes all but the keep newest backups in it.
+func backupToDir(ctx context.Context, db *core.DB, dir string, keep int) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	dest := filepath.Join(dir, fmt.Sprintf("chatter-%s.db", time.Now().UTC().Format("20060102T150405")))
+	if err := core.BackupSQLite(ctx, db.DB, dest); err != nil {
+		return "", err
+	}
+	if keep == 0 {
+		return dest, nil
+	}
+	// the timestamps in the names sort in the order the backups were taken
+	backups, err := filepath.Glob(filepath.Join(dir, "chatter-*.db"))
+	if err != nil {
+		return dest, err
+	}
+	slices.Sort(backups)
+	for len(backups) > keep {
+		if err := os.Remove(backups[0]); err != nil {
+			return dest, err
+		}
+		backups = backups[1:]
+	}
+	return dest, nil
+}
+
+// scheduleBackups backs up the database every interval until the app is shut down.
+func (app *App) scheduleBackups() {
+	interval := app.config.Backup.Interval
+	if interval <= 0 {
+		return
+	}
+	if app.config.Database == "postgres" {
+		app.logger.Warn("scheduled backups are only taken of SQLite databases")
+		return
+	}
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-app.context.Done():
+				return
+			case <-ticker.C:
+				dest, err := backupToDir(app.context, app.db, app.config.Backup.Dir, app.config.Backup.Keep)
+				if err != nil {
+					app.logger.Error(fmt.Sprintf("back up database: %v", err))
+					continue
+				}
+				app.logger.Info(fmt.Sprintf("backed up database to %s", dest))
+			}
+		}
+	}()
+}
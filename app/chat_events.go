@@ -16,6 +16,20 @@ const (
 	OfflineEvent     = "offline"
 	IsOnlineEvent    = "is_online"
 	TypingEvent      = "typing"
+	// DeleteMessageEvent asks to delete a message, MessageDeletedEvent tells the members of the room
+	// that it is gone.
+	DeleteMessageEvent  = "delete_message"
+	MessageDeletedEvent = "message_deleted"
+
+	MemberAddedEvent       = "member_added"
+	MemberRemovedEvent     = "member_removed"
+	MemberRoleChangedEvent = "member_role_changed"
+	RoomUpdatedEvent       = "room_updated"
+	RoomArchivedEvent      = "room_archived"
+	RoomUnarchivedEvent    = "room_unarchived"
+	RoomDeletedEvent       = "room_deleted"
+	MessagePinnedEvent     = "message_pinned"
+	MessageUnpinnedEvent   = "message_unpinned"
 )
 
 type MessageEventPayload struct {
@@ -25,6 +39,14 @@ type MessageEventPayload struct {
 	Data   string    `json:"data"`
 	Sender string    `json:"sender"`
 	SentAt time.Time `json:"sent_at"`
+	// Notify tells the recipient whether to alert the user, according to their room preferences.
+	Notify bool `json:"notify"`
+}
+
+type DeleteMessageEventPayload struct {
+	ID        int    `json:"id"`
+	RoomID    string `json:"room_id"`
+	DeletedBy string `json:"deleted_by"`
 }
 
 type ReadMessageEventPayload struct {
@@ -40,6 +62,39 @@ type TypingEventPayload struct {
 	RoomID   string `json:"room_id"`
 }
 
+// MemberEventPayload describes a membership change of a room.
+// Actor is the user who made the change; it is the member themselves when they leave.
+type MemberEventPayload struct {
+	RoomID   string          `json:"room_id"`
+	Username string          `json:"username"`
+	Role     core.MemberRole `json:"role,omitempty"`
+	Actor    string          `json:"actor"`
+}
+
+// RoomUpdatedEventPayload carries the metadata of a room after it was changed by Actor.
+type RoomUpdatedEventPayload struct {
+	RoomID      string              `json:"room_id"`
+	Name        string              `json:"name"`
+	Topic       string              `json:"topic"`
+	Description string              `json:"description"`
+	Avatar      string              `json:"avatar"`
+	Visibility  core.RoomVisibility `json:"visibility"`
+	Actor       string              `json:"actor"`
+}
+
+// RoomEventPayload tells the members that Actor archived, restored or deleted the room.
+type RoomEventPayload struct {
+	RoomID string `json:"room_id"`
+	Actor  string `json:"actor"`
+}
+
+// PinEventPayload tells the members that Actor pinned or unpinned a message of the room.
+type PinEventPayload struct {
+	RoomID    string `json:"room_id"`
+	MessageID int    `json:"message_id"`
+	Actor     string `json:"actor"`
+}
+
 type OnlineEventPayload struct {
 	Username string `json:"username"`
 }
@@ -74,7 +129,47 @@ func (app *App) MessageEventHandler(ctx context.Context, e *core.Event) error {
 	msg.SentAt = createdMsg.SentAt
 	msg.ID = createdMsg.ID
 
-	members, err := app.chatStore.GetRoomMembers(ctx, msg.RoomID)
+	preferences, err := app.chatStore.GetRoomMemberPreferences(ctx, msg.RoomID)
+	if err != nil {
+		return fmt.Errorf("GetRoomMemberPreferences: %w", err)
+	}
+
+	// every member receives the message, but only those whose preferences allow it are alerted
+	var notified, silent []string
+	for username, p := range preferences {
+		if p.ShouldNotify(username, *createdMsg, createdMsg.SentAt) {
+			notified = append(notified, username)
+		} else {
+			silent = append(silent, username)
+		}
+	}
+
+	if len(silent) > 0 {
+		if err := app.eventRouter.EmitTo(MessageEvent, msg, silent...); err != nil {
+			return err
+		}
+	}
+	if len(notified) > 0 {
+		msg.Notify = true
+		if err := app.eventRouter.EmitTo(MessageEvent, msg, notified...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (app *App) DeleteMessageEventHandler(ctx context.Context, e *core.Event) error {
+	var deleted DeleteMessageEventPayload
+	if err := json.Unmarshal(e.Payload, &deleted); err != nil {
+		return fmt.Errorf("Unmarshal: %w", err)
+	}
+
+	if err := app.chatStore.DeleteMessage(ctx, deleted.RoomID, deleted.ID, e.Dispatcher); err != nil {
+		return fmt.Errorf("DeleteMessage: %w", err)
+	}
+	deleted.DeletedBy = e.Dispatcher
+
+	members, err := app.chatStore.GetRoomMembers(ctx, deleted.RoomID)
 	if err != nil {
 		return fmt.Errorf("GetRoomMembers: %w", err)
 	}
@@ -84,10 +179,7 @@ func (app *App) MessageEventHandler(ctx context.Context, e *core.Event) error {
 		usernames = append(usernames, member.Username)
 	}
 
-	if err := app.eventRouter.EmitTo(MessageEvent, msg, usernames...); err != nil {
-		return err
-	}
-	return nil
+	return app.eventRouter.EmitTo(MessageDeletedEvent, deleted, usernames...)
 }
 
 func (app *App) ReadMessageHandler(ctx context.Context, e *core.Event) error {
@@ -1,7 +1,9 @@
 package chatter
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -10,11 +12,13 @@ import (
 )
 
 type ChatHandler struct {
-	chatStore core.ChatStore
+	chatStore   core.ChatStore
+	eventRouter *core.EventRouter
+	blobStore   core.BlobStore
 }
 
-func NewChatHandler(chatStore core.ChatStore) *ChatHandler {
-	return &ChatHandler{chatStore: chatStore}
+func NewChatHandler(chatStore core.ChatStore, eventRouter *core.EventRouter, blobStore core.BlobStore) *ChatHandler {
+	return &ChatHandler{chatStore: chatStore, eventRouter: eventRouter, blobStore: blobStore}
 }
 
 type CreateRoomPayload struct {
@@ -58,17 +62,9 @@ type AddRoomMemberPayload struct {
 
 func (h *ChatHandler) AddRoomMemberHandler(w http.ResponseWriter, r *http.Request) error {
 	session := SessionFromRequest(r)
-	inRoom, role, err := h.chatStore.IsRoomMember(r.Context(), r.PathValue("roomID"), session.Username)
-	if err != nil {
-		return err
-	}
-	if !inRoom {
-		return router.NewJsonError(http.StatusForbidden, core.ErrInvalidRoom.Error())
-	}
-
-	if !(role == core.Admin || role == core.Owner) {
-		return router.NewJsonError(http.StatusForbidden, core.ErrDisAllowedOperation.Error())
-
+	roomID := r.PathValue("roomID")
+	if _, err := h.chatStore.Authorize(r.Context(), roomID, session.Username, core.InviteMembers); err != nil {
+		return authorizationError(err)
 	}
 
 	var payload AddRoomMemberPayload
@@ -81,7 +77,11 @@ func (h *ChatHandler) AddRoomMemberHandler(w http.ResponseWriter, r *http.Reques
 		return router.NewJsonError(http.StatusBadRequest, "invalid input")
 	}
 
-	if err := h.chatStore.AddRoomMember(r.Context(), r.PathValue("roomID"), payload.Username, payload.Role); err != nil {
+	if err := h.chatStore.AuthorizeRoleAssignment(r.Context(), roomID, session.Username, payload.Role); err != nil {
+		return authorizationError(err)
+	}
+
+	if err := h.chatStore.AddRoomMember(r.Context(), roomID, payload.Username, payload.Role); err != nil {
 		if err == core.ErrInvalidRoom || err == core.ErrInvalidUser {
 			return router.NewJsonError(http.StatusBadRequest, err.Error())
 		}
@@ -91,6 +91,9 @@ func (h *ChatHandler) AddRoomMemberHandler(w http.ResponseWriter, r *http.Reques
 		return err
 	}
 
+	h.emitMemberEvent(r.Context(), MemberAddedEvent, MemberEventPayload{
+		RoomID: roomID, Username: payload.Username, Role: payload.Role, Actor: session.Username})
+
 	w.WriteHeader(http.StatusNoContent)
 	return nil
 }
@@ -99,7 +102,151 @@ func (h *ChatHandler) RemoveRoomMemberHandler(w http.ResponseWriter, r *http.Req
 	session := SessionFromRequest(r)
 	roomID := r.PathValue("roomID")
 	userID := r.PathValue("userID")
-	inRoom, role, err := h.chatStore.IsRoomMember(r.Context(), roomID, session.Username)
+	if _, err := h.chatStore.AuthorizeMember(r.Context(), roomID, session.Username, userID, core.RemoveMembers); err != nil {
+		return authorizationError(err)
+	}
+
+	if err := h.chatStore.RemoveRoomMember(r.Context(), roomID, userID); err != nil {
+		if err == core.ErrInvalidRoom || err == core.ErrInvalidMember {
+			return router.NewJsonError(http.StatusBadRequest, err.Error())
+		}
+		return err
+	}
+
+	// the removed user is no longer a member, so they are notified explicitly
+	h.emitMemberEvent(r.Context(), MemberRemovedEvent, MemberEventPayload{
+		RoomID: roomID, Username: userID, Actor: session.Username}, userID)
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type UpdateMemberRolePayload struct {
+	Role core.MemberRole `json:"role" validate:"required"`
+}
+
+func (h *ChatHandler) UpdateMemberRoleHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+	userID := r.PathValue("userID")
+
+	var payload UpdateMemberRolePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	if err := validate.Struct(payload); err != nil {
+		return router.NewJsonError(http.StatusBadRequest, "invalid input")
+	}
+
+	if _, err := h.chatStore.AuthorizeMember(r.Context(), roomID, session.Username, userID, core.ChangeRoles); err != nil {
+		return authorizationError(err)
+	}
+	if err := h.chatStore.AuthorizeRoleAssignment(r.Context(), roomID, session.Username, payload.Role); err != nil {
+		return authorizationError(err)
+	}
+
+	if err := h.chatStore.UpdateMemberRole(r.Context(), roomID, userID, payload.Role); err != nil {
+		return authorizationError(err)
+	}
+
+	h.emitMemberEvent(r.Context(), MemberRoleChangedEvent, MemberEventPayload{
+		RoomID: roomID, Username: userID, Role: payload.Role, Actor: session.Username})
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type TransferOwnershipPayload struct {
+	Username string `json:"username" validate:"required"`
+}
+
+func (h *ChatHandler) TransferOwnershipHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+
+	var payload TransferOwnershipPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	if err := validate.Struct(payload); err != nil {
+		return router.NewJsonError(http.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.chatStore.TransferOwnership(r.Context(), roomID, session.Username, payload.Username); err != nil {
+		return authorizationError(err)
+	}
+
+	h.emitMemberEvent(r.Context(), MemberRoleChangedEvent, MemberEventPayload{
+		RoomID: roomID, Username: payload.Username, Role: core.Owner, Actor: session.Username})
+	h.emitMemberEvent(r.Context(), MemberRoleChangedEvent, MemberEventPayload{
+		RoomID: roomID, Username: session.Username, Role: core.Admin, Actor: session.Username})
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type LeaveRoomPayload struct {
+	// Successor is the member who becomes the owner when the owner leaves.
+	Successor string `json:"successor"`
+}
+
+func (h *ChatHandler) LeaveRoomHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+
+	var payload LeaveRoomPayload
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			return err
+		}
+	}
+	r.Body.Close()
+
+	_, transferred, err := h.chatStore.LeaveRoom(r.Context(), roomID, session.Username, payload.Successor)
+	if err != nil {
+		if errors.Is(err, core.ErrSuccessorRequired) {
+			return router.NewJsonError(http.StatusBadRequest, err.Error())
+		}
+		return authorizationError(err)
+	}
+
+	if transferred {
+		h.emitMemberEvent(r.Context(), MemberRoleChangedEvent, MemberEventPayload{
+			RoomID: roomID, Username: payload.Successor, Role: core.Owner, Actor: session.Username})
+	}
+	h.emitMemberEvent(r.Context(), MemberRemovedEvent, MemberEventPayload{
+		RoomID: roomID, Username: session.Username, Actor: session.Username}, session.Username)
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// emitMemberEvent broadcasts a membership change to the members of the room and to extra users,
+// e.g. a member who has just been removed.
+// The change has already been made, so failures are not returned to the client.
+func (h *ChatHandler) emitMemberEvent(ctx context.Context, eventType string, payload MemberEventPayload, extra ...string) {
+	members, err := h.chatStore.GetRoomMembers(ctx, payload.RoomID)
+	if err != nil {
+		return
+	}
+
+	usernames := make([]string, 0, len(members)+len(extra))
+	for _, member := range members {
+		usernames = append(usernames, member.Username)
+	}
+	usernames = append(usernames, extra...)
+
+	h.eventRouter.EmitTo(eventType, payload, usernames...)
+}
+
+func (h *ChatHandler) GetRoomPermissionsHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+	inRoom, _, err := h.chatStore.IsRoomMember(r.Context(), roomID, session.Username)
 	if err != nil {
 		return err
 	}
@@ -107,19 +254,70 @@ func (h *ChatHandler) RemoveRoomMemberHandler(w http.ResponseWriter, r *http.Req
 		return router.NewJsonError(http.StatusForbidden, core.ErrInvalidRoom.Error())
 	}
 
-	if !(role == core.Admin || role == core.Owner) {
+	permissions, err := h.chatStore.GetRoomPermissions(r.Context(), roomID)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(permissions)
+}
+
+type SetRoomPermissionPayload struct {
+	Role       core.MemberRole `json:"role" validate:"required"`
+	Permission core.Permission `json:"permission" validate:"required"`
+	Allowed    bool            `json:"allowed"`
+}
+
+// SetRoomPermissionHandler overrides a permission of a role in the room.
+// Only the owner can do it, otherwise admins could grant themselves anything.
+func (h *ChatHandler) SetRoomPermissionHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+	inRoom, role, err := h.chatStore.IsRoomMember(r.Context(), roomID, session.Username)
+	if err != nil {
+		return err
+	}
+	if !inRoom {
+		return router.NewJsonError(http.StatusForbidden, core.ErrInvalidRoom.Error())
+	}
+	if role != core.Owner {
 		return router.NewJsonError(http.StatusForbidden, core.ErrDisAllowedOperation.Error())
 	}
 
-	if err := h.chatStore.RemoveRoomMember(r.Context(), roomID, userID); err != nil {
-		if err == core.ErrInvalidRoom || err == core.ErrInvalidMember {
-			return router.NewJsonError(http.StatusBadRequest, err.Error())
-		}
+	var payload SetRoomPermissionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		return err
 	}
+	r.Body.Close()
 
-	w.WriteHeader(http.StatusNoContent)
-	return nil
+	if err := validate.Struct(payload); err != nil {
+		return router.NewJsonError(http.StatusBadRequest, "invalid input")
+	}
+
+	err = h.chatStore.SetRoomPermission(r.Context(), roomID, payload.Role, payload.Permission, payload.Allowed)
+	if err != nil {
+		return authorizationError(err)
+	}
+
+	permissions, err := h.chatStore.GetRoomPermissions(r.Context(), roomID)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(permissions)
+}
+
+// authorizationError maps the errors of the ChatStore authorization checks to API errors.
+func authorizationError(err error) error {
+	switch {
+	case errors.Is(err, core.ErrInvalidRoom), errors.Is(err, core.ErrDisAllowedOperation):
+		return router.NewJsonError(http.StatusForbidden, err.Error())
+	case errors.Is(err, core.ErrInvalidMember), errors.Is(err, core.ErrInvalidPermission):
+		return router.NewJsonError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, core.ErrArchivedRoom):
+		return router.NewJsonError(http.StatusConflict, err.Error())
+	}
+	return err
 }
 
 func (h *ChatHandler) GetRoomByIDHandler(w http.ResponseWriter, r *http.Request) error {
@@ -162,7 +360,13 @@ func (h *ChatHandler) GetRoomUserRoomsHandler(w http.ResponseWriter, r *http.Req
 	offsetStr := query.Get("offset")
 	offset, _ := strconv.Atoi(offsetStr)
 
-	rooms, err := h.chatStore.GetUserRooms(r.Context(), id, offset, limit)
+	var rooms []core.Room
+	var err error
+	if archived, _ := strconv.ParseBool(query.Get("archived")); archived {
+		rooms, err = h.chatStore.GetArchivedUserRooms(r.Context(), id, offset, limit)
+	} else {
+		rooms, err = h.chatStore.GetUserRooms(r.Context(), id, offset, limit)
+	}
 	if err != nil {
 		return err
 	}
@@ -178,7 +382,57 @@ func (h *ChatHandler) GetRoomUserRoomsHandler(w http.ResponseWriter, r *http.Req
 	return nil
 }
 
+// RoomPreviewLimit is the number of recent messages that non-members can read in public rooms.
+const RoomPreviewLimit = 20
+
+func (h *ChatHandler) GetRoomDirectoryHandler(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	offset, _ := strconv.Atoi(query.Get("offset"))
+
+	entries, err := h.chatStore.GetRoomDirectory(r.Context(), query.Get("q"), offset, limit)
+	if err != nil {
+		return err
+	}
+	if entries == nil {
+		entries = []core.DirectoryEntry{}
+	}
+
+	return json.NewEncoder(w).Encode(entries)
+}
+
+func (h *ChatHandler) JoinRoomHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+
+	inRoom, _, err := h.chatStore.IsRoomMember(r.Context(), roomID, session.Username)
+	if err != nil {
+		return err
+	}
+	if inRoom {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	if err := h.chatStore.JoinRoom(r.Context(), roomID, session.Username); err != nil {
+		if errors.Is(err, core.ErrInvalidRoom) {
+			return router.NewJsonError(http.StatusNotFound, "room not found")
+		}
+		if errors.Is(err, core.ErrDisAllowedOperation) {
+			return router.NewJsonError(http.StatusForbidden, "the room cannot be joined without an invite")
+		}
+		return err
+	}
+
+	h.emitMemberEvent(r.Context(), MemberAddedEvent, MemberEventPayload{
+		RoomID: roomID, Username: session.Username, Role: core.Member, Actor: session.Username})
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
 func (h *ChatHandler) GetRoomMessagesHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
 	roomID := r.PathValue("roomID")
 	query := r.URL.Query()
 	limitStr := query.Get("limit")
@@ -186,6 +440,28 @@ func (h *ChatHandler) GetRoomMessagesHandler(w http.ResponseWriter, r *http.Requ
 	offsetStr := query.Get("offset")
 	offset, _ := strconv.Atoi(offsetStr)
 
+	inRoom, _, err := h.chatStore.IsRoomMember(r.Context(), roomID, session.Username)
+	if err != nil {
+		return err
+	}
+	if !inRoom {
+		room, err := h.chatStore.GetRoomByID(r.Context(), roomID)
+		if err != nil {
+			return err
+		}
+		if room == nil || !room.Visibility.Public() {
+			return router.NewJsonError(http.StatusForbidden, "you are not in this room")
+		}
+		// non-members get a read-only preview of the most recent messages
+		offset = max(offset, 0)
+		if offset >= RoomPreviewLimit {
+			return json.NewEncoder(w).Encode([]core.Message{})
+		}
+		if limit <= 0 || offset+limit > RoomPreviewLimit {
+			limit = RoomPreviewLimit - offset
+		}
+	}
+
 	messages, err := h.chatStore.GetRoomMessages(r.Context(), roomID, offset, limit)
 	if err != nil {
 		return err
@@ -199,6 +475,27 @@ func (h *ChatHandler) GetRoomMessagesHandler(w http.ResponseWriter, r *http.Requ
 	return nil
 }
 
+// DeleteMessageHandler deletes a message. Members can delete their own messages,
+// the messages of others need the delete_others_messages permission.
+func (h *ChatHandler) DeleteMessageHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+	messageID, err := strconv.Atoi(r.PathValue("messageID"))
+	if err != nil {
+		return router.NewJsonError(http.StatusBadRequest, core.ErrInvalidMessage.Error())
+	}
+
+	if err := h.chatStore.DeleteMessage(r.Context(), roomID, messageID, session.Username); err != nil {
+		if errors.Is(err, core.ErrInvalidMessage) {
+			return router.NewJsonError(http.StatusNotFound, err.Error())
+		}
+		return authorizationError(err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
 func (h *ChatHandler) SendMessageHandler(w http.ResponseWriter, r *http.Request) error {
 	var payload core.MessageCreateInput
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
@@ -212,6 +509,9 @@ func (h *ChatHandler) SendMessageHandler(w http.ResponseWriter, r *http.Request)
 
 	message, err := h.chatStore.SendMessageToRoom(r.Context(), payload)
 	if err != nil {
+		if err == core.ErrDisAllowedOperation {
+			return router.NewJsonError(http.StatusForbidden, err.Error())
+		}
 		if err == core.ErrInvalidRoom || err == core.ErrInvalidMessageType || err == core.ErrInvalidMessage {
 			return router.NewJsonError(http.StatusBadRequest, err.Error())
 		}
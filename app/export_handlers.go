@@ -0,0 +1,99 @@
+package chatter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/putto11262002/chatter/core"
+	"github.com/putto11262002/chatter/pkg/router"
+)
+
+// exportExtensions are the file extensions of the export formats.
+var exportExtensions = map[core.ExportFormat]string{
+	core.ExportJSON:     "json",
+	core.ExportMarkdown: "md",
+	core.ExportHTML:     "html",
+}
+
+type ExportHandler struct {
+	exporter *core.Exporter
+}
+
+func NewExportHandler(exporter *core.Exporter) *ExportHandler {
+	return &ExportHandler{exporter: exporter}
+}
+
+// CreateExportHandler starts an export of the room for the user.
+// The job is returned right away; its status is polled until it is done.
+func (h *ExportHandler) CreateExportHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+
+	var payload core.ExportInput
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	job, err := h.exporter.Submit(r.Context(), roomID, session.Username, payload)
+	if err != nil {
+		if errors.Is(err, core.ErrInvalidExport) {
+			return router.NewJsonError(http.StatusBadRequest, "invalid input")
+		}
+		return authorizationError(err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	return json.NewEncoder(w).Encode(job)
+}
+
+func (h *ExportHandler) GetExportHandler(w http.ResponseWriter, r *http.Request) error {
+	job, err := h.exportFromRequest(r)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(job)
+}
+
+func (h *ExportHandler) DownloadExportHandler(w http.ResponseWriter, r *http.Request) error {
+	job, err := h.exportFromRequest(r)
+	if err != nil {
+		return err
+	}
+	if job.Status != core.ExportDone {
+		return router.NewJsonError(http.StatusConflict, fmt.Sprintf("export is %s", job.Status))
+	}
+
+	file, err := h.exporter.Open(r.Context(), job)
+	if err != nil {
+		if errors.Is(err, core.ErrBlobNotFound) {
+			return router.NewJsonError(http.StatusNotFound, "export not found")
+		}
+		return authorizationError(err)
+	}
+	defer file.Close()
+
+	name := fmt.Sprintf("chatter-%s-%s.%s", job.RoomID, job.CreatedAt.Format("20060102T150405"), exportExtensions[job.Format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	http.ServeContent(w, r, name, time.Time{}, file)
+	return nil
+}
+
+// exportFromRequest returns the export job in the path if it was requested by the user for the room.
+func (h *ExportHandler) exportFromRequest(r *http.Request) (*core.ExportJob, error) {
+	session := SessionFromRequest(r)
+	job, err := h.exporter.Get(r.Context(), r.PathValue("jobID"), session.Username)
+	if err != nil {
+		if errors.Is(err, core.ErrInvalidExport) {
+			return nil, router.NewJsonError(http.StatusNotFound, "export not found")
+		}
+		return nil, err
+	}
+	if job.RoomID != r.PathValue("roomID") {
+		return nil, router.NewJsonError(http.StatusNotFound, "export not found")
+	}
+	return job, nil
+}
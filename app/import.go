@@ -0,0 +1,110 @@
+package chatter
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/putto11262002/chatter/core"
+)
+
+const importUsage = `usage: chatter import [-dry-run] [-link-existing] FILE
+
+Imports the users, public and private channels and messages of a Slack export zip.
+Users get random passwords and sign in after resetting them or through single sign-on.
+Running an import again only adds what is new since the last run.
+Direct messages are not imported.
+
+options:
+  -dry-run         report what would be imported without changing anything
+  -link-existing   link Slack users to the existing users with the same username,
+                   instead of creating them with a numbered username
+`
+
+// Import runs the import subcommand and prints how the export was mapped to out.
+func Import(ctx context.Context, config *Config, args []string, out io.Writer) error {
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %s", FormatValidationErrors(err))
+	}
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	dryRun := flags.Bool("dry-run", false, "")
+	linkExisting := flags.Bool("link-existing", false, "")
+	if err := flags.Parse(args); err != nil {
+		fmt.Fprint(out, importUsage)
+		return err
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprint(out, importUsage)
+		return errors.New("import takes the export file")
+	}
+
+	export, err := zip.OpenReader(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("open export: %w", err)
+	}
+	defer export.Close()
+
+	db, migrator, err := openDatabase(config)
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	defer db.Close()
+	if _, _, err := migrator.Up(ctx); err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+
+	userStore := core.NewSQLUserStore(db)
+	chatStore := core.NewSQLChatStore(db, userStore)
+	report, err := core.NewSlackImporter(userStore, chatStore, core.SlackImportConfig{
+		DryRun:            *dryRun,
+		LinkExistingUsers: *linkExisting,
+	}).Import(ctx, &export.Reader)
+	if err != nil {
+		return err
+	}
+	return printImportReport(out, report)
+}
+
+func printImportReport(out io.Writer, report *core.ImportReport) error {
+	if report.DryRun {
+		fmt.Fprintln(out, "dry run, nothing was imported")
+		fmt.Fprintln(out)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SLACK USER\tNAME\tUSERNAME\tSTATUS")
+	for _, user := range report.Users {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", user.ExternalID, user.ExternalName, user.Username, user.Status)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(out)
+
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHANNEL\tNAME\tROOM\tSTATUS\tMEMBERS\tMESSAGES\tDUPLICATES\tUNSUPPORTED")
+	for _, room := range report.Rooms {
+		roomID, status := room.RoomID, string(room.Status)
+		if roomID == "" {
+			roomID = "-"
+		}
+		if room.Reason != "" {
+			status += " (" + room.Reason + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\n", room.ExternalID, room.ExternalName, roomID, status,
+			room.Members, room.Messages, room.Duplicates, room.Unsupported)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if report.Conversations > 0 {
+		fmt.Fprintf(out, "\nskipped %d direct message conversations\n", report.Conversations)
+	}
+	return nil
+}
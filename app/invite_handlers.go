@@ -0,0 +1,124 @@
+package chatter
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/putto11262002/chatter/core"
+	"github.com/putto11262002/chatter/pkg/router"
+)
+
+func (h *ChatHandler) CreateInviteHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+
+	var payload core.CreateInviteInput
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	invite, err := h.chatStore.CreateInvite(r.Context(), roomID, session.Username, payload)
+	if err != nil {
+		if errors.Is(err, core.ErrInvalidInvite) {
+			return router.NewJsonError(http.StatusBadRequest, "invalid input")
+		}
+		return authorizationError(err)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	return json.NewEncoder(w).Encode(invite)
+}
+
+// GetRoomInvitesHandler lists the invites of the room to members who can invite.
+func (h *ChatHandler) GetRoomInvitesHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+	if _, err := h.chatStore.Authorize(r.Context(), roomID, session.Username, core.InviteMembers); err != nil {
+		return authorizationError(err)
+	}
+
+	invites, err := h.chatStore.GetRoomInvites(r.Context(), roomID)
+	if err != nil {
+		return err
+	}
+	if invites == nil {
+		invites = []core.Invite{}
+	}
+
+	return json.NewEncoder(w).Encode(invites)
+}
+
+func (h *ChatHandler) GetInviteUsesHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+	code := r.PathValue("code")
+	if _, err := h.chatStore.Authorize(r.Context(), roomID, session.Username, core.InviteMembers); err != nil {
+		return authorizationError(err)
+	}
+
+	invite, err := h.chatStore.GetInvite(r.Context(), code)
+	if err != nil {
+		return err
+	}
+	if invite == nil || invite.RoomID != roomID {
+		return router.NewJsonError(http.StatusNotFound, core.ErrInvalidInvite.Error())
+	}
+
+	uses, err := h.chatStore.GetInviteUses(r.Context(), code)
+	if err != nil {
+		return err
+	}
+	if uses == nil {
+		uses = []core.InviteUse{}
+	}
+
+	return json.NewEncoder(w).Encode(uses)
+}
+
+func (h *ChatHandler) RevokeInviteHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+	if _, err := h.chatStore.Authorize(r.Context(), roomID, session.Username, core.InviteMembers); err != nil {
+		return authorizationError(err)
+	}
+
+	if err := h.chatStore.RevokeInvite(r.Context(), roomID, r.PathValue("code")); err != nil {
+		if errors.Is(err, core.ErrInvalidInvite) {
+			return router.NewJsonError(http.StatusNotFound, err.Error())
+		}
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type AcceptInviteResponse struct {
+	RoomID string `json:"room_id"`
+}
+
+// AcceptInviteHandler joins the user to the room of the invite.
+// Accepting an invite to a room the user is already in succeeds without using it up.
+func (h *ChatHandler) AcceptInviteHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+
+	invite, joined, err := h.chatStore.AcceptInvite(r.Context(), r.PathValue("code"), session.Username)
+	if err != nil {
+		if errors.Is(err, core.ErrInvalidInvite) {
+			return router.NewJsonError(http.StatusNotFound, err.Error())
+		}
+		if errors.Is(err, core.ErrInvalidUser) {
+			return router.NewJsonError(http.StatusBadRequest, err.Error())
+		}
+		return err
+	}
+
+	if joined {
+		h.emitMemberEvent(r.Context(), MemberAddedEvent, MemberEventPayload{
+			RoomID: invite.RoomID, Username: session.Username, Role: invite.Role, Actor: session.Username})
+	}
+
+	return json.NewEncoder(w).Encode(AcceptInviteResponse{RoomID: invite.RoomID})
+}
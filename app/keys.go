@@ -0,0 +1,96 @@
+package chatter
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/putto11262002/chatter/core"
+	"github.com/putto11262002/chatter/pkg/router"
+)
+
+// loadKeyring builds the keyring from the configured keys.
+// The legacy secret is added first so that configured keys take over signing from it.
+func loadKeyring(config *Config) (*core.Keyring, error) {
+	var keys []*core.SigningKey
+	if len(config.Auth.Secret) > 0 {
+		keys = append(keys, core.NewHMACKey("", config.Auth.Secret))
+	}
+
+	for _, keyConfig := range config.Auth.Keys {
+		var key *core.SigningKey
+		if keyConfig.File != "" {
+			var err error
+			key, err = core.LoadPrivateKeyPEM(keyConfig.ID, keyConfig.File)
+			if err != nil {
+				return nil, fmt.Errorf("load key %q: %w", keyConfig.ID, err)
+			}
+		} else {
+			key = core.NewHMACKey(keyConfig.ID, keyConfig.Secret)
+		}
+		key.SignFrom = keyConfig.SignFrom
+		key.VerifyUntil = keyConfig.VerifyUntil
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		secret, err := authSecret(config)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, core.NewHMACKey("", secret))
+	}
+
+	return core.NewKeyring(keys...)
+}
+
+// authSecret returns the configured secret, or else the secret kept in the secret file.
+// Keys that are never published, such as the key of TOTP challenges, are derived from it.
+func authSecret(config *Config) ([]byte, error) {
+	if len(config.Auth.Secret) > 0 {
+		return config.Auth.Secret, nil
+	}
+	return loadOrCreateSecret(config.Auth.SecretFile)
+}
+
+// loadOrCreateSecret reads a base64 encoded secret from the file,
+// creating the file with a random 32 byte secret the first time.
+func loadOrCreateSecret(file string) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if err == nil {
+		secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("decode secret file: %w", err)
+		}
+		return secret, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read secret file: %w", err)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("generate secret: %w", err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(secret) + "\n"
+	if err := os.WriteFile(file, []byte(encoded), 0o600); err != nil {
+		return nil, fmt.Errorf("write secret file: %w", err)
+	}
+	return secret, nil
+}
+
+// JWKSHandler publishes the public keys that Chatter tokens can be verified with,
+// so that other services can verify them without sharing a secret.
+func JWKSHandler(keyring *core.Keyring) router.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		return json.NewEncoder(w).Encode(keyring.JWKS())
+	}
+}
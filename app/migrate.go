@@ -0,0 +1,183 @@
+package chatter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"text/tabwriter"
+
+	"github.com/pressly/goose/v3"
+	"github.com/putto11262002/chatter/core"
+	"github.com/putto11262002/chatter/migrations"
+)
+
+const migrateUsage = `usage: chatter migrate <command>
+
+commands:
+  status        show which migrations are applied
+  up            apply the pending migrations
+  down          roll back the most recent migration
+  redo          roll back the most recent migration and apply it again
+  create NAME   create a new SQL migration in the migrations directory
+
+SQLite databases are backed up next to the database file before up, down and redo.
+Migrations are built into the binary, so rebuild it after creating one,
+or point sqlite.migrations or postgres.migrations at the directory.
+`
+
+// openDatabase opens the database of the config together with a migrator of its schema.
+func openDatabase(config *Config) (*core.DB, *core.Migrator, error) {
+	switch config.Database {
+	case "postgres":
+		db, err := core.NewPostgresDB(config.Postgres.DSN,
+			migrationsFS(config.Postgres.Migrations, migrations.Postgres))
+		if err != nil {
+			return nil, nil, err
+		}
+		migrator, err := db.Migrator()
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		return core.NewDB(db.DB, core.Postgres), migrator, nil
+	default:
+		sqliteOptions := &core.SQLiteDBOption{
+			Mode:        "rwc",
+			JournalMode: "WAL",
+			BusyTimeout: config.SQLite.BusyTimeout,
+			Synchronous: config.SQLite.Synchronous,
+			ForeignKeys: true,
+			MmapSize:    config.SQLite.MmapSize,
+			MaxReaders:  config.SQLite.MaxReaders,
+		}
+		db, err := core.NewSQLiteDB(config.SQLite.File,
+			migrationsFS(config.SQLite.Migrations, migrations.SQLite), sqliteOptions)
+		if err != nil {
+			return nil, nil, err
+		}
+		migrator, err := db.Migrator()
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		return core.NewReadWriteDB(db.Readers, db.DB, core.SQLite), migrator, nil
+	}
+}
+
+// migrationsFS returns the migrations in dir, or the embedded ones if dir is empty.
+func migrationsFS(dir string, embedded fs.FS) fs.FS {
+	if dir == "" {
+		return embedded
+	}
+	return os.DirFS(dir)
+}
+
+// migrationsDir is the directory that new migrations are created in.
+func migrationsDir(config *Config) string {
+	switch config.Database {
+	case "postgres":
+		if config.Postgres.Migrations != "" {
+			return config.Postgres.Migrations
+		}
+		return "./migrations/postgres"
+	default:
+		if config.SQLite.Migrations != "" {
+			return config.SQLite.Migrations
+		}
+		return "./migrations"
+	}
+}
+
+// Migrate runs the migrate subcommand with its arguments, reporting what it did to out.
+func Migrate(ctx context.Context, config *Config, args []string, out io.Writer) error {
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %s", FormatValidationErrors(err))
+	}
+	if len(args) == 0 {
+		fmt.Fprint(out, migrateUsage)
+		return errors.New("missing command")
+	}
+
+	if args[0] == "create" {
+		if len(args) != 2 {
+			fmt.Fprint(out, migrateUsage)
+			return errors.New("create takes the name of the migration")
+		}
+		dir := migrationsDir(config)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		return goose.Create(nil, dir, args[1], "sql")
+	}
+
+	db, migrator, err := openDatabase(config)
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tSTATE\tAPPLIED AT\tMIGRATION")
+		for _, status := range statuses {
+			appliedAt := "-"
+			if !status.AppliedAt.IsZero() {
+				appliedAt = status.AppliedAt.UTC().Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", status.Source.Version, status.State, appliedAt, status.Source.Path)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		// a newer binary may have migrated the database past the migrations listed
+		return migrator.Check(ctx)
+	case "up":
+		results, backup, err := migrator.Up(ctx)
+		if backup != "" {
+			printBackup(out, config, backup)
+		}
+		printMigrationResults(out, results)
+		if err == nil && len(results) == 0 {
+			fmt.Fprintln(out, "no pending migrations")
+		}
+		return err
+	case "down":
+		result, backup, err := migrator.Down(ctx)
+		printBackup(out, config, backup)
+		if result != nil {
+			printMigrationResults(out, []*goose.MigrationResult{result})
+		}
+		return err
+	case "redo":
+		results, backup, err := migrator.Redo(ctx)
+		printBackup(out, config, backup)
+		printMigrationResults(out, results)
+		return err
+	default:
+		fmt.Fprint(out, migrateUsage)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func printMigrationResults(out io.Writer, results []*goose.MigrationResult) {
+	for _, result := range results {
+		fmt.Fprintln(out, result)
+	}
+}
+
+func printBackup(out io.Writer, config *Config, backup string) {
+	switch {
+	case backup != "":
+		fmt.Fprintf(out, "backed up the database to %s\n", backup)
+	case config.Database == "postgres":
+		fmt.Fprintln(out, "PostgreSQL databases are not backed up automatically, take a dump with pg_dump first")
+	}
+}
@@ -0,0 +1,99 @@
+package chatter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/putto11262002/chatter/core"
+	"github.com/putto11262002/chatter/pkg/router"
+)
+
+const oidcStateCookieName = "oidc_state"
+
+type OIDCHandler struct {
+	provider  *core.OIDCProvider
+	authStore core.AuthStore
+	auditLog  core.AuditLog
+}
+
+func NewOIDCHandler(provider *core.OIDCProvider, authStore core.AuthStore, auditLog core.AuditLog) *OIDCHandler {
+	return &OIDCHandler{provider: provider, authStore: authStore, auditLog: auditLog}
+}
+
+// LoginHandler redirects the user to the identity provider.
+// The state is also stored in a cookie so that the callback can only be completed by the same browser.
+func (h *OIDCHandler) LoginHandler(w http.ResponseWriter, r *http.Request) error {
+	url, state, err := h.provider.AuthCodeURL()
+	if err != nil {
+		return fmt.Errorf("AuthCodeURL: %w", err)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    state,
+		Expires:  time.Now().Add(10 * time.Minute),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/api/auth/oidc",
+	})
+	http.Redirect(w, r, url, http.StatusFound)
+	return nil
+}
+
+// CallbackHandler completes the login, signs the user in and redirects to the web app.
+func (h *OIDCHandler) CallbackHandler(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		return router.NewJsonError(http.StatusUnauthorized, query.Get("error"))
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookieName)
+	if err != nil || cookie.Value != state {
+		return router.NewJsonError(http.StatusBadRequest, core.ErrInvalidOIDCState.Error())
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    "",
+		Expires:  time.Unix(0, 0),
+		HttpOnly: true,
+		Path:     "/api/auth/oidc",
+	})
+
+	user, err := h.provider.Exchange(r.Context(), state, query.Get("code"))
+	if err != nil {
+		switch {
+		case errors.Is(err, core.ErrInvalidOIDCState):
+			return router.NewJsonError(http.StatusBadRequest, err.Error())
+		case errors.Is(err, core.ErrInvalidOIDCIdentity), errors.Is(err, core.ErrConflictedUser):
+			return router.NewJsonError(http.StatusUnauthorized, err.Error())
+		}
+		return err
+	}
+
+	session, err := h.authStore.IssueSession(r.Context(), user.Username)
+	if err != nil {
+		if errors.Is(err, core.ErrDeactivatedUser) {
+			if err := h.auditLog.Record(r.Context(), core.AuditEntry{
+				Action: core.AuditSignInFailed, Target: user.Username,
+				Details: map[string]string{"method": "oidc", "reason": "deactivated"},
+			}); err != nil {
+				return fmt.Errorf("Record: %w", err)
+			}
+			return router.NewJsonError(http.StatusForbidden, err.Error())
+		}
+		return fmt.Errorf("IssueSession: %w", err)
+	}
+	if err := h.auditLog.Record(r.Context(), core.AuditEntry{
+		Action: core.AuditSignIn, Actor: user.Username, Target: user.Username,
+		Details: map[string]string{"method": "oidc"},
+	}); err != nil {
+		return fmt.Errorf("Record: %w", err)
+	}
+
+	setSessionCookie(w, session)
+	http.Redirect(w, r, "/", http.StatusFound)
+	return nil
+}
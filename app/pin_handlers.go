@@ -0,0 +1,168 @@
+package chatter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/putto11262002/chatter/core"
+	"github.com/putto11262002/chatter/pkg/router"
+)
+
+// messageIDFromRequest parses the messageID path parameter.
+func messageIDFromRequest(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(r.PathValue("messageID"))
+	if err != nil {
+		return 0, router.NewJsonError(http.StatusBadRequest, core.ErrInvalidMessage.Error())
+	}
+	return id, nil
+}
+
+func (h *ChatHandler) GetRoomPinsHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+
+	inRoom, _, err := h.chatStore.IsRoomMember(r.Context(), roomID, session.Username)
+	if err != nil {
+		return err
+	}
+	if !inRoom {
+		return router.NewJsonError(http.StatusForbidden, core.ErrInvalidRoom.Error())
+	}
+
+	pins, err := h.chatStore.GetRoomPins(r.Context(), roomID)
+	if err != nil {
+		return err
+	}
+	if pins == nil {
+		pins = []core.Pin{}
+	}
+
+	return json.NewEncoder(w).Encode(pins)
+}
+
+func (h *ChatHandler) PinMessageHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+	messageID, err := messageIDFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	pin, err := h.chatStore.PinMessage(r.Context(), roomID, messageID, session.Username)
+	if err != nil {
+		if errors.Is(err, core.ErrInvalidMessage) {
+			return router.NewJsonError(http.StatusNotFound, err.Error())
+		}
+		return authorizationError(err)
+	}
+
+	h.emitPinEvent(r.Context(), MessagePinnedEvent, PinEventPayload{
+		RoomID: roomID, MessageID: messageID, Actor: session.Username})
+
+	return json.NewEncoder(w).Encode(pin)
+}
+
+func (h *ChatHandler) UnpinMessageHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+	messageID, err := messageIDFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	if err := h.chatStore.UnpinMessage(r.Context(), roomID, messageID, session.Username); err != nil {
+		if errors.Is(err, core.ErrInvalidMessage) {
+			return router.NewJsonError(http.StatusNotFound, err.Error())
+		}
+		return authorizationError(err)
+	}
+
+	h.emitPinEvent(r.Context(), MessageUnpinnedEvent, PinEventPayload{
+		RoomID: roomID, MessageID: messageID, Actor: session.Username})
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// emitPinEvent tells the members of the room that the pins changed.
+// The change has already been made, so failures are not returned to the client.
+func (h *ChatHandler) emitPinEvent(ctx context.Context, eventType string, payload PinEventPayload) {
+	members, err := h.chatStore.GetRoomMembers(ctx, payload.RoomID)
+	if err != nil {
+		return
+	}
+
+	usernames := make([]string, 0, len(members))
+	for _, member := range members {
+		usernames = append(usernames, member.Username)
+	}
+	h.eventRouter.EmitTo(eventType, payload, usernames...)
+}
+
+func (h *ChatHandler) GetMyBookmarksHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	offset, _ := strconv.Atoi(query.Get("offset"))
+
+	bookmarks, err := h.chatStore.GetBookmarks(r.Context(), session.Username, offset, limit)
+	if err != nil {
+		return err
+	}
+	if bookmarks == nil {
+		bookmarks = []core.Bookmark{}
+	}
+
+	return json.NewEncoder(w).Encode(bookmarks)
+}
+
+// BookmarkMessageHandler saves a message for the signed in user, or updates the note of the bookmark.
+func (h *ChatHandler) BookmarkMessageHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	messageID, err := messageIDFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	var payload core.BookmarkInput
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			return err
+		}
+	}
+	r.Body.Close()
+
+	bookmark, err := h.chatStore.BookmarkMessage(r.Context(), session.Username, messageID, payload)
+	if err != nil {
+		switch {
+		case errors.Is(err, core.ErrInvalidBookmark):
+			return router.NewJsonError(http.StatusBadRequest, err.Error())
+		case errors.Is(err, core.ErrInvalidMessage):
+			return router.NewJsonError(http.StatusNotFound, err.Error())
+		}
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(bookmark)
+}
+
+func (h *ChatHandler) RemoveBookmarkHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	messageID, err := messageIDFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	if err := h.chatStore.RemoveBookmark(r.Context(), session.Username, messageID); err != nil {
+		if errors.Is(err, core.ErrInvalidBookmark) {
+			return router.NewJsonError(http.StatusNotFound, err.Error())
+		}
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
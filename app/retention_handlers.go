@@ -0,0 +1,95 @@
+package chatter
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/putto11262002/chatter/core"
+	"github.com/putto11262002/chatter/pkg/router"
+)
+
+func (h *ChatHandler) GetRoomRetentionHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+
+	inRoom, _, err := h.chatStore.IsRoomMember(r.Context(), roomID, session.Username)
+	if err != nil {
+		return err
+	}
+	if !inRoom {
+		return router.NewJsonError(http.StatusForbidden, core.ErrInvalidRoom.Error())
+	}
+
+	retention, err := h.chatStore.GetRoomRetention(r.Context(), roomID)
+	if err != nil {
+		return authorizationError(err)
+	}
+
+	return json.NewEncoder(w).Encode(retention)
+}
+
+// SetRoomRetentionHandler overrides the global retention policy for the room.
+// A null body makes the room use the global policy again.
+func (h *ChatHandler) SetRoomRetentionHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+	if _, err := h.chatStore.Authorize(r.Context(), roomID, session.Username, core.ManageRetention); err != nil {
+		return authorizationError(err)
+	}
+
+	var policy *core.RetentionPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	if err := h.chatStore.SetRoomRetention(r.Context(), roomID, policy); err != nil {
+		if errors.Is(err, core.ErrInvalidRetention) {
+			return router.NewJsonError(http.StatusBadRequest, err.Error())
+		}
+		return authorizationError(err)
+	}
+
+	retention, err := h.chatStore.GetRoomRetention(r.Context(), roomID)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(retention)
+}
+
+type SetLegalHoldPayload struct {
+	Hold bool `json:"hold"`
+}
+
+// SetLegalHoldHandler places the room on legal hold or lifts it. A hold is meant to outlast
+// whoever manages the retention of the room, so only the owner can place or lift it.
+func (h *ChatHandler) SetLegalHoldHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+	role, err := h.chatStore.Authorize(r.Context(), roomID, session.Username, core.ManageRetention)
+	if err != nil {
+		return authorizationError(err)
+	}
+	if role != core.Owner {
+		return router.NewJsonError(http.StatusForbidden, core.ErrDisAllowedOperation.Error())
+	}
+
+	var payload SetLegalHoldPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	if err := h.chatStore.SetLegalHold(r.Context(), roomID, payload.Hold); err != nil {
+		return authorizationError(err)
+	}
+
+	retention, err := h.chatStore.GetRoomRetention(r.Context(), roomID)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(retention)
+}
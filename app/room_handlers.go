@@ -0,0 +1,292 @@
+package chatter
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/putto11262002/chatter/core"
+	"github.com/putto11262002/chatter/pkg/router"
+)
+
+// avatarContentTypes are the image formats accepted as room avatars.
+var avatarContentTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+func (h *ChatHandler) UpdateRoomHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+
+	var payload core.UpdateRoomInput
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	room, err := h.updateRoom(r.Context(), roomID, session.Username, payload)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(room)
+}
+
+func (h *ChatHandler) GetRoomAvatarHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+
+	inRoom, _, err := h.chatStore.IsRoomMember(r.Context(), roomID, session.Username)
+	if err != nil {
+		return err
+	}
+	if !inRoom {
+		return router.NewJsonError(http.StatusForbidden, "you are not in this room")
+	}
+
+	room, err := h.chatStore.GetRoomByID(r.Context(), roomID)
+	if err != nil {
+		return err
+	}
+	if room == nil || room.Avatar == "" {
+		return router.NewJsonError(http.StatusNotFound, "avatar not found")
+	}
+
+	blob, err := h.blobStore.Open(r.Context(), room.Avatar)
+	if err != nil {
+		if errors.Is(err, core.ErrBlobNotFound) {
+			return router.NewJsonError(http.StatusNotFound, "avatar not found")
+		}
+		return err
+	}
+	defer blob.Close()
+
+	// the key changes whenever the avatar does, so it doubles as the etag
+	w.Header().Set("ETag", `"`+room.Avatar+`"`)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	http.ServeContent(w, r, "", time.Time{}, blob)
+	return nil
+}
+
+// UploadRoomAvatarHandler replaces the avatar of the room with the image in the request body.
+func (h *ChatHandler) UploadRoomAvatarHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+	defer r.Body.Close()
+
+	// check before storing anything, UpdateRoom checks again
+	if _, err := h.chatStore.Authorize(r.Context(), roomID, session.Username, core.RenameRoom); err != nil {
+		return authorizationError(err)
+	}
+
+	body := bufio.NewReader(r.Body)
+	head, _ := body.Peek(512)
+	if !slices.Contains(avatarContentTypes, http.DetectContentType(head)) {
+		return router.NewJsonError(http.StatusUnsupportedMediaType, "avatar must be a png, jpeg, gif or webp image")
+	}
+
+	key, err := h.blobStore.Put(r.Context(), body)
+	if err != nil {
+		if errors.Is(err, core.ErrBlobTooLarge) {
+			return router.NewJsonError(http.StatusRequestEntityTooLarge, err.Error())
+		}
+		return err
+	}
+
+	room, err := h.setRoomAvatar(r.Context(), roomID, session.Username, key)
+	if err != nil {
+		h.blobStore.Delete(r.Context(), key)
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(room)
+}
+
+func (h *ChatHandler) DeleteRoomAvatarHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+
+	room, err := h.setRoomAvatar(r.Context(), roomID, session.Username, "")
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(room)
+}
+
+// setRoomAvatar points the room at a new avatar and deletes the blob of the previous one.
+func (h *ChatHandler) setRoomAvatar(ctx context.Context, roomID, actor, key string) (*core.Room, error) {
+	previous, err := h.chatStore.GetRoomByID(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if previous == nil {
+		return nil, router.NewJsonError(http.StatusForbidden, core.ErrInvalidRoom.Error())
+	}
+
+	room, err := h.updateRoom(ctx, roomID, actor, core.UpdateRoomInput{Avatar: &key})
+	if err != nil {
+		return nil, err
+	}
+
+	if previous.Avatar != "" && previous.Avatar != key {
+		h.blobStore.Delete(ctx, previous.Avatar)
+	}
+	return room, nil
+}
+
+func (h *ChatHandler) ArchiveRoomHandler(w http.ResponseWriter, r *http.Request) error {
+	return h.archiveRoom(w, r, true)
+}
+
+func (h *ChatHandler) UnarchiveRoomHandler(w http.ResponseWriter, r *http.Request) error {
+	return h.archiveRoom(w, r, false)
+}
+
+// archiveRoom archives or restores the room, then tells the members so open clients
+// can switch the room to or from read-only.
+func (h *ChatHandler) archiveRoom(w http.ResponseWriter, r *http.Request, archived bool) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+
+	if err := h.chatStore.ArchiveRoom(r.Context(), roomID, session.Username, archived); err != nil {
+		return authorizationError(err)
+	}
+
+	room, err := h.chatStore.GetRoomByID(r.Context(), roomID)
+	if err != nil {
+		return err
+	}
+	if room == nil {
+		return router.NewJsonError(http.StatusNotFound, "room not found")
+	}
+
+	eventType := RoomArchivedEvent
+	if !archived {
+		eventType = RoomUnarchivedEvent
+	}
+	usernames := make([]string, 0, len(room.Members))
+	for _, member := range room.Members {
+		usernames = append(usernames, member.Username)
+	}
+	h.eventRouter.EmitTo(eventType, RoomEventPayload{RoomID: roomID, Actor: session.Username}, usernames...)
+
+	return json.NewEncoder(w).Encode(room)
+}
+
+// DeleteRoomHandler deletes the room and its avatar, then tells the former members
+// so open clients close the room.
+func (h *ChatHandler) DeleteRoomHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+
+	// the members and avatar are gone once the room is deleted
+	room, err := h.chatStore.GetRoomByID(r.Context(), roomID)
+	if err != nil {
+		return err
+	}
+	if room == nil {
+		return router.NewJsonError(http.StatusForbidden, core.ErrInvalidRoom.Error())
+	}
+
+	if err := h.chatStore.DeleteRoom(r.Context(), roomID, session.Username); err != nil {
+		return authorizationError(err)
+	}
+
+	if room.Avatar != "" {
+		h.blobStore.Delete(r.Context(), room.Avatar)
+	}
+
+	usernames := make([]string, 0, len(room.Members))
+	for _, member := range room.Members {
+		usernames = append(usernames, member.Username)
+	}
+	h.eventRouter.EmitTo(RoomDeletedEvent, RoomEventPayload{RoomID: roomID, Actor: session.Username}, usernames...)
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *ChatHandler) GetRoomPreferencesHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+
+	preferences, err := h.chatStore.GetRoomPreferences(r.Context(), roomID, session.Username)
+	if err != nil {
+		return authorizationError(err)
+	}
+
+	return json.NewEncoder(w).Encode(preferences)
+}
+
+// UpdateRoomPreferencesHandler updates the preferences of the signed in member for the room.
+// Only the fields present in the body are changed.
+func (h *ChatHandler) UpdateRoomPreferencesHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	roomID := r.PathValue("roomID")
+
+	var payload core.UpdateRoomPreferencesInput
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	preferences, err := h.chatStore.UpdateRoomPreferences(r.Context(), roomID, session.Username, payload)
+	if err != nil {
+		if errors.Is(err, core.ErrInvalidPreferences) {
+			return router.NewJsonError(http.StatusBadRequest, err.Error())
+		}
+		return authorizationError(err)
+	}
+
+	return json.NewEncoder(w).Encode(preferences)
+}
+
+// updateRoom applies the update, then broadcasts the new metadata and the system message
+// recording the change to the members of the room.
+func (h *ChatHandler) updateRoom(ctx context.Context, roomID, actor string, input core.UpdateRoomInput) (*core.Room, error) {
+	message, err := h.chatStore.UpdateRoom(ctx, roomID, actor, input)
+	if err != nil {
+		if errors.Is(err, core.ErrInvalidRoomInput) {
+			return nil, router.NewJsonError(http.StatusBadRequest, "invalid input")
+		}
+		return nil, authorizationError(err)
+	}
+
+	room, err := h.chatStore.GetRoomByID(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if room == nil {
+		return nil, router.NewJsonError(http.StatusNotFound, "room not found")
+	}
+	if message == nil {
+		return room, nil
+	}
+
+	usernames := make([]string, 0, len(room.Members))
+	for _, member := range room.Members {
+		usernames = append(usernames, member.Username)
+	}
+	h.eventRouter.EmitTo(RoomUpdatedEvent, RoomUpdatedEventPayload{
+		RoomID:      room.ID,
+		Name:        room.Name,
+		Topic:       room.Topic,
+		Description: room.Description,
+		Avatar:      room.Avatar,
+		Visibility:  room.Visibility,
+		Actor:       actor,
+	}, usernames...)
+	h.eventRouter.EmitTo(MessageEvent, MessageEventPayload{
+		ID:     message.ID,
+		RoomID: message.RoomID,
+		Type:   message.Type,
+		Data:   message.Data,
+		Sender: message.Sender,
+		SentAt: message.SentAt,
+	}, usernames...)
+
+	return room, nil
+}
@@ -2,6 +2,7 @@ package chatter
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -10,11 +11,16 @@ import (
 )
 
 type UserHandler struct {
-	store core.UserStore
+	store     core.UserStore
+	authStore core.AuthStore
+	resetter  *core.PasswordResetter
+	exporter  *core.Exporter
 }
 
-func NewUserHandler(store core.UserStore) *UserHandler {
-	return &UserHandler{store: store}
+func NewUserHandler(store core.UserStore, authStore core.AuthStore, resetter *core.PasswordResetter,
+	exporter *core.Exporter,
+) *UserHandler {
+	return &UserHandler{store: store, authStore: authStore, resetter: resetter, exporter: exporter}
 }
 
 func (h *UserHandler) RegisterUserHandler(w http.ResponseWriter, r *http.Request) error {
@@ -70,3 +76,161 @@ func (h *UserHandler) GetUserByUsernameHandler(w http.ResponseWriter, r *http.Re
 	json.NewEncoder(w).Encode(user)
 	return nil
 }
+
+func (h *UserHandler) UpdateMeHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	var input core.UpdateUserInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return fmt.Errorf("Decode: %w", err)
+	}
+	defer r.Body.Close()
+
+	if err := input.Validate(); err != nil {
+		return router.NewJsonError(http.StatusBadRequest, "invalid input")
+	}
+
+	user, err := h.store.UpdateUser(r.Context(), session.Username, input)
+	if err != nil {
+		if errors.Is(err, core.ErrInvalidUser) {
+			return router.NewJsonError(http.StatusNotFound, "user not found")
+		}
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(user)
+}
+
+type ChangePasswordPayload struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=8"`
+}
+
+// ChangePasswordHandler changes the password of the signed in user.
+// Every other session is signed out and the current one is replaced with a new session.
+func (h *UserHandler) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	var payload ChangePasswordPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return fmt.Errorf("Decode: %w", err)
+	}
+	defer r.Body.Close()
+
+	if err := validate.Struct(payload); err != nil {
+		return router.NewJsonError(http.StatusBadRequest, "invalid input")
+	}
+
+	err := h.authStore.ChangePassword(r.Context(), session.Username, payload.CurrentPassword, payload.NewPassword)
+	if err != nil {
+		if errors.Is(err, core.ErrBadCredentials) {
+			return router.NewJsonError(http.StatusUnauthorized, err.Error())
+		}
+		if errors.Is(err, core.ErrTooManyAttempts) {
+			return tooManyAttempts(w, err)
+		}
+		return err
+	}
+
+	newSession, err := h.authStore.IssueSession(r.Context(), session.Username)
+	if err != nil {
+		return fmt.Errorf("IssueSession: %w", err)
+	}
+	setSessionCookie(w, newSession)
+
+	return json.NewEncoder(w).Encode(newSession)
+}
+
+type PasswordResetRequestPayload struct {
+	Username string `json:"username" validate:"required"`
+}
+
+// RequestPasswordResetHandler sends a reset token to the user.
+// It always answers 202 Accepted so that it cannot be used to find out which usernames exist.
+// Requests are throttled per username and client IP address.
+func (h *UserHandler) RequestPasswordResetHandler(w http.ResponseWriter, r *http.Request) error {
+	var payload PasswordResetRequestPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return fmt.Errorf("Decode: %w", err)
+	}
+	defer r.Body.Close()
+
+	if err := validate.Struct(payload); err != nil {
+		return router.NewJsonError(http.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.authStore.AllowPasswordReset(r.Context(), payload.Username); err != nil {
+		if errors.Is(err, core.ErrTooManyAttempts) {
+			return tooManyAttempts(w, err)
+		}
+		return fmt.Errorf("AllowPasswordReset: %w", err)
+	}
+
+	if err := h.resetter.RequestReset(r.Context(), payload.Username); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
+
+type PasswordResetPayload struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=8"`
+}
+
+func (h *UserHandler) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) error {
+	var payload PasswordResetPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return fmt.Errorf("Decode: %w", err)
+	}
+	defer r.Body.Close()
+
+	if err := validate.Struct(payload); err != nil {
+		return router.NewJsonError(http.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.resetter.Reset(r.Context(), payload.Token, payload.Password); err != nil {
+		if errors.Is(err, core.ErrInvalidResetToken) {
+			return router.NewJsonError(http.StatusBadRequest, err.Error())
+		}
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// DeactivateMeHandler deactivates the account of the signed in user and signs them out everywhere.
+func (h *UserHandler) DeactivateMeHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	if err := h.store.DeactivateUser(r.Context(), session.Username); err != nil {
+		if errors.Is(err, core.ErrInvalidUser) {
+			return router.NewJsonError(http.StatusNotFound, "user not found")
+		}
+		return err
+	}
+
+	clearSessionCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// DeleteMeHandler deletes the account of the signed in user.
+// Rooms they own that still have other members must be handed over or emptied first.
+// Their exports go with the account, since they hold the history of rooms they have left.
+func (h *UserHandler) DeleteMeHandler(w http.ResponseWriter, r *http.Request) error {
+	session := SessionFromRequest(r)
+	if err := h.store.DeleteUser(r.Context(), session.Username); err != nil {
+		switch {
+		case errors.Is(err, core.ErrInvalidUser):
+			return router.NewJsonError(http.StatusNotFound, "user not found")
+		case errors.Is(err, core.ErrDisAllowedOperation):
+			return router.NewJsonError(http.StatusConflict, "user owns rooms that have other members")
+		}
+		return err
+	}
+	h.exporter.DeleteUserExports(r.Context(), session.Username)
+
+	clearSessionCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,22 @@
+package core
+
+import "time"
+
+// RoomListing is a room as listed to administrators, whatever its visibility.
+type RoomListing struct {
+	DirectoryEntry
+	ArchivedAt *time.Time `json:"archived_at"`
+	LegalHold  bool       `json:"legal_hold"`
+}
+
+// InstanceStats are the totals of the instance.
+type InstanceStats struct {
+	Users            int `json:"users"`
+	DeactivatedUsers int `json:"deactivated_users"`
+	Admins           int `json:"admins"`
+	Rooms            int `json:"rooms"`
+	ArchivedRooms    int `json:"archived_rooms"`
+	Messages         int `json:"messages"`
+	// MessagesToday is how many messages were sent in the last 24 hours.
+	MessagesToday int `json:"messages_today"`
+}
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+func (s *SQLChatStore) GetRooms(ctx context.Context, search string, offset, limit int) ([]RoomListing, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	query := `
+	SELECT r.id, r.name, r.topic, r.description, r.avatar, r.visibility,
+	(SELECT count(*) FROM room_members AS rm WHERE rm.room_id = r.id) AS member_count,
+	r.archived_at, r.legal_hold
+	FROM rooms AS r
+	WHERE ` + s.db.Dialect.Contains("lower(r.name)", "lower(@search)") + `
+	ORDER BY r.name ASC, r.id ASC
+	LIMIT @limit OFFSET @offset`
+	rows, err := s.db.QueryContext(ctx, query,
+		sql.Named("search", search), sql.Named("limit", limit), sql.Named("offset", offset))
+	if err != nil {
+		return nil, fmt.Errorf("QueryContext: %w", err)
+	}
+	defer rows.Close()
+
+	rooms := []RoomListing{}
+	for rows.Next() {
+		var room RoomListing
+		var archivedAt sql.NullTime
+		if err := rows.Scan(&room.ID, &room.Name, &room.Topic, &room.Description, &room.Avatar,
+			&room.Visibility, &room.MemberCount, &archivedAt, &room.LegalHold); err != nil {
+			return nil, fmt.Errorf("rows.Scan: %w", err)
+		}
+		if archivedAt.Valid {
+			room.ArchivedAt = &archivedAt.Time
+		}
+		rooms = append(rooms, room)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err: %w", err)
+	}
+	return rooms, nil
+}
+
+func (s *SQLChatStore) GetInstanceStats(ctx context.Context) (*InstanceStats, error) {
+	var stats InstanceStats
+	row := s.db.QueryRowContext(ctx, `
+	SELECT
+	(SELECT count(*) FROM users WHERE deleted_at IS NULL),
+	(SELECT count(*) FROM users WHERE deleted_at IS NULL AND deactivated_at IS NOT NULL),
+	(SELECT count(*) FROM users WHERE deleted_at IS NULL AND is_admin),
+	(SELECT count(*) FROM rooms),
+	(SELECT count(*) FROM rooms WHERE archived_at IS NOT NULL),
+	(SELECT count(*) FROM messages),
+	(SELECT count(*) FROM messages WHERE sent_at >= @since)`,
+		sql.Named("since", time.Now().UTC().Add(-24*time.Hour)))
+	if err := row.Scan(&stats.Users, &stats.DeactivatedUsers, &stats.Admins, &stats.Rooms,
+		&stats.ArchivedRooms, &stats.Messages, &stats.MessagesToday); err != nil {
+		return nil, fmt.Errorf("row.Scan: %w", err)
+	}
+	return &stats, nil
+}
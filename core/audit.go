@@ -0,0 +1,114 @@
+package core
+
+import (
+	"context"
+	"time"
+)
+
+// AuditAction is a kind of security relevant action recorded in the audit log.
+type AuditAction string
+
+const (
+	AuditSignIn       AuditAction = "auth.signin"
+	AuditSignInFailed AuditAction = "auth.signin_failed"
+	AuditSignOut      AuditAction = "auth.signout"
+
+	AuditMemberAdded   AuditAction = "room.member_added"
+	AuditMemberRemoved AuditAction = "room.member_removed"
+	AuditRoleChanged   AuditAction = "room.role_changed"
+	AuditRoomDeleted   AuditAction = "room.deleted"
+
+	AuditUserDeactivated  AuditAction = "admin.user_deactivated"
+	AuditUserReactivated  AuditAction = "admin.user_reactivated"
+	AuditPasswordSet      AuditAction = "admin.password_set"
+	AuditSessionsRevoked  AuditAction = "admin.sessions_revoked"
+	AuditTOTPReset        AuditAction = "admin.totp_reset"
+	AuditAdminGranted     AuditAction = "admin.admin_granted"
+	AuditAdminRevoked     AuditAction = "admin.admin_revoked"
+	AuditRetentionChanged AuditAction = "admin.retention_changed"
+	AuditLegalHoldChanged AuditAction = "admin.legal_hold_changed"
+	AuditUnlocked         AuditAction = "admin.unlocked"
+
+	// AuditPurged records that entries were deleted by the audit retention,
+	// so that the start of the log moving is itself on the log.
+	AuditPurged AuditAction = "audit.purged"
+)
+
+// AuditEntry is an entry of the audit log.
+type AuditEntry struct {
+	ID        int64       `json:"id"`
+	CreatedAt time.Time   `json:"created_at"`
+	Action    AuditAction `json:"action"`
+	// Actor is the user who did it, or empty for anonymous requests such as failed sign ins.
+	Actor string `json:"actor"`
+	// Target is the user it was done to, if any.
+	Target string `json:"target"`
+	// RoomID is the room it was done in, if any.
+	RoomID    string            `json:"room_id"`
+	IP        string            `json:"ip"`
+	UserAgent string            `json:"user_agent"`
+	Details   map[string]string `json:"details"`
+	// PrevHash and Hash chain the entries when chaining is enabled, otherwise they are empty.
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// AuditFilter selects entries of the audit log. Zero fields match every entry.
+type AuditFilter struct {
+	Actor  string
+	Action AuditAction
+	Target string
+	RoomID string
+	IP     string
+	Since  time.Time
+	// Until is exclusive.
+	Until time.Time
+	// Limit is set to 50 if it is a zero value.
+	Limit  int
+	Offset int
+}
+
+// AuditVerification is the result of checking the hash chain of the audit log.
+type AuditVerification struct {
+	// Entries is how many chained entries were checked.
+	Entries int  `json:"entries"`
+	Valid   bool `json:"valid"`
+	// BrokenAt is the ID of the first entry that does not match the chain, if any.
+	BrokenAt int64 `json:"broken_at,omitempty"`
+}
+
+// AuditLog is an append-only log of security relevant actions.
+type AuditLog interface {
+	// Record appends the entry. Its time, actor, IP address and user agent are taken from
+	// the context (see ContextWithActor, ContextWithClientIP and ContextWithUserAgent)
+	// unless they are set.
+	Record(ctx context.Context, entry AuditEntry) error
+
+	// Query returns the entries that match the filter, most recent first.
+	Query(ctx context.Context, filter AuditFilter) ([]AuditEntry, error)
+
+	// Purge deletes the entries created before the given time and returns how many were deleted.
+	// Only the start of the log is deleted, up to the first entry created at or after the given time.
+	// The purge is recorded as an AuditPurged entry with the hash of the last deleted entry,
+	// which the chain of the remaining entries continues from.
+	Purge(ctx context.Context, before time.Time) (int, error)
+
+	// Verify recomputes the hash chain and reports the first entry that does not match it.
+	// Entries recorded before chaining was enabled are skipped, but once the chain starts
+	// an entry that is not chained breaks it. The chain must start from nothing or from
+	// an entry that a recorded purge deleted.
+	Verify(ctx context.Context) (*AuditVerification, error)
+}
+
+type userAgentKey struct{}
+
+// ContextWithUserAgent attaches the user agent of the client making the request to the context.
+func ContextWithUserAgent(ctx context.Context, userAgent string) context.Context {
+	return context.WithValue(ctx, userAgentKey{}, userAgent)
+}
+
+// UserAgentFromContext returns the user agent attached by ContextWithUserAgent, if any.
+func UserAgentFromContext(ctx context.Context) string {
+	userAgent, _ := ctx.Value(userAgentKey{}).(string)
+	return userAgent
+}
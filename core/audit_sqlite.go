@@ -0,0 +1,325 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var _ AuditLog = (*SQLAuditLog)(nil)
+
+// SQLAuditLog is an AuditLog in the audit_log table.
+// The stores record into it within their own transactions, so an action and its entry
+// are committed together. A nil *SQLAuditLog records nothing.
+type SQLAuditLog struct {
+	db *DB
+	// chain makes every entry carry the hash of the one before it.
+	chain bool
+}
+
+// NewSQLAuditLog returns an audit log on db. If chain is true, every entry is hashed
+// together with the hash of the previous entry, so that Verify can detect entries
+// that were changed, removed or inserted behind the log's back.
+func NewSQLAuditLog(db *DB, chain bool) *SQLAuditLog {
+	return &SQLAuditLog{db: db, chain: chain}
+}
+
+func (l *SQLAuditLog) Record(ctx context.Context, entry AuditEntry) error {
+	if l == nil {
+		return nil
+	}
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := l.appendTx(ctx, tx, entry); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Commit: %w", err)
+	}
+	return nil
+}
+
+// appendTx appends the entry within the transaction, filling in what is missing from the context.
+func (l *SQLAuditLog) appendTx(ctx context.Context, tx *Tx, entry AuditEntry) error {
+	if l == nil {
+		return nil
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	// the hash is computed over the time as it is read back, so it is kept to what both databases store
+	entry.CreatedAt = entry.CreatedAt.UTC().Truncate(time.Microsecond)
+	if entry.Actor == "" {
+		entry.Actor = ActorFromContext(ctx, "")
+	}
+	if entry.IP == "" {
+		entry.IP = ClientIPFromContext(ctx)
+	}
+	if entry.UserAgent == "" {
+		entry.UserAgent = UserAgentFromContext(ctx)
+	}
+	if entry.Details == nil {
+		entry.Details = map[string]string{}
+	}
+	details, err := json.Marshal(entry.Details)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	var prevHash, hash string
+	if l.chain {
+		if query := tx.Dialect.LockTable("audit_log"); query != "" {
+			if _, err := tx.ExecContext(ctx, query); err != nil {
+				return fmt.Errorf("ExecContext: %w", err)
+			}
+		}
+		if err := tx.QueryRowContext(ctx, `
+		SELECT hash FROM audit_log WHERE hash <> '' ORDER BY id DESC LIMIT 1`).Scan(&prevHash); err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("QueryRowContext: %w", err)
+		}
+		hash = auditHash(prevHash, entry, string(details))
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+	INSERT INTO audit_log (created_at, action, actor, target, room_id, ip, user_agent, details, prev_hash, hash)
+	VALUES (@created_at, @action, @actor, @target, @room_id, @ip, @user_agent, @details, @prev_hash, @hash)`,
+		sql.Named("created_at", entry.CreatedAt),
+		sql.Named("action", string(entry.Action)),
+		sql.Named("actor", entry.Actor),
+		sql.Named("target", entry.Target),
+		sql.Named("room_id", entry.RoomID),
+		sql.Named("ip", entry.IP),
+		sql.Named("user_agent", entry.UserAgent),
+		sql.Named("details", string(details)),
+		sql.Named("prev_hash", prevHash),
+		sql.Named("hash", hash)); err != nil {
+		return fmt.Errorf("ExecContext: %w", err)
+	}
+	return nil
+}
+
+// auditHash hashes the entry together with the hash of the entry before it.
+// details is the JSON the details are stored as.
+func auditHash(prevHash string, entry AuditEntry, details string) string {
+	h := sha256.New()
+	for _, field := range []string{
+		prevHash,
+		entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+		string(entry.Action),
+		entry.Actor,
+		entry.Target,
+		entry.RoomID,
+		entry.IP,
+		entry.UserAgent,
+		details,
+	} {
+		// length prefixes keep the boundaries between the fields unambiguous
+		h.Write([]byte(strconv.Itoa(len(field)) + ":" + field))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (l *SQLAuditLog) Query(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = 50
+	}
+
+	var conditions []string
+	args := []any{sql.Named("limit", filter.Limit), sql.Named("offset", filter.Offset)}
+	for _, f := range []struct {
+		column string
+		value  string
+	}{
+		{"actor", filter.Actor},
+		{"action", string(filter.Action)},
+		{"target", filter.Target},
+		{"room_id", filter.RoomID},
+		{"ip", filter.IP},
+	} {
+		if f.value != "" {
+			conditions = append(conditions, f.column+" = @"+f.column)
+			args = append(args, sql.Named(f.column, f.value))
+		}
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "created_at >= @since")
+		args = append(args, sql.Named("since", filter.Since.UTC()))
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "created_at < @until")
+		args = append(args, sql.Named("until", filter.Until.UTC()))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	rows, err := l.db.QueryContext(ctx, `
+	SELECT id, created_at, action, actor, target, room_id, ip, user_agent, details, prev_hash, hash
+	FROM audit_log `+where+`
+	ORDER BY id DESC LIMIT @limit OFFSET @offset`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("QueryContext: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		entry, _, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// scanAuditEntry scans a row of audit_log and also returns its details as they are stored.
+func scanAuditEntry(rows *sql.Rows) (AuditEntry, string, error) {
+	var entry AuditEntry
+	var details string
+	if err := rows.Scan(&entry.ID, &entry.CreatedAt, &entry.Action, &entry.Actor, &entry.Target, &entry.RoomID,
+		&entry.IP, &entry.UserAgent, &details, &entry.PrevHash, &entry.Hash); err != nil {
+		return entry, "", fmt.Errorf("rows.Scan: %w", err)
+	}
+	entry.CreatedAt = entry.CreatedAt.UTC()
+	if err := json.Unmarshal([]byte(details), &entry.Details); err != nil {
+		return entry, "", fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	return entry, details, nil
+}
+
+func (l *SQLAuditLog) Purge(ctx context.Context, before time.Time) (int, error) {
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	// the log is only cut at its start, so that what remains is still a whole chain:
+	// entries from the first one created at or after before are kept
+	var keep sql.NullInt64
+	if err := tx.QueryRowContext(ctx, `SELECT MIN(id) FROM audit_log WHERE created_at >= @before`,
+		sql.Named("before", before.UTC())).Scan(&keep); err != nil {
+		return 0, fmt.Errorf("QueryRowContext: %w", err)
+	}
+	if !keep.Valid {
+		keep.Int64 = math.MaxInt64
+	}
+
+	// the first remaining entry is chained to the last removed one, which Verify trusts through the purge entry
+	var lastHash string
+	if err := tx.QueryRowContext(ctx, `
+	SELECT hash FROM audit_log WHERE id < @keep AND hash <> '' ORDER BY id DESC LIMIT 1`,
+		sql.Named("keep", keep.Int64)).Scan(&lastHash); err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("QueryRowContext: %w", err)
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM audit_log WHERE id < @keep`, sql.Named("keep", keep.Int64))
+	if err != nil {
+		return 0, fmt.Errorf("ExecContext: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("RowsAffected: %w", err)
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	details := map[string]string{
+		"entries": strconv.FormatInt(n, 10),
+		"before":  before.UTC().Format(time.RFC3339),
+	}
+	if lastHash != "" {
+		details["last_hash"] = lastHash
+	}
+	if err := l.appendTx(ctx, tx, AuditEntry{Action: AuditPurged, Details: details}); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("Commit: %w", err)
+	}
+	return int(n), nil
+}
+
+// Verify walks the chain from the oldest chained entry. That entry must start the chain
+// or follow an entry whose removal was recorded by Purge, and every entry after it must be chained.
+func (l *SQLAuditLog) Verify(ctx context.Context) (*AuditVerification, error) {
+	anchors, err := l.purgedHashes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := l.db.QueryContext(ctx, `
+	SELECT id, created_at, action, actor, target, room_id, ip, user_agent, details, prev_hash, hash
+	FROM audit_log ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("QueryContext: %w", err)
+	}
+	defer rows.Close()
+
+	verification := &AuditVerification{Valid: true}
+	chained := false
+	var lastHash string
+	for rows.Next() {
+		entry, details, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		// entries recorded before chaining was enabled are not on the chain
+		if entry.Hash == "" && !chained {
+			continue
+		}
+		verification.Entries++
+		linked := entry.PrevHash == lastHash
+		if !chained {
+			linked = anchors[entry.PrevHash]
+		}
+		if entry.Hash == "" || !linked || auditHash(entry.PrevHash, entry, details) != entry.Hash {
+			verification.Valid = false
+			verification.BrokenAt = entry.ID
+			return verification, nil
+		}
+		chained = true
+		lastHash = entry.Hash
+	}
+	return verification, rows.Err()
+}
+
+// purgedHashes returns the hashes the chain may start from: none, or that of an entry removed by Purge.
+func (l *SQLAuditLog) purgedHashes(ctx context.Context) (map[string]bool, error) {
+	rows, err := l.db.QueryContext(ctx, `SELECT details FROM audit_log WHERE action = @action`,
+		sql.Named("action", string(AuditPurged)))
+	if err != nil {
+		return nil, fmt.Errorf("QueryContext: %w", err)
+	}
+	defer rows.Close()
+
+	hashes := map[string]bool{"": true}
+	for rows.Next() {
+		var details string
+		if err := rows.Scan(&details); err != nil {
+			return nil, fmt.Errorf("rows.Scan: %w", err)
+		}
+		var purge map[string]string
+		if err := json.Unmarshal([]byte(details), &purge); err != nil {
+			return nil, fmt.Errorf("json.Unmarshal: %w", err)
+		}
+		if hash := purge["last_hash"]; hash != "" {
+			hashes[hash] = true
+		}
+	}
+	return hashes, rows.Err()
+}
@@ -0,0 +1,253 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func auditActions(entries []AuditEntry) []AuditAction {
+	actions := make([]AuditAction, len(entries))
+	for i, entry := range entries {
+		actions[i] = entry.Action
+	}
+	return actions
+}
+
+func TestAuditLog(t *testing.T) {
+	t.Run("record and query", func(t *testing.T) {
+		f := NewBaseFixture(t)
+		defer f.tearDown()
+		log := NewSQLAuditLog(f.db, false)
+
+		ctx := ContextWithUserAgent(ContextWithClientIP(ContextWithActor(f.ctx, "admin"), "10.0.0.1"), "curl/8.0")
+		yesterday := time.Now().Add(-24 * time.Hour)
+		require.Nil(t, log.Record(ctx, AuditEntry{Action: AuditUserDeactivated, Target: "alice", CreatedAt: yesterday}))
+		require.Nil(t, log.Record(ctx, AuditEntry{Action: AuditPasswordSet, Target: "bob",
+			Details: map[string]string{"reason": "support"}}))
+		require.Nil(t, log.Record(f.ctx, AuditEntry{Action: AuditSignInFailed, Target: "bob"}))
+
+		entries, err := log.Query(f.ctx, AuditFilter{})
+		require.Nil(t, err)
+		assert.Equal(t, []AuditAction{AuditSignInFailed, AuditPasswordSet, AuditUserDeactivated}, auditActions(entries))
+		assert.Equal(t, "admin", entries[1].Actor)
+		assert.Equal(t, "10.0.0.1", entries[1].IP)
+		assert.Equal(t, "curl/8.0", entries[1].UserAgent)
+		assert.Equal(t, map[string]string{"reason": "support"}, entries[1].Details)
+		assert.Equal(t, "", entries[0].Actor)
+		assert.Empty(t, entries[0].Hash)
+
+		entries, err = log.Query(f.ctx, AuditFilter{Target: "bob", Actor: "admin"})
+		require.Nil(t, err)
+		assert.Equal(t, []AuditAction{AuditPasswordSet}, auditActions(entries))
+
+		entries, err = log.Query(f.ctx, AuditFilter{Until: time.Now().Add(-time.Hour)})
+		require.Nil(t, err)
+		assert.Equal(t, []AuditAction{AuditUserDeactivated}, auditActions(entries))
+
+		entries, err = log.Query(f.ctx, AuditFilter{Since: time.Now().Add(-time.Hour), Limit: 1, Offset: 1})
+		require.Nil(t, err)
+		assert.Equal(t, []AuditAction{AuditPasswordSet}, auditActions(entries))
+	})
+
+	t.Run("entries cannot be changed", func(t *testing.T) {
+		f := NewBaseFixture(t)
+		defer f.tearDown()
+		log := NewSQLAuditLog(f.db, false)
+		require.Nil(t, log.Record(f.ctx, AuditEntry{Action: AuditSignIn, Actor: "alice"}))
+
+		_, err := f.db.ExecContext(f.ctx, "UPDATE audit_log SET actor = 'mallory'")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("verify detects removed entries", func(t *testing.T) {
+		f := NewBaseFixture(t)
+		defer f.tearDown()
+		log := NewSQLAuditLog(f.db, true)
+		for _, username := range []string{"alice", "bob", "carol"} {
+			require.Nil(t, log.Record(f.ctx, AuditEntry{Action: AuditSignIn, Actor: username}))
+		}
+
+		verification, err := log.Verify(f.ctx)
+		require.Nil(t, err)
+		assert.Equal(t, &AuditVerification{Entries: 3, Valid: true}, verification)
+
+		entries, err := log.Query(f.ctx, AuditFilter{})
+		require.Nil(t, err)
+		assert.Equal(t, entries[1].Hash, entries[0].PrevHash)
+		_, err = f.db.ExecContext(f.ctx, "DELETE FROM audit_log WHERE id = ?", entries[1].ID)
+		require.Nil(t, err)
+
+		verification, err = log.Verify(f.ctx)
+		require.Nil(t, err)
+		assert.False(t, verification.Valid)
+		assert.Equal(t, entries[0].ID, verification.BrokenAt)
+	})
+
+	t.Run("verify detects entries removed from the start", func(t *testing.T) {
+		f := NewBaseFixture(t)
+		defer f.tearDown()
+		log := NewSQLAuditLog(f.db, true)
+		for _, username := range []string{"alice", "bob"} {
+			require.Nil(t, log.Record(f.ctx, AuditEntry{Action: AuditSignIn, Actor: username}))
+		}
+
+		entries, err := log.Query(f.ctx, AuditFilter{})
+		require.Nil(t, err)
+		_, err = f.db.ExecContext(f.ctx, "DELETE FROM audit_log WHERE id = ?", entries[1].ID)
+		require.Nil(t, err)
+
+		verification, err := log.Verify(f.ctx)
+		require.Nil(t, err)
+		assert.False(t, verification.Valid)
+		assert.Equal(t, entries[0].ID, verification.BrokenAt)
+	})
+
+	t.Run("verify detects unchained entries", func(t *testing.T) {
+		f := NewBaseFixture(t)
+		defer f.tearDown()
+		// entries from before chaining was enabled are skipped
+		require.Nil(t, NewSQLAuditLog(f.db, false).Record(f.ctx, AuditEntry{Action: AuditSignIn, Actor: "alice"}))
+		log := NewSQLAuditLog(f.db, true)
+		require.Nil(t, log.Record(f.ctx, AuditEntry{Action: AuditSignIn, Actor: "bob"}))
+		verification, err := log.Verify(f.ctx)
+		require.Nil(t, err)
+		assert.Equal(t, &AuditVerification{Entries: 1, Valid: true}, verification)
+
+		// but one after the chain has started is not taken on trust
+		require.Nil(t, NewSQLAuditLog(f.db, false).Record(f.ctx, AuditEntry{Action: AuditSignIn, Actor: "mallory"}))
+		entries, err := log.Query(f.ctx, AuditFilter{})
+		require.Nil(t, err)
+		verification, err = log.Verify(f.ctx)
+		require.Nil(t, err)
+		assert.False(t, verification.Valid)
+		assert.Equal(t, entries[0].ID, verification.BrokenAt)
+	})
+
+	t.Run("verify detects forged entries", func(t *testing.T) {
+		f := NewBaseFixture(t)
+		defer f.tearDown()
+		log := NewSQLAuditLog(f.db, true)
+		require.Nil(t, log.Record(f.ctx, AuditEntry{Action: AuditSignIn, Actor: "alice"}))
+		entries, err := log.Query(f.ctx, AuditFilter{})
+		require.Nil(t, err)
+
+		// a forger knows the previous hash but not what the entry was hashed with
+		_, err = f.db.ExecContext(f.ctx, `
+		INSERT INTO audit_log (created_at, action, actor, target, room_id, ip, user_agent, details, prev_hash, hash)
+		VALUES (?, 'auth.signin', 'mallory', '', '', '', '', '{}', ?, ?)`,
+			time.Now().UTC(), entries[0].Hash, entries[0].Hash)
+		require.Nil(t, err)
+
+		verification, err := log.Verify(f.ctx)
+		require.Nil(t, err)
+		assert.False(t, verification.Valid)
+		assert.NotZero(t, verification.BrokenAt)
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		f := NewBaseFixture(t)
+		defer f.tearDown()
+		log := NewSQLAuditLog(f.db, true)
+		old := time.Now().Add(-48 * time.Hour)
+		require.Nil(t, log.Record(f.ctx, AuditEntry{Action: AuditSignIn, Actor: "alice", CreatedAt: old}))
+		require.Nil(t, log.Record(f.ctx, AuditEntry{Action: AuditSignIn, Actor: "bob", CreatedAt: old}))
+		require.Nil(t, log.Record(f.ctx, AuditEntry{Action: AuditSignIn, Actor: "carol"}))
+
+		purged, err := log.Query(f.ctx, AuditFilter{Actor: "bob"})
+		require.Nil(t, err)
+
+		n, err := log.Purge(f.ctx, time.Now().Add(-24*time.Hour))
+		require.Nil(t, err)
+		assert.Equal(t, 2, n)
+
+		entries, err := log.Query(f.ctx, AuditFilter{})
+		require.Nil(t, err)
+		assert.Equal(t, []AuditAction{AuditPurged, AuditSignIn}, auditActions(entries))
+		assert.Equal(t, "2", entries[0].Details["entries"])
+		assert.Equal(t, purged[0].Hash, entries[0].Details["last_hash"])
+
+		// the oldest remaining entry continues from the last purged one
+		verification, err := log.Verify(f.ctx)
+		require.Nil(t, err)
+		assert.Equal(t, &AuditVerification{Entries: 2, Valid: true}, verification)
+
+		n, err = log.Purge(f.ctx, time.Now().Add(-24*time.Hour))
+		require.Nil(t, err)
+		assert.Equal(t, 0, n)
+	})
+
+	t.Run("purge only removes the start of the log", func(t *testing.T) {
+		f := NewBaseFixture(t)
+		defer f.tearDown()
+		log := NewSQLAuditLog(f.db, true)
+		old := time.Now().Add(-48 * time.Hour)
+		require.Nil(t, log.Record(f.ctx, AuditEntry{Action: AuditSignIn, Actor: "alice", CreatedAt: old}))
+		require.Nil(t, log.Record(f.ctx, AuditEntry{Action: AuditSignIn, Actor: "bob"}))
+		require.Nil(t, log.Record(f.ctx, AuditEntry{Action: AuditSignIn, Actor: "carol", CreatedAt: old}))
+
+		n, err := log.Purge(f.ctx, time.Now().Add(-24*time.Hour))
+		require.Nil(t, err)
+		assert.Equal(t, 1, n)
+
+		entries, err := log.Query(f.ctx, AuditFilter{Action: AuditSignIn})
+		require.Nil(t, err)
+		assert.Len(t, entries, 2)
+		verification, err := log.Verify(f.ctx)
+		require.Nil(t, err)
+		assert.True(t, verification.Valid)
+	})
+}
+
+func TestAuditedStores(t *testing.T) {
+	t.Run("membership and room deletes", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		log := NewSQLAuditLog(f.db, true)
+		f.chatStore = NewSQLChatStore(f.db, f.userStore, WithChatAuditLog(log))
+		room := seedRoomWithRoles(f)
+
+		ctx := ContextWithActor(f.ctx, owner.Username)
+		require.Nil(t, f.chatStore.UpdateMemberRole(ctx, room.ID, member1.Username, Admin))
+		require.Nil(t, f.chatStore.RemoveRoomMember(ctx, room.ID, member1.Username))
+		require.Nil(t, f.chatStore.ForceDeleteRoom(ctx, room.ID))
+
+		entries, err := log.Query(f.ctx, AuditFilter{RoomID: room.ID})
+		require.Nil(t, err)
+		assert.Equal(t, []AuditAction{
+			AuditRoomDeleted, AuditMemberRemoved, AuditRoleChanged, AuditMemberAdded, AuditMemberAdded,
+		}, auditActions(entries))
+		assert.Equal(t, owner.Username, entries[0].Actor)
+		assert.Equal(t, member1.Username, entries[1].Target)
+		assert.Equal(t, map[string]string{"role": string(Admin), "previous_role": string(Member)}, entries[2].Details)
+
+		verification, err := log.Verify(f.ctx)
+		require.Nil(t, err)
+		assert.True(t, verification.Valid)
+	})
+
+	t.Run("sign ins and sign outs", func(t *testing.T) {
+		f := NewAuthFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, user)
+		log := NewSQLAuditLog(f.db, false)
+		authStore := NewSQLAuthStore(f.db, f.userStore, secret, WithAuditLog(log))
+
+		ctx := ContextWithClientIP(f.ctx, "10.0.0.2")
+		_, err := authStore.NewSession(ctx, user.Username, "wrong password")
+		assert.Equal(t, ErrBadCredentials, err)
+		session, err := authStore.NewSession(ctx, user.Username, user.Password)
+		require.Nil(t, err)
+		require.Nil(t, authStore.DestroySession(ctx, *session))
+
+		entries, err := log.Query(f.ctx, AuditFilter{Target: user.Username})
+		require.Nil(t, err)
+		assert.Equal(t, []AuditAction{AuditSignOut, AuditSignIn, AuditSignInFailed}, auditActions(entries))
+		assert.Equal(t, user.Username, entries[1].Actor)
+		assert.Equal(t, "", entries[2].Actor)
+		assert.Equal(t, "bad_credentials", entries[2].Details["reason"])
+		assert.Equal(t, "10.0.0.2", entries[2].IP)
+	})
+}
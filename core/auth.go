@@ -16,14 +16,76 @@ var (
 	ErrBadCredentials  = errors.New("invalid credentials")
 	ErrUnauthenticated = errors.New("unauthenticated")
 	ErrUnauthorized    = errors.New("unauthorized")
+	// ErrDeactivatedUser is returned when a deactivated user tries to sign in.
+	ErrDeactivatedUser = errors.New("user deactivated")
 )
 
 type AuthStore interface {
+	// NewSession authenticates the credentials and creates a session for the user.
+	// If none of the configured authenticators recognise the credentials, it returns ErrBadCredentials.
+	// If the user is deactivated, it returns ErrDeactivatedUser.
+	// Failed attempts are counted per username and per client IP address (see ContextWithClientIP).
+	// While either is blocked, it returns a *LockedError without checking the credentials.
+	// If the user has two-factor authentication enabled, no session is created and a *TOTPChallenge
+	// is returned as the error instead. It is completed with VerifyTOTPChallenge.
 	NewSession(ctx context.Context, username, password string) (sesion *Session, err error)
 
+	// IssueSession creates a session for a user that has already been authenticated elsewhere,
+	// for example by an external identity provider.
+	// If the user does not exist, it returns ErrInvalidUser.
+	// If the user is deactivated, it returns ErrDeactivatedUser.
+	IssueSession(ctx context.Context, username string) (*Session, error)
+
+	// ChangePassword changes the password of a signed in user after checking their current one.
+	// A wrong current password returns ErrBadCredentials and counts as a failed sign in,
+	// so it is throttled like NewSession and returns a *LockedError while blocked.
+	ChangePassword(ctx context.Context, username, currentPassword, newPassword string) error
+
+	// RevokeSessions invalidates every session of the user that has been issued so far.
+	RevokeSessions(ctx context.Context, username string) error
+
 	DestroySession(ctx context.Context, session Session) error
 
 	Session(ctx context.Context, token string) (payload *Session, err error)
+
+	// VerifyTOTPChallenge exchanges a challenge returned by NewSession and a TOTP or recovery code
+	// for a session. A challenge can only be used once.
+	// If the challenge is invalid or expired, it returns ErrUnauthenticated.
+	// If the code is wrong or has already been used, it returns ErrInvalidTOTPCode.
+	// Wrong codes count as failed attempts against the username like wrong passwords do,
+	// and a challenge is revoked after maxTOTPChallengeFailures of them.
+	VerifyTOTPChallenge(ctx context.Context, challenge, code string) (*Session, error)
+
+	// EnrollTOTP starts two-factor enrollment by generating a new secret and recovery codes.
+	// Enrollment is pending until it is confirmed with ConfirmTOTP and replaces any earlier
+	// pending enrollment.
+	// If the user already has two-factor authentication enabled, it returns ErrTOTPEnabled.
+	EnrollTOTP(ctx context.Context, username string) (*TOTPEnrollment, error)
+
+	// ConfirmTOTP enables two-factor authentication once the user proves their app works.
+	// If there is no pending enrollment, it returns ErrTOTPNotEnrolled.
+	// If the code is wrong, it returns ErrInvalidTOTPCode.
+	ConfirmTOTP(ctx context.Context, username, code string) error
+
+	// DisableTOTP turns two-factor authentication off after checking a TOTP or recovery code.
+	// If it is not enabled, it returns ErrTOTPNotEnrolled.
+	// If the code is wrong, it returns ErrInvalidTOTPCode.
+	DisableTOTP(ctx context.Context, username, code string) error
+
+	// ResetTOTP removes two-factor authentication from a user without a code.
+	// It is meant for administrators helping users that lost their device and recovery codes.
+	ResetTOTP(ctx context.Context, username string) error
+
+	// GetLockouts returns the lockouts recorded since the given time, most recent first.
+	GetLockouts(ctx context.Context, since time.Time) ([]Lockout, error)
+
+	// Unlock forgets the failed attempts of a username or IP address and lifts any block on it.
+	Unlock(ctx context.Context, scope, subject string) error
+
+	// AllowPasswordReset counts a password reset request for the username from the client IP address.
+	// Requests are throttled like failed sign ins but separately from them.
+	// While the username or the address is blocked, it returns a *LockedError.
+	AllowPasswordReset(ctx context.Context, username string) error
 }
 
 type HttpAuth struct {
@@ -2,77 +2,288 @@ package core
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
 	"errors"
 	"fmt"
 	"time"
 )
 
-type SQLiteAuthStore struct {
-	tokenExp  time.Duration
-	secret    []byte
-	userStore UserStore
-	db        *sql.DB
+// SQLAuthStore is an AuthStore backed by a SQL database.
+type SQLAuthStore struct {
+	tokenExp       time.Duration
+	keyring        *Keyring
+	challengeKeys  *Keyring
+	userStore      UserStore
+	authenticators []Authenticator
+	userLockout    LockoutPolicy
+	ipLockout      LockoutPolicy
+	now            func() time.Time
+	auditLog       *SQLAuditLog
+	db             *DB
 }
 
-type AuthOptions func(*SQLiteAuthStore)
+type AuthOptions func(*SQLAuthStore)
 
 func WithTokenExp(exp time.Duration) AuthOptions {
-	return func(a *SQLiteAuthStore) {
+	return func(a *SQLAuthStore) {
 		a.tokenExp = exp
 	}
 }
 
-func NewSQLiteAuthStore(db *sql.DB, userStore UserStore, secret []byte, opts ...AuthOptions) *SQLiteAuthStore {
-	auth := &SQLiteAuthStore{
-		tokenExp:  time.Hour * 24,
-		secret:    secret,
-		userStore: userStore,
-		db:        db,
+// WithAuthenticators replaces the authenticators that NewSession tries in order.
+// The default is a single PasswordAuthenticator backed by the UserStore.
+func WithAuthenticators(authenticators ...Authenticator) AuthOptions {
+	return func(a *SQLAuthStore) {
+		a.authenticators = authenticators
 	}
+}
+
+// WithKeyring signs and verifies sessions with the keys of the keyring instead of the secret
+// passed to NewSQLAuthStore. TOTP challenges are still signed with a key derived from the secret,
+// so that they are never signed by a key published in the JWKS.
+func WithKeyring(keyring *Keyring) AuthOptions {
+	return func(a *SQLAuthStore) {
+		a.keyring = keyring
+	}
+}
+
+// WithLockoutPolicy replaces the policies that throttle failed sign ins
+// per username and per client IP address.
+func WithLockoutPolicy(user, ip LockoutPolicy) AuthOptions {
+	return func(a *SQLAuthStore) {
+		a.userLockout = user
+		a.ipLockout = ip
+	}
+}
+
+// WithClock replaces the clock used to issue sessions and to throttle failed sign ins. It is meant for tests.
+func WithClock(now func() time.Time) AuthOptions {
+	return func(a *SQLAuthStore) {
+		a.now = now
+	}
+}
+
+// WithAuditLog records sign ins, failed sign ins and sign outs in the audit log.
+func WithAuditLog(auditLog *SQLAuditLog) AuthOptions {
+	return func(a *SQLAuthStore) {
+		a.auditLog = auditLog
+	}
+}
+
+func NewSQLAuthStore(db *DB, userStore UserStore, secret []byte, opts ...AuthOptions) *SQLAuthStore {
+	auth := &SQLAuthStore{
+		tokenExp:      time.Hour * 24,
+		keyring:       &Keyring{keys: []*SigningKey{NewHMACKey("", secret)}, now: time.Now},
+		challengeKeys: newChallengeKeyring(secret),
+		userStore:     userStore,
+		userLockout:   DefaultLockoutPolicy,
+		ipLockout:     DefaultIPLockoutPolicy,
+		now:           time.Now,
+		db:            db,
+	}
+	auth.authenticators = []Authenticator{NewPasswordAuthenticator(userStore)}
 	for _, opt := range opts {
 		opt(auth)
 	}
 	return auth
 }
 
-func (a *SQLiteAuthStore) NewSession(ctx context.Context, username, password string) (*Session, error) {
+// newChallengeKeyring returns the keyring that signs TOTP challenges with a key derived from the secret.
+// Without a secret, a random key is used and challenges only work until the process exits.
+func newChallengeKeyring(secret []byte) *Keyring {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		rand.Read(secret)
+	}
+	key := NewHMACKey(totpChallengeKeyID, deriveKey(secret, totpChallengePurpose))
+	return &Keyring{keys: []*SigningKey{key}, now: time.Now}
+}
+
+func (a *SQLAuthStore) NewSession(ctx context.Context, username, password string) (*Session, error) {
+	session, err := a.newSession(ctx, username, password)
+	if err := a.recordSignIn(ctx, username, "password", session, err); err != nil {
+		return nil, err
+	}
+	return session, err
+}
+
+func (a *SQLAuthStore) newSession(ctx context.Context, username, password string) (*Session, error) {
+	attempt, err := a.beginAttempt(ctx, lockoutSubjects(ctx, LockoutScopeUsername, LockoutScopeIP, username))
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := a.authenticate(ctx, username, password)
+	if endErr := a.endAttempt(ctx, attempt, errors.Is(err, ErrBadCredentials)); endErr != nil {
+		return nil, fmt.Errorf("endAttempt: %w", endErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.clearFailures(ctx, username); err != nil {
+		return nil, fmt.Errorf("clearFailures: %w", err)
+	}
+
+	enabled, err := a.isTOTPEnabled(ctx, user.Username)
+	if err != nil {
+		return nil, fmt.Errorf("isTOTPEnabled: %w", err)
+	}
+	if enabled {
+		exp := time.Now().Add(totpChallengeExp)
+		claims := NewClaim(*user, exp)
+		claims.Purpose = totpChallengePurpose
+		// challenges are blacklisted once used, so two issued in the same second must differ
+		claims.ID, err = randomString(16)
+		if err != nil {
+			return nil, fmt.Errorf("generate challenge id: %w", err)
+		}
+		token, err := a.challengeKeys.Sign(claims)
+		if err != nil {
+			return nil, fmt.Errorf("creating challenge token: %w", err)
+		}
+		return nil, &TOTPChallenge{Token: token, ExpiresAt: exp}
+	}
+
+	return a.issueSession(ctx, *user)
+}
+
+// recordSignIn records the outcome of a sign in in the audit log.
+// Outcomes that are neither a session nor a refusal, such as a TOTP challenge, are not recorded.
+// A sign in that cannot be recorded fails.
+func (a *SQLAuthStore) recordSignIn(ctx context.Context, username, method string, session *Session, err error) error {
+	entry := AuditEntry{Action: AuditSignIn, Target: username, Details: map[string]string{"method": method}}
+	var locked *LockedError
+	switch {
+	case err == nil:
+		entry.Actor = session.Username
+		entry.Target = session.Username
+	case errors.Is(err, ErrBadCredentials):
+		entry.Details["reason"] = "bad_credentials"
+	case errors.Is(err, ErrInvalidTOTPCode):
+		entry.Details["reason"] = "invalid_totp_code"
+	case errors.Is(err, ErrDeactivatedUser):
+		entry.Details["reason"] = "deactivated"
+	case errors.As(err, &locked):
+		entry.Details["reason"] = "locked"
+		entry.Details["scope"] = locked.Scope
+	default:
+		return nil
+	}
+	if err != nil {
+		entry.Action = AuditSignInFailed
+	}
+	if err := a.auditLog.Record(ctx, entry); err != nil {
+		return fmt.Errorf("recordSignIn: %w", err)
+	}
+	return nil
+}
+
+// authenticate tries each authenticator in order and returns the first user that is resolved.
+// An authenticator that does not recognise the credentials falls through to the next one.
+func (a *SQLAuthStore) authenticate(ctx context.Context, username, password string) (*UserWithoutSecrets, error) {
+	for _, authenticator := range a.authenticators {
+		user, err := authenticator.Authenticate(ctx, username, password)
+		if err != nil {
+			if errors.Is(err, ErrBadCredentials) {
+				continue
+			}
+			return nil, fmt.Errorf("authenticate: %w", err)
+		}
+		return user, nil
+	}
+	return nil, ErrBadCredentials
+}
+
+func (a *SQLAuthStore) IssueSession(ctx context.Context, username string) (*Session, error) {
 	user, err := a.userStore.GetUserByUsername(ctx, username)
 	if err != nil {
 		return nil, fmt.Errorf("get user by username: %w", err)
 	}
 	if user == nil {
-		return nil, ErrBadCredentials
+		return nil, ErrInvalidUser
 	}
 
-	ok, err := a.userStore.ComparePassword(ctx, username, password)
-	if err != nil {
-		return nil, fmt.Errorf("compare password: %w", err)
+	return a.issueSession(ctx, *user)
+}
 
+// issueSession signs a session token that carries the current token version of the user.
+// Deactivated users cannot get a session.
+func (a *SQLAuthStore) issueSession(ctx context.Context, user UserWithoutSecrets) (*Session, error) {
+	version, deactivated, err := a.userStatus(ctx, user.Username)
+	if err != nil {
+		return nil, fmt.Errorf("userStatus: %w", err)
+	}
+	if deactivated {
+		return nil, ErrDeactivatedUser
 	}
 
-	if !ok {
-		return nil, ErrBadCredentials
+	exp := a.now().Add(a.tokenExp)
+	claims := NewClaim(user, exp)
+	claims.Version = version
+	t, err := a.keyring.Sign(claims)
+	if err != nil {
+		return nil, fmt.Errorf("creating token: %w", err)
 	}
 
-	t, exp, err := NewToken(*user, a.tokenExp, a.secret)
+	return &Session{Username: user.Username, ExpiresAt: exp, Token: t}, nil
+}
 
+func (a *SQLAuthStore) ChangePassword(ctx context.Context, username, currentPassword, newPassword string) error {
+	attempt, err := a.beginAttempt(ctx, lockoutSubjects(ctx, LockoutScopeUsername, LockoutScopeIP, username))
 	if err != nil {
-		return nil, fmt.Errorf("creating token: %w", err)
+		return err
 	}
 
-	return &Session{Username: username, ExpiresAt: exp, Token: t}, nil
+	err = a.userStore.ChangePassword(ctx, username, currentPassword, newPassword)
+	if endErr := a.endAttempt(ctx, attempt, errors.Is(err, ErrBadCredentials)); endErr != nil {
+		return fmt.Errorf("endAttempt: %w", endErr)
+	}
+	return err
+}
+
+// userStatus returns the token version of the user and whether they are deactivated.
+// A user that does not exist is reported as deactivated.
+func (a *SQLAuthStore) userStatus(ctx context.Context, username string) (int, bool, error) {
+	row := a.db.QueryRowContext(ctx,
+		"SELECT token_version, deactivated_at FROM users WHERE username = @username",
+		sql.Named("username", username))
+	var version int
+	var deactivatedAt sql.NullTime
+	if err := row.Scan(&version, &deactivatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, true, nil
+		}
+		return 0, false, fmt.Errorf("row.Scan: %w", err)
+	}
+	return version, deactivatedAt.Valid, nil
+}
+
+func (a *SQLAuthStore) RevokeSessions(ctx context.Context, username string) error {
+	_, err := a.db.ExecContext(ctx,
+		"UPDATE users SET token_version = token_version + 1 WHERE username = @username",
+		sql.Named("username", username))
+	if err != nil {
+		return fmt.Errorf("ExecContext: %w", err)
+	}
+	return nil
 }
 
-func (a *SQLiteAuthStore) DestroySession(ctx context.Context, session Session) error {
+func (a *SQLAuthStore) DestroySession(ctx context.Context, session Session) error {
 	if err := a.blacklistToken(ctx, session.Token); err != nil {
 		return fmt.Errorf("blacklisting token: %w", err)
 	}
+	if err := a.auditLog.Record(ctx, AuditEntry{
+		Action: AuditSignOut, Actor: session.Username, Target: session.Username,
+	}); err != nil {
+		return fmt.Errorf("Record: %w", err)
+	}
 
 	return nil
 }
 
-func (a *SQLiteAuthStore) unblacklistToken(ctx context.Context, token string) error {
+func (a *SQLAuthStore) unblacklistToken(ctx context.Context, token string) error {
 	_, err := a.db.ExecContext(ctx, "DELETE FROM blacklists WHERE token = @token", sql.Named("token", token))
 	if err != nil {
 		return err
@@ -80,7 +291,7 @@ func (a *SQLiteAuthStore) unblacklistToken(ctx context.Context, token string) er
 	return nil
 }
 
-func (a *SQLiteAuthStore) blacklistToken(ctx context.Context, token string) error {
+func (a *SQLAuthStore) blacklistToken(ctx context.Context, token string) error {
 	_, err := a.db.ExecContext(ctx, "INSERT INTO blacklists (token) VALUES (@token)", sql.Named("token", token))
 	if err != nil {
 		return err
@@ -88,7 +299,7 @@ func (a *SQLiteAuthStore) blacklistToken(ctx context.Context, token string) erro
 	return nil
 }
 
-func (a *SQLiteAuthStore) isBlacklisted(ctx context.Context, token string) (bool, error) {
+func (a *SQLAuthStore) isBlacklisted(ctx context.Context, token string) (bool, error) {
 	row := a.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM blacklists WHERE token = @token", sql.Named("token", token))
 	var count int
 	if err := row.Scan(&count); err != nil {
@@ -97,8 +308,8 @@ func (a *SQLiteAuthStore) isBlacklisted(ctx context.Context, token string) (bool
 	return count > 0, nil
 }
 
-func (a *SQLiteAuthStore) Session(ctx context.Context, t string) (session *Session, err error) {
-	claims, err := VerifyToken(t, a.secret)
+func (a *SQLAuthStore) Session(ctx context.Context, t string) (session *Session, err error) {
+	claims, err := a.keyring.Verify(t)
 	if err != nil {
 		if errors.Is(err, ErrTokenExpired) || errors.Is(err, ErrTokenInvalid) {
 			return nil, ErrUnauthenticated
@@ -106,6 +317,11 @@ func (a *SQLiteAuthStore) Session(ctx context.Context, t string) (session *Sessi
 		return nil, fmt.Errorf("verifying token: %w", err)
 	}
 
+	// tokens issued for a specific purpose, e.g. a TOTP challenge, are not sessions
+	if claims.Purpose != "" {
+		return nil, ErrUnauthenticated
+	}
+
 	isBlacklisted, err := a.isBlacklisted(ctx, t)
 	if err != nil {
 		return nil, fmt.Errorf("checking blacklist: %w", err)
@@ -115,6 +331,14 @@ func (a *SQLiteAuthStore) Session(ctx context.Context, t string) (session *Sessi
 		return nil, ErrUnauthenticated
 	}
 
+	version, deactivated, err := a.userStatus(ctx, claims.Username)
+	if err != nil {
+		return nil, fmt.Errorf("userStatus: %w", err)
+	}
+	if deactivated || version != claims.Version {
+		return nil, ErrUnauthenticated
+	}
+
 	session = &Session{
 		Username: claims.Username,
 		Token:    t,
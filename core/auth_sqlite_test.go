@@ -17,9 +17,9 @@ type AuthFixture struct {
 func NewAuthFixture(t *testing.T) *AuthFixture {
 	base := NewBaseFixture(t)
 
-	userStore := NewSqlieUserStore(base.db)
+	userStore := NewSQLUserStore(base.db)
 
-	authStore := NewSQLiteAuthStore(base.db, userStore, secret)
+	authStore := NewSQLAuthStore(base.db, userStore, secret)
 
 	return &AuthFixture{
 		userStore:   userStore,
@@ -98,7 +98,7 @@ func TestSession(t *testing.T) {
 		defer f.tearDown()
 		token, _, err := NewToken(
 			UserWithoutSecrets{Username: user.Username}, time.Hour, secret)
-		err = f.authStore.(*SQLiteAuthStore).blacklistToken(f.ctx, token)
+		err = f.authStore.(*SQLAuthStore).blacklistToken(f.ctx, token)
 		require.Nil(t, err)
 
 		session, err := f.authStore.Session(f.ctx, token)
@@ -145,3 +145,29 @@ func TestDestroySession(t *testing.T) {
 	require.NotNil(t, err)
 	assert.Equal(t, ErrUnauthenticated, err)
 }
+
+func TestIssueSession(t *testing.T) {
+	t.Run("existing user", func(t *testing.T) {
+		f := NewAuthFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, user)
+
+		session, err := f.authStore.IssueSession(f.ctx, user.Username)
+		require.Nil(t, err)
+		require.NotNil(t, session)
+		assert.Equal(t, user.Username, session.Username)
+
+		session, err = f.authStore.Session(f.ctx, session.Token)
+		require.Nil(t, err)
+		assert.Equal(t, user.Username, session.Username)
+	})
+
+	t.Run("user does not exist", func(t *testing.T) {
+		f := NewAuthFixture(t)
+		defer f.tearDown()
+
+		session, err := f.authStore.IssueSession(f.ctx, "random")
+		require.Nil(t, session)
+		assert.Equal(t, ErrInvalidUser, err)
+	})
+}
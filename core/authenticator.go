@@ -0,0 +1,42 @@
+package core
+
+import (
+	"context"
+	"fmt"
+)
+
+// Authenticator verifies a set of credentials and resolves the local user they belong to.
+type Authenticator interface {
+	// Authenticate returns the user identified by the credentials.
+	// If the credentials are not recognised, it returns ErrBadCredentials.
+	Authenticate(ctx context.Context, username, password string) (*UserWithoutSecrets, error)
+}
+
+// PasswordAuthenticator authenticates users against the passwords stored in the UserStore.
+type PasswordAuthenticator struct {
+	userStore UserStore
+}
+
+func NewPasswordAuthenticator(userStore UserStore) *PasswordAuthenticator {
+	return &PasswordAuthenticator{userStore: userStore}
+}
+
+func (a *PasswordAuthenticator) Authenticate(ctx context.Context, username, password string) (*UserWithoutSecrets, error) {
+	user, err := a.userStore.GetUserByUsername(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("get user by username: %w", err)
+	}
+	if user == nil {
+		return nil, ErrBadCredentials
+	}
+
+	ok, err := a.userStore.ComparePassword(ctx, username, password)
+	if err != nil {
+		return nil, fmt.Errorf("compare password: %w", err)
+	}
+	if !ok {
+		return nil, ErrBadCredentials
+	}
+
+	return user, nil
+}
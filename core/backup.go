@@ -0,0 +1,163 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrInvalidBackup is returned when a backup fails its integrity check or is not a chatter database.
+var ErrInvalidBackup = errors.New("invalid backup")
+
+// backupStepPages is how many pages are copied at a time, so that writers are not blocked for long.
+const backupStepPages = 1024
+
+// BackupSQLite copies the SQLite database db to a new database file at dest with the online backup API.
+// It is safe to call while the database is in use, including in WAL mode.
+// The copy is written next to dest and renamed when it is complete, so dest is never a partial backup.
+func BackupSQLite(ctx context.Context, db *sql.DB, dest string) error {
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("%s already exists", dest)
+	}
+	tmp := dest + ".tmp"
+	os.Remove(tmp)
+	if err := backupSQLite(ctx, db, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+func backupSQLite(ctx context.Context, db *sql.DB, dest string) error {
+	destDB, err := sql.Open("sqlite3", "file:"+dest+"?mode=rwc")
+	if err != nil {
+		return err
+	}
+	defer destDB.Close()
+
+	srcConn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("Conn: %w", err)
+	}
+	defer srcConn.Close()
+	destConn, err := destDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("Conn: %w", err)
+	}
+	defer destConn.Close()
+
+	return destConn.Raw(func(destRaw any) error {
+		return srcConn.Raw(func(srcRaw any) error {
+			backup, err := destRaw.(*sqlite3.SQLiteConn).Backup("main", srcRaw.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return fmt.Errorf("Backup: %w", err)
+			}
+			defer backup.Close()
+			for {
+				done, err := backup.Step(backupStepPages)
+				if err != nil {
+					return fmt.Errorf("Step: %w", err)
+				}
+				if done {
+					return backup.Finish()
+				}
+				// the source is busy or there are pages left, let others use it in between
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+		})
+	})
+}
+
+// RestoreSQLite replaces the SQLite database at file with a copy of the backup at src.
+// The backup must pass PRAGMA integrity_check and its schema must not be newer than migrations,
+// otherwise it returns ErrInvalidBackup or ErrSchemaTooNew and file is left alone.
+// If there is a database at file, it is backed up next to it first, and where is returned.
+// Nothing may be using the database while it is restored.
+func RestoreSQLite(ctx context.Context, file, src string, migrations fs.FS) (string, error) {
+	backup, err := NewSQLiteDB(src, migrations, &SQLiteDBOption{Mode: "ro"})
+	if err != nil {
+		return "", err
+	}
+	defer backup.Close()
+	if err := checkIntegrity(ctx, backup.DB); err != nil {
+		return "", err
+	}
+	migrator, err := backup.Migrator()
+	if err != nil {
+		return "", err
+	}
+	if version, _, err := migrator.Version(ctx); err != nil || version == 0 {
+		return "", fmt.Errorf("%w: it is not a chatter database", ErrInvalidBackup)
+	}
+	if err := migrator.Check(ctx); err != nil {
+		return "", err
+	}
+
+	var previous string
+	if _, err := os.Stat(file); err == nil {
+		current, err := NewSQLiteDB(file, migrations, &SQLiteDBOption{Mode: "ro"})
+		if err != nil {
+			return "", err
+		}
+		previous = fmt.Sprintf("%s.%s.bak", file, time.Now().UTC().Format("20060102T150405.000000000"))
+		err = BackupSQLite(ctx, current.DB, previous)
+		current.Close()
+		if err != nil {
+			return "", fmt.Errorf("back up %s: %w", file, err)
+		}
+	}
+
+	tmp := file + ".restore"
+	os.Remove(tmp)
+	if err := BackupSQLite(ctx, backup.DB, tmp); err != nil {
+		return previous, fmt.Errorf("copy %s: %w", src, err)
+	}
+	// the write-ahead log of the replaced database must not be applied to the restored one
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(file + suffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			os.Remove(tmp)
+			return previous, err
+		}
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return previous, err
+	}
+	return previous, nil
+}
+
+func checkIntegrity(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var problem string
+		if err := rows.Scan(&problem); err != nil {
+			return fmt.Errorf("Scan: %w", err)
+		}
+		if problem != "ok" {
+			problems = append(problems, problem)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidBackup, strings.Join(problems, "; "))
+	}
+	return nil
+}
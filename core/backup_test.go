@@ -0,0 +1,99 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/putto11262002/chatter/migrations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSQLiteFile(t *testing.T, file string) *SQLiteDB {
+	db, err := NewSQLiteDB(file, migrations.SQLite, &SQLiteDBOption{Mode: "rwc", JournalMode: "WAL", ForeignKeys: true})
+	require.Nil(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.Nil(t, db.Migrate())
+	return db
+}
+
+func countUsers(t *testing.T, file string) int {
+	db, err := NewSQLiteDB(file, migrations.SQLite, &SQLiteDBOption{Mode: "ro"})
+	require.Nil(t, err)
+	defer db.Close()
+	var count int
+	require.Nil(t, db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count))
+	return count
+}
+
+func TestBackupSQLite(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	db := newSQLiteFile(t, filepath.Join(dir, "chatter.db"))
+	users := NewSQLUserStore(NewReadWriteDB(db.Readers, db.DB, SQLite))
+	require.Nil(t, users.CreateUser(ctx, User{Username: "alice", Password: "password", Name: "Alice"}))
+
+	dest := filepath.Join(dir, "backup.db")
+	require.Nil(t, db.Backup(ctx, dest))
+	assert.Equal(t, 1, countUsers(t, dest))
+	_, err := os.Stat(dest + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+
+	// backups never overwrite a file
+	assert.NotNil(t, db.Backup(ctx, dest))
+}
+
+func TestRestoreSQLite(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "chatter.db")
+	db := newSQLiteFile(t, file)
+	users := NewSQLUserStore(NewReadWriteDB(db.Readers, db.DB, SQLite))
+	require.Nil(t, users.CreateUser(ctx, User{Username: "alice", Password: "password", Name: "Alice"}))
+	backup := filepath.Join(dir, "backup.db")
+	require.Nil(t, db.Backup(ctx, backup))
+	require.Nil(t, users.CreateUser(ctx, User{Username: "bob", Password: "password", Name: "Bob"}))
+	require.Nil(t, db.Close())
+
+	t.Run("restore", func(t *testing.T) {
+		previous, err := RestoreSQLite(ctx, file, backup, migrations.SQLite)
+		require.Nil(t, err)
+		assert.Equal(t, 1, countUsers(t, file))
+		// the replaced database is kept
+		assert.Equal(t, 2, countUsers(t, previous))
+	})
+
+	t.Run("corrupt backup", func(t *testing.T) {
+		corrupt := filepath.Join(dir, "corrupt.db")
+		require.Nil(t, os.WriteFile(corrupt, []byte("not a database"), 0o644))
+		_, err := RestoreSQLite(ctx, file, corrupt, migrations.SQLite)
+		assert.ErrorIs(t, err, ErrInvalidBackup)
+		assert.Equal(t, 1, countUsers(t, file))
+	})
+
+	t.Run("not a chatter database", func(t *testing.T) {
+		other := filepath.Join(dir, "other.db")
+		otherDB, err := NewSQLiteDB(other, migrations.SQLite, &SQLiteDBOption{Mode: "rwc"})
+		require.Nil(t, err)
+		_, err = otherDB.Exec("CREATE TABLE things (id INTEGER PRIMARY KEY)")
+		require.Nil(t, err)
+		otherDB.Close()
+
+		_, err = RestoreSQLite(ctx, file, other, migrations.SQLite)
+		assert.ErrorIs(t, err, ErrInvalidBackup)
+	})
+
+	t.Run("schema newer than the binary", func(t *testing.T) {
+		newer := filepath.Join(dir, "newer.db")
+		newerDB := newSQLiteFile(t, newer)
+		_, err := newerDB.Exec("INSERT INTO goose_db_version (version_id, is_applied) VALUES (99991231000000, TRUE)")
+		require.Nil(t, err)
+		newerDB.Close()
+
+		_, err = RestoreSQLite(ctx, file, newer, migrations.SQLite)
+		assert.ErrorIs(t, err, ErrSchemaTooNew)
+		assert.Equal(t, 1, countUsers(t, file))
+	})
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrBlobNotFound is returned when no blob is stored under a key.
+	ErrBlobNotFound = errors.New("blob not found")
+	// ErrBlobTooLarge is returned when a blob is larger than the limit of the store.
+	ErrBlobTooLarge = errors.New("blob too large")
+)
+
+// BlobStore stores opaque files, such as avatars, under generated keys.
+type BlobStore interface {
+	// Put stores the content of r and returns the key of the blob.
+	// If the content is larger than the limit of the store, it returns ErrBlobTooLarge.
+	Put(ctx context.Context, r io.Reader) (string, error)
+	// Open returns the content of the blob. The caller must close it.
+	// If the blob does not exist, it returns ErrBlobNotFound.
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Delete removes the blob. Deleting a blob that does not exist is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// LocalBlobStore keeps blobs as files in a directory.
+type LocalBlobStore struct {
+	dir     string
+	maxSize int64
+}
+
+// NewLocalBlobStore creates a blob store in dir, which is created if it does not exist.
+// Blobs larger than maxSize bytes are refused; zero means no limit.
+func NewLocalBlobStore(dir string, maxSize int64) (*LocalBlobStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("MkdirAll: %w", err)
+	}
+	return &LocalBlobStore{dir: dir, maxSize: maxSize}, nil
+}
+
+func (s *LocalBlobStore) Put(ctx context.Context, r io.Reader) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("rand.Read: %w", err)
+	}
+	key := hex.EncodeToString(b)
+
+	// write to a temporary file first so a failed upload never leaves a partial blob behind
+	tmp, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return "", fmt.Errorf("CreateTemp: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	src := r
+	if s.maxSize > 0 {
+		src = io.LimitReader(r, s.maxSize+1)
+	}
+	n, err := io.Copy(tmp, src)
+	if err != nil {
+		return "", fmt.Errorf("io.Copy: %w", err)
+	}
+	if s.maxSize > 0 && n > s.maxSize {
+		return "", ErrBlobTooLarge
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("Close: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path(key)); err != nil {
+		return "", fmt.Errorf("Rename: %w", err)
+	}
+	return key, nil
+}
+
+func (s *LocalBlobStore) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	if !validBlobKey(key) {
+		return nil, ErrBlobNotFound
+	}
+	f, err := os.Open(s.path(key))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrBlobNotFound
+		}
+		return nil, fmt.Errorf("Open: %w", err)
+	}
+	return f, nil
+}
+
+func (s *LocalBlobStore) Delete(ctx context.Context, key string) error {
+	if !validBlobKey(key) {
+		return nil
+	}
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("Remove: %w", err)
+	}
+	return nil
+}
+
+func (s *LocalBlobStore) path(key string) string {
+	return filepath.Join(s.dir, key)
+}
+
+// validBlobKey rejects keys that were not generated by Put, so that a key can never escape the directory.
+func validBlobKey(key string) bool {
+	if len(key) != 32 {
+		return false
+	}
+	return strings.Trim(key, "0123456789abcdef") == ""
+}
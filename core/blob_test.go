@@ -0,0 +1,37 @@
+package core
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalBlobStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalBlobStore(t.TempDir(), 8)
+	require.Nil(t, err)
+
+	key, err := store.Put(ctx, strings.NewReader("avatar"))
+	require.Nil(t, err)
+
+	blob, err := store.Open(ctx, key)
+	require.Nil(t, err)
+	content, err := io.ReadAll(blob)
+	blob.Close()
+	require.Nil(t, err)
+	assert.Equal(t, "avatar", string(content))
+
+	_, err = store.Put(ctx, strings.NewReader("too large"))
+	assert.Equal(t, ErrBlobTooLarge, err)
+
+	require.Nil(t, store.Delete(ctx, key))
+	_, err = store.Open(ctx, key)
+	assert.Equal(t, ErrBlobNotFound, err)
+
+	_, err = store.Open(ctx, "../chatter.db")
+	assert.Equal(t, ErrBlobNotFound, err)
+}
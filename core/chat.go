@@ -20,6 +20,9 @@ const (
 	// TextMessage indicates that the message is a text message.
 	// The Data field of the message should be interpreted as a UTF-8 encoded string.
 	TextMessage
+	// SystemMessage indicates that the message records a change to the room, such as a rename.
+	// The Data field of the message is a JSON encoded SystemMessageBody.
+	SystemMessage
 )
 
 // ChatType represents the type of a chat room.
@@ -37,6 +40,24 @@ const (
 	Member MemberRole = "member"
 )
 
+// RoomVisibility controls who can find and join a room.
+type RoomVisibility string
+
+const (
+	// RoomPrivate rooms can only be seen by their members. It is the default.
+	RoomPrivate RoomVisibility = "private"
+	// RoomPublicListed rooms are listed in the directory and can be previewed by anyone,
+	// but users still have to be added or invited.
+	RoomPublicListed RoomVisibility = "public_listed"
+	// RoomPublicJoin rooms are listed in the directory and anyone can join them.
+	RoomPublicJoin RoomVisibility = "public_join"
+)
+
+// Public reports whether the room is listed in the directory and can be previewed by non-members.
+func (v RoomVisibility) Public() bool {
+	return v == RoomPublicListed || v == RoomPublicJoin
+}
+
 // RoomUser represents a user in a chat room.
 // It is used to store additional information about the room that is specific to the user.
 type RoomMember struct {
@@ -48,12 +69,35 @@ type RoomMember struct {
 
 // Room represents a chat room.
 type Room struct {
-	ID                  string       `json:"id"`
-	Members             []RoomMember `json:"members"`
-	Name                string       `json:"name"`
-	LastMessageSentAt   time.Time    `json:"last_message_sent_at"`
-	LastMessageSent     int          `json:"last_message_sent"`
-	LastMessageSentData string       `json:"last_message_sent_data"`
+	ID          string       `json:"id"`
+	Members     []RoomMember `json:"members"`
+	Name        string       `json:"name"`
+	Topic       string       `json:"topic"`
+	Description string       `json:"description"`
+	// Avatar is the key of the avatar image in the blob store. It is empty if the room has no avatar.
+	Avatar string `json:"avatar"`
+	// ArchivedAt is when the room was archived, or nil if it is not. Archived rooms are read-only.
+	ArchivedAt *time.Time `json:"archived_at"`
+	// Visibility controls who can find and join the room.
+	Visibility          RoomVisibility `json:"visibility"`
+	LastMessageSentAt   time.Time      `json:"last_message_sent_at"`
+	LastMessageSent     int            `json:"last_message_sent"`
+	LastMessageSentData string         `json:"last_message_sent_data"`
+	// LastMessageSentType tells how LastMessageSentData should be interpreted.
+	LastMessageSentType MessageType `json:"last_message_sent_type"`
+	// Preferences are those of the user the room was listed for by GetUserRooms.
+	Preferences *RoomPreferences `json:"preferences,omitempty"`
+}
+
+// DirectoryEntry is a public room as listed in the room directory.
+type DirectoryEntry struct {
+	ID          string         `json:"id"`
+	Name        string         `json:"name"`
+	Topic       string         `json:"topic"`
+	Description string         `json:"description"`
+	Avatar      string         `json:"avatar"`
+	Visibility  RoomVisibility `json:"visibility"`
+	MemberCount int            `json:"member_count"`
 }
 
 // RoomSummary represents a summary of a chat room from the perspective of a member.
@@ -90,6 +134,12 @@ var (
 	ErrInsufficientUsers   = errors.New("insufficient users")
 	ErrDisAllowedOperation = errors.New("disallowed operation")
 	ErrInvalidMember       = errors.New("invalid member")
+	// ErrSuccessorRequired is returned when the owner leaves a room without naming a new owner.
+	ErrSuccessorRequired = errors.New("successor required")
+	// ErrArchivedRoom is returned when a room is changed while it is archived.
+	ErrArchivedRoom = errors.New("archived room")
+	// ErrInvalidRoomInput is returned when the metadata of a room is invalid.
+	ErrInvalidRoomInput = errors.New("invalid room input")
 )
 
 // MessageCreateInput represents the input for creating a message.
@@ -105,6 +155,23 @@ func (m *MessageCreateInput) Validate() error {
 	return validate.Struct(m)
 }
 
+// UpdateRoomInput represents the input for updating the metadata of a room.
+// Only the fields that are not nil are updated.
+type UpdateRoomInput struct {
+	Name        *string         `json:"name" validate:"omitnil,min=1,max=100"`
+	Topic       *string         `json:"topic" validate:"omitnil,max=250"`
+	Description *string         `json:"description" validate:"omitnil,max=2000"`
+	Visibility  *RoomVisibility `json:"visibility" validate:"omitnil,oneof=private public_listed public_join"`
+	// Avatar is the key of an image in the blob store. It is set through the avatar upload,
+	// so it cannot be pointed at an arbitrary blob by clients.
+	Avatar *string `json:"-"`
+}
+
+// Validate validates the room input.
+func (u *UpdateRoomInput) Validate() error {
+	return validate.Struct(u)
+}
+
 type ChatStore interface {
 
 	// CreateRoom creates a chat room with the given name and users.
@@ -112,14 +179,27 @@ type ChatStore interface {
 	// If the number of users is less than 2, it returns ErrInvalidUser.
 	// If there are duplicate users, it is deduplicated.
 	// If the error is nil, it returns the ID of the created room.
+	// The creation is recorded as a SystemRoomCreated message.
 	CreateRoom(ctx context.Context, name, owner string) (string, error)
 
+	// AddRoomMember adds the user to the room with the role and records a SystemMemberAdded message
+	// attributed to the actor attached with ContextWithActor, or to the user if there is none.
+	// Adding a user who is already a member does nothing.
 	AddRoomMember(ctx context.Context, roomID string, user string, role MemberRole) error
 
+	// RemoveRoomMember removes the user from the room and records a SystemMemberRemoved message
+	// attributed to the actor attached with ContextWithActor, or to nobody if there is none.
+	// If the actor is the user themselves, a SystemMemberLeft message is recorded instead.
+	// The owner cannot be removed, it returns ErrDisAllowedOperation.
 	RemoveRoomMember(ctx context.Context, roomID string, user string) error
 
+	// GetUserRooms returns the rooms of the user that are not archived, with the preferences of the user.
+	// Pinned rooms come first, then rooms by their sort position, then by the last message sent.
 	GetUserRooms(ctx context.Context, user string, offset, litmit int) ([]Room, error)
 
+	// GetArchivedUserRooms returns the archived rooms of the user, in the same order as GetUserRooms.
+	GetArchivedUserRooms(ctx context.Context, user string, offset, limit int) ([]Room, error)
+
 	// GetRoomByID returns the room with the given ID.
 	// If the room is not found, it returns nil.
 	GetRoomByID(ctx context.Context, roomID string) (*Room, error)
@@ -133,6 +213,7 @@ type ChatStore interface {
 
 	// SendMessageToRoom sends a message to the room.
 	// If the user is not a member of the room, it returns ErrInvalidRoom.
+	// If the user does not have the PostMessages permission, it returns ErrDisAllowedOperation.
 	// If the message type is not supported, it returns ErrInvaidMessageType.
 	// If the message is invalid, it returns ErrInvalidMessage.
 	// The validity of the message is determined by the MessageCreateInput.Validate method.
@@ -140,7 +221,18 @@ type ChatStore interface {
 	// has read all previous messages in the room.
 	SendMessageToRoom(ctx context.Context, message MessageCreateInput) (*Message, error)
 
-	// GetRoomMessages returns a list of messages in the room ordered in descending order of sent_at.
+	// DeleteMessage deletes a message from the room.
+	// Members can delete their own messages, deleting the messages of others needs DeleteOthersMessages.
+	// If the user is not a member of the room, it returns ErrInvalidRoom.
+	// If the message is not in the room, it returns ErrInvalidMessage.
+	// If the user may not delete the message, it returns ErrDisAllowedOperation.
+	// System messages cannot be deleted, it returns ErrDisAllowedOperation.
+	// If the room is archived, it returns ErrArchivedRoom.
+	DeleteMessage(ctx context.Context, roomID string, messageID int, username string) error
+
+	// GetRoomMessages returns a page of the messages in the room. Pages go back in time
+	// from the most recent message, and the messages of a page are in the order they were sent.
+	// System messages recording changes to the room are included.
 	// Reading offset and limit can be specified to paginate the results.
 	// If the limit is a zero value, the limit is set to 100.
 	GetRoomMessages(ctx context.Context, roomID string, offset, limit int) ([]Message, error)
@@ -162,4 +254,242 @@ type ChatStore interface {
 
 	// AreFriends returns true if the two users are friends.
 	AreFriends(ctx context.Context, user1, user2 string) (bool, error)
+
+	// Authorize checks that the user is a member of the room whose role has the permission
+	// and returns their role.
+	// If the user is not a member of the room, it returns ErrInvalidRoom.
+	// If the room is archived, it returns ErrArchivedRoom for every permission but ArchiveRoom.
+	// If the role does not have the permission, it returns ErrDisAllowedOperation.
+	Authorize(ctx context.Context, roomID, username string, permission Permission) (MemberRole, error)
+
+	// AuthorizeMember checks that the actor has the permission and outranks the target member,
+	// and returns the role of the target.
+	// It returns the errors of Authorize, and ErrInvalidMember if the target is not a member.
+	AuthorizeMember(ctx context.Context, roomID, actor, target string, permission Permission) (MemberRole, error)
+
+	// AuthorizeRoleAssignment checks that the actor can give the role to another member.
+	// Anyone can be made a Member; other roles need ChangeRoles and a role that outranks them.
+	// It returns the errors of Authorize, and ErrInvalidPermission if the role is not known.
+	AuthorizeRoleAssignment(ctx context.Context, roomID, actor string, role MemberRole) error
+
+	// GetRoomPermissions returns the permissions of every role in the room,
+	// with the overrides of the room applied to DefaultRolePermissions.
+	GetRoomPermissions(ctx context.Context, roomID string) (RolePermissions, error)
+
+	// SetRoomPermission grants or revokes a permission for a role in the room.
+	// The permissions of the owner cannot be changed, it returns ErrDisAllowedOperation.
+	// If the permission or role is not known, it returns ErrInvalidPermission.
+	// If the room does not exist, it returns ErrInvalidRoom.
+	SetRoomPermission(ctx context.Context, roomID string, role MemberRole, permission Permission, allowed bool) error
+
+	// UpdateMemberRole changes the role of a member.
+	// Ownership cannot be given or taken this way, it returns ErrDisAllowedOperation;
+	// use TransferOwnership instead.
+	// If the user is not a member of the room, it returns ErrInvalidMember.
+	// If the role is not known, it returns ErrInvalidPermission.
+	UpdateMemberRole(ctx context.Context, roomID, username string, role MemberRole) error
+
+	// TransferOwnership makes another member the owner of the room. The previous owner becomes an admin.
+	// If from is not a member of the room, it returns ErrInvalidRoom.
+	// If from is not the owner, it returns ErrDisAllowedOperation.
+	// If to is not a member of the room, it returns ErrInvalidMember.
+	TransferOwnership(ctx context.Context, roomID, from, to string) error
+
+	// LeaveRoom removes the user from the room.
+	// The owner has to name a successor among the members, who becomes the new owner.
+	// It reports whether the room was deleted, which happens when the owner is the last member,
+	// and whether ownership was transferred to the successor. The successor is ignored
+	// when the user is not the owner.
+	// If the user is not a member of the room, it returns ErrInvalidRoom.
+	// If the owner does not name a successor, it returns ErrSuccessorRequired.
+	// If the successor is not a member of the room, it returns ErrInvalidMember.
+	LeaveRoom(ctx context.Context, roomID, username, successor string) (deleted bool, transferred bool, err error)
+
+	// UpdateRoom updates the metadata of the room and records the change as a SystemMessage
+	// sent by the actor, which is returned. If nothing changed, the returned message is nil.
+	// If the actor is not a member of the room, it returns ErrInvalidRoom.
+	// Changing the visibility takes the ChangeVisibility permission and the other fields take RenameRoom;
+	// if the actor does not have them, it returns ErrDisAllowedOperation.
+	// If the input is invalid, it returns ErrInvalidRoomInput.
+	UpdateRoom(ctx context.Context, roomID, actor string, input UpdateRoomInput) (*Message, error)
+
+	// CreateInvite creates an invite code that lets users join the room with the role of the input.
+	// It returns the errors of Authorize for the InviteMembers permission,
+	// and of AuthorizeRoleAssignment for the role.
+	// If the input is invalid, it returns ErrInvalidInvite.
+	CreateInvite(ctx context.Context, roomID, actor string, input CreateInviteInput) (*Invite, error)
+
+	// GetRoomInvites returns the invites of the room, including revoked and expired ones,
+	// most recent first. A nil slice is returned if there are none.
+	GetRoomInvites(ctx context.Context, roomID string) ([]Invite, error)
+
+	// GetInvite returns the invite with the code. If it does not exist, it returns nil.
+	GetInvite(ctx context.Context, code string) (*Invite, error)
+
+	// RevokeInvite stops the invite of the room from being used.
+	// If the invite does not exist in the room or is already revoked, it returns ErrInvalidInvite.
+	RevokeInvite(ctx context.Context, roomID, code string) error
+
+	// AcceptInvite adds the user to the room of the invite and records the use.
+	// It returns the invite and whether the user joined; members of the room do not use up the invite.
+	// If the invite does not exist, or is revoked, expired or used up, it returns ErrInvalidInvite.
+	// If the user does not exist, it returns ErrInvalidUser.
+	// If the room is archived, it returns ErrArchivedRoom.
+	AcceptInvite(ctx context.Context, code, username string) (*Invite, bool, error)
+
+	// GetInviteUses returns who joined with the invite, oldest first.
+	GetInviteUses(ctx context.Context, code string) ([]InviteUse, error)
+
+	// GetRoomDirectory returns the public rooms whose name contains search, ignoring case,
+	// ordered by the number of members then name.
+	// If the limit is not positive, the limit is set to 20.
+	// A nil slice is returned if there are no rooms.
+	GetRoomDirectory(ctx context.Context, search string, offset, limit int) ([]DirectoryEntry, error)
+
+	// JoinRoom adds the user to a RoomPublicJoin room as a Member.
+	// Joining a room the user is already in does nothing.
+	// If the room does not exist, it returns ErrInvalidRoom.
+	// If anyone cannot join the room, it returns ErrDisAllowedOperation.
+	// If the user does not exist, it returns ErrInvalidUser.
+	// If the room is archived, it returns ErrArchivedRoom.
+	JoinRoom(ctx context.Context, roomID, username string) error
+
+	// ArchiveRoom archives the room, making it read-only, or restores it.
+	// Archiving an archived room, or restoring one that is not, does nothing.
+	// The change is recorded as a SystemMessage sent by the actor.
+	// It returns the errors of Authorize for the ArchiveRoom permission.
+	ArchiveRoom(ctx context.Context, roomID, actor string, archived bool) error
+
+	// DeleteRoom deletes the room with its members, messages, permissions and invites.
+	// Only the owner can delete a room, otherwise it returns ErrDisAllowedOperation.
+	// If the actor is not a member of the room, it returns ErrInvalidRoom.
+	DeleteRoom(ctx context.Context, roomID, actor string) error
+
+	// GetRooms returns every room whose name contains search, ignoring case, ordered by name.
+	// It is meant for administrators.
+	// If the limit is a zero value, the limit is set to 20.
+	GetRooms(ctx context.Context, search string, offset, limit int) ([]RoomListing, error)
+
+	// ForceDeleteRoom deletes the room like DeleteRoom without checking who is deleting it.
+	// It is meant for administrators.
+	// If the room does not exist, it returns ErrInvalidRoom.
+	ForceDeleteRoom(ctx context.Context, roomID string) error
+
+	// GetInstanceStats counts the users, rooms and messages of the instance.
+	GetInstanceStats(ctx context.Context) (*InstanceStats, error)
+
+	// GetRoomPreferences returns the preferences of the member for the room.
+	// If the user is not a member of the room, it returns ErrInvalidRoom.
+	GetRoomPreferences(ctx context.Context, roomID, username string) (*RoomPreferences, error)
+
+	// GetRoomMemberPreferences returns the preferences of every member of the room by username.
+	GetRoomMemberPreferences(ctx context.Context, roomID string) (map[string]RoomPreferences, error)
+
+	// UpdateRoomPreferences updates the preferences of the member for the room and returns them.
+	// If the input is invalid, it returns ErrInvalidPreferences.
+	// If the user is not a member of the room, it returns ErrInvalidRoom.
+	UpdateRoomPreferences(ctx context.Context, roomID, username string, input UpdateRoomPreferencesInput) (*RoomPreferences, error)
+
+	// PinMessage pins a message of the room. Pinning a pinned message returns the existing pin.
+	// It returns the errors of Authorize for the PinMessages permission.
+	// If the message is not in the room, it returns ErrInvalidMessage.
+	PinMessage(ctx context.Context, roomID string, messageID int, actor string) (*Pin, error)
+
+	// UnpinMessage unpins a message of the room.
+	// It returns the errors of Authorize for the PinMessages permission.
+	// If the message is not pinned in the room, it returns ErrInvalidMessage.
+	UnpinMessage(ctx context.Context, roomID string, messageID int, actor string) error
+
+	// GetRoomPins returns the pinned messages of the room, most recently pinned first.
+	// A nil slice is returned if there are no pins.
+	GetRoomPins(ctx context.Context, roomID string) ([]Pin, error)
+
+	// BookmarkMessage saves a message for the user, or updates the note of an existing bookmark.
+	// If the user cannot read the message because they are not a member of its room,
+	// it returns ErrInvalidMessage. If the input is invalid, it returns ErrInvalidBookmark.
+	BookmarkMessage(ctx context.Context, username string, messageID int, input BookmarkInput) (*Bookmark, error)
+
+	// RemoveBookmark removes a bookmark of the user.
+	// If the user has not bookmarked the message, it returns ErrInvalidBookmark.
+	RemoveBookmark(ctx context.Context, username string, messageID int) error
+
+	// GetBookmarks returns the bookmarks of the user across all rooms, most recently saved first.
+	// Bookmarks in rooms the user is no longer a member of are left out.
+	// If the limit is a zero value, the limit is set to 50.
+	// A nil slice is returned if there are no bookmarks.
+	GetBookmarks(ctx context.Context, username string, offset, limit int) ([]Bookmark, error)
+
+	// GetRoomRetention returns the retention settings of the room.
+	// If the room does not exist, it returns ErrInvalidRoom.
+	GetRoomRetention(ctx context.Context, roomID string) (*RoomRetention, error)
+
+	// SetRoomRetention overrides the global retention policy for the room,
+	// or makes the room use the global policy again if the policy is nil.
+	// If the room does not exist, it returns ErrInvalidRoom.
+	// If the policy is invalid, it returns ErrInvalidRetention.
+	SetRoomRetention(ctx context.Context, roomID string, policy *RetentionPolicy) error
+
+	// SetLegalHold places the room on legal hold, which exempts its messages from purging, or lifts it.
+	// If the room does not exist, it returns ErrInvalidRoom.
+	SetLegalHold(ctx context.Context, roomID string, hold bool) error
+
+	// PurgeExpiredMessages deletes messages that have expired at now, with their read receipts,
+	// pins and bookmarks. Rooms without a policy of their own use the global policy.
+	// At most limit messages, the oldest first, are deleted from each room in a transaction of its own.
+	// If the limit is a zero value, the limit is set to 500.
+	// The last message of the room and the last message read by its members are moved back
+	// to the newest message left, or 0 if none are. A purge is recorded for each room
+	// that messages were deleted from, and the purges are returned.
+	PurgeExpiredMessages(ctx context.Context, global RetentionPolicy, now time.Time, limit int) ([]Purge, error)
+
+	// GetPurges returns the purges recorded since the given time, most recent first.
+	GetPurges(ctx context.Context, since time.Time) ([]Purge, error)
+
+	// StreamRoomMessages calls fn with every message of the room, oldest first.
+	// The messages are read a page at a time, and the first error returned by fn is returned.
+	StreamRoomMessages(ctx context.Context, roomID string, fn func(Message) error) error
+
+	// CreateExportJob records a pending export of the room for the user and returns it.
+	// If the user is not a member of the room, it returns ErrInvalidRoom.
+	// If the input is invalid, it returns ErrInvalidExport.
+	CreateExportJob(ctx context.Context, roomID, username string, input ExportInput) (*ExportJob, error)
+
+	// GetExportJob returns the export job. If it does not exist, it returns ErrInvalidExport.
+	GetExportJob(ctx context.Context, id string) (*ExportJob, error)
+
+	// UpdateExportJob saves the status, error, file and completion time of the job.
+	// If it does not exist, it returns ErrInvalidExport.
+	UpdateExportJob(ctx context.Context, job ExportJob) error
+
+	// GetUnfinishedExportJobs returns the jobs that are pending or running, oldest first.
+	GetUnfinishedExportJobs(ctx context.Context) ([]ExportJob, error)
+
+	// DeleteExportJobs deletes the jobs created before the given time and returns them.
+	DeleteExportJobs(ctx context.Context, before time.Time) ([]ExportJob, error)
+
+	// DeleteUserExportJobs deletes the jobs requested by the user and returns them.
+	DeleteUserExportJobs(ctx context.Context, username string) ([]ExportJob, error)
+
+	// GetImportedRoom returns the ID of the room that the channel of the source was imported as,
+	// or an empty string if it has not been imported. The room may have been deleted since.
+	GetImportedRoom(ctx context.Context, source, externalID string) (string, error)
+
+	// LinkImportedRoom records that the channel of the source was imported as the room.
+	LinkImportedRoom(ctx context.Context, source, externalID, roomID string) error
+
+	// ImportMessages adds the messages of the source to the room with their original senders
+	// and timestamps, in one transaction, and returns how many were added.
+	// Messages that were imported before, even if they have been deleted since, are skipped.
+	// The members of the room are marked as having read the imported messages.
+	// The last message of the room only changes if an imported message was sent after it.
+	// Messages are ordered by ID, not by when they were sent, in GetRoomMessages and
+	// by the retention policies, so the messages should be given oldest first and imported
+	// before the room is used: history imported into a room with newer messages is listed
+	// after them and is kept as if it were newer.
+	// If the room does not exist, it returns ErrInvalidRoom.
+	// If a sender does not exist, it returns ErrInvalidUser.
+	ImportMessages(ctx context.Context, roomID, source string, messages []ImportMessage) (int, error)
+
+	// CountImportedMessages returns how many of the messages of the source have been imported.
+	CountImportedMessages(ctx context.Context, source string, externalIDs []string) (int, error)
 }
@@ -3,6 +3,7 @@ package core
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"slices"
@@ -12,19 +13,34 @@ import (
 	"github.com/google/uuid"
 )
 
-type SQLiteChatStore struct {
-	db        *sql.DB
+// SQLChatStore is a ChatStore backed by a SQL database.
+type SQLChatStore struct {
+	db        *DB
 	userStore UserStore
+	auditLog  *SQLAuditLog
 }
 
-func NewSQLiteChatStore(db *sql.DB, userStore UserStore) *SQLiteChatStore {
-	return &SQLiteChatStore{
+type ChatOptions func(*SQLChatStore)
+
+// WithChatAuditLog records membership changes and room deletes in the audit log.
+func WithChatAuditLog(auditLog *SQLAuditLog) ChatOptions {
+	return func(s *SQLChatStore) {
+		s.auditLog = auditLog
+	}
+}
+
+func NewSQLChatStore(db *DB, userStore UserStore, opts ...ChatOptions) *SQLChatStore {
+	s := &SQLChatStore{
 		db:        db,
 		userStore: userStore,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
-func (s *SQLiteChatStore) CreateRoom(ctx context.Context, name string, ownerUsername string) (string, error) {
+func (s *SQLChatStore) CreateRoom(ctx context.Context, name string, ownerUsername string) (string, error) {
 
 	owner, err := s.userStore.GetUserByUsername(ctx, ownerUsername)
 	if err != nil {
@@ -63,6 +79,13 @@ func (s *SQLiteChatStore) CreateRoom(ctx context.Context, name string, ownerUser
 		return "", fmt.Errorf("ExecContext(insert room_members): %w", err)
 	}
 
+	if _, err := insertSystemMessage(ctx, tx, id, SystemMessageBody{
+		Key:   SystemRoomCreated,
+		Actor: ownerUsername,
+	}); err != nil {
+		return "", err
+	}
+
 	if err := tx.Commit(); err != nil {
 		return "", fmt.Errorf("Commit: %w", err)
 	}
@@ -70,7 +93,7 @@ func (s *SQLiteChatStore) CreateRoom(ctx context.Context, name string, ownerUser
 	return id, nil
 }
 
-func (s *SQLiteChatStore) AddRoomMember(ctx context.Context, roomID, username string, role MemberRole) error {
+func (s *SQLChatStore) AddRoomMember(ctx context.Context, roomID, username string, role MemberRole) error {
 
 	// check if user exist
 	user, err := s.userStore.GetUserByUsername(ctx, username)
@@ -94,18 +117,59 @@ func (s *SQLiteChatStore) AddRoomMember(ctx context.Context, roomID, username st
 
 	}
 
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := s.addRoomMember(ctx, tx, roomID, username, role, ActorFromContext(ctx, username)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Commit: %w", err)
+	}
+	return nil
+}
+
+// addRoomMember inserts the membership and records who added the user.
+// It returns false if the user is already a member, in which case nothing is changed.
+func (s *SQLChatStore) addRoomMember(ctx context.Context, tx *Tx, roomID, username string, role MemberRole, actor string) (bool, error) {
 	query := `INSERT INTO room_members (room_id, username, role, last_message_read)
 		VALUES (@room_id, @username, @role, @last_message_read) ON CONFLICT DO NOTHING`
-	_, err = s.db.ExecContext(ctx, query,
+	result, err := tx.ExecContext(ctx, query,
 		sql.Named("room_id", roomID), sql.Named("username", username),
 		sql.Named("role", role), sql.Named("last_message_read", 0))
 	if err != nil {
-		return fmt.Errorf("ExecContext: %w", err)
+		return false, fmt.Errorf("ExecContext: %w", err)
 	}
-	return nil
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("RowsAffected: %w", err)
+	}
+	if affected == 0 {
+		return false, nil
+	}
+
+	if _, err := insertSystemMessage(ctx, tx, roomID, SystemMessageBody{
+		Key:    SystemMemberAdded,
+		Actor:  actor,
+		Target: username,
+		Role:   role,
+	}); err != nil {
+		return false, err
+	}
+	if err := s.auditLog.appendTx(ctx, tx, AuditEntry{
+		Action: AuditMemberAdded, Actor: actor, Target: username, RoomID: roomID,
+		Details: map[string]string{"role": string(role)},
+	}); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
-func (s *SQLiteChatStore) RemoveRoomMember(ctx context.Context, roomID, username string) error {
+func (s *SQLChatStore) RemoveRoomMember(ctx context.Context, roomID, username string) error {
 	room, err := s.GetRoomByID(ctx, roomID)
 	if err != nil {
 		return fmt.Errorf("GetRoomByID: %w", err)
@@ -124,19 +188,45 @@ func (s *SQLiteChatStore) RemoveRoomMember(ctx context.Context, roomID, username
 	if role == Owner {
 		return ErrDisAllowedOperation
 	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
 	query := `DELETE FROM room_members WHERE room_id = @room_id AND username = @username`
-	_, err = s.db.ExecContext(ctx, query,
+	_, err = tx.ExecContext(ctx, query,
 		sql.Named("room_id", roomID), sql.Named("username", username))
 	if err != nil {
 		return fmt.Errorf("ExecContext: %w", err)
 	}
+
+	// without an actor the removal is not attributed to anyone, e.g. when made by a background sync
+	body := SystemMessageBody{Key: SystemMemberRemoved, Actor: ActorFromContext(ctx, ""), Target: username}
+	if body.Actor == username {
+		body = SystemMessageBody{Key: SystemMemberLeft, Actor: username}
+	}
+	if _, err := insertSystemMessage(ctx, tx, roomID, body); err != nil {
+		return err
+	}
+	if err := s.auditLog.appendTx(ctx, tx, AuditEntry{
+		Action: AuditMemberRemoved, Actor: ActorFromContext(ctx, username), Target: username, RoomID: roomID,
+	}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Commit: %w", err)
+	}
 	return nil
 }
 
-func (s *SQLiteChatStore) GetRoomByID(ctx context.Context, roomID string) (*Room, error) {
+func (s *SQLChatStore) GetRoomByID(ctx context.Context, roomID string) (*Room, error) {
 
 	query := `
-		SELECT r.id, r.name, r.last_message_sent_at, r.last_message_sent, last_message_sent_data,
+		SELECT r.id, r.name, r.topic, r.description, r.avatar, r.visibility, r.archived_at,
+		r.last_message_sent_at, r.last_message_sent, last_message_sent_data, last_message_sent_type,
 		ru.username, ru.role, ru.last_message_read FROM rooms AS r 
 		INNER JOIN room_members AS ru ON r.id = ru.room_id 
 		WHERE r.id = @id`
@@ -148,18 +238,21 @@ func (s *SQLiteChatStore) GetRoomByID(ctx context.Context, roomID string) (*Room
 	}
 
 	var id string
-	var name string
+	var name, topic, description, avatar string
+	var visibility RoomVisibility
+	var archivedAt sql.NullTime
 	var lastMessageSentAt time.Time
 	var lastMessageSent int
 	var lastMessageSentData string
+	var lastMessageSentType MessageType
 
 	members := make([]RoomMember, 0, 2)
 
 	for row.Next() {
 		var member RoomMember
 		if err := row.Scan(
-			&id, &name, &lastMessageSentAt,
-			&lastMessageSent, &lastMessageSentData,
+			&id, &name, &topic, &description, &avatar, &visibility, &archivedAt, &lastMessageSentAt,
+			&lastMessageSent, &lastMessageSentData, &lastMessageSentType,
 			&member.Username, &member.Role, &member.LastMessageRead,
 		); err != nil {
 			break
@@ -179,16 +272,24 @@ func (s *SQLiteChatStore) GetRoomByID(ctx context.Context, roomID string) (*Room
 	room := Room{
 		ID:                  id,
 		Name:                name,
+		Topic:               topic,
+		Description:         description,
+		Avatar:              avatar,
+		Visibility:          visibility,
 		LastMessageSentAt:   lastMessageSentAt,
 		LastMessageSent:     lastMessageSent,
 		LastMessageSentData: lastMessageSentData,
+		LastMessageSentType: lastMessageSentType,
 		Members:             members,
 	}
+	if archivedAt.Valid {
+		room.ArchivedAt = &archivedAt.Time
+	}
 
 	return &room, nil
 }
 
-func (s *SQLiteChatStore) IsRoomMember(ctx context.Context, roomID, user string) (bool, MemberRole, error) {
+func (s *SQLChatStore) IsRoomMember(ctx context.Context, roomID, user string) (bool, MemberRole, error) {
 	query := `
 	SELECT count(*), ru.role
 	FROM room_members AS ru 
@@ -216,25 +317,37 @@ func (s *SQLiteChatStore) IsRoomMember(ctx context.Context, roomID, user string)
 	return true, role, nil
 }
 
-func (s *SQLiteChatStore) GetUserRooms(ctx context.Context, user string, offset, litmit int) ([]Room, error) {
+func (s *SQLChatStore) GetUserRooms(ctx context.Context, user string, offset, litmit int) ([]Room, error) {
+	return s.getUserRooms(ctx, user, false, offset, litmit)
+}
+
+func (s *SQLChatStore) GetArchivedUserRooms(ctx context.Context, user string, offset, limit int) ([]Room, error) {
+	return s.getUserRooms(ctx, user, true, offset, limit)
+}
+
+func (s *SQLChatStore) getUserRooms(ctx context.Context, user string, archived bool, offset, litmit int) ([]Room, error) {
 	// Select all the rooms that belong to the user order in desc of id
 	// then join it with room_member
 
 	query := `
 	WITH r as (
-	    SELECT r.id, r.name, r.last_message_sent_at, r.last_message_sent, r.last_message_sent_data
+	    SELECT r.id, r.name, r.topic, r.description, r.avatar, r.visibility, r.archived_at,
+	    r.last_message_sent_at, r.last_message_sent, r.last_message_sent_data, r.last_message_sent_type,
+	    rm.muted_until, rm.pinned, rm.favorite, rm.sort_position, rm.notification_level
 	    FROM room_members as rm
 	    INNER JOIN rooms as r ON rm.room_id = r.id
-	    WHERE rm.username = @username
-	    ORDER BY r.last_message_sent_at DESC, r.name ASC
+	    WHERE rm.username = @username AND (r.archived_at IS NOT NULL) = @archived
+	    ORDER BY rm.pinned DESC, rm.sort_position = 0, rm.sort_position ASC,
+	    r.last_message_sent_at DESC, r.name ASC
 	    LIMIT @limit OFFSET @offset
 	)
-	SELECT r.id, r.name, r.last_message_sent_at, r.last_message_sent, r.last_message_sent_data,
+	SELECT r.id, r.name, r.topic, r.description, r.avatar, r.visibility, r.archived_at,
+	r.last_message_sent_at, r.last_message_sent, r.last_message_sent_data, r.last_message_sent_type,
+	r.muted_until, r.pinned, r.favorite, r.sort_position, r.notification_level,
 	rm.username, rm.role,  rm.last_message_read
 	FROM r 
 	INNER JOIN room_members as rm
 	ON r.id = rm.room_id
-	ORDER BY r.last_message_sent_at DESC, r.name ASC
 	`
 
 	if litmit == 0 {
@@ -246,7 +359,8 @@ func (s *SQLiteChatStore) GetUserRooms(ctx context.Context, user string, offset,
 	}
 
 	rows, err := s.db.QueryContext(ctx, query,
-		sql.Named("username", user), sql.Named("limit", litmit), sql.Named("offset", offset))
+		sql.Named("username", user), sql.Named("archived", archived),
+		sql.Named("limit", litmit), sql.Named("offset", offset))
 	if err != nil {
 		return nil, fmt.Errorf("QueryContext: %w", err)
 	}
@@ -254,13 +368,21 @@ func (s *SQLiteChatStore) GetUserRooms(ctx context.Context, user string, offset,
 	roomMap := make(map[string]*Room)
 	var (
 		id, name, username, lastMessageSentData string
+		topic, description, avatar              string
+		visibility                              RoomVisibility
+		archivedAt                              sql.NullTime
 		lastMessageSentAt                       time.Time
 		lastMessageSent, lastMessageRead        int
+		lastMessageSentType                     MessageType
 		role                                    MemberRole
+		preferences                             RoomPreferences
+		mutedUntil                              sql.NullTime
 	)
 	for rows.Next() {
-		if err := rows.Scan(&id, &name, &lastMessageSentAt,
-			&lastMessageSent, &lastMessageSentData, &username, &role, &lastMessageRead); err != nil {
+		if err := rows.Scan(&id, &name, &topic, &description, &avatar, &visibility, &archivedAt, &lastMessageSentAt,
+			&lastMessageSent, &lastMessageSentData, &lastMessageSentType,
+			&mutedUntil, &preferences.Pinned, &preferences.Favorite, &preferences.SortPosition,
+			&preferences.NotificationLevel, &username, &role, &lastMessageRead); err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
 				return nil, nil
 			}
@@ -272,10 +394,26 @@ func (s *SQLiteChatStore) GetUserRooms(ctx context.Context, user string, offset,
 			room = &Room{
 				ID:                  id,
 				Name:                name,
+				Topic:               topic,
+				Description:         description,
+				Avatar:              avatar,
+				Visibility:          visibility,
 				LastMessageSentAt:   lastMessageSentAt,
 				LastMessageSent:     lastMessageSent,
 				LastMessageSentData: lastMessageSentData,
+				LastMessageSentType: lastMessageSentType,
+			}
+			// the scanned values are reused by the next row, so they are copied
+			if archivedAt.Valid {
+				archivedAt := archivedAt.Time
+				room.ArchivedAt = &archivedAt
+			}
+			preferences := preferences
+			if mutedUntil.Valid {
+				mutedUntil := mutedUntil.Time
+				preferences.MutedUntil = &mutedUntil
 			}
+			room.Preferences = &preferences
 			roomMap[id] = room
 		}
 
@@ -294,6 +432,9 @@ func (s *SQLiteChatStore) GetUserRooms(ctx context.Context, user string, offset,
 		rooms = append(rooms, *r)
 	}
 	slices.SortFunc(rooms, func(i, j Room) int {
+		if cmp := compareRoomPreferences(*i.Preferences, *j.Preferences); cmp != 0 {
+			return cmp
+		}
 		lastMessageSentCmp := j.LastMessageSentAt.Compare(i.LastMessageSentAt)
 		if lastMessageSentCmp != 0 {
 			return lastMessageSentCmp
@@ -306,7 +447,7 @@ func (s *SQLiteChatStore) GetUserRooms(ctx context.Context, user string, offset,
 
 }
 
-func (s *SQLiteChatStore) GetRoomSummaries(ctx context.Context, user string, offset, limit int) ([]RoomSummary, error) {
+func (s *SQLChatStore) GetRoomSummaries(ctx context.Context, user string, offset, limit int) ([]RoomSummary, error) {
 	query := `
 	WITH my_rooms AS 
 	(SELECT ru.room_id, ru.room_name 
@@ -379,17 +520,13 @@ func (s *SQLiteChatStore) GetRoomSummaries(ctx context.Context, user string, off
 	return roomViews, nil
 }
 
-func (s *SQLiteChatStore) SendMessageToRoom(ctx context.Context, message MessageCreateInput) (*Message, error) {
+func (s *SQLChatStore) SendMessageToRoom(ctx context.Context, message MessageCreateInput) (*Message, error) {
 	err := message.Validate()
 	if err != nil {
 		return nil, ErrInvalidMessage
 	}
-	ok, _, err := s.IsRoomMember(ctx, message.RoomID, message.Sender)
-	if err != nil {
-		return nil, fmt.Errorf("IsUserInRoom: %w", err)
-	}
-	if !ok {
-		return nil, ErrInvalidRoom
+	if _, err := s.Authorize(ctx, message.RoomID, message.Sender, PostMessages); err != nil {
+		return nil, err
 	}
 	if message.Type != TextMessage {
 		return nil, ErrInvalidMessageType
@@ -402,19 +539,12 @@ func (s *SQLiteChatStore) SendMessageToRoom(ctx context.Context, message Message
 	defer tx.Rollback()
 
 	sentAt := time.Now().UTC()
-	query := `
-	INSERT INTO messages (type, room_id, sender, data, sent_at) 
-	VALUES ( @type, @room_id, @sender, @data, @sent_at) RETURNING id`
-	row := tx.QueryRowContext(ctx, query,
-		sql.Named("type", message.Type),
-		sql.Named("room_id", message.RoomID), sql.Named("sender", message.Sender),
-		sql.Named("data", message.Data), sql.Named("sent_at", sentAt))
-	var id int
-	if err := row.Scan(&id); err != nil {
-		return nil, fmt.Errorf("row.Scan: %w", err)
+	id, err := insertMessage(ctx, tx, message.Type, message.RoomID, message.Sender, message.Data, sentAt)
+	if err != nil {
+		return nil, err
 	}
 
-	query = `
+	query := `
 	UPDATE room_members SET last_message_read = @last_message_read 
 	WHERE room_id = @room_id AND username = @username
 	`
@@ -425,40 +555,99 @@ func (s *SQLiteChatStore) SendMessageToRoom(ctx context.Context, message Message
 		return nil, fmt.Errorf("ExecContext(update room_members): %w", err)
 	}
 
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("Commit: %w", err)
+	}
+
+	createdMessage := &Message{
+		ID:     id,
+		Type:   message.Type,
+		Data:   message.Data,
+		RoomID: message.RoomID,
+		Sender: message.Sender,
+		SentAt: sentAt,
+	}
+
+	return createdMessage, nil
+}
+
+// insertMessage inserts a message and makes it the last message of the room,
+// unless the room has a message sent after it, as it may when the message is imported.
+func insertMessage(ctx context.Context, tx *Tx, msgType MessageType, roomID, sender, data string, sentAt time.Time) (int, error) {
+	query := `
+	INSERT INTO messages (type, room_id, sender, data, sent_at) 
+	VALUES ( @type, @room_id, @sender, @data, @sent_at) RETURNING id`
+	row := tx.QueryRowContext(ctx, query,
+		sql.Named("type", msgType),
+		sql.Named("room_id", roomID), sql.Named("sender", sender),
+		sql.Named("data", data), sql.Named("sent_at", sentAt))
+	var id int
+	if err := row.Scan(&id); err != nil {
+		return 0, fmt.Errorf("row.Scan: %w", err)
+	}
+
 	query = `
 	UPDATE rooms SET 
 	last_message_sent = @last_message_sent,
 	last_message_sent_at = @last_message_sent_at,
-	last_message_sent_data = @last_message_sent_data
-	WHERE id = @room_id
+	last_message_sent_data = @last_message_sent_data,
+	last_message_sent_type = @last_message_sent_type
+	WHERE id = @room_id AND last_message_sent_at <= @last_message_sent_at
 	`
-	_, err = tx.ExecContext(ctx, query,
-		sql.Named("room_id", message.RoomID),
+	_, err := tx.ExecContext(ctx, query,
+		sql.Named("room_id", roomID),
 		sql.Named("last_message_sent", id),
 		sql.Named("last_message_sent_at", sentAt),
-		sql.Named("last_message_sent_data", message.Data),
+		sql.Named("last_message_sent_data", data),
+		sql.Named("last_message_sent_type", msgType),
 	)
 	if err != nil {
-		return nil, fmt.Errorf("ExectContext(update room): %w", err)
+		return 0, fmt.Errorf("ExectContext(update room): %w", err)
 	}
+	return id, nil
+}
 
-	if err := tx.Commit(); err != nil {
-		return nil, fmt.Errorf("Commit: %w", err)
+// insertSystemMessage records a change to the room in its timeline.
+// The actor of the change is the sender of the message and has read it.
+// A change without an actor is sent by its target, as every message needs a sender.
+func insertSystemMessage(ctx context.Context, tx *Tx, roomID string, body SystemMessageBody) (*Message, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %w", err)
 	}
 
-	createdMessage := &Message{
-		ID:     id,
-		Type:   message.Type,
-		Data:   message.Data,
-		RoomID: message.RoomID,
-		Sender: message.Sender,
-		SentAt: sentAt,
+	sender := body.Actor
+	if sender == "" {
+		sender = body.Target
 	}
 
-	return createdMessage, nil
+	sentAt := time.Now().UTC()
+	id, err := insertMessage(ctx, tx, SystemMessage, roomID, sender, string(data), sentAt)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	UPDATE room_members SET last_message_read = @last_message_read
+	WHERE room_id = @room_id AND username = @username`
+	_, err = tx.ExecContext(ctx, query,
+		sql.Named("last_message_read", id), sql.Named("room_id", roomID),
+		sql.Named("username", sender))
+	if err != nil {
+		return nil, fmt.Errorf("ExecContext(update room_members): %w", err)
+	}
+
+	return &Message{
+		ID:     id,
+		Type:   SystemMessage,
+		Data:   string(data),
+		RoomID: roomID,
+		Sender: sender,
+		SentAt: sentAt,
+	}, nil
 }
 
-func (s *SQLiteChatStore) GetRoomMessages(ctx context.Context, roomID string, offset, limit int) ([]Message, error) {
+func (s *SQLChatStore) GetRoomMessages(ctx context.Context, roomID string, offset, limit int) ([]Message, error) {
 
 	query := `
 	SELECT id, type, data, room_id, sender, sent_at 
@@ -500,7 +689,111 @@ func (s *SQLiteChatStore) GetRoomMessages(ctx context.Context, roomID string, of
 	return messages, nil
 }
 
-func (s *SQLiteChatStore) ReadRoomMessages(ctx context.Context, roomID, user string) (int, time.Time, error) {
+func (s *SQLChatStore) DeleteMessage(ctx context.Context, roomID string, messageID int, username string) error {
+	ok, _, err := s.IsRoomMember(ctx, roomID, username)
+	if err != nil {
+		return fmt.Errorf("IsRoomMember: %w", err)
+	}
+	if !ok {
+		return ErrInvalidRoom
+	}
+
+	row := s.db.QueryRowContext(ctx,
+		"SELECT type, sender FROM messages WHERE id = @id AND room_id = @room_id",
+		sql.Named("id", messageID), sql.Named("room_id", roomID))
+	var msgType MessageType
+	var sender string
+	if err := row.Scan(&msgType, &sender); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidMessage
+		}
+		return fmt.Errorf("row.Scan: %w", err)
+	}
+	// the timeline of the room is kept intact
+	if msgType == SystemMessage {
+		return ErrDisAllowedOperation
+	}
+	if sender != username {
+		if _, err := s.Authorize(ctx, roomID, username, DeleteOthersMessages); err != nil {
+			return err
+		}
+	} else {
+		archived, err := s.isArchived(ctx, roomID)
+		if err != nil {
+			return fmt.Errorf("isArchived: %w", err)
+		}
+		if archived {
+			return ErrArchivedRoom
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	queries := []string{
+		"DELETE FROM message_interactions WHERE message_id = @id",
+		"DELETE FROM room_pins WHERE message_id = @id",
+		"DELETE FROM bookmarks WHERE message_id = @id",
+		"DELETE FROM messages WHERE id = @id",
+	}
+	for _, query := range queries {
+		if _, err := tx.ExecContext(ctx, query, sql.Named("id", messageID)); err != nil {
+			return fmt.Errorf("ExecContext(delete message): %w", err)
+		}
+	}
+
+	// the room shows the newest message that is left
+	var last Message
+	row = tx.QueryRowContext(ctx,
+		"SELECT id, type, data, sent_at FROM messages WHERE room_id = @room_id ORDER BY id DESC LIMIT 1",
+		sql.Named("room_id", roomID))
+	err = row.Scan(&last.ID, &last.Type, &last.Data, &last.SentAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		_, err = tx.ExecContext(ctx, `
+		UPDATE rooms SET last_message_sent = 0, last_message_sent_data = '', last_message_sent_type = @type
+		WHERE id = @room_id AND last_message_sent = @id`,
+			sql.Named("type", TextMessage),
+			sql.Named("room_id", roomID), sql.Named("id", messageID))
+	case err != nil:
+		return fmt.Errorf("row.Scan(last message): %w", err)
+	default:
+		_, err = tx.ExecContext(ctx, `
+		UPDATE rooms SET
+		last_message_sent = @last_message_sent,
+		last_message_sent_at = @last_message_sent_at,
+		last_message_sent_data = @last_message_sent_data,
+		last_message_sent_type = @last_message_sent_type
+		WHERE id = @room_id AND last_message_sent = @id`,
+			sql.Named("last_message_sent", last.ID), sql.Named("last_message_sent_at", last.SentAt),
+			sql.Named("last_message_sent_data", last.Data), sql.Named("last_message_sent_type", last.Type),
+			sql.Named("room_id", roomID), sql.Named("id", messageID))
+	}
+	if err != nil {
+		return fmt.Errorf("ExecContext(update rooms): %w", err)
+	}
+
+	// members who read up to the deleted message have read up to the message before it
+	_, err = tx.ExecContext(ctx, `
+	UPDATE room_members SET last_message_read = COALESCE((
+		SELECT MAX(m.id) FROM messages AS m WHERE m.room_id = room_members.room_id AND m.id < @id
+	), 0)
+	WHERE room_id = @room_id AND last_message_read = @id`,
+		sql.Named("room_id", roomID), sql.Named("id", messageID))
+	if err != nil {
+		return fmt.Errorf("ExecContext(update room_members): %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Commit: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLChatStore) ReadRoomMessages(ctx context.Context, roomID, user string) (int, time.Time, error) {
 	ok, _, err := s.IsRoomMember(ctx, roomID, user)
 	if err != nil {
 		return 0, time.Time{}, fmt.Errorf("userInRoom: %w", err)
@@ -560,7 +853,7 @@ func (s *SQLiteChatStore) ReadRoomMessages(ctx context.Context, roomID, user str
 	return lastMessageRead, readAt, nil
 }
 
-func (s *SQLiteChatStore) GetRoomMembers(ctx context.Context, roomID string) ([]RoomMember, error) {
+func (s *SQLChatStore) GetRoomMembers(ctx context.Context, roomID string) ([]RoomMember, error) {
 
 	query := `
 	SELECT room_id, username, role
@@ -596,7 +889,7 @@ func (s *SQLiteChatStore) GetRoomMembers(ctx context.Context, roomID string) ([]
 
 // GetConnectedUsers returns a list of friends of the user.
 // A friend is a user that has a private chat or are part of a group chat with the user.
-func (s *SQLiteChatStore) GetFriends(ctx context.Context, username string) ([]string, error) {
+func (s *SQLChatStore) GetFriends(ctx context.Context, username string) ([]string, error) {
 	query := `
 	WITH user_rooms AS (
 		SELECT room_id
@@ -644,7 +937,7 @@ func (s *SQLiteChatStore) GetFriends(ctx context.Context, username string) ([]st
 	return friends, nil
 }
 
-func (s *SQLiteChatStore) AreFriends(ctx context.Context, username1, username2 string) (bool, error) {
+func (s *SQLChatStore) AreFriends(ctx context.Context, username1, username2 string) (bool, error) {
 	query := `
 	SELECT count(*) 
 	FROM room_members AS rm1
@@ -2,14 +2,11 @@ package core
 
 import (
 	"context"
-	"database/sql"
-	"os"
 	"slices"
 	"strings"
 	"testing"
 
 	_ "github.com/mattn/go-sqlite3"
-	"github.com/pressly/goose/v3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -23,46 +20,23 @@ var (
 type ChatFixture struct {
 	userStore UserStore
 	chatStore ChatStore
-	db        *sql.DB
+	db        *DB
 	ctx       context.Context
 	tearDown  func()
 	t         *testing.T
 }
 
 func NewChatFixture(t *testing.T) *ChatFixture {
-	ctx, cancel := context.WithCancel(context.Background())
-
-	db, err := sql.Open("sqlite3", "file::memory:?cache=shared")
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	migrationfs := os.DirFS("../migrations")
-	goose.SetBaseFS(migrationfs)
-
-	if err := goose.SetDialect("sqlite3"); err != nil {
-		t.Fatal(err)
-	}
-
-	if err := goose.Up(db, "."); err != nil {
-		t.Fatal(err)
-	}
-
-	userStore := NewSqlieUserStore(db)
-
-	f := &ChatFixture{
+	base := NewBaseFixture(t)
+	userStore := NewSQLUserStore(base.db)
+	return &ChatFixture{
 		userStore: userStore,
-		chatStore: NewSQLiteChatStore(db, userStore),
-		ctx:       ctx,
-		db:        db,
-		tearDown: func() {
-			cancel()
-			db.Close()
-		},
-		t: t,
+		chatStore: NewSQLChatStore(base.db, userStore),
+		ctx:       base.ctx,
+		db:        base.db,
+		tearDown:  base.tearDown,
+		t:         t,
 	}
-
-	return f
 }
 
 func TestCreateRoom(t *testing.T) {
@@ -80,8 +54,11 @@ func TestCreateRoom(t *testing.T) {
 		require.Nil(t, err)
 		assert.Equal(t, id, room.ID)
 		assert.Equal(t, roomName, room.Name)
-		assert.Zero(t, room.LastMessageSentAt)
-		assert.Equal(t, 0, room.LastMessageSent)
+		// the creation is the first message of the room
+		assert.NotZero(t, room.LastMessageSentAt)
+		assert.NotZero(t, room.LastMessageSent)
+		assert.Equal(t, SystemMessage, room.LastMessageSentType)
+		assert.JSONEq(t, `{"key":"room_created","actor":"`+owner.Username+`"}`, room.LastMessageSentData)
 		assert.Len(t, room.Members, 1)
 		assert.Equal(t, owner.Username, room.Members[0].Username)
 		assert.Equal(t, Owner, room.Members[0].Role)
@@ -362,6 +339,14 @@ func sortMembers(rooms []Room) {
 	}
 }
 
+// withDefaultPreferences sets the preferences that GetUserRooms lists rooms with
+// when the user has not changed them.
+func withDefaultPreferences(rooms []Room) {
+	for i := range rooms {
+		rooms[i].Preferences = &RoomPreferences{NotificationLevel: NotifyAll}
+	}
+}
+
 func TestGetUserRooms(t *testing.T) {
 
 	t.Run("filter logic", func(t *testing.T) {
@@ -373,12 +358,11 @@ func TestGetUserRooms(t *testing.T) {
 		err := f.chatStore.AddRoomMember(f.ctx, rooms[0].ID,
 			member1.Username, Member)
 		require.Nil(t, err)
-		rooms[0].Members = append(rooms[0].Members, RoomMember{
-			Username: member1.Username,
-			RoomID:   rooms[0].ID,
-			Role:     Member,
-		})
+		room, err := f.chatStore.GetRoomByID(f.ctx, rooms[0].ID)
+		require.Nil(t, err)
+		rooms[0] = *room
 		sortMembers(rooms)
+		withDefaultPreferences(rooms)
 
 		ownerRooms, err := f.chatStore.GetUserRooms(f.ctx, owner.Username, 0, len(rooms))
 		require.Nil(t, err)
@@ -401,16 +385,18 @@ func TestGetUserRooms(t *testing.T) {
 		defer f.tearDown()
 		seedUsers(f.ctx, f.t, f.userStore, owner, member1)
 		rooms := seedRooms(f, owner, "Room1", "Room2")
+		withDefaultPreferences(rooms)
 
+		// the room created last has the most recent message
 		page1, err := f.chatStore.GetUserRooms(f.ctx, owner.Username, 0, 1)
 		require.Nil(t, err)
 		require.Len(t, page1, 1)
-		require.Contains(t, page1, rooms[0])
+		require.Contains(t, page1, rooms[1])
 
 		page2, err := f.chatStore.GetUserRooms(f.ctx, owner.Username, 1, 1)
 		require.Nil(t, err)
 		require.Len(t, page2, 1)
-		require.Contains(t, page2, rooms[1])
+		require.Contains(t, page2, rooms[0])
 	})
 }
 
@@ -435,7 +421,8 @@ func TestSendMessageToRoom(t *testing.T) {
 
 		messages, err := f.chatStore.GetRoomMessages(f.ctx, room.ID, 0, 1)
 		require.Nil(t, err)
-		require.Nil(t, messages)
+		require.Len(t, messages, 1)
+		require.Equal(t, SystemMessage, messages[0].Type)
 	})
 
 	t.Run("send message with invalid message type", func(t *testing.T) {
@@ -453,7 +440,8 @@ func TestSendMessageToRoom(t *testing.T) {
 
 		messages, err := f.chatStore.GetRoomMessages(f.ctx, room.ID, 0, 1)
 		require.Nil(t, err)
-		require.Nil(t, messages)
+		require.Len(t, messages, 1)
+		require.Equal(t, SystemMessage, messages[0].Type)
 	})
 
 	t.Run("send invalid message", func(t *testing.T) {
@@ -466,7 +454,8 @@ func TestSendMessageToRoom(t *testing.T) {
 
 		messages, err := f.chatStore.GetRoomMessages(f.ctx, room.ID, 0, 1)
 		require.Nil(t, err)
-		require.Nil(t, messages)
+		require.Len(t, messages, 1)
+		require.Equal(t, SystemMessage, messages[0].Type)
 	})
 
 	t.Run("send valid message to room", func(t *testing.T) {
@@ -517,10 +506,13 @@ func TestGetRoomMessages(t *testing.T) {
 		require.Len(t, messages, 0)
 	})
 
-	t.Run("get messages from an empty room", func(t *testing.T) {
-		messages, err := f.chatStore.GetRoomMessages(f.ctx, room.ID, 0, 1)
+	t.Run("get messages from a room without chat messages", func(t *testing.T) {
+		messages, err := f.chatStore.GetRoomMessages(f.ctx, room.ID, 0, 0)
 		require.Nil(t, err)
-		require.Nil(t, messages)
+		require.Len(t, messages, 2)
+		for _, message := range messages {
+			require.Equal(t, SystemMessage, message.Type)
+		}
 	})
 
 	t.Run("get messages from a room", func(t *testing.T) {
@@ -656,3 +648,97 @@ func getRoomMemberByUsername(room Room, username string) RoomMember {
 	}
 	return target
 }
+
+func TestDeleteMessage(t *testing.T) {
+	send := func(f *ChatFixture, roomID, sender, data string) *Message {
+		message, err := f.chatStore.SendMessageToRoom(f.ctx, MessageCreateInput{
+			Type: TextMessage, Data: data, Sender: sender, RoomID: roomID,
+		})
+		require.Nil(f.t, err)
+		return message
+	}
+
+	t.Run("member cannot delete the message of another member", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+		require.Nil(t, f.chatStore.AddRoomMember(f.ctx, room.ID, member2.Username, Member))
+		message := send(f, room.ID, member1.Username, "hello")
+
+		err := f.chatStore.DeleteMessage(f.ctx, room.ID, message.ID, member2.Username)
+		assert.Equal(t, ErrDisAllowedOperation, err)
+
+		// the room can allow it
+		require.Nil(t, f.chatStore.SetRoomPermission(f.ctx, room.ID, Member, DeleteOthersMessages, true))
+		require.Nil(t, f.chatStore.DeleteMessage(f.ctx, room.ID, message.ID, member2.Username))
+	})
+
+	t.Run("sender deletes their own message", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+		first := send(f, room.ID, member1.Username, "first")
+		second := send(f, room.ID, member1.Username, "second")
+
+		require.Nil(t, f.chatStore.DeleteMessage(f.ctx, room.ID, second.ID, member1.Username))
+
+		messages, err := f.chatStore.GetRoomMessages(f.ctx, room.ID, 0, 1)
+		require.Nil(t, err)
+		require.Len(t, messages, 1)
+		assert.Equal(t, first.ID, messages[0].ID)
+
+		// the room and the sender fall back to the message before it
+		got, err := f.chatStore.GetRoomByID(f.ctx, room.ID)
+		require.Nil(t, err)
+		assert.Equal(t, first.ID, got.LastMessageSent)
+		assert.Equal(t, "first", got.LastMessageSentData)
+		assert.Equal(t, TextMessage, got.LastMessageSentType)
+		for _, member := range got.Members {
+			if member.Username == member1.Username {
+				assert.Equal(t, first.ID, member.LastMessageRead)
+			}
+		}
+
+		err = f.chatStore.DeleteMessage(f.ctx, room.ID, second.ID, member1.Username)
+		assert.Equal(t, ErrInvalidMessage, err)
+	})
+
+	t.Run("admin deletes the message of a member", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+		message := send(f, room.ID, member1.Username, "hello")
+
+		require.Nil(t, f.chatStore.DeleteMessage(f.ctx, room.ID, message.ID, admin.Username))
+
+		// the room can take it away
+		message = send(f, room.ID, member1.Username, "hello")
+		require.Nil(t, f.chatStore.SetRoomPermission(f.ctx, room.ID, Admin, DeleteOthersMessages, false))
+		err := f.chatStore.DeleteMessage(f.ctx, room.ID, message.ID, admin.Username)
+		assert.Equal(t, ErrDisAllowedOperation, err)
+	})
+
+	t.Run("non member", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+		message := send(f, room.ID, member1.Username, "hello")
+
+		err := f.chatStore.DeleteMessage(f.ctx, room.ID, message.ID, member2.Username)
+		assert.Equal(t, ErrInvalidRoom, err)
+	})
+
+	t.Run("system message", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+
+		messages, err := f.chatStore.GetRoomMessages(f.ctx, room.ID, 0, 1)
+		require.Nil(t, err)
+		require.Len(t, messages, 1)
+		require.Equal(t, SystemMessage, messages[0].Type)
+
+		err = f.chatStore.DeleteMessage(f.ctx, room.ID, messages[0].ID, owner.Username)
+		assert.Equal(t, ErrDisAllowedOperation, err)
+	})
+}
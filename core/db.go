@@ -1,12 +1,16 @@
 package core
 
 import (
+	"context"
 	"database/sql"
-	"os"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io/fs"
 	"strings"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
-	"github.com/pressly/goose/v3"
+	"github.com/mattn/go-sqlite3"
 )
 
 type SQLiteDBOption struct {
@@ -16,6 +20,17 @@ type SQLiteDBOption struct {
 	Cache string
 	// JournalMode be DELETE | TRUNCATE | PERSIST | MEMORY | WAL | OFF
 	JournalMode string
+	// BusyTimeout is how long a connection waits for a lock held by another connection,
+	// such as a checkpoint or another process, before it fails with SQLITE_BUSY.
+	BusyTimeout time.Duration
+	// Synchronous can be OFF | NORMAL | FULL | EXTRA. NORMAL is safe in WAL mode.
+	Synchronous string
+	// ForeignKeys enforces the foreign key constraints of the schema.
+	ForeignKeys bool
+	// MmapSize is how many bytes of the database file are memory mapped. 0 disables mmap.
+	MmapSize int64
+	// MaxReaders is the size of the reader pool. The default is 4.
+	MaxReaders int
 }
 
 func (config *SQLiteDBOption) DSN(sb *strings.Builder) {
@@ -23,32 +38,58 @@ func (config *SQLiteDBOption) DSN(sb *strings.Builder) {
 		return
 	}
 
+	var params []string
 	if config.Mode != "" {
-		sb.WriteString("?mode=")
-		sb.WriteString(config.Mode)
+		params = append(params, "mode="+config.Mode)
 	}
-
 	if config.Cache != "" {
-		sb.WriteString("&cache=")
-		sb.WriteString(config.Cache)
+		params = append(params, "cache="+config.Cache)
+	}
+	if len(params) > 0 {
+		sb.WriteString("?")
+		sb.WriteString(strings.Join(params, "&"))
 	}
+}
 
-	if config.JournalMode != "" {
-		sb.WriteString("&journal_mode=")
-		sb.WriteString(config.JournalMode)
+// pragmas are run on every new connection.
+func (config *SQLiteDBOption) pragmas() []string {
+	if config == nil {
+		return nil
 	}
 
+	var pragmas []string
+	if config.JournalMode != "" {
+		pragmas = append(pragmas, "PRAGMA journal_mode = "+config.JournalMode)
+	}
+	if config.BusyTimeout > 0 {
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA busy_timeout = %d", config.BusyTimeout.Milliseconds()))
+	}
+	if config.Synchronous != "" {
+		pragmas = append(pragmas, "PRAGMA synchronous = "+config.Synchronous)
+	}
+	if config.ForeignKeys {
+		pragmas = append(pragmas, "PRAGMA foreign_keys = ON")
+	}
+	if config.MmapSize > 0 {
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA mmap_size = %d", config.MmapSize))
+	}
+	return pragmas
 }
 
+// SQLiteDB is an SQLite database with a pool of a single writer connection, which is the embedded *sql.DB,
+// and a pool of readers. SQLite allows one writer at a time, so funnelling the writes through one connection
+// queues them in Go rather than failing them with SQLITE_BUSY, while readers are not blocked in WAL mode.
+// In memory databases share a cache between their connections and are not split; Readers is the writer.
 type SQLiteDB struct {
 	*sql.DB
-	config       *SQLiteDBOption
-	file         string
-	migrationDir string
+	Readers    *sql.DB
+	config     *SQLiteDBOption
+	file       string
+	migrations fs.FS
 }
 
-func NewSQLiteDB(file, migrationDir string, config *SQLiteDBOption) (*SQLiteDB, error) {
-	db := &SQLiteDB{config: config, migrationDir: migrationDir, file: file}
+func NewSQLiteDB(file string, migrations fs.FS, config *SQLiteDBOption) (*SQLiteDB, error) {
+	db := &SQLiteDB{config: config, migrations: migrations, file: file}
 
 	var dsn strings.Builder
 	dsn.WriteString("file:")
@@ -57,26 +98,82 @@ func NewSQLiteDB(file, migrationDir string, config *SQLiteDBOption) (*SQLiteDB,
 	if db.config != nil {
 		config.DSN(&dsn)
 	}
-	d, err := sql.Open("sqlite3", dsn.String())
-	if err != nil {
-		return nil, err
+	pragmas := config.pragmas()
+
+	db.DB = sql.OpenDB(sqliteConnector{dsn: dsn.String(), pragmas: pragmas})
+	db.DB.SetMaxOpenConns(1)
+	// connections to a shared memory database fail with SQLITE_LOCKED instead of waiting
+	// for each other, so readers share the single connection of the writer
+	if config != nil && config.Mode == "memory" {
+		db.Readers = db.DB
+		return db, nil
 	}
+	// the connection is kept open, so that the pragmas are not run again for every write
+	db.DB.SetConnMaxIdleTime(0)
+	db.DB.SetConnMaxLifetime(0)
 
-	db.DB = d
+	maxReaders := 4
+	if config != nil && config.MaxReaders > 0 {
+		maxReaders = config.MaxReaders
+	}
+	// readers cannot write by mistake, which would contend with the writer
+	db.Readers = sql.OpenDB(sqliteConnector{dsn: dsn.String(), pragmas: append(pragmas, "PRAGMA query_only = ON")})
+	db.Readers.SetMaxOpenConns(maxReaders)
+	db.Readers.SetMaxIdleConns(maxReaders)
 	return db, nil
 }
 
-func (db *SQLiteDB) Migrate() error {
-	migrationfs := os.DirFS(db.migrationDir)
-	goose.SetBaseFS(migrationfs)
+// Close closes the writer and the readers.
+func (db *SQLiteDB) Close() error {
+	err := db.DB.Close()
+	if db.Readers != db.DB {
+		err = errors.Join(err, db.Readers.Close())
+	}
+	return err
+}
 
-	if err := goose.SetDialect("sqlite3"); err != nil {
-		return err
+// sqliteConnector opens connections to the database at dsn and runs the pragmas on them.
+type sqliteConnector struct {
+	dsn     string
+	pragmas []string
+}
 
-	}
+func (c sqliteConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.Driver().Open(c.dsn)
+}
+
+func (c sqliteConnector) Driver() driver.Driver {
+	return &sqlite3.SQLiteDriver{ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+		for _, pragma := range c.pragmas {
+			if _, err := conn.Exec(pragma, nil); err != nil {
+				return fmt.Errorf("%s: %w", pragma, err)
+			}
+		}
+		return nil
+	}}
+}
 
-	if err := goose.Up(db.DB, "."); err != nil {
+// Migrate applies the pending migrations.
+// If the database schema is newer than the binary, it returns ErrSchemaTooNew.
+func (db *SQLiteDB) Migrate() error {
+	migrator, err := db.Migrator()
+	if err != nil {
 		return err
 	}
-	return nil
+	_, _, err = migrator.Up(context.Background())
+	return err
+}
+
+// Migrator returns a migrator that backs up the database file next to it before applying or rolling back migrations.
+func (db *SQLiteDB) Migrator() (*Migrator, error) {
+	return NewMigrator(db.DB, SQLite, db.migrations, func(ctx context.Context, version int64) (string, error) {
+		dest := fmt.Sprintf("%s.%d-%s.bak", db.file, version, time.Now().UTC().Format("20060102T150405.000000000"))
+		return dest, db.Backup(ctx, dest)
+	})
+}
+
+// Backup writes a consistent copy of the database to the file at dest, which must not exist.
+// It is safe to call while the database is in use.
+func (db *SQLiteDB) Backup(ctx context.Context, dest string) error {
+	return BackupSQLite(ctx, db.Readers, dest)
 }
@@ -0,0 +1,79 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	db := NewTestSQLiteFile(t)
+	userStore := NewSQLUserStore(db)
+	chatStore := NewSQLChatStore(db, userStore)
+
+	const members, messages = 8, 50
+	require.Nil(t, userStore.CreateUser(ctx, User{Username: "owner", Password: "password", Name: "Owner"}))
+	roomID, err := chatStore.CreateRoom(ctx, "General", "owner")
+	require.Nil(t, err)
+	for i := range members {
+		username := fmt.Sprintf("member%d", i)
+		require.Nil(t, userStore.CreateUser(ctx, User{Username: username, Password: "password", Name: username}))
+		require.Nil(t, chatStore.AddRoomMember(ctx, roomID, username, Member))
+	}
+
+	// every member sends and reads as the event router does, while the room is read
+	var wg sync.WaitGroup
+	errs := make(chan error, members*messages*3)
+	for i := range members {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			username := fmt.Sprintf("member%d", i)
+			for j := range messages {
+				_, err := chatStore.SendMessageToRoom(ctx, MessageCreateInput{
+					Type: TextMessage, Data: fmt.Sprintf("%d", j), Sender: username, RoomID: roomID})
+				errs <- err
+				_, _, err = chatStore.ReadRoomMessages(ctx, roomID, username)
+				errs <- err
+				_, err = chatStore.GetRoomMessages(ctx, roomID, 0, 20)
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		var sqliteErr sqlite3.Error
+		if assert.Nil(t, err) || !assert.ErrorAs(t, err, &sqliteErr) {
+			continue
+		}
+		assert.NotEqual(t, sqlite3.ErrBusy, sqliteErr.Code)
+		assert.NotEqual(t, sqlite3.ErrLocked, sqliteErr.Code)
+	}
+
+	sent, err := chatStore.GetRoomMessages(ctx, roomID, 0, members*messages)
+	require.Nil(t, err)
+	assert.Equal(t, members*messages, len(sent))
+}
+
+func TestSQLiteForeignKeys(t *testing.T) {
+	db := NewTestSQLiteFile(t)
+	_, err := db.ExecContext(context.Background(),
+		"INSERT INTO room_members (room_id, username, role) VALUES ('random', 'random', 'member')")
+	var sqliteErr sqlite3.Error
+	require.ErrorAs(t, err, &sqliteErr)
+	assert.Equal(t, sqlite3.ErrConstraintForeignKey, sqliteErr.ExtendedCode)
+}
+
+func TestSQLiteReadersCannotWrite(t *testing.T) {
+	db := NewTestSQLiteFile(t)
+	_, err := db.DB.ExecContext(context.Background(),
+		"INSERT INTO users (username, password, name) VALUES ('alice', '', 'Alice')")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,217 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Dialect is the flavour of SQL spoken by a database backend.
+// The SQL stores write their queries for SQLite with @name or ? parameters;
+// a dialect rewrites the parameters and provides the few expressions that differ.
+type Dialect interface {
+	// Goose is the name goose knows the dialect by.
+	Goose() string
+	// Bind rewrites the parameters of the query and its arguments for the backend.
+	Bind(query string, args []any) (string, []any)
+	// Contains returns an expression that is true when the text expression contains substr.
+	Contains(text, substr string) string
+	// JSONText returns an expression of the text at key in the JSON object of the column.
+	JSONText(column, key string) string
+	// JSONSetText returns an expression of the JSON object of the column with key set to value.
+	JSONSetText(column, key, value string) string
+	// DeferForeignKeys returns the statement that defers the foreign key checks of a transaction
+	// until it commits, or an empty string if they are deferred already.
+	DeferForeignKeys() string
+	// LockTable returns the statement that stops other transactions writing to the table
+	// until the transaction commits, or an empty string if writes are serialized already.
+	LockTable(table string) string
+}
+
+var (
+	// SQLite is the dialect of SQLite, which the queries are written in.
+	SQLite Dialect = sqliteDialect{}
+	// Postgres is the dialect of PostgreSQL.
+	Postgres Dialect = postgresDialect{}
+)
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Goose() string { return "sqlite3" }
+
+func (sqliteDialect) Bind(query string, args []any) (string, []any) { return query, args }
+
+func (sqliteDialect) Contains(text, substr string) string {
+	return fmt.Sprintf("instr(%s, %s) > 0", text, substr)
+}
+
+func (sqliteDialect) JSONText(column, key string) string {
+	return fmt.Sprintf("json_extract(%s, '$.%s')", column, key)
+}
+
+func (sqliteDialect) JSONSetText(column, key, value string) string {
+	return fmt.Sprintf("json_set(%s, '$.%s', %s)", column, key, value)
+}
+
+func (sqliteDialect) DeferForeignKeys() string { return "PRAGMA defer_foreign_keys = ON" }
+
+// LockTable returns an empty string; SQLite has a single writer.
+func (sqliteDialect) LockTable(table string) string { return "" }
+
+type postgresDialect struct{}
+
+func (postgresDialect) Goose() string { return "postgres" }
+
+// Bind replaces @name and ? parameters with $n placeholders, giving every name a single placeholder.
+// Parameters inside string literals are left alone.
+func (postgresDialect) Bind(query string, args []any) (string, []any) {
+	named := make(map[string]any)
+	var positional []any
+	for _, arg := range args {
+		if n, ok := arg.(sql.NamedArg); ok {
+			named[n.Name] = n.Value
+		} else {
+			positional = append(positional, arg)
+		}
+	}
+
+	var sb strings.Builder
+	bound := make([]any, 0, len(args))
+	placeholders := make(map[string]int)
+	inString := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'':
+			inString = !inString
+			sb.WriteByte(c)
+		case inString:
+			sb.WriteByte(c)
+		case c == '?' && len(positional) > 0:
+			bound = append(bound, positional[0])
+			positional = positional[1:]
+			sb.WriteString("$" + strconv.Itoa(len(bound)))
+		case c == '@':
+			end := i + 1
+			for end < len(query) && isIdentChar(query[end]) {
+				end++
+			}
+			name := query[i+1 : end]
+			value, ok := named[name]
+			if !ok {
+				sb.WriteByte(c)
+				continue
+			}
+			n, ok := placeholders[name]
+			if !ok {
+				bound = append(bound, value)
+				n = len(bound)
+				placeholders[name] = n
+			}
+			sb.WriteString("$" + strconv.Itoa(n))
+			i = end - 1
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), bound
+}
+
+func isIdentChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+// DeferForeignKeys returns an empty string; the foreign keys of the schema are INITIALLY DEFERRED.
+func (postgresDialect) DeferForeignKeys() string { return "" }
+
+func (postgresDialect) LockTable(table string) string {
+	return fmt.Sprintf("LOCK TABLE %s IN EXCLUSIVE MODE", table)
+}
+
+func (postgresDialect) Contains(text, substr string) string {
+	return fmt.Sprintf("strpos(%s, %s) > 0", text, substr)
+}
+
+func (postgresDialect) JSONText(column, key string) string {
+	return fmt.Sprintf("(%s::jsonb ->> '%s')", column, key)
+}
+
+func (postgresDialect) JSONSetText(column, key, value string) string {
+	return fmt.Sprintf("jsonb_set(%s::jsonb, '{%s}', to_jsonb(%s::text))::text", column, key, value)
+}
+
+// DB is a *sql.DB whose queries are bound for its dialect before they are run.
+// Statements and transactions run on the writer, which is the *sql.DB itself
+// unless it was made with NewReadWriteDB.
+type DB struct {
+	*sql.DB
+	Dialect Dialect
+	writer  *sql.DB
+}
+
+// NewDB wraps the database so that the queries of the SQL stores run on it.
+func NewDB(db *sql.DB, dialect Dialect) *DB {
+	return &DB{DB: db, Dialect: dialect, writer: db}
+}
+
+// NewReadWriteDB wraps the databases so that the queries of the SQL stores run on readers,
+// and their statements and transactions on writer.
+func NewReadWriteDB(readers, writer *sql.DB, dialect Dialect) *DB {
+	return &DB{DB: readers, Dialect: dialect, writer: writer}
+}
+
+// Close closes the readers and the writer.
+func (db *DB) Close() error {
+	err := db.DB.Close()
+	if db.writer != db.DB {
+		err = errors.Join(err, db.writer.Close())
+	}
+	return err
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	query, args = db.Dialect.Bind(query, args)
+	return db.writer.ExecContext(ctx, query, args...)
+}
+
+func (db *DB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	query, args = db.Dialect.Bind(query, args)
+	return db.DB.QueryContext(ctx, query, args...)
+}
+
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	query, args = db.Dialect.Bind(query, args)
+	return db.DB.QueryRowContext(ctx, query, args...)
+}
+
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	tx, err := db.writer.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, Dialect: db.Dialect}, nil
+}
+
+// Tx is a *sql.Tx whose queries are bound for its dialect before they are run.
+type Tx struct {
+	*sql.Tx
+	Dialect Dialect
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	query, args = tx.Dialect.Bind(query, args)
+	return tx.Tx.ExecContext(ctx, query, args...)
+}
+
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	query, args = tx.Dialect.Bind(query, args)
+	return tx.Tx.QueryContext(ctx, query, args...)
+}
+
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	query, args = tx.Dialect.Bind(query, args)
+	return tx.Tx.QueryRowContext(ctx, query, args...)
+}
@@ -0,0 +1,37 @@
+package core
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostgresBind(t *testing.T) {
+	t.Run("named parameters", func(t *testing.T) {
+		query, args := Postgres.Bind(
+			"SELECT * FROM rooms WHERE id = @id AND (name = @name OR topic = @name) AND id != @id_other",
+			[]any{sql.Named("name", "general"), sql.Named("id", "1"), sql.Named("id_other", "2")})
+		assert.Equal(t, "SELECT * FROM rooms WHERE id = $1 AND (name = $2 OR topic = $2) AND id != $3", query)
+		assert.Equal(t, []any{"1", "general", "2"}, args)
+	})
+
+	t.Run("positional parameters", func(t *testing.T) {
+		query, args := Postgres.Bind("UPDATE room_invites SET expires_at = ? WHERE code = ?", []any{1, "abc"})
+		assert.Equal(t, "UPDATE room_invites SET expires_at = $1 WHERE code = $2", query)
+		assert.Equal(t, []any{1, "abc"}, args)
+	})
+
+	t.Run("string literals", func(t *testing.T) {
+		query, args := Postgres.Bind("SELECT '@name?', 'it''s' || @name", []any{sql.Named("name", "x")})
+		assert.Equal(t, "SELECT '@name?', 'it''s' || $1", query)
+		assert.Equal(t, []any{"x"}, args)
+	})
+}
+
+func TestSQLiteBind(t *testing.T) {
+	args := []any{sql.Named("id", "1")}
+	query, bound := SQLite.Bind("SELECT * FROM rooms WHERE id = @id", args)
+	assert.Equal(t, "SELECT * FROM rooms WHERE id = @id", query)
+	assert.Equal(t, args, bound)
+}
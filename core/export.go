@@ -0,0 +1,252 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	// exports are rendered in the timezone of the requester, whatever the host has installed
+	_ "time/tzdata"
+)
+
+// ErrInvalidExport is returned when an export job does not exist or its input is invalid.
+var ErrInvalidExport = errors.New("invalid export")
+
+// ExportFormat is the format a room is exported in.
+type ExportFormat = string
+
+const (
+	// ExportJSON is a structured document of the room, its members and messages.
+	ExportJSON ExportFormat = "json"
+	// ExportMarkdown is a readable transcript.
+	ExportMarkdown ExportFormat = "markdown"
+	// ExportHTML is a standalone web page of the transcript.
+	ExportHTML ExportFormat = "html"
+)
+
+// ExportStatus is the progress of an export job.
+type ExportStatus = string
+
+const (
+	ExportPending ExportStatus = "pending"
+	ExportRunning ExportStatus = "running"
+	ExportDone    ExportStatus = "done"
+	ExportFailed  ExportStatus = "failed"
+)
+
+// ExportJob is an export of a room requested by one of its members.
+type ExportJob struct {
+	ID       string       `json:"id"`
+	RoomID   string       `json:"room_id"`
+	Username string       `json:"username"`
+	Format   ExportFormat `json:"format"`
+	// Timezone is the IANA name of the timezone that timestamps are written in.
+	Timezone string       `json:"timezone"`
+	Status   ExportStatus `json:"status"`
+	// Error tells why the job failed.
+	Error string `json:"error,omitempty"`
+	// File is the key of the export in the blob store of the exporter once it is done.
+	File        string     `json:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+// ExportInput represents the input for exporting a room.
+type ExportInput struct {
+	Format ExportFormat `json:"format" validate:"required,oneof=json markdown html"`
+	// Timezone is the IANA name of a timezone, e.g. Asia/Bangkok. The default is UTC.
+	Timezone string `json:"timezone" validate:"omitempty,timezone"`
+}
+
+func (i *ExportInput) Validate() error {
+	return validate.Struct(i)
+}
+
+// exportWorkers is how many exports run at the same time.
+const exportWorkers = 2
+
+// Exporter runs export jobs in the background and keeps the exports until they expire.
+type Exporter struct {
+	store     ChatStore
+	userStore UserStore
+	files     BlobStore
+	ttl       time.Duration
+	logger    *slog.Logger
+	ctx       context.Context
+	wg        *sync.WaitGroup
+	workers   chan struct{}
+}
+
+// NewExporter creates an exporter that writes exports to files and deletes them after ttl.
+// Jobs stop when ctx is done, and wg waits for them to.
+func NewExporter(ctx context.Context, wg *sync.WaitGroup, store ChatStore, userStore UserStore,
+	files BlobStore, ttl time.Duration, logger *slog.Logger) *Exporter {
+	return &Exporter{
+		store:     store,
+		userStore: userStore,
+		files:     files,
+		ttl:       ttl,
+		logger:    logger,
+		ctx:       ctx,
+		wg:        wg,
+		workers:   make(chan struct{}, exportWorkers),
+	}
+}
+
+// Start runs the jobs that were left unfinished by the last shutdown
+// and deletes expired exports every hour until the context of the exporter is done.
+func (e *Exporter) Start() error {
+	jobs, err := e.store.GetUnfinishedExportJobs(e.ctx)
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		e.run(job)
+	}
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			e.expire()
+			select {
+			case <-e.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// Submit creates an export job of the room for the user and runs it in the background.
+// It returns the errors of ChatStore.CreateExportJob.
+func (e *Exporter) Submit(ctx context.Context, roomID, username string, input ExportInput) (*ExportJob, error) {
+	job, err := e.store.CreateExportJob(ctx, roomID, username, input)
+	if err != nil {
+		return nil, err
+	}
+	e.run(*job)
+	return job, nil
+}
+
+// Get returns the export job of the user.
+// If the job does not exist or was requested by someone else, it returns ErrInvalidExport.
+func (e *Exporter) Get(ctx context.Context, id, username string) (*ExportJob, error) {
+	job, err := e.store.GetExportJob(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Username != username {
+		return nil, ErrInvalidExport
+	}
+	return job, nil
+}
+
+// Open returns the export of a job that is done. The caller must close it.
+// If the job is not done, it returns ErrInvalidExport.
+// If the user who requested it is no longer a member of the room, it returns ErrInvalidRoom.
+func (e *Exporter) Open(ctx context.Context, job *ExportJob) (io.ReadSeekCloser, error) {
+	if job.Status != ExportDone {
+		return nil, ErrInvalidExport
+	}
+	inRoom, _, err := e.store.IsRoomMember(ctx, job.RoomID, job.Username)
+	if err != nil {
+		return nil, err
+	}
+	if !inRoom {
+		return nil, ErrInvalidRoom
+	}
+	return e.files.Open(ctx, job.File)
+}
+
+// DeleteUserExports deletes the export jobs of the user and their exports,
+// e.g. when the account of the user is deleted.
+func (e *Exporter) DeleteUserExports(ctx context.Context, username string) {
+	jobs, err := e.store.DeleteUserExportJobs(ctx, username)
+	if err != nil {
+		e.logger.Error(fmt.Sprintf("delete export jobs of %s: %v", username, err))
+		return
+	}
+	e.deleteFiles(ctx, jobs)
+}
+
+func (e *Exporter) run(job ExportJob) {
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		select {
+		case e.workers <- struct{}{}:
+			defer func() { <-e.workers }()
+		case <-e.ctx.Done():
+			return
+		}
+
+		job.Status = ExportRunning
+		if err := e.store.UpdateExportJob(e.ctx, job); err != nil {
+			e.logger.Error(fmt.Sprintf("update export job %s: %v", job.ID, err))
+			return
+		}
+
+		file, err := e.export(job)
+		now := time.Now().UTC()
+		job.CompletedAt = &now
+		if err != nil {
+			// a job stopped by a shutdown is run again on the next start
+			if e.ctx.Err() != nil {
+				return
+			}
+			e.logger.Error(fmt.Sprintf("export room %s: %v", job.RoomID, err))
+			job.Status, job.Error = ExportFailed, err.Error()
+		} else {
+			job.Status, job.File = ExportDone, file
+		}
+		if err := e.store.UpdateExportJob(e.ctx, job); err != nil {
+			// the job was deleted while it ran, along with its user
+			if errors.Is(err, ErrInvalidExport) {
+				e.deleteFiles(e.ctx, []ExportJob{job})
+				return
+			}
+			e.logger.Error(fmt.Sprintf("update export job %s: %v", job.ID, err))
+		}
+	}()
+}
+
+// export writes the export to a new blob and returns its key.
+func (e *Exporter) export(job ExportJob) (string, error) {
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(WriteExport(e.ctx, w, e.store, e.userStore, job))
+	}()
+	key, err := e.files.Put(e.ctx, r)
+	// unblock the writer if the blob store gave up early
+	r.CloseWithError(err)
+	return key, err
+}
+
+func (e *Exporter) expire() {
+	jobs, err := e.store.DeleteExportJobs(e.ctx, time.Now().Add(-e.ttl))
+	if err != nil {
+		e.logger.Error(fmt.Sprintf("delete expired export jobs: %v", err))
+		return
+	}
+	e.deleteFiles(e.ctx, jobs)
+}
+
+// deleteFiles deletes the exports of jobs that are done.
+func (e *Exporter) deleteFiles(ctx context.Context, jobs []ExportJob) {
+	for _, job := range jobs {
+		if job.File == "" {
+			continue
+		}
+		if err := e.files.Delete(ctx, job.File); err != nil {
+			e.logger.Error(fmt.Sprintf("delete export %s: %v", job.ID, err))
+		}
+	}
+}
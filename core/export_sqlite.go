@@ -0,0 +1,186 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// streamPageSize is how many messages StreamRoomMessages reads at a time.
+const streamPageSize = 500
+
+func (s *SQLChatStore) StreamRoomMessages(ctx context.Context, roomID string, fn func(Message) error) error {
+	after := 0
+	for {
+		rows, err := s.db.QueryContext(ctx, `
+		SELECT id, type, data, room_id, sender, sent_at FROM messages
+		WHERE room_id = @room_id AND id > @after
+		ORDER BY id LIMIT @limit`,
+			sql.Named("room_id", roomID), sql.Named("after", after), sql.Named("limit", streamPageSize))
+		if err != nil {
+			return fmt.Errorf("QueryContext: %w", err)
+		}
+		// the page is read before fn is called, so that fn can use the store
+		page := make([]Message, 0, streamPageSize)
+		for rows.Next() {
+			var m Message
+			if err := rows.Scan(&m.ID, &m.Type, &m.Data, &m.RoomID, &m.Sender, &m.SentAt); err != nil {
+				rows.Close()
+				return fmt.Errorf("rows.Scan: %w", err)
+			}
+			page = append(page, m)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("rows.Err: %w", err)
+		}
+
+		for _, m := range page {
+			if err := fn(m); err != nil {
+				return err
+			}
+		}
+		if len(page) < streamPageSize {
+			return nil
+		}
+		after = page[len(page)-1].ID
+	}
+}
+
+const exportJobColumns = "id, room_id, username, format, timezone, status, error, file, created_at, completed_at"
+
+func scanExportJob(row scanner) (*ExportJob, error) {
+	var job ExportJob
+	if err := row.Scan(&job.ID, &job.RoomID, &job.Username, &job.Format, &job.Timezone,
+		&job.Status, &job.Error, &job.File, &job.CreatedAt, &job.CompletedAt); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (s *SQLChatStore) CreateExportJob(ctx context.Context, roomID, username string, input ExportInput) (*ExportJob, error) {
+	if err := input.Validate(); err != nil {
+		return nil, ErrInvalidExport
+	}
+	ok, _, err := s.IsRoomMember(ctx, roomID, username)
+	if err != nil {
+		return nil, fmt.Errorf("IsRoomMember: %w", err)
+	}
+	if !ok {
+		return nil, ErrInvalidRoom
+	}
+
+	job := ExportJob{
+		ID:        uuid.New().String(),
+		RoomID:    roomID,
+		Username:  username,
+		Format:    input.Format,
+		Timezone:  input.Timezone,
+		Status:    ExportPending,
+		CreatedAt: time.Now().UTC(),
+	}
+	if job.Timezone == "" {
+		job.Timezone = "UTC"
+	}
+	_, err = s.db.ExecContext(ctx, `
+	INSERT INTO export_jobs (id, room_id, username, format, timezone, status, created_at)
+	VALUES (@id, @room_id, @username, @format, @timezone, @status, @created_at)`,
+		sql.Named("id", job.ID), sql.Named("room_id", job.RoomID), sql.Named("username", job.Username),
+		sql.Named("format", job.Format), sql.Named("timezone", job.Timezone),
+		sql.Named("status", job.Status), sql.Named("created_at", job.CreatedAt))
+	if err != nil {
+		return nil, fmt.Errorf("ExecContext: %w", err)
+	}
+	return &job, nil
+}
+
+func (s *SQLChatStore) GetExportJob(ctx context.Context, id string) (*ExportJob, error) {
+	row := s.db.QueryRowContext(ctx,
+		"SELECT "+exportJobColumns+" FROM export_jobs WHERE id = @id", sql.Named("id", id))
+	job, err := scanExportJob(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidExport
+		}
+		return nil, fmt.Errorf("row.Scan: %w", err)
+	}
+	return job, nil
+}
+
+func (s *SQLChatStore) UpdateExportJob(ctx context.Context, job ExportJob) error {
+	result, err := s.db.ExecContext(ctx, `
+	UPDATE export_jobs SET status = @status, error = @error, file = @file, completed_at = @completed_at
+	WHERE id = @id`,
+		sql.Named("status", job.Status), sql.Named("error", job.Error), sql.Named("file", job.File),
+		sql.Named("completed_at", job.CompletedAt), sql.Named("id", job.ID))
+	if err != nil {
+		return fmt.Errorf("ExecContext: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("RowsAffected: %w", err)
+	}
+	if affected == 0 {
+		return ErrInvalidExport
+	}
+	return nil
+}
+
+func (s *SQLChatStore) GetUnfinishedExportJobs(ctx context.Context) ([]ExportJob, error) {
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT `+exportJobColumns+` FROM export_jobs
+	WHERE status IN (@pending, @running) ORDER BY created_at, id`,
+		sql.Named("pending", ExportPending), sql.Named("running", ExportRunning))
+	if err != nil {
+		return nil, fmt.Errorf("QueryContext: %w", err)
+	}
+	return scanExportJobs(rows)
+}
+
+func (s *SQLChatStore) DeleteExportJobs(ctx context.Context, before time.Time) ([]ExportJob, error) {
+	return s.deleteExportJobs(ctx, "created_at < @before", sql.Named("before", before.UTC()))
+}
+
+func (s *SQLChatStore) DeleteUserExportJobs(ctx context.Context, username string) ([]ExportJob, error) {
+	return s.deleteExportJobs(ctx, "username = @username", sql.Named("username", username))
+}
+
+// deleteExportJobs deletes the jobs that match the condition and returns them.
+func (s *SQLChatStore) deleteExportJobs(ctx context.Context, where string, args ...any) ([]ExportJob, error) {
+	// the deletion has to run on the writer, which only transactions are guaranteed to use
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "DELETE FROM export_jobs WHERE "+where+" RETURNING "+exportJobColumns, args...)
+	if err != nil {
+		return nil, fmt.Errorf("QueryContext: %w", err)
+	}
+	jobs, err := scanExportJobs(rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("Commit: %w", err)
+	}
+	return jobs, nil
+}
+
+func scanExportJobs(rows *sql.Rows) ([]ExportJob, error) {
+	defer rows.Close()
+	var jobs []ExportJob
+	for rows.Next() {
+		job, err := scanExportJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("rows.Scan: %w", err)
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
@@ -0,0 +1,240 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamRoomMessages(t *testing.T) {
+	f := NewChatFixture(t)
+	defer f.tearDown()
+	room := seedRoomWithRoles(f)
+	for i := range streamPageSize + 10 {
+		seedMessage(f, room.ID, member1.Username, fmt.Sprintf("%d", i))
+	}
+	sent, err := f.chatStore.GetRoomMessages(f.ctx, room.ID, 0, streamPageSize*2)
+	require.Nil(t, err)
+
+	var streamed []Message
+	require.Nil(t, f.chatStore.StreamRoomMessages(f.ctx, room.ID, func(m Message) error {
+		streamed = append(streamed, m)
+		return nil
+	}))
+	assert.Equal(t, sent, streamed)
+
+	stop := fmt.Errorf("stop")
+	calls := 0
+	assert.Equal(t, stop, f.chatStore.StreamRoomMessages(f.ctx, room.ID, func(m Message) error {
+		calls++
+		return stop
+	}))
+	assert.Equal(t, 1, calls)
+}
+
+func TestExportJobs(t *testing.T) {
+	f := NewChatFixture(t)
+	defer f.tearDown()
+	room := seedRoomWithRoles(f)
+
+	_, err := f.chatStore.CreateExportJob(f.ctx, room.ID, member2.Username, ExportInput{Format: ExportJSON})
+	assert.Equal(t, ErrInvalidRoom, err)
+	_, err = f.chatStore.CreateExportJob(f.ctx, room.ID, member1.Username, ExportInput{Format: "pdf"})
+	assert.Equal(t, ErrInvalidExport, err)
+	_, err = f.chatStore.CreateExportJob(f.ctx, room.ID, member1.Username,
+		ExportInput{Format: ExportJSON, Timezone: "Mars/Olympus_Mons"})
+	assert.Equal(t, ErrInvalidExport, err)
+
+	job, err := f.chatStore.CreateExportJob(f.ctx, room.ID, member1.Username, ExportInput{Format: ExportMarkdown})
+	require.Nil(t, err)
+	assert.Equal(t, ExportPending, job.Status)
+	assert.Equal(t, "UTC", job.Timezone)
+
+	unfinished, err := f.chatStore.GetUnfinishedExportJobs(f.ctx)
+	require.Nil(t, err)
+	require.Len(t, unfinished, 1)
+	assert.Equal(t, job.ID, unfinished[0].ID)
+
+	completedAt := time.Now().UTC()
+	job.Status, job.File, job.CompletedAt = ExportDone, "file", &completedAt
+	require.Nil(t, f.chatStore.UpdateExportJob(f.ctx, *job))
+	got, err := f.chatStore.GetExportJob(f.ctx, job.ID)
+	require.Nil(t, err)
+	assert.Equal(t, ExportDone, got.Status)
+	assert.Equal(t, "file", got.File)
+	assert.NotNil(t, got.CompletedAt)
+	unfinished, err = f.chatStore.GetUnfinishedExportJobs(f.ctx)
+	require.Nil(t, err)
+	assert.Empty(t, unfinished)
+
+	deleted, err := f.chatStore.DeleteExportJobs(f.ctx, time.Now().Add(time.Minute))
+	require.Nil(t, err)
+	require.Len(t, deleted, 1)
+	assert.Equal(t, "file", deleted[0].File)
+	_, err = f.chatStore.GetExportJob(f.ctx, job.ID)
+	assert.Equal(t, ErrInvalidExport, err)
+	assert.Equal(t, ErrInvalidExport, f.chatStore.UpdateExportJob(f.ctx, *job))
+}
+
+func TestWriteExport(t *testing.T) {
+	f := NewChatFixture(t)
+	defer f.tearDown()
+	room := seedRoomWithRoles(f)
+	message := seedMessage(f, room.ID, member1.Username, "<b>hello</b>")
+	job := ExportJob{RoomID: room.ID, Username: member1.Username, Timezone: "Asia/Bangkok"}
+
+	t.Run("json", func(t *testing.T) {
+		job.Format = ExportJSON
+		var buf bytes.Buffer
+		require.Nil(t, WriteExport(f.ctx, &buf, f.chatStore, f.userStore, job))
+
+		var export struct {
+			exportHeader
+			Messages []exportMessage `json:"messages"`
+		}
+		require.Nil(t, json.Unmarshal(buf.Bytes(), &export))
+		assert.Equal(t, room.Name, export.Room.Name)
+		assert.Equal(t, "Asia/Bangkok", export.Timezone)
+		assert.Len(t, export.Members, 3)
+		i := slices.IndexFunc(export.Members, func(m exportMember) bool { return m.Username == member1.Username })
+		assert.Equal(t, "Member 1", export.Members[i].Name)
+
+		last := export.Messages[len(export.Messages)-1]
+		assert.Equal(t, message.ID, last.ID)
+		assert.Equal(t, "<b>hello</b>", last.Text)
+		assert.Equal(t, "Member 1", last.SenderName)
+		_, offset := last.SentAt.Zone()
+		assert.Equal(t, 7*60*60, offset)
+		// the room was created by its owner
+		assert.Equal(t, "system", export.Messages[0].Type)
+		assert.Equal(t, "Owner created the room", export.Messages[0].Text)
+	})
+
+	t.Run("markdown", func(t *testing.T) {
+		job.Format = ExportMarkdown
+		var buf bytes.Buffer
+		require.Nil(t, WriteExport(f.ctx, &buf, f.chatStore, f.userStore, job))
+		assert.True(t, strings.HasPrefix(buf.String(), "# "+room.Name))
+		assert.Contains(t, buf.String(), "- Member 1 (@member1), member")
+		assert.Contains(t, buf.String(), "**Member 1** (@member1) · "+message.SentAt.In(mustLoadLocation(t, "Asia/Bangkok")).Format(exportTimeFormat))
+		assert.Contains(t, buf.String(), "<b>hello</b>")
+	})
+
+	t.Run("html", func(t *testing.T) {
+		job.Format = ExportHTML
+		var buf bytes.Buffer
+		require.Nil(t, WriteExport(f.ctx, &buf, f.chatStore, f.userStore, job))
+		assert.Contains(t, buf.String(), "&lt;b&gt;hello&lt;/b&gt;")
+		assert.NotContains(t, buf.String(), "<b>hello</b>")
+		assert.True(t, strings.HasSuffix(buf.String(), "</html>\n"))
+	})
+
+	t.Run("room does not exist", func(t *testing.T) {
+		job := ExportJob{RoomID: "random", Format: ExportJSON, Timezone: "UTC"}
+		assert.Equal(t, ErrInvalidRoom, WriteExport(f.ctx, io.Discard, f.chatStore, f.userStore, job))
+	})
+}
+
+func TestExporter(t *testing.T) {
+	f := NewChatFixture(t)
+	defer f.tearDown()
+	room := seedRoomWithRoles(f)
+	seedMessage(f, room.ID, member1.Username, "hello")
+	files, err := NewLocalBlobStore(t.TempDir(), 0)
+	require.Nil(t, err)
+	var wg sync.WaitGroup
+	exporter := NewExporter(f.ctx, &wg, f.chatStore, f.userStore, files, time.Hour,
+		slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	job, err := exporter.Submit(f.ctx, room.ID, member1.Username, ExportInput{Format: ExportJSON})
+	require.Nil(t, err)
+	require.Eventually(t, func() bool {
+		job, err = exporter.Get(f.ctx, job.ID, member1.Username)
+		require.Nil(t, err)
+		return job.Status == ExportDone
+	}, 5*time.Second, 10*time.Millisecond)
+
+	file, err := exporter.Open(f.ctx, job)
+	require.Nil(t, err)
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	require.Nil(t, err)
+	assert.True(t, json.Valid(content))
+	assert.Contains(t, string(content), `"text":"hello"`)
+
+	// only the user who requested an export can see it
+	_, err = exporter.Get(f.ctx, job.ID, admin.Username)
+	assert.Equal(t, ErrInvalidExport, err)
+
+	// exports are kept until they expire
+	exporter.expire()
+	_, err = exporter.Get(f.ctx, job.ID, member1.Username)
+	require.Nil(t, err)
+	exporter.ttl = 0
+	exporter.expire()
+	_, err = exporter.Get(f.ctx, job.ID, member1.Username)
+	assert.Equal(t, ErrInvalidExport, err)
+	_, err = files.Open(f.ctx, job.File)
+	assert.Equal(t, ErrBlobNotFound, err)
+	wg.Wait()
+}
+
+func TestExporterAccess(t *testing.T) {
+	f := NewChatFixture(t)
+	defer f.tearDown()
+	room := seedRoomWithRoles(f)
+	seedMessage(f, room.ID, member1.Username, "hello")
+	files, err := NewLocalBlobStore(t.TempDir(), 0)
+	require.Nil(t, err)
+	var wg sync.WaitGroup
+	exporter := NewExporter(f.ctx, &wg, f.chatStore, f.userStore, files, time.Hour,
+		slog.New(slog.NewTextHandler(io.Discard, nil)))
+	export := func(username string) *ExportJob {
+		job, err := exporter.Submit(f.ctx, room.ID, username, ExportInput{Format: ExportMarkdown})
+		require.Nil(t, err)
+		wg.Wait()
+		job, err = exporter.Get(f.ctx, job.ID, username)
+		require.Nil(t, err)
+		require.Equal(t, ExportDone, job.Status)
+		return job
+	}
+
+	t.Run("member left the room", func(t *testing.T) {
+		job := export(member1.Username)
+		require.Nil(t, f.chatStore.RemoveRoomMember(f.ctx, room.ID, member1.Username))
+
+		_, err := exporter.Open(f.ctx, job)
+		assert.Equal(t, ErrInvalidRoom, err)
+	})
+
+	t.Run("user deleted", func(t *testing.T) {
+		job := export(admin.Username)
+		other := export(owner.Username)
+
+		exporter.DeleteUserExports(f.ctx, admin.Username)
+		_, err := exporter.Get(f.ctx, job.ID, admin.Username)
+		assert.Equal(t, ErrInvalidExport, err)
+		_, err = files.Open(f.ctx, job.File)
+		assert.Equal(t, ErrBlobNotFound, err)
+
+		file, err := exporter.Open(f.ctx, other)
+		require.Nil(t, err)
+		file.Close()
+	})
+}
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	require.Nil(t, err)
+	return location
+}
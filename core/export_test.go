@@ -0,0 +1,37 @@
+package core
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/putto11262002/chatter/migrations"
+)
+
+// NewTestDB returns an empty database of the backend the tests run against, see NewBaseFixture.
+// It is closed when the test finishes.
+func NewTestDB(t *testing.T) *DB {
+	f := NewBaseFixture(t)
+	t.Cleanup(f.tearDown)
+	return f.db
+}
+
+// NewTestSQLiteFile returns an empty SQLite database in a file, opened the way the server opens it,
+// with a single writer and a pool of readers. It is closed when the test finishes.
+func NewTestSQLiteFile(t *testing.T) *DB {
+	db, err := NewSQLiteDB(filepath.Join(t.TempDir(), "chatter.db"), migrations.SQLite, &SQLiteDBOption{
+		Mode:        "rwc",
+		JournalMode: "WAL",
+		BusyTimeout: 5 * time.Second,
+		Synchronous: "NORMAL",
+		ForeignKeys: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	return NewReadWriteDB(db.Readers, db.DB, SQLite)
+}
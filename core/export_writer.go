@@ -0,0 +1,365 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// exportTimeFormat is how timestamps are written in Markdown and HTML exports.
+const exportTimeFormat = "2006-01-02 15:04:05 MST"
+
+type exportRoom struct {
+	ID          string         `json:"id"`
+	Name        string         `json:"name"`
+	Topic       string         `json:"topic"`
+	Description string         `json:"description"`
+	Visibility  RoomVisibility `json:"visibility"`
+	// Avatar is the metadata of the avatar image, which is not part of the export.
+	Avatar     *exportAttachment `json:"avatar"`
+	ArchivedAt *time.Time        `json:"archived_at"`
+}
+
+type exportAttachment struct {
+	Key string `json:"key"`
+}
+
+type exportMember struct {
+	Username string     `json:"username"`
+	Name     string     `json:"name"`
+	Role     MemberRole `json:"role"`
+}
+
+type exportMessage struct {
+	ID         int       `json:"id"`
+	Type       string    `json:"type"`
+	Sender     string    `json:"sender"`
+	SenderName string    `json:"sender_name"`
+	SentAt     time.Time `json:"sent_at"`
+	// Text is the content of a text message, or the description of a system message.
+	Text string `json:"text"`
+	// Event is the structured content of a system message.
+	Event *SystemMessageBody `json:"event,omitempty"`
+}
+
+type exportHeader struct {
+	Room       exportRoom     `json:"room"`
+	ExportedBy string         `json:"exported_by"`
+	ExportedAt time.Time      `json:"exported_at"`
+	Timezone   string         `json:"timezone"`
+	Members    []exportMember `json:"members"`
+}
+
+// exportWriter writes the parts of an export in one format.
+type exportWriter interface {
+	header(w io.Writer, h exportHeader) error
+	message(w io.Writer, m exportMessage, first bool) error
+	footer(w io.Writer) error
+}
+
+// WriteExport writes the room of the job with its members and messages to w in the format of the job.
+// Messages are read from the store a page at a time, oldest first, and timestamps are written in
+// the timezone of the job.
+// If the room does not exist, it returns ErrInvalidRoom.
+func WriteExport(ctx context.Context, w io.Writer, store ChatStore, userStore UserStore, job ExportJob) error {
+	var writer exportWriter
+	switch job.Format {
+	case ExportJSON:
+		writer = jsonExportWriter{}
+	case ExportMarkdown:
+		writer = markdownExportWriter{}
+	case ExportHTML:
+		writer = htmlExportWriter{}
+	default:
+		return ErrInvalidExport
+	}
+	location, err := time.LoadLocation(job.Timezone)
+	if err != nil {
+		return ErrInvalidExport
+	}
+
+	room, err := store.GetRoomByID(ctx, job.RoomID)
+	if err != nil {
+		return err
+	}
+	if room == nil {
+		return ErrInvalidRoom
+	}
+
+	names := newExportNames(userStore)
+	usernames := []string{job.Username}
+	for _, member := range room.Members {
+		usernames = append(usernames, member.Username)
+	}
+	if err := names.load(ctx, usernames...); err != nil {
+		return err
+	}
+
+	h := exportHeader{
+		Room: exportRoom{
+			ID: room.ID, Name: room.Name, Topic: room.Topic, Description: room.Description,
+			Visibility: room.Visibility, ArchivedAt: room.ArchivedAt,
+		},
+		ExportedBy: job.Username,
+		ExportedAt: time.Now().In(location),
+		Timezone:   job.Timezone,
+		Members:    make([]exportMember, 0, len(room.Members)),
+	}
+	if room.Avatar != "" {
+		h.Room.Avatar = &exportAttachment{Key: room.Avatar}
+	}
+	if h.Room.ArchivedAt != nil {
+		archivedAt := h.Room.ArchivedAt.In(location)
+		h.Room.ArchivedAt = &archivedAt
+	}
+	for _, member := range room.Members {
+		h.Members = append(h.Members, exportMember{
+			Username: member.Username, Name: names.get(ctx, member.Username), Role: member.Role})
+	}
+	slices.SortFunc(h.Members, func(a, b exportMember) int { return strings.Compare(a.Username, b.Username) })
+
+	buf := bufio.NewWriter(w)
+	if err := writer.header(buf, h); err != nil {
+		return err
+	}
+	first := true
+	err = store.StreamRoomMessages(ctx, job.RoomID, func(message Message) error {
+		m := exportMessage{
+			ID:         message.ID,
+			Type:       "text",
+			Sender:     message.Sender,
+			SenderName: names.get(ctx, message.Sender),
+			SentAt:     message.SentAt.In(location),
+			Text:       message.Data,
+		}
+		if message.Type == SystemMessage {
+			var body SystemMessageBody
+			if err := json.Unmarshal([]byte(message.Data), &body); err != nil {
+				return fmt.Errorf("json.Unmarshal: %w", err)
+			}
+			m.Type, m.Event = "system", &body
+			m.Text = describeSystemMessage(body, func(username string) string { return names.get(ctx, username) })
+		}
+		err := writer.message(buf, m, first)
+		first = false
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := writer.footer(buf); err != nil {
+		return err
+	}
+	return buf.Flush()
+}
+
+// exportNames looks up the names of users, including those who have left the room, once each.
+type exportNames struct {
+	userStore UserStore
+	names     map[string]string
+}
+
+func newExportNames(userStore UserStore) *exportNames {
+	return &exportNames{userStore: userStore, names: make(map[string]string)}
+}
+
+func (n *exportNames) load(ctx context.Context, usernames ...string) error {
+	users, err := n.userStore.GetUsersByUsernames(ctx, usernames...)
+	if err != nil {
+		return fmt.Errorf("GetUsersByUsernames: %w", err)
+	}
+	for _, username := range usernames {
+		n.names[username] = username
+	}
+	for _, user := range users {
+		if user.Name != "" {
+			n.names[user.Username] = user.Name
+		}
+	}
+	return nil
+}
+
+// get returns the name of the user, or the username if the user has none or cannot be found.
+func (n *exportNames) get(ctx context.Context, username string) string {
+	if _, ok := n.names[username]; !ok {
+		if err := n.load(ctx, username); err != nil {
+			return username
+		}
+	}
+	return n.names[username]
+}
+
+// describeSystemMessage returns a sentence describing the change recorded by a system message.
+func describeSystemMessage(body SystemMessageBody, name func(string) string) string {
+	actor, target := name(body.Actor), name(body.Target)
+	switch body.Key {
+	case SystemRoomCreated:
+		return actor + " created the room"
+	case SystemRoomUpdated:
+		fields := make([]string, 0, len(body.Fields))
+		for _, field := range slices.Sorted(maps.Keys(body.Fields)) {
+			fields = append(fields, fmt.Sprintf("%s to %q", field, body.Fields[field]))
+		}
+		if len(fields) == 0 {
+			return actor + " updated the room"
+		}
+		return actor + " changed the " + strings.Join(fields, ", ")
+	case SystemRoomArchived:
+		return actor + " archived the room"
+	case SystemRoomUnarchived:
+		return actor + " restored the room from the archive"
+	case SystemMemberAdded:
+		if body.Actor == body.Target {
+			return actor + " joined the room"
+		}
+		return fmt.Sprintf("%s added %s as %s", actor, target, body.Role)
+	case SystemMemberRemoved:
+		return fmt.Sprintf("%s removed %s", actor, target)
+	case SystemMemberLeft:
+		return actor + " left the room"
+	case SystemMemberRoleChanged:
+		return fmt.Sprintf("%s made %s %s", actor, target, body.Role)
+	case SystemOwnershipTransferred:
+		return fmt.Sprintf("%s made %s the owner", actor, target)
+	default:
+		return fmt.Sprintf("%s: %s", actor, body.Key)
+	}
+}
+
+type jsonExportWriter struct{}
+
+func (jsonExportWriter) header(w io.Writer, h exportHeader) error {
+	b, err := json.Marshal(h)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+	// the messages are streamed into the object after the header fields
+	if _, err := w.Write(b[:len(b)-1]); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, `,"messages":[`)
+	return err
+}
+
+func (jsonExportWriter) message(w io.Writer, m exportMessage, first bool) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+	if !first {
+		if _, err := io.WriteString(w, ","); err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func (jsonExportWriter) footer(w io.Writer) error {
+	_, err := io.WriteString(w, "]}\n")
+	return err
+}
+
+type markdownExportWriter struct{}
+
+func (markdownExportWriter) header(w io.Writer, h exportHeader) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# %s\n\n", h.Room.Name)
+	if h.Room.Topic != "" {
+		fmt.Fprintf(&sb, "**Topic:** %s\n\n", h.Room.Topic)
+	}
+	if h.Room.Description != "" {
+		fmt.Fprintf(&sb, "%s\n\n", h.Room.Description)
+	}
+	if h.Room.ArchivedAt != nil {
+		fmt.Fprintf(&sb, "Archived on %s.\n\n", h.Room.ArchivedAt.Format(exportTimeFormat))
+	}
+	fmt.Fprintf(&sb, "Exported by @%s on %s (%s).\n\n", h.ExportedBy, h.ExportedAt.Format(exportTimeFormat), h.Timezone)
+	sb.WriteString("## Members\n\n")
+	for _, member := range h.Members {
+		fmt.Fprintf(&sb, "- %s (@%s), %s\n", member.Name, member.Username, member.Role)
+	}
+	sb.WriteString("\n## Messages\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (markdownExportWriter) message(w io.Writer, m exportMessage, _ bool) error {
+	var err error
+	if m.Event != nil {
+		_, err = fmt.Fprintf(w, "\n_%s_ · %s\n", m.Text, m.SentAt.Format(exportTimeFormat))
+	} else {
+		_, err = fmt.Fprintf(w, "\n**%s** (@%s) · %s\n\n%s\n", m.SenderName, m.Sender, m.SentAt.Format(exportTimeFormat), m.Text)
+	}
+	return err
+}
+
+func (markdownExportWriter) footer(w io.Writer) error {
+	return nil
+}
+
+type htmlExportWriter struct{}
+
+func (htmlExportWriter) header(w io.Writer, h exportHeader) error {
+	var sb strings.Builder
+	name := html.EscapeString(h.Room.Name)
+	fmt.Fprintf(&sb, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { font-family: sans-serif; max-width: 48rem; margin: 2rem auto; }
+.message { margin: 1rem 0; }
+.message p { margin: 0.25rem 0; white-space: pre-wrap; }
+.system { color: #666; font-style: italic; }
+time { color: #666; font-size: 0.875rem; }
+</style>
+</head>
+<body>
+<h1>%s</h1>
+`, name, name)
+	if h.Room.Topic != "" {
+		fmt.Fprintf(&sb, "<p><strong>Topic:</strong> %s</p>\n", html.EscapeString(h.Room.Topic))
+	}
+	if h.Room.Description != "" {
+		fmt.Fprintf(&sb, "<p>%s</p>\n", html.EscapeString(h.Room.Description))
+	}
+	if h.Room.ArchivedAt != nil {
+		fmt.Fprintf(&sb, "<p>Archived on %s.</p>\n", h.Room.ArchivedAt.Format(exportTimeFormat))
+	}
+	fmt.Fprintf(&sb, "<p>Exported by @%s on %s (%s).</p>\n<h2>Members</h2>\n<ul>\n",
+		html.EscapeString(h.ExportedBy), h.ExportedAt.Format(exportTimeFormat), html.EscapeString(h.Timezone))
+	for _, member := range h.Members {
+		fmt.Fprintf(&sb, "<li>%s (@%s), %s</li>\n",
+			html.EscapeString(member.Name), html.EscapeString(member.Username), html.EscapeString(string(member.Role)))
+	}
+	sb.WriteString("</ul>\n<h2>Messages</h2>\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (htmlExportWriter) message(w io.Writer, m exportMessage, _ bool) error {
+	sentAt := fmt.Sprintf(`<time datetime="%s">%s</time>`, m.SentAt.Format(time.RFC3339), m.SentAt.Format(exportTimeFormat))
+	var err error
+	if m.Event != nil {
+		_, err = fmt.Fprintf(w, "<div class=\"message system\" id=\"message-%d\">%s · %s</div>\n",
+			m.ID, html.EscapeString(m.Text), sentAt)
+	} else {
+		_, err = fmt.Fprintf(w, "<div class=\"message\" id=\"message-%d\"><strong>%s</strong> (@%s) %s<p>%s</p></div>\n",
+			m.ID, html.EscapeString(m.SenderName), html.EscapeString(m.Sender), sentAt, html.EscapeString(m.Text))
+	}
+	return err
+}
+
+func (htmlExportWriter) footer(w io.Writer) error {
+	_, err := io.WriteString(w, "</body>\n</html>\n")
+	return err
+}
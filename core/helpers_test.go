@@ -28,19 +28,13 @@ func seedRooms(f *ChatFixture, owner User, names ...string) []Room {
 			f.t.Fatal(err)
 		}
 
-		newRoom := Room{
-			ID:   roomID,
-			Name: name,
-			Members: []RoomMember{
-				{
-					Username: owner.Username,
-					Role:     Owner,
-					RoomID:   roomID,
-				},
-			},
+		// the room is read back as creating it records a system message
+		newRoom, err := f.chatStore.GetRoomByID(f.ctx, roomID)
+		if err != nil {
+			f.t.Fatal(err)
 		}
 
-		rooms = append(rooms, newRoom)
+		rooms = append(rooms, *newRoom)
 	}
 	return rooms
 }
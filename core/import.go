@@ -0,0 +1,19 @@
+package core
+
+import "time"
+
+const (
+	// importKindRoom maps a channel of the source to a room.
+	importKindRoom = "room"
+	// importKindMessage maps a message of the source to a message.
+	importKindMessage = "message"
+)
+
+// ImportMessage is a text message from another chat service.
+type ImportMessage struct {
+	// ExternalID identifies the message in its source, so that it is only imported once.
+	ExternalID string
+	Sender     string
+	Data       string
+	SentAt     time.Time
+}
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// importCountChunk is how many external IDs CountImportedMessages looks up at a time.
+const importCountChunk = 500
+
+func (s *SQLChatStore) GetImportedRoom(ctx context.Context, source, externalID string) (string, error) {
+	var roomID string
+	err := s.db.QueryRowContext(ctx, `
+	SELECT local_id FROM import_mappings
+	WHERE source = @source AND kind = @kind AND external_id = @external_id`,
+		sql.Named("source", source), sql.Named("kind", importKindRoom), sql.Named("external_id", externalID),
+	).Scan(&roomID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", fmt.Errorf("row.Scan: %w", err)
+	}
+	return roomID, nil
+}
+
+func (s *SQLChatStore) LinkImportedRoom(ctx context.Context, source, externalID, roomID string) error {
+	_, err := s.db.ExecContext(ctx, `
+	INSERT INTO import_mappings (source, kind, external_id, local_id)
+	VALUES (@source, @kind, @external_id, @local_id)
+	ON CONFLICT (source, kind, external_id) DO UPDATE SET local_id = excluded.local_id`,
+		sql.Named("source", source), sql.Named("kind", importKindRoom),
+		sql.Named("external_id", externalID), sql.Named("local_id", roomID))
+	if err != nil {
+		return fmt.Errorf("ExecContext: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLChatStore) ImportMessages(ctx context.Context, roomID, source string, messages []ImportMessage) (int, error) {
+	room, err := s.GetRoomByID(ctx, roomID)
+	if err != nil {
+		return 0, fmt.Errorf("GetRoomByID: %w", err)
+	}
+	if room == nil {
+		return 0, ErrInvalidRoom
+	}
+
+	senders := make(map[string]bool)
+	for _, m := range messages {
+		senders[m.Sender] = true
+	}
+	usernames := make([]string, 0, len(senders))
+	for sender := range senders {
+		usernames = append(usernames, sender)
+	}
+	if len(usernames) > 0 {
+		users, err := s.userStore.GetUsersByUsernames(ctx, usernames...)
+		if err != nil {
+			return 0, fmt.Errorf("GetUsersByUsernames: %w", err)
+		}
+		if len(users) != len(usernames) {
+			return 0, ErrInvalidUser
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	imported, last := 0, 0
+	for _, m := range messages {
+		var exists int
+		err := tx.QueryRowContext(ctx, `
+		SELECT 1 FROM import_mappings
+		WHERE source = @source AND kind = @kind AND external_id = @external_id`,
+			sql.Named("source", source), sql.Named("kind", importKindMessage),
+			sql.Named("external_id", m.ExternalID)).Scan(&exists)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("row.Scan: %w", err)
+		}
+
+		id, err := insertMessage(ctx, tx, TextMessage, roomID, m.Sender, m.Data, m.SentAt.UTC())
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.ExecContext(ctx, `
+		INSERT INTO import_mappings (source, kind, external_id, local_id)
+		VALUES (@source, @kind, @external_id, @local_id)`,
+			sql.Named("source", source), sql.Named("kind", importKindMessage),
+			sql.Named("external_id", m.ExternalID), sql.Named("local_id", strconv.Itoa(id)))
+		if err != nil {
+			return 0, fmt.Errorf("ExecContext(insert import_mappings): %w", err)
+		}
+		imported, last = imported+1, id
+	}
+
+	if imported > 0 {
+		// the history was read in the source, so it is not reported as unread here
+		_, err = tx.ExecContext(ctx, `
+		UPDATE room_members SET last_message_read = @last
+		WHERE room_id = @room_id AND last_message_read < @last`,
+			sql.Named("last", last), sql.Named("room_id", roomID))
+		if err != nil {
+			return 0, fmt.Errorf("ExecContext(update room_members): %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("Commit: %w", err)
+	}
+	return imported, nil
+}
+
+func (s *SQLChatStore) CountImportedMessages(ctx context.Context, source string, externalIDs []string) (int, error) {
+	total := 0
+	for start := 0; start < len(externalIDs); start += importCountChunk {
+		chunk := externalIDs[start:min(start+importCountChunk, len(externalIDs))]
+		args := make([]any, 0, len(chunk)+2)
+		args = append(args, source, importKindMessage)
+		for _, id := range chunk {
+			args = append(args, id)
+		}
+		var count int
+		err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM import_mappings
+		WHERE source = ? AND kind = ? AND external_id IN (`+strings.Repeat("?,", len(chunk)-1)+`?)`,
+			args...).Scan(&count)
+		if err != nil {
+			return 0, fmt.Errorf("row.Scan: %w", err)
+		}
+		total += count
+	}
+	return total, nil
+}
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrInvalidInvite is returned when an invite does not exist, or is revoked, expired or used up.
+	ErrInvalidInvite = errors.New("invalid invite")
+)
+
+// Invite is a shareable code that lets users join a room by themselves.
+type Invite struct {
+	Code   string     `json:"code"`
+	RoomID string     `json:"room_id"`
+	Role   MemberRole `json:"role"`
+	// CreatedBy is the member who created the invite.
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt is nil if the invite does not expire.
+	ExpiresAt *time.Time `json:"expires_at"`
+	// MaxUses is the number of users that can join with the invite. Zero means no limit.
+	MaxUses   int        `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// Usable reports whether users can still join with the invite at the time.
+func (i *Invite) Usable(now time.Time) bool {
+	if i.RevokedAt != nil {
+		return false
+	}
+	if i.ExpiresAt != nil && !now.Before(*i.ExpiresAt) {
+		return false
+	}
+	return i.MaxUses == 0 || i.Uses < i.MaxUses
+}
+
+// InviteUse records a user joining a room with an invite.
+type InviteUse struct {
+	Code     string    `json:"code"`
+	Username string    `json:"username"`
+	UsedAt   time.Time `json:"used_at"`
+}
+
+// CreateInviteInput represents the input for creating an invite.
+type CreateInviteInput struct {
+	// Role is given to users who join with the invite. The default is Member.
+	Role MemberRole `json:"role"`
+	// ExpiresIn is how many seconds the invite can be used for. Zero means it does not expire.
+	ExpiresIn int `json:"expires_in" validate:"min=0"`
+	// MaxUses is the number of users that can join with the invite. Zero means no limit.
+	MaxUses int `json:"max_uses" validate:"min=0"`
+}
@@ -0,0 +1,237 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func (s *SQLChatStore) CreateInvite(ctx context.Context, roomID, actor string, input CreateInviteInput) (*Invite, error) {
+	if err := validate.Struct(input); err != nil {
+		return nil, ErrInvalidInvite
+	}
+	if input.Role == "" {
+		input.Role = Member
+	}
+	if _, err := s.Authorize(ctx, roomID, actor, InviteMembers); err != nil {
+		return nil, err
+	}
+	if err := s.AuthorizeRoleAssignment(ctx, roomID, actor, input.Role); err != nil {
+		return nil, err
+	}
+
+	code, err := randomString(12)
+	if err != nil {
+		return nil, fmt.Errorf("generate invite code: %w", err)
+	}
+	now := time.Now().UTC()
+	invite := &Invite{
+		Code:      code,
+		RoomID:    roomID,
+		Role:      input.Role,
+		CreatedBy: actor,
+		CreatedAt: now,
+		MaxUses:   input.MaxUses,
+	}
+	if input.ExpiresIn > 0 {
+		expiresAt := now.Add(time.Duration(input.ExpiresIn) * time.Second)
+		invite.ExpiresAt = &expiresAt
+	}
+
+	query := `
+	INSERT INTO room_invites (code, room_id, role, created_by, created_at, expires_at, max_uses)
+	VALUES (@code, @room_id, @role, @created_by, @created_at, @expires_at, @max_uses)`
+	_, err = s.db.ExecContext(ctx, query,
+		sql.Named("code", invite.Code), sql.Named("room_id", invite.RoomID),
+		sql.Named("role", invite.Role), sql.Named("created_by", invite.CreatedBy),
+		sql.Named("created_at", invite.CreatedAt), sql.Named("expires_at", invite.ExpiresAt),
+		sql.Named("max_uses", invite.MaxUses))
+	if err != nil {
+		return nil, fmt.Errorf("ExecContext: %w", err)
+	}
+	return invite, nil
+}
+
+const inviteColumns = "code, room_id, role, created_by, created_at, expires_at, max_uses, uses, revoked_at"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanInvite(row scanner) (*Invite, error) {
+	var invite Invite
+	var expiresAt, revokedAt sql.NullTime
+	if err := row.Scan(&invite.Code, &invite.RoomID, &invite.Role, &invite.CreatedBy, &invite.CreatedAt,
+		&expiresAt, &invite.MaxUses, &invite.Uses, &revokedAt); err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		invite.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		invite.RevokedAt = &revokedAt.Time
+	}
+	return &invite, nil
+}
+
+func (s *SQLChatStore) GetRoomInvites(ctx context.Context, roomID string) ([]Invite, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+inviteColumns+" FROM room_invites WHERE room_id = @room_id ORDER BY created_at DESC",
+		sql.Named("room_id", roomID))
+	if err != nil {
+		return nil, fmt.Errorf("QueryContext: %w", err)
+	}
+	defer rows.Close()
+
+	var invites []Invite
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			return nil, fmt.Errorf("rows.Scan: %w", err)
+		}
+		invites = append(invites, *invite)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err: %w", err)
+	}
+	return invites, nil
+}
+
+func (s *SQLChatStore) GetInvite(ctx context.Context, code string) (*Invite, error) {
+	row := s.db.QueryRowContext(ctx,
+		"SELECT "+inviteColumns+" FROM room_invites WHERE code = @code",
+		sql.Named("code", code))
+	invite, err := scanInvite(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("row.Scan: %w", err)
+	}
+	return invite, nil
+}
+
+func (s *SQLChatStore) RevokeInvite(ctx context.Context, roomID, code string) error {
+	result, err := s.db.ExecContext(ctx, `
+	UPDATE room_invites SET revoked_at = @now
+	WHERE code = @code AND room_id = @room_id AND revoked_at IS NULL`,
+		sql.Named("now", time.Now().UTC()), sql.Named("code", code), sql.Named("room_id", roomID))
+	if err != nil {
+		return fmt.Errorf("ExecContext: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("RowsAffected: %w", err)
+	}
+	if affected == 0 {
+		return ErrInvalidInvite
+	}
+	return nil
+}
+
+func (s *SQLChatStore) AcceptInvite(ctx context.Context, code, username string) (*Invite, bool, error) {
+	user, err := s.userStore.GetUserByUsername(ctx, username)
+	if err != nil {
+		return nil, false, fmt.Errorf("GetUserByUsername: %w", err)
+	}
+	if user == nil {
+		return nil, false, ErrInvalidUser
+	}
+
+	invite, err := s.GetInvite(ctx, code)
+	if err != nil {
+		return nil, false, fmt.Errorf("GetInvite: %w", err)
+	}
+	now := time.Now().UTC()
+	if invite == nil || !invite.Usable(now) {
+		return nil, false, ErrInvalidInvite
+	}
+
+	ok, _, err := s.IsRoomMember(ctx, invite.RoomID, username)
+	if err != nil {
+		return nil, false, fmt.Errorf("IsRoomMember: %w", err)
+	}
+	// members do not use up the invite
+	if ok {
+		return invite, false, nil
+	}
+
+	archived, err := s.isArchived(ctx, invite.RoomID)
+	if err != nil {
+		return nil, false, fmt.Errorf("isArchived: %w", err)
+	}
+	if archived {
+		return nil, false, ErrArchivedRoom
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	// the conditions are checked again so that concurrent joins cannot exceed the limit
+	result, err := tx.ExecContext(ctx, `
+	UPDATE room_invites SET uses = uses + 1
+	WHERE code = @code AND revoked_at IS NULL
+	AND (expires_at IS NULL OR expires_at > @now)
+	AND (max_uses = 0 OR uses < max_uses)`,
+		sql.Named("code", code), sql.Named("now", now))
+	if err != nil {
+		return nil, false, fmt.Errorf("ExecContext(update room_invites): %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, false, fmt.Errorf("RowsAffected: %w", err)
+	}
+	if affected == 0 {
+		return nil, false, ErrInvalidInvite
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO room_invite_uses (code, username, used_at) VALUES (@code, @username, @used_at)",
+		sql.Named("code", code), sql.Named("username", username), sql.Named("used_at", now))
+	if err != nil {
+		return nil, false, fmt.Errorf("ExecContext(insert room_invite_uses): %w", err)
+	}
+
+	joined, err := s.addRoomMember(ctx, tx, invite.RoomID, username, invite.Role, username)
+	if err != nil {
+		return nil, false, err
+	}
+	if !joined {
+		// joined in the meantime
+		return invite, false, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, fmt.Errorf("Commit: %w", err)
+	}
+	invite.Uses++
+	return invite, true, nil
+}
+
+func (s *SQLChatStore) GetInviteUses(ctx context.Context, code string) ([]InviteUse, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT code, username, used_at FROM room_invite_uses WHERE code = @code ORDER BY id",
+		sql.Named("code", code))
+	if err != nil {
+		return nil, fmt.Errorf("QueryContext: %w", err)
+	}
+	defer rows.Close()
+
+	var uses []InviteUse
+	for rows.Next() {
+		var use InviteUse
+		if err := rows.Scan(&use.Code, &use.Username, &use.UsedAt); err != nil {
+			return nil, fmt.Errorf("rows.Scan: %w", err)
+		}
+		uses = append(uses, use)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err: %w", err)
+	}
+	return uses, nil
+}
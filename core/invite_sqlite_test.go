@@ -0,0 +1,120 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateInvite(t *testing.T) {
+	f := NewChatFixture(t)
+	defer f.tearDown()
+	room := seedRoomWithRoles(f)
+
+	invite, err := f.chatStore.CreateInvite(f.ctx, room.ID, admin.Username, CreateInviteInput{ExpiresIn: 60, MaxUses: 2})
+	require.Nil(t, err)
+	assert.NotEmpty(t, invite.Code)
+	assert.Equal(t, Member, invite.Role)
+	require.NotNil(t, invite.ExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), *invite.ExpiresAt, 5*time.Second)
+
+	invites, err := f.chatStore.GetRoomInvites(f.ctx, room.ID)
+	require.Nil(t, err)
+	require.Len(t, invites, 1)
+	assert.Equal(t, invite.Code, invites[0].Code)
+
+	// an admin cannot hand out admin invites, members cannot invite at all
+	_, err = f.chatStore.CreateInvite(f.ctx, room.ID, admin.Username, CreateInviteInput{Role: Admin})
+	assert.Equal(t, ErrDisAllowedOperation, err)
+	_, err = f.chatStore.CreateInvite(f.ctx, room.ID, member1.Username, CreateInviteInput{})
+	assert.Equal(t, ErrDisAllowedOperation, err)
+	_, err = f.chatStore.CreateInvite(f.ctx, room.ID, owner.Username, CreateInviteInput{MaxUses: -1})
+	assert.Equal(t, ErrInvalidInvite, err)
+}
+
+func TestAcceptInvite(t *testing.T) {
+	t.Run("join with an invite", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+		invite, err := f.chatStore.CreateInvite(f.ctx, room.ID, owner.Username, CreateInviteInput{Role: Admin})
+		require.Nil(t, err)
+
+		accepted, joined, err := f.chatStore.AcceptInvite(f.ctx, invite.Code, member2.Username)
+		require.Nil(t, err)
+		assert.True(t, joined)
+		assert.Equal(t, room.ID, accepted.RoomID)
+
+		ok, role, err := f.chatStore.IsRoomMember(f.ctx, room.ID, member2.Username)
+		require.Nil(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, Admin, role)
+
+		// members do not use up the invite
+		_, joined, err = f.chatStore.AcceptInvite(f.ctx, invite.Code, member1.Username)
+		require.Nil(t, err)
+		assert.False(t, joined)
+
+		uses, err := f.chatStore.GetInviteUses(f.ctx, invite.Code)
+		require.Nil(t, err)
+		require.Len(t, uses, 1)
+		assert.Equal(t, member2.Username, uses[0].Username)
+
+		got, err := f.chatStore.GetInvite(f.ctx, invite.Code)
+		require.Nil(t, err)
+		assert.Equal(t, 1, got.Uses)
+	})
+
+	t.Run("used up", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, f.t, f.userStore, owner, member1, member2)
+		room := seedRooms(f, owner)[0]
+		invite, err := f.chatStore.CreateInvite(f.ctx, room.ID, owner.Username, CreateInviteInput{MaxUses: 1})
+		require.Nil(t, err)
+
+		_, _, err = f.chatStore.AcceptInvite(f.ctx, invite.Code, member1.Username)
+		require.Nil(t, err)
+		_, _, err = f.chatStore.AcceptInvite(f.ctx, invite.Code, member2.Username)
+		assert.Equal(t, ErrInvalidInvite, err)
+	})
+
+	t.Run("revoked", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+		invite, err := f.chatStore.CreateInvite(f.ctx, room.ID, owner.Username, CreateInviteInput{})
+		require.Nil(t, err)
+
+		require.Nil(t, f.chatStore.RevokeInvite(f.ctx, room.ID, invite.Code))
+		assert.Equal(t, ErrInvalidInvite, f.chatStore.RevokeInvite(f.ctx, room.ID, invite.Code))
+
+		_, _, err = f.chatStore.AcceptInvite(f.ctx, invite.Code, member2.Username)
+		assert.Equal(t, ErrInvalidInvite, err)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+		invite, err := f.chatStore.CreateInvite(f.ctx, room.ID, owner.Username, CreateInviteInput{ExpiresIn: 60})
+		require.Nil(t, err)
+		_, err = f.db.ExecContext(f.ctx, "UPDATE room_invites SET expires_at = ? WHERE code = ?",
+			time.Now().UTC().Add(-time.Second), invite.Code)
+		require.Nil(t, err)
+
+		_, _, err = f.chatStore.AcceptInvite(f.ctx, invite.Code, member2.Username)
+		assert.Equal(t, ErrInvalidInvite, err)
+	})
+
+	t.Run("unknown code", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, f.t, f.userStore, member1)
+
+		_, _, err := f.chatStore.AcceptInvite(f.ctx, "random", member1.Username)
+		assert.Equal(t, ErrInvalidInvite, err)
+	})
+}
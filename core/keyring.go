@@ -0,0 +1,231 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+var (
+	// ErrNoSigningKey is returned when none of the keys in a keyring can sign at the moment.
+	ErrNoSigningKey = errors.New("no signing key")
+	// ErrUnsupportedKey is returned when a PEM file does not hold an RSA or Ed25519 private key.
+	ErrUnsupportedKey = errors.New("unsupported key")
+)
+
+// SigningKey is a key in a Keyring.
+// Overlapping validity lets keys be rotated without signing anyone out: a new key is added with
+// SignFrom in the future so that verifiers fetching the JWKS learn about it before it is used,
+// and the old key is kept with VerifyUntil set to when the last token it signed expires.
+type SigningKey struct {
+	// ID is sent in the kid header of the tokens signed with the key.
+	// Tokens without a kid are verified with the key that has an empty ID.
+	ID        string
+	Algorithm string
+	// SignFrom is when the key starts signing tokens. The zero value means it always could.
+	SignFrom time.Time
+	// VerifyUntil is when tokens signed with the key stop being accepted.
+	// The key stops signing at the same time. The zero value means it never expires.
+	VerifyUntil time.Time
+	signKey     any
+	verifyKey   any
+}
+
+// NewHMACKey returns an HS256 key. HMAC keys can verify tokens but are never published in the JWKS.
+func NewHMACKey(id string, secret []byte) *SigningKey {
+	return &SigningKey{ID: id, Algorithm: AlgorithmHS256, signKey: secret, verifyKey: secret}
+}
+
+// deriveKey derives a secret for a single purpose from secret, so that a token signed for one purpose
+// cannot be passed off as another.
+func deriveKey(secret []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// ParsePrivateKeyPEM returns an RS256 or EdDSA key from a PEM encoded PKCS #8 private key.
+// PKCS #1 RSA private keys are accepted as well.
+func ParsePrivateKeyPEM(id string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM block found", ErrUnsupportedKey)
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%w: PEM block type %q", ErrUnsupportedKey, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse private key: %w", err)
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return &SigningKey{ID: id, Algorithm: AlgorithmRS256, signKey: key, verifyKey: &key.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &SigningKey{ID: id, Algorithm: AlgorithmEdDSA, signKey: key, verifyKey: key.Public()}, nil
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+	}
+}
+
+// LoadPrivateKeyPEM reads a key with ParsePrivateKeyPEM from a file.
+func LoadPrivateKeyPEM(id, file string) (*SigningKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+	return ParsePrivateKeyPEM(id, data)
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+func (k *SigningKey) canVerify(now time.Time) bool {
+	return k.VerifyUntil.IsZero() || now.Before(k.VerifyUntil)
+}
+
+func (k *SigningKey) canSign(now time.Time) bool {
+	return !now.Before(k.SignFrom) && k.canVerify(now)
+}
+
+// Keyring signs tokens with the newest of its keys that can sign and verifies tokens with any key
+// that has not expired, picked by the kid header.
+type Keyring struct {
+	keys []*SigningKey
+	now  func() time.Time
+}
+
+// NewKeyring creates a keyring. Key IDs must be unique.
+func NewKeyring(keys ...*SigningKey) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoSigningKey
+	}
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key.ID] {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		seen[key.ID] = true
+		if key.method() == nil {
+			return nil, fmt.Errorf("%w: algorithm %q", ErrUnsupportedKey, key.Algorithm)
+		}
+	}
+	return &Keyring{keys: keys, now: time.Now}, nil
+}
+
+// signingKey returns the key that started signing most recently.
+// If several started at the same time, the one added last wins.
+func (k *Keyring) signingKey() (*SigningKey, error) {
+	now := k.now()
+	var current *SigningKey
+	for _, key := range k.keys {
+		if key.canSign(now) && (current == nil || !key.SignFrom.Before(current.SignFrom)) {
+			current = key
+		}
+	}
+	if current == nil {
+		return nil, ErrNoSigningKey
+	}
+	return current, nil
+}
+
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	key, err := k.signingKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.method(), claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	return token.SignedString(key.signKey)
+}
+
+// Verify parses and verifies a token signed by one of the keys.
+// It returns the same errors as VerifyToken.
+func (k *Keyring) Verify(token string) (*AuthClaims, error) {
+	now := k.now()
+	return verifyToken(token, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		for _, key := range k.keys {
+			if key.ID != kid {
+				continue
+			}
+			if !key.canVerify(now) || token.Method.Alg() != key.Algorithm {
+				return nil, jwt.ErrTokenSignatureInvalid
+			}
+			return key.verifyKey, nil
+		}
+		return nil, jwt.ErrTokenSignatureInvalid
+	}, AlgorithmHS256, AlgorithmRS256, AlgorithmEdDSA)
+}
+
+// JWK is a public key in the JSON Web Key format of RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	// Crv and X are set for Ed25519 keys.
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	// N and E are set for RSA keys.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys that tokens can currently be verified with, including keys
+// that are yet to start signing. HMAC keys are secret and left out.
+func (k *Keyring) JWKS() JWKS {
+	now := k.now()
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range k.keys {
+		if !key.canVerify(now) {
+			continue
+		}
+		switch pub := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "RSA", Use: "sig", Alg: key.Algorithm, Kid: key.ID,
+				N: base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "OKP", Use: "sig", Alg: key.Algorithm, Kid: key.ID, Crv: "Ed25519",
+				X: base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	return jwks
+}
@@ -0,0 +1,218 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ed25519KeyPEM(t *testing.T) []byte {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.Nil(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func rsaKeyPEM(t *testing.T) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+func testClaims() *AuthClaims {
+	return NewClaim(UserWithoutSecrets{Username: "username"}, time.Now().Add(time.Hour))
+}
+
+func TestParsePrivateKeyPEM(t *testing.T) {
+	key, err := ParsePrivateKeyPEM("ed", ed25519KeyPEM(t))
+	require.Nil(t, err)
+	assert.Equal(t, AlgorithmEdDSA, key.Algorithm)
+
+	key, err = ParsePrivateKeyPEM("rsa", rsaKeyPEM(t))
+	require.Nil(t, err)
+	assert.Equal(t, AlgorithmRS256, key.Algorithm)
+
+	_, err = ParsePrivateKeyPEM("bad", []byte("not a key"))
+	assert.ErrorIs(t, err, ErrUnsupportedKey)
+}
+
+func TestKeyring(t *testing.T) {
+	t.Run("sign and verify", func(t *testing.T) {
+		for _, data := range [][]byte{ed25519KeyPEM(t), rsaKeyPEM(t)} {
+			key, err := ParsePrivateKeyPEM("key", data)
+			require.Nil(t, err)
+			keyring, err := NewKeyring(key)
+			require.Nil(t, err)
+
+			token, err := keyring.Sign(testClaims())
+			require.Nil(t, err)
+			claims, err := keyring.Verify(token)
+			require.Nil(t, err)
+			assert.Equal(t, "username", claims.Username)
+
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, &AuthClaims{})
+			require.Nil(t, err)
+			assert.Equal(t, "key", parsed.Header["kid"])
+			assert.Equal(t, key.Algorithm, parsed.Header["alg"])
+		}
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		now := time.Now()
+		old, err := ParsePrivateKeyPEM("old", ed25519KeyPEM(t))
+		require.Nil(t, err)
+		old.VerifyUntil = now.Add(2 * time.Hour)
+		next, err := ParsePrivateKeyPEM("next", rsaKeyPEM(t))
+		require.Nil(t, err)
+		next.SignFrom = now.Add(time.Hour)
+
+		keyring, err := NewKeyring(old, next)
+		require.Nil(t, err)
+		keyring.now = func() time.Time { return now }
+
+		// the next key is published before it signs
+		jwks := keyring.JWKS()
+		require.Len(t, jwks.Keys, 2)
+		assert.Equal(t, "OKP", jwks.Keys[0].Kty)
+		assert.Equal(t, "Ed25519", jwks.Keys[0].Crv)
+		assert.Equal(t, "RSA", jwks.Keys[1].Kty)
+		assert.Equal(t, "next", jwks.Keys[1].Kid)
+
+		oldToken, err := keyring.Sign(testClaims())
+		require.Nil(t, err)
+		parsed, _, err := jwt.NewParser().ParseUnverified(oldToken, &AuthClaims{})
+		require.Nil(t, err)
+		assert.Equal(t, "old", parsed.Header["kid"])
+
+		// both keys verify during the overlap
+		now = now.Add(90 * time.Minute)
+		newToken, err := keyring.Sign(testClaims())
+		require.Nil(t, err)
+		parsed, _, err = jwt.NewParser().ParseUnverified(newToken, &AuthClaims{})
+		require.Nil(t, err)
+		assert.Equal(t, "next", parsed.Header["kid"])
+		_, err = keyring.Verify(oldToken)
+		assert.Nil(t, err)
+		_, err = keyring.Verify(newToken)
+		assert.Nil(t, err)
+
+		// the old key is retired
+		now = now.Add(time.Hour)
+		_, err = keyring.Verify(oldToken)
+		assert.Equal(t, ErrTokenInvalid, err)
+		assert.Len(t, keyring.JWKS().Keys, 1)
+	})
+
+	t.Run("legacy secret verifies tokens without kid", func(t *testing.T) {
+		key, err := ParsePrivateKeyPEM("key", ed25519KeyPEM(t))
+		require.Nil(t, err)
+		keyring, err := NewKeyring(NewHMACKey("", secret), key)
+		require.Nil(t, err)
+
+		token, _, err := NewToken(UserWithoutSecrets{Username: "username"}, time.Hour, secret)
+		require.Nil(t, err)
+		claims, err := keyring.Verify(token)
+		require.Nil(t, err)
+		assert.Equal(t, "username", claims.Username)
+
+		// HMAC keys are never published
+		assert.Len(t, keyring.JWKS().Keys, 1)
+	})
+
+	t.Run("algorithm must match the key", func(t *testing.T) {
+		key, err := ParsePrivateKeyPEM("key", rsaKeyPEM(t))
+		require.Nil(t, err)
+		keyring, err := NewKeyring(key)
+		require.Nil(t, err)
+
+		// an HS256 token that claims to be signed by the RSA key
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+		token.Header["kid"] = "key"
+		signed, err := token.SignedString([]byte("public key bytes"))
+		require.Nil(t, err)
+
+		_, err = keyring.Verify(signed)
+		assert.Equal(t, ErrTokenInvalid, err)
+	})
+
+	t.Run("unknown kid", func(t *testing.T) {
+		keyring, err := NewKeyring(NewHMACKey("a", secret))
+		require.Nil(t, err)
+		other, err := NewKeyring(NewHMACKey("b", secret))
+		require.Nil(t, err)
+
+		token, err := other.Sign(testClaims())
+		require.Nil(t, err)
+		_, err = keyring.Verify(token)
+		assert.Equal(t, ErrTokenInvalid, err)
+	})
+
+	t.Run("duplicate ids", func(t *testing.T) {
+		_, err := NewKeyring(NewHMACKey("a", secret), NewHMACKey("a", secret))
+		assert.NotNil(t, err)
+	})
+}
+
+func TestAuthStoreKeyring(t *testing.T) {
+	f := NewAuthFixture(t)
+	defer f.tearDown()
+	seedUsers(f.ctx, t, f.userStore, user)
+
+	key, err := ParsePrivateKeyPEM("key", ed25519KeyPEM(t))
+	require.Nil(t, err)
+	keyring, err := NewKeyring(NewHMACKey("", secret), key)
+	require.Nil(t, err)
+	authStore := NewSQLAuthStore(f.db, f.userStore, nil, WithKeyring(keyring))
+
+	// sessions issued with the secret before the keyring was set up stay valid
+	legacy, err := f.authStore.NewSession(f.ctx, user.Username, user.Password)
+	require.Nil(t, err)
+	_, err = authStore.Session(f.ctx, legacy.Token)
+	assert.Nil(t, err)
+
+	session, err := authStore.NewSession(f.ctx, user.Username, user.Password)
+	require.Nil(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(session.Token, &AuthClaims{})
+	require.Nil(t, err)
+	assert.Equal(t, AlgorithmEdDSA, parsed.Method.Alg())
+	_, err = authStore.Session(f.ctx, session.Token)
+	assert.Nil(t, err)
+}
+
+func TestAuthStoreKeyringChallenge(t *testing.T) {
+	f := NewAuthFixture(t)
+	defer f.tearDown()
+	seedUsers(f.ctx, t, f.userStore, user)
+	enrollment := enrollTOTP(f, user.Username)
+
+	key, err := ParsePrivateKeyPEM("key", ed25519KeyPEM(t))
+	require.Nil(t, err)
+	keyring, err := NewKeyring(key)
+	require.Nil(t, err)
+	authStore := NewSQLAuthStore(f.db, f.userStore, secret, WithKeyring(keyring))
+
+	_, err = authStore.NewSession(f.ctx, user.Username, user.Password)
+	var challenge *TOTPChallenge
+	require.True(t, errors.As(err, &challenge))
+
+	// the challenge is not signed with a published key, so it cannot pass as a session
+	_, err = keyring.Verify(challenge.Token)
+	assert.Equal(t, ErrTokenInvalid, err)
+	_, err = authStore.Session(f.ctx, challenge.Token)
+	assert.Equal(t, ErrUnauthenticated, err)
+
+	session, err := authStore.VerifyTOTPChallenge(f.ctx, challenge.Token, enrollment.RecoveryCodes[0])
+	require.Nil(t, err)
+	_, err = authStore.Session(f.ctx, session.Token)
+	assert.Nil(t, err)
+}
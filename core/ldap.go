@@ -0,0 +1,292 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	ldapProviderName          = "ldap"
+	defaultLDAPUserFilter     = "(uid={username})"
+	defaultLDAPNameAttribute  = "cn"
+	defaultLDAPGroupFilter    = "(member={dn})"
+	defaultLDAPGroupAttribute = "cn"
+)
+
+type LDAPConfig struct {
+	// URL is the address of the directory, e.g. ldaps://ldap.example.com:636.
+	URL string
+	// StartTLS upgrades a plain ldap:// connection to TLS before binding.
+	StartTLS  bool
+	TLSConfig *tls.Config
+	// BindDN and BindPassword are the credentials of the account used to look up users.
+	// If BindDN is empty, the lookup is done with an anonymous bind.
+	BindDN       string
+	BindPassword string
+	// UserBaseDN is where users are searched for.
+	UserBaseDN string
+	// UserFilter finds the entry of the user signing in.
+	// {username} is replaced with the escaped username. The default is (uid={username}).
+	UserFilter string
+	// NameAttribute is the attribute mapped to User.Name. The default is cn.
+	NameAttribute string
+	// GroupBaseDN is where groups are searched for. Group sync is disabled if it is empty.
+	GroupBaseDN string
+	// GroupFilter finds the groups of the user.
+	// {dn} is replaced with the escaped DN of the user. The default is (member={dn}).
+	GroupFilter string
+	// GroupAttribute is the attribute that holds the group name. The default is cn.
+	GroupAttribute string
+	// GroupRooms maps group names to the IDs of the rooms that members of the group belong to.
+	GroupRooms map[string]string
+	// LinkExistingUsers links a directory user to the local user with the same username on first login.
+	// If false, such a login is refused, so a directory entry cannot take over a local account.
+	LinkExistingUsers bool
+	// Logger reports directories that cannot be reached. The default is slog.Default().
+	Logger *slog.Logger
+}
+
+// LDAPAuthenticator authenticates users by binding to an LDAP directory with their credentials.
+// Users are provisioned locally on their first login.
+// If a ChatStore is given and group sync is configured, room membership follows group membership
+// every time the user signs in.
+// While the directory cannot be reached, credentials are reported as not recognised,
+// so that the authenticators after it, such as local passwords, keep working.
+type LDAPAuthenticator struct {
+	config    LDAPConfig
+	userStore UserStore
+	chatStore ChatStore
+}
+
+func NewLDAPAuthenticator(config LDAPConfig, userStore UserStore, chatStore ChatStore) *LDAPAuthenticator {
+	if config.UserFilter == "" {
+		config.UserFilter = defaultLDAPUserFilter
+	}
+	if config.NameAttribute == "" {
+		config.NameAttribute = defaultLDAPNameAttribute
+	}
+	if config.GroupFilter == "" {
+		config.GroupFilter = defaultLDAPGroupFilter
+	}
+	if config.GroupAttribute == "" {
+		config.GroupAttribute = defaultLDAPGroupAttribute
+	}
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+
+	return &LDAPAuthenticator{
+		config:    config,
+		userStore: userStore,
+		chatStore: chatStore,
+	}
+}
+
+func (a *LDAPAuthenticator) connect() (*ldap.Conn, error) {
+	var opts []ldap.DialOpt
+	if a.config.TLSConfig != nil {
+		opts = append(opts, ldap.DialWithTLSConfig(a.config.TLSConfig))
+	}
+	conn, err := ldap.DialURL(a.config.URL, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.config.StartTLS {
+		tlsConfig := a.config.TLSConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("start tls: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func (a *LDAPAuthenticator) Authenticate(ctx context.Context, username, password string) (*UserWithoutSecrets, error) {
+	// an empty password is an unauthenticated bind, which most servers accept
+	if username == "" || password == "" {
+		return nil, ErrBadCredentials
+	}
+
+	conn, err := a.connect()
+	if err != nil {
+		a.config.Logger.Error(fmt.Sprintf("ldap: dial: %v", err))
+		return nil, ErrBadCredentials
+	}
+	defer conn.Close()
+
+	if a.config.BindDN != "" {
+		err = conn.Bind(a.config.BindDN, a.config.BindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		a.config.Logger.Error(fmt.Sprintf("ldap: service bind: %v", err))
+		return nil, ErrBadCredentials
+	}
+
+	entry, err := a.findUser(conn, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrBadCredentials
+		}
+		return nil, fmt.Errorf("user bind: %w", err)
+	}
+
+	user, err := a.provision(ctx, username, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.chatStore != nil && a.config.GroupBaseDN != "" && len(a.config.GroupRooms) > 0 {
+		groups, err := a.findGroups(conn, entry.DN)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.syncRooms(ctx, user.Username, groups); err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+// findUser returns the directory entry of the user.
+// If there is no such user or the username is ambiguous, it returns ErrBadCredentials.
+func (a *LDAPAuthenticator) findUser(conn *ldap.Conn, username string) (*ldap.Entry, error) {
+	filter := strings.ReplaceAll(a.config.UserFilter, "{username}", ldap.EscapeFilter(username))
+	req := ldap.NewSearchRequest(a.config.UserBaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		filter, []string{a.config.NameAttribute}, nil)
+
+	res, err := conn.Search(req)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, ErrBadCredentials
+		}
+		return nil, fmt.Errorf("search user: %w", err)
+	}
+	if len(res.Entries) != 1 {
+		return nil, ErrBadCredentials
+	}
+	return res.Entries[0], nil
+}
+
+// findGroups returns the names of the groups that the user is a member of.
+func (a *LDAPAuthenticator) findGroups(conn *ldap.Conn, dn string) ([]string, error) {
+	filter := strings.ReplaceAll(a.config.GroupFilter, "{dn}", ldap.EscapeFilter(dn))
+	req := ldap.NewSearchRequest(a.config.GroupBaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter, []string{a.config.GroupAttribute}, nil)
+
+	res, err := conn.Search(req)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("search groups: %w", err)
+	}
+
+	groups := make([]string, 0, len(res.Entries))
+	for _, entry := range res.Entries {
+		if name := entry.GetAttributeValue(a.config.GroupAttribute); name != "" {
+			groups = append(groups, name)
+			continue
+		}
+		// fall back to the first RDN value, e.g. cn=admins,ou=groups
+		if dn, err := ldap.ParseDN(entry.DN); err == nil && len(dn.RDNs) > 0 {
+			groups = append(groups, dn.RDNs[0].Attributes[0].Value)
+		}
+	}
+	return groups, nil
+}
+
+// provision returns the local user for the directory entry, creating it on first login.
+// The entry is linked to the local user by its DN. A local user that has the same username
+// is only adopted by the directory if LinkExistingUsers is set; otherwise the credentials
+// are not recognised and are left to the authenticators after it.
+func (a *LDAPAuthenticator) provision(ctx context.Context, username string, entry *ldap.Entry) (*UserWithoutSecrets, error) {
+	user, err := a.userStore.GetUserByIdentity(ctx, ldapProviderName, entry.DN)
+	if err != nil {
+		return nil, fmt.Errorf("GetUserByIdentity: %w", err)
+	}
+	if user != nil {
+		return user, nil
+	}
+
+	user, err = a.userStore.GetUserByUsername(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("GetUserByUsername: %w", err)
+	}
+	if user != nil && !a.config.LinkExistingUsers {
+		return nil, ErrBadCredentials
+	}
+
+	if user == nil {
+		name := entry.GetAttributeValue(a.config.NameAttribute)
+		if len(name) < 3 {
+			name = username
+		}
+		// the directory owns the password, the local one is never handed out
+		password, err := randomString(32)
+		if err != nil {
+			return nil, fmt.Errorf("generate password: %w", err)
+		}
+		if err := a.userStore.CreateUser(ctx, User{Name: name, Username: username, Password: password}); err != nil {
+			return nil, fmt.Errorf("CreateUser: %w", err)
+		}
+		user = &UserWithoutSecrets{Name: name, Username: username}
+	}
+
+	identity := Identity{Provider: ldapProviderName, Subject: entry.DN, Username: user.Username}
+	if err := a.userStore.LinkIdentity(ctx, identity); err != nil {
+		if errors.Is(err, ErrConflictedIdentity) {
+			return nil, ErrBadCredentials
+		}
+		return nil, fmt.Errorf("LinkIdentity: %w", err)
+	}
+
+	return user, nil
+}
+
+// syncRooms adds the user to the rooms mapped to their groups and removes plain members
+// from the mapped rooms of groups they have left. Owners and admins are never removed.
+func (a *LDAPAuthenticator) syncRooms(ctx context.Context, username string, groups []string) error {
+	inGroup := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		inGroup[strings.ToLower(group)] = true
+	}
+
+	for group, roomID := range a.config.GroupRooms {
+		isMember, role, err := a.chatStore.IsRoomMember(ctx, roomID, username)
+		if err != nil {
+			return fmt.Errorf("IsRoomMember: %w", err)
+		}
+
+		switch {
+		case inGroup[strings.ToLower(group)] && !isMember:
+			err := a.chatStore.AddRoomMember(ctx, roomID, username, Member)
+			if err != nil && !errors.Is(err, ErrInvalidRoom) {
+				return fmt.Errorf("AddRoomMember: %w", err)
+			}
+		case !inGroup[strings.ToLower(group)] && isMember && role == Member:
+			if err := a.chatStore.RemoveRoomMember(ctx, roomID, username); err != nil {
+				return fmt.Errorf("RemoveRoomMember: %w", err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/jimlambrt/gldap"
+	"github.com/jimlambrt/gldap/testdirectory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type LDAPFixture struct {
+	*ChatFixture
+	directory *testdirectory.Directory
+}
+
+func NewLDAPFixture(t *testing.T) *LDAPFixture {
+	directory := testdirectory.Start(t, testdirectory.WithNoTLS(t),
+		testdirectory.WithDefaults(t, &testdirectory.Defaults{AllowAnonymousBind: true}))
+
+	directory.SetUsers(
+		gldap.NewEntry("cn=alice,"+testdirectory.DefaultUserDN, map[string][]string{
+			"cn":          {"alice"},
+			"displayName": {"Alice Liddell"},
+			"password":    {"wonderland"},
+		}),
+		gldap.NewEntry("cn=bob,"+testdirectory.DefaultUserDN, map[string][]string{
+			"cn":          {"bob"},
+			"displayName": {"Bob"},
+			"password":    {"builder1"},
+		}),
+	)
+
+	return &LDAPFixture{
+		ChatFixture: NewChatFixture(t),
+		directory:   directory,
+	}
+}
+
+func (f *LDAPFixture) authenticator(groupRooms map[string]string) *LDAPAuthenticator {
+	return NewLDAPAuthenticator(LDAPConfig{
+		URL:           fmt.Sprintf("ldap://%s:%d", f.directory.Host(), f.directory.Port()),
+		UserBaseDN:    testdirectory.DefaultUserDN,
+		UserFilter:    "(cn={username})",
+		NameAttribute: "displayName",
+		GroupBaseDN:   testdirectory.DefaultGroupDN,
+		GroupRooms:    groupRooms,
+	}, f.userStore, f.chatStore)
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	t.Run("provision user on first login", func(t *testing.T) {
+		f := NewLDAPFixture(t)
+		defer f.tearDown()
+		a := f.authenticator(nil)
+
+		u, err := a.Authenticate(f.ctx, "alice", "wonderland")
+		require.Nil(t, err)
+		require.NotNil(t, u)
+		assert.Equal(t, "alice", u.Username)
+		assert.Equal(t, "Alice Liddell", u.Name)
+
+		stored, err := f.userStore.GetUserByUsername(f.ctx, "alice")
+		require.Nil(t, err)
+		require.NotNil(t, stored)
+		assert.Equal(t, "Alice Liddell", stored.Name)
+
+		linked, err := f.userStore.GetUserByIdentity(f.ctx, "ldap", "cn=alice,"+testdirectory.DefaultUserDN)
+		require.Nil(t, err)
+		require.NotNil(t, linked)
+		assert.Equal(t, "alice", linked.Username)
+
+		// second login uses the linked user
+		u, err = a.Authenticate(f.ctx, "alice", "wonderland")
+		require.Nil(t, err)
+		assert.Equal(t, "alice", u.Username)
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		f := NewLDAPFixture(t)
+		defer f.tearDown()
+		a := f.authenticator(nil)
+
+		u, err := a.Authenticate(f.ctx, "alice", "password")
+		require.Nil(t, u)
+		assert.Equal(t, ErrBadCredentials, err)
+
+		stored, err := f.userStore.GetUserByUsername(f.ctx, "alice")
+		require.Nil(t, err)
+		assert.Nil(t, stored)
+	})
+
+	t.Run("empty password", func(t *testing.T) {
+		f := NewLDAPFixture(t)
+		defer f.tearDown()
+		a := f.authenticator(nil)
+
+		u, err := a.Authenticate(f.ctx, "alice", "")
+		require.Nil(t, u)
+		assert.Equal(t, ErrBadCredentials, err)
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		f := NewLDAPFixture(t)
+		defer f.tearDown()
+		a := f.authenticator(nil)
+
+		u, err := a.Authenticate(f.ctx, "carol", "password")
+		require.Nil(t, u)
+		assert.Equal(t, ErrBadCredentials, err)
+	})
+
+	t.Run("falls through to local passwords", func(t *testing.T) {
+		f := NewLDAPFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, owner)
+
+		authStore := NewSQLAuthStore(f.db, f.userStore, secret,
+			WithAuthenticators(f.authenticator(nil), NewPasswordAuthenticator(f.userStore)))
+
+		session, err := authStore.NewSession(f.ctx, owner.Username, owner.Password)
+		require.Nil(t, err)
+		assert.Equal(t, owner.Username, session.Username)
+
+		session, err = authStore.NewSession(f.ctx, "bob", "builder1")
+		require.Nil(t, err)
+		assert.Equal(t, "bob", session.Username)
+	})
+
+	t.Run("unreachable directory falls through to local passwords", func(t *testing.T) {
+		f := NewLDAPFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, owner)
+
+		a := NewLDAPAuthenticator(LDAPConfig{
+			URL:        "ldap://127.0.0.1:1",
+			UserBaseDN: testdirectory.DefaultUserDN,
+		}, f.userStore, f.chatStore)
+		authStore := NewSQLAuthStore(f.db, f.userStore, secret,
+			WithAuthenticators(a, NewPasswordAuthenticator(f.userStore)))
+
+		session, err := authStore.NewSession(f.ctx, owner.Username, owner.Password)
+		require.Nil(t, err)
+		assert.Equal(t, owner.Username, session.Username)
+
+		_, err = authStore.NewSession(f.ctx, "bob", "builder1")
+		assert.Equal(t, ErrBadCredentials, err)
+	})
+
+	t.Run("does not adopt local users", func(t *testing.T) {
+		f := NewLDAPFixture(t)
+		defer f.tearDown()
+		local := User{Username: "alice", Password: "local password", Name: "Local Alice"}
+		seedUsers(f.ctx, t, f.userStore, local)
+
+		u, err := f.authenticator(nil).Authenticate(f.ctx, "alice", "wonderland")
+		require.Nil(t, u)
+		assert.Equal(t, ErrBadCredentials, err)
+
+		linked, err := f.userStore.GetUserByIdentity(f.ctx, "ldap", "cn=alice,"+testdirectory.DefaultUserDN)
+		require.Nil(t, err)
+		assert.Nil(t, linked)
+
+		a := f.authenticator(nil)
+		a.config.LinkExistingUsers = true
+		u, err = a.Authenticate(f.ctx, "alice", "wonderland")
+		require.Nil(t, err)
+		assert.Equal(t, "Local Alice", u.Name)
+	})
+}
+
+func TestLDAPGroupSync(t *testing.T) {
+	f := NewLDAPFixture(t)
+	defer f.tearDown()
+	seedUsers(f.ctx, t, f.userStore, owner)
+	rooms := seedRooms(f.ChatFixture, owner, "Engineering", "Sales")
+	a := f.authenticator(map[string]string{
+		"engineering": rooms[0].ID,
+		"sales":       rooms[1].ID,
+	})
+
+	f.directory.SetGroups(
+		testdirectory.NewGroup(t, "engineering", []string{"alice"}),
+		testdirectory.NewGroup(t, "sales", []string{"bob"}),
+	)
+
+	_, err := a.Authenticate(f.ctx, "alice", "wonderland")
+	require.Nil(t, err)
+
+	ok, role, err := f.chatStore.IsRoomMember(f.ctx, rooms[0].ID, "alice")
+	require.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Member, role)
+	ok, _, err = f.chatStore.IsRoomMember(f.ctx, rooms[1].ID, "alice")
+	require.Nil(t, err)
+	assert.False(t, ok)
+
+	// alice moves from engineering to sales
+	f.directory.SetGroups(
+		testdirectory.NewGroup(t, "engineering", []string{"bob"}),
+		testdirectory.NewGroup(t, "sales", []string{"alice", "bob"}),
+	)
+
+	_, err = a.Authenticate(f.ctx, "alice", "wonderland")
+	require.Nil(t, err)
+
+	ok, _, err = f.chatStore.IsRoomMember(f.ctx, rooms[0].ID, "alice")
+	require.Nil(t, err)
+	assert.False(t, ok)
+	ok, _, err = f.chatStore.IsRoomMember(f.ctx, rooms[1].ID, "alice")
+	require.Nil(t, err)
+	assert.True(t, ok)
+}
@@ -0,0 +1,230 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// lockoutSubject is a username or IP address that attempts are counted against.
+type lockoutSubject struct {
+	scope   string
+	subject string
+}
+
+// lockoutSubjects returns the subjects that an attempt for username is counted against:
+// the username under userScope and the client IP address, if known, under ipScope.
+// The order is fixed so that concurrent attempts lock the rows in the same order.
+func lockoutSubjects(ctx context.Context, userScope, ipScope, username string) []lockoutSubject {
+	subjects := []lockoutSubject{{scope: userScope, subject: username}}
+	if ip := ClientIPFromContext(ctx); ip != "" {
+		subjects = append(subjects, lockoutSubject{scope: ipScope, subject: ip})
+	}
+	return subjects
+}
+
+func (a *SQLAuthStore) lockoutPolicy(scope string) LockoutPolicy {
+	if scope == LockoutScopeIP || scope == LockoutScopeResetIP {
+		return a.ipLockout
+	}
+	return a.userLockout
+}
+
+// loginAttempt is an attempt that has been counted as a failure before the credentials were checked.
+type loginAttempt struct {
+	now    time.Time
+	counts []attemptCount
+}
+
+type attemptCount struct {
+	lockoutSubject
+	failures     int
+	blockedUntil sql.NullTime
+	locked       bool
+}
+
+// beginAttempt counts an attempt as a failure against each subject and blocks the next attempt
+// according to their policy. If a subject is already blocked, nothing is counted and it returns a LockedError.
+// The check and the count happen in one transaction, so a burst of concurrent attempts
+// cannot all pass the check before any of them is counted. It runs before the credentials are checked,
+// so that a blocked attacker does not get to run bcrypt. The attempt must be settled with endAttempt.
+func (a *SQLAuthStore) beginAttempt(ctx context.Context, subjects []lockoutSubject) (*loginAttempt, error) {
+	now := a.now().UTC().Truncate(time.Microsecond)
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	attempt := &loginAttempt{now: now}
+	for _, s := range subjects {
+		policy := a.lockoutPolicy(s.scope)
+
+		_, err := tx.ExecContext(ctx, `
+		INSERT INTO login_attempts (scope, subject, failures, last_failure_at)
+		VALUES (@scope, @subject, 0, @now)
+		ON CONFLICT (scope, subject) DO NOTHING`,
+			sql.Named("scope", s.scope), sql.Named("subject", s.subject), sql.Named("now", now))
+		if err != nil {
+			return nil, fmt.Errorf("ExecContext(insert login_attempts): %w", err)
+		}
+
+		// failures older than the window are forgotten
+		var windowStart time.Time
+		if policy.Window > 0 {
+			windowStart = now.Add(-policy.Window)
+		}
+		row := tx.QueryRowContext(ctx, `
+		UPDATE login_attempts SET
+		failures = CASE WHEN last_failure_at < @window_start THEN 1 ELSE failures + 1 END,
+		last_failure_at = @now
+		WHERE scope = @scope AND subject = @subject AND (blocked_until IS NULL OR blocked_until <= @now)
+		RETURNING failures`,
+			sql.Named("scope", s.scope), sql.Named("subject", s.subject),
+			sql.Named("window_start", windowStart), sql.Named("now", now))
+		count := attemptCount{lockoutSubject: s}
+		if err := row.Scan(&count.failures); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, blockedError(ctx, tx, s)
+			}
+			return nil, fmt.Errorf("row.Scan: %w", err)
+		}
+
+		var delay time.Duration
+		delay, count.locked = policy.blockFor(count.failures)
+		if delay > 0 {
+			count.blockedUntil = sql.NullTime{Time: now.Add(delay), Valid: true}
+			_, err := tx.ExecContext(ctx, `
+			UPDATE login_attempts SET blocked_until = @blocked_until WHERE scope = @scope AND subject = @subject`,
+				sql.Named("scope", s.scope), sql.Named("subject", s.subject),
+				sql.Named("blocked_until", count.blockedUntil))
+			if err != nil {
+				return nil, fmt.Errorf("ExecContext(block login_attempts): %w", err)
+			}
+		}
+		attempt.counts = append(attempt.counts, count)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("Commit: %w", err)
+	}
+	return attempt, nil
+}
+
+// blockedError returns the LockedError for a subject that beginAttempt found blocked.
+func blockedError(ctx context.Context, tx *Tx, s lockoutSubject) error {
+	row := tx.QueryRowContext(ctx,
+		"SELECT blocked_until FROM login_attempts WHERE scope = @scope AND subject = @subject",
+		sql.Named("scope", s.scope), sql.Named("subject", s.subject))
+	var blockedUntil time.Time
+	if err := row.Scan(&blockedUntil); err != nil {
+		return fmt.Errorf("row.Scan: %w", err)
+	}
+	return &LockedError{Scope: s.scope, RetryAfter: blockedUntil}
+}
+
+// endAttempt settles an attempt started with beginAttempt.
+// A failed attempt stays counted, and a lockout it caused is recorded in login_lockouts.
+// Any other attempt is taken back along with the block it caused.
+func (a *SQLAuthStore) endAttempt(ctx context.Context, attempt *loginAttempt, failed bool) error {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, c := range attempt.counts {
+		if !failed {
+			_, err := tx.ExecContext(ctx, `
+			UPDATE login_attempts SET
+			failures = CASE WHEN failures > 0 THEN failures - 1 ELSE 0 END,
+			blocked_until = CASE WHEN blocked_until = @blocked_until THEN NULL ELSE blocked_until END
+			WHERE scope = @scope AND subject = @subject`,
+				sql.Named("scope", c.scope), sql.Named("subject", c.subject),
+				sql.Named("blocked_until", c.blockedUntil))
+			if err != nil {
+				return fmt.Errorf("ExecContext(refund login_attempts): %w", err)
+			}
+			continue
+		}
+		if !c.locked {
+			continue
+		}
+
+		// start counting again once the lockout is over
+		_, err := tx.ExecContext(ctx, `
+		UPDATE login_attempts SET failures = 0 WHERE scope = @scope AND subject = @subject`,
+			sql.Named("scope", c.scope), sql.Named("subject", c.subject))
+		if err != nil {
+			return fmt.Errorf("ExecContext(reset login_attempts): %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+		INSERT INTO login_lockouts (scope, subject, failures, locked_at, locked_until)
+		VALUES (@scope, @subject, @failures, @now, @locked_until)`,
+			sql.Named("scope", c.scope), sql.Named("subject", c.subject), sql.Named("failures", c.failures),
+			sql.Named("now", attempt.now), sql.Named("locked_until", c.blockedUntil.Time))
+		if err != nil {
+			return fmt.Errorf("ExecContext(insert login_lockouts): %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Commit: %w", err)
+	}
+	return nil
+}
+
+// clearFailures forgets the failed attempts against the username after a successful sign in.
+// Failures from the IP address are kept, otherwise one valid account would be enough to
+// keep guessing the passwords of others.
+func (a *SQLAuthStore) clearFailures(ctx context.Context, username string) error {
+	return a.Unlock(ctx, LockoutScopeUsername, username)
+}
+
+func (a *SQLAuthStore) AllowPasswordReset(ctx context.Context, username string) error {
+	attempt, err := a.beginAttempt(ctx,
+		lockoutSubjects(ctx, LockoutScopeResetUsername, LockoutScopeResetIP, username))
+	if err != nil {
+		return err
+	}
+	// every request counts, whether or not the username exists
+	if err := a.endAttempt(ctx, attempt, true); err != nil {
+		return fmt.Errorf("endAttempt: %w", err)
+	}
+	return nil
+}
+
+func (a *SQLAuthStore) GetLockouts(ctx context.Context, since time.Time) ([]Lockout, error) {
+	rows, err := a.db.QueryContext(ctx, `
+	SELECT id, scope, subject, failures, locked_at, locked_until FROM login_lockouts
+	WHERE locked_at >= @since ORDER BY locked_at DESC, id DESC`,
+		sql.Named("since", since.UTC()))
+	if err != nil {
+		return nil, fmt.Errorf("QueryContext: %w", err)
+	}
+	defer rows.Close()
+
+	var lockouts []Lockout
+	for rows.Next() {
+		var l Lockout
+		if err := rows.Scan(&l.ID, &l.Scope, &l.Subject, &l.Failures, &l.LockedAt, &l.LockedUntil); err != nil {
+			return nil, fmt.Errorf("rows.Scan: %w", err)
+		}
+		lockouts = append(lockouts, l)
+	}
+	return lockouts, rows.Err()
+}
+
+func (a *SQLAuthStore) Unlock(ctx context.Context, scope, subject string) error {
+	_, err := a.db.ExecContext(ctx,
+		"DELETE FROM login_attempts WHERE scope = @scope AND subject = @subject",
+		sql.Named("scope", scope), sql.Named("subject", subject))
+	if err != nil {
+		return fmt.Errorf("ExecContext: %w", err)
+	}
+	return nil
+}
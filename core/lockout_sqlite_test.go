@@ -0,0 +1,259 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a clock that only moves when told to.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+var testLockoutPolicy = LockoutPolicy{
+	FreeAttempts:    2,
+	BaseDelay:       time.Second,
+	MaxDelay:        time.Minute,
+	MaxAttempts:     5,
+	LockoutDuration: 15 * time.Minute,
+	Window:          time.Hour,
+}
+
+func newLockoutAuthStore(f *AuthFixture, clock *fakeClock, ipPolicy LockoutPolicy) AuthStore {
+	return NewSQLAuthStore(f.db, f.userStore, secret,
+		WithClock(clock.Now), WithLockoutPolicy(testLockoutPolicy, ipPolicy))
+}
+
+func TestLockout(t *testing.T) {
+	t.Run("backoff then lockout", func(t *testing.T) {
+		f := NewAuthFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, user)
+		clock := &fakeClock{now: time.Now()}
+		authStore := newLockoutAuthStore(f, clock, DefaultIPLockoutPolicy)
+
+		for i := 0; i < 2; i++ {
+			_, err := authStore.NewSession(f.ctx, user.Username, "wrong password")
+			assert.Equal(t, ErrBadCredentials, err)
+		}
+
+		// the third failure starts the backoff
+		_, err := authStore.NewSession(f.ctx, user.Username, "wrong password")
+		assert.Equal(t, ErrBadCredentials, err)
+		_, err = authStore.NewSession(f.ctx, user.Username, user.Password)
+		var locked *LockedError
+		require.True(t, errors.As(err, &locked))
+		assert.ErrorIs(t, err, ErrTooManyAttempts)
+		assert.Equal(t, LockoutScopeUsername, locked.Scope)
+		assert.WithinDuration(t, clock.Now().Add(time.Second), locked.RetryAfter, time.Millisecond)
+
+		clock.Advance(time.Second)
+		_, err = authStore.NewSession(f.ctx, user.Username, "wrong password")
+		assert.Equal(t, ErrBadCredentials, err)
+		_, err = authStore.NewSession(f.ctx, user.Username, "wrong password")
+		assert.ErrorIs(t, err, ErrTooManyAttempts)
+
+		clock.Advance(2 * time.Second)
+		_, err = authStore.NewSession(f.ctx, user.Username, "wrong password")
+		assert.Equal(t, ErrBadCredentials, err)
+
+		// the fifth failure is a lockout
+		_, err = authStore.NewSession(f.ctx, user.Username, user.Password)
+		require.True(t, errors.As(err, &locked))
+		assert.WithinDuration(t, clock.Now().Add(15*time.Minute), locked.RetryAfter, time.Millisecond)
+
+		lockouts, err := authStore.GetLockouts(f.ctx, clock.Now().Add(-time.Hour))
+		require.Nil(t, err)
+		require.Len(t, lockouts, 1)
+		assert.Equal(t, LockoutScopeUsername, lockouts[0].Scope)
+		assert.Equal(t, user.Username, lockouts[0].Subject)
+		assert.Equal(t, 5, lockouts[0].Failures)
+
+		clock.Advance(15 * time.Minute)
+		session, err := authStore.NewSession(f.ctx, user.Username, user.Password)
+		require.Nil(t, err)
+		assert.Equal(t, user.Username, session.Username)
+	})
+
+	t.Run("success clears failures", func(t *testing.T) {
+		f := NewAuthFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, user)
+		clock := &fakeClock{now: time.Now()}
+		authStore := newLockoutAuthStore(f, clock, DefaultIPLockoutPolicy)
+
+		for i := 0; i < 2; i++ {
+			_, err := authStore.NewSession(f.ctx, user.Username, "wrong password")
+			assert.Equal(t, ErrBadCredentials, err)
+		}
+		_, err := authStore.NewSession(f.ctx, user.Username, user.Password)
+		require.Nil(t, err)
+
+		for i := 0; i < 2; i++ {
+			_, err := authStore.NewSession(f.ctx, user.Username, "wrong password")
+			assert.Equal(t, ErrBadCredentials, err)
+		}
+		_, err = authStore.NewSession(f.ctx, user.Username, user.Password)
+		assert.Nil(t, err)
+	})
+
+	t.Run("per ip", func(t *testing.T) {
+		f := NewAuthFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, user)
+		clock := &fakeClock{now: time.Now()}
+		authStore := newLockoutAuthStore(f, clock, LockoutPolicy{MaxAttempts: 3, LockoutDuration: time.Minute})
+		ctx := ContextWithClientIP(f.ctx, "192.0.2.1")
+
+		// spreading guesses over many usernames still locks the address
+		for _, username := range []string{"alice", "bob", "carol"} {
+			_, err := authStore.NewSession(ctx, username, "password")
+			assert.Equal(t, ErrBadCredentials, err)
+		}
+
+		_, err := authStore.NewSession(ctx, user.Username, user.Password)
+		var locked *LockedError
+		require.True(t, errors.As(err, &locked))
+		assert.Equal(t, LockoutScopeIP, locked.Scope)
+
+		// other addresses are not affected
+		_, err = authStore.NewSession(ContextWithClientIP(context.Background(), "192.0.2.2"),
+			user.Username, user.Password)
+		assert.Nil(t, err)
+
+		require.Nil(t, authStore.Unlock(f.ctx, LockoutScopeIP, "192.0.2.1"))
+		_, err = authStore.NewSession(ctx, user.Username, user.Password)
+		assert.Nil(t, err)
+	})
+
+	t.Run("failures expire after the window", func(t *testing.T) {
+		f := NewAuthFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, user)
+		clock := &fakeClock{now: time.Now()}
+		authStore := newLockoutAuthStore(f, clock, DefaultIPLockoutPolicy)
+
+		for i := 0; i < 2; i++ {
+			_, err := authStore.NewSession(f.ctx, user.Username, "wrong password")
+			assert.Equal(t, ErrBadCredentials, err)
+		}
+		clock.Advance(2 * time.Hour)
+
+		// counting starts again, so this is a free attempt
+		_, err := authStore.NewSession(f.ctx, user.Username, "wrong password")
+		assert.Equal(t, ErrBadCredentials, err)
+		_, err = authStore.NewSession(f.ctx, user.Username, user.Password)
+		assert.Nil(t, err)
+	})
+}
+
+func TestLockoutConcurrentAttempts(t *testing.T) {
+	f := NewAuthFixture(t)
+	defer f.tearDown()
+	// the in-memory database takes one writer at a time
+	f.db.SetMaxOpenConns(1)
+	seedUsers(f.ctx, t, f.userStore, user)
+	policy := LockoutPolicy{FreeAttempts: 3, MaxAttempts: 3, LockoutDuration: time.Minute}
+	authStore := NewSQLAuthStore(f.db, f.userStore, secret, WithLockoutPolicy(policy, DefaultIPLockoutPolicy))
+
+	// a burst of guesses cannot get past the threshold before the failures are counted
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := authStore.NewSession(f.ctx, user.Username, "wrong password")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	badCredentials, locked := 0, 0
+	for err := range errs {
+		switch {
+		case errors.Is(err, ErrBadCredentials):
+			badCredentials++
+		case errors.Is(err, ErrTooManyAttempts):
+			locked++
+		default:
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	assert.Equal(t, 3, badCredentials)
+	assert.Equal(t, 7, locked)
+
+	lockouts, err := authStore.GetLockouts(f.ctx, time.Now().Add(-time.Hour))
+	require.Nil(t, err)
+	assert.Len(t, lockouts, 1)
+}
+
+func TestChangePasswordLockout(t *testing.T) {
+	f := NewAuthFixture(t)
+	defer f.tearDown()
+	seedUsers(f.ctx, t, f.userStore, user)
+	clock := &fakeClock{now: time.Now()}
+	authStore := newLockoutAuthStore(f, clock, DefaultIPLockoutPolicy)
+
+	for i := 0; i < 3; i++ {
+		err := authStore.ChangePassword(f.ctx, user.Username, "wrong password", "new password")
+		assert.Equal(t, ErrBadCredentials, err)
+	}
+
+	// wrong current passwords count against signing in too
+	_, err := authStore.NewSession(f.ctx, user.Username, user.Password)
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+	err = authStore.ChangePassword(f.ctx, user.Username, user.Password, "new password")
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+
+	clock.Advance(time.Second)
+	require.Nil(t, authStore.ChangePassword(f.ctx, user.Username, user.Password, "new password"))
+}
+
+func TestAllowPasswordReset(t *testing.T) {
+	f := NewAuthFixture(t)
+	defer f.tearDown()
+	seedUsers(f.ctx, t, f.userStore, user)
+	clock := &fakeClock{now: time.Now()}
+	authStore := newLockoutAuthStore(f, clock, LockoutPolicy{MaxAttempts: 3, LockoutDuration: time.Minute})
+	ctx := ContextWithClientIP(f.ctx, "192.0.2.1")
+
+	for i := 0; i < 2; i++ {
+		require.Nil(t, authStore.AllowPasswordReset(ctx, user.Username))
+	}
+	// the username gets a backoff after its free requests
+	require.Nil(t, authStore.AllowPasswordReset(ctx, user.Username))
+	err := authStore.AllowPasswordReset(ctx, user.Username)
+	var locked *LockedError
+	require.True(t, errors.As(err, &locked))
+	assert.Equal(t, LockoutScopeResetUsername, locked.Scope)
+
+	// the address is locked after three requests, even for other usernames
+	err = authStore.AllowPasswordReset(ctx, "bob")
+	require.True(t, errors.As(err, &locked))
+	assert.Equal(t, LockoutScopeResetIP, locked.Scope)
+
+	// signing in is not affected
+	_, err = authStore.NewSession(ctx, user.Username, user.Password)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,38 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockoutPolicyBlockFor(t *testing.T) {
+	policy := LockoutPolicy{
+		FreeAttempts:    2,
+		BaseDelay:       time.Second,
+		MaxDelay:        5 * time.Second,
+		MaxAttempts:     8,
+		LockoutDuration: time.Hour,
+	}
+
+	cases := []struct {
+		failures int
+		delay    time.Duration
+		locked   bool
+	}{
+		{1, 0, false},
+		{2, 0, false},
+		{3, time.Second, false},
+		{4, 2 * time.Second, false},
+		{5, 4 * time.Second, false},
+		{6, 5 * time.Second, false},
+		{7, 5 * time.Second, false},
+		{8, time.Hour, true},
+	}
+	for _, c := range cases {
+		delay, locked := policy.blockFor(c.failures)
+		assert.Equal(t, c.delay, delay, "failures %d", c.failures)
+		assert.Equal(t, c.locked, locked, "failures %d", c.failures)
+	}
+}
@@ -0,0 +1,222 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+func (s *SQLChatStore) UpdateMemberRole(ctx context.Context, roomID, username string, role MemberRole) error {
+	if !role.Valid() {
+		return ErrInvalidPermission
+	}
+	if role == Owner {
+		return ErrDisAllowedOperation
+	}
+
+	ok, current, err := s.IsRoomMember(ctx, roomID, username)
+	if err != nil {
+		return fmt.Errorf("IsRoomMember: %w", err)
+	}
+	if !ok {
+		return ErrInvalidMember
+	}
+	if current == Owner {
+		return ErrDisAllowedOperation
+	}
+
+	if current == role {
+		return nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE room_members SET role = @role WHERE room_id = @room_id AND username = @username",
+		sql.Named("role", role), sql.Named("room_id", roomID), sql.Named("username", username))
+	if err != nil {
+		return fmt.Errorf("ExecContext: %w", err)
+	}
+
+	if _, err := insertSystemMessage(ctx, tx, roomID, SystemMessageBody{
+		Key:    SystemMemberRoleChanged,
+		Actor:  ActorFromContext(ctx, username),
+		Target: username,
+		Role:   role,
+	}); err != nil {
+		return err
+	}
+	if err := s.auditLog.appendTx(ctx, tx, AuditEntry{
+		Action: AuditRoleChanged, Actor: ActorFromContext(ctx, username), Target: username, RoomID: roomID,
+		Details: map[string]string{"role": string(role), "previous_role": string(current)},
+	}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Commit: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLChatStore) TransferOwnership(ctx context.Context, roomID, from, to string) error {
+	ok, role, err := s.IsRoomMember(ctx, roomID, from)
+	if err != nil {
+		return fmt.Errorf("IsRoomMember: %w", err)
+	}
+	if !ok {
+		return ErrInvalidRoom
+	}
+	if role != Owner || from == to {
+		return ErrDisAllowedOperation
+	}
+
+	ok, _, err = s.IsRoomMember(ctx, roomID, to)
+	if err != nil {
+		return fmt.Errorf("IsRoomMember: %w", err)
+	}
+	if !ok {
+		return ErrInvalidMember
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := s.transferOwnership(ctx, tx, roomID, from, to); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Commit: %w", err)
+	}
+	return nil
+}
+
+// transferOwnership makes the new owner, keeps the previous owner on as an admin
+// and records the change in the timeline.
+func (s *SQLChatStore) transferOwnership(ctx context.Context, tx *Tx, roomID, from, to string) error {
+	query := "UPDATE room_members SET role = @role WHERE room_id = @room_id AND username = @username"
+	if _, err := tx.ExecContext(ctx, query,
+		sql.Named("role", Admin), sql.Named("room_id", roomID), sql.Named("username", from)); err != nil {
+		return fmt.Errorf("ExecContext(demote owner): %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, query,
+		sql.Named("role", Owner), sql.Named("room_id", roomID), sql.Named("username", to)); err != nil {
+		return fmt.Errorf("ExecContext(promote successor): %w", err)
+	}
+	if _, err := insertSystemMessage(ctx, tx, roomID, SystemMessageBody{
+		Key:    SystemOwnershipTransferred,
+		Actor:  from,
+		Target: to,
+		Role:   Owner,
+	}); err != nil {
+		return err
+	}
+	return s.auditLog.appendTx(ctx, tx, AuditEntry{
+		Action: AuditRoleChanged, Actor: from, Target: to, RoomID: roomID,
+		Details: map[string]string{"role": string(Owner), "previous_owner": from},
+	})
+}
+
+func (s *SQLChatStore) LeaveRoom(ctx context.Context, roomID, username, successor string) (bool, bool, error) {
+	members, err := s.GetRoomMembers(ctx, roomID)
+	if err != nil {
+		return false, false, fmt.Errorf("GetRoomMembers: %w", err)
+	}
+
+	var leaving *RoomMember
+	successorIsMember := false
+	for i, member := range members {
+		if member.Username == username {
+			leaving = &members[i]
+		}
+		if successor != "" && member.Username == successor && successor != username {
+			successorIsMember = true
+		}
+	}
+	if leaving == nil {
+		return false, false, ErrInvalidRoom
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, false, fmt.Errorf("BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	deleted, transferred := false, false
+	switch {
+	case leaving.Role == Owner && len(members) == 1:
+		// nobody is left to hand the room to
+		if err := deleteRoom(ctx, tx, roomID); err != nil {
+			return false, false, err
+		}
+		if err := s.auditLog.appendTx(ctx, tx, AuditEntry{Action: AuditRoomDeleted, Actor: username, RoomID: roomID}); err != nil {
+			return false, false, err
+		}
+		deleted = true
+	case leaving.Role == Owner && successor == "":
+		return false, false, ErrSuccessorRequired
+	case leaving.Role == Owner && !successorIsMember:
+		return false, false, ErrInvalidMember
+	case leaving.Role == Owner:
+		if err := s.transferOwnership(ctx, tx, roomID, username, successor); err != nil {
+			return false, false, err
+		}
+		transferred = true
+	}
+
+	if !deleted {
+		_, err = tx.ExecContext(ctx,
+			"DELETE FROM room_members WHERE room_id = @room_id AND username = @username",
+			sql.Named("room_id", roomID), sql.Named("username", username))
+		if err != nil {
+			return false, false, fmt.Errorf("ExecContext(delete room_members): %w", err)
+		}
+		if _, err := insertSystemMessage(ctx, tx, roomID, SystemMessageBody{
+			Key:   SystemMemberLeft,
+			Actor: username,
+		}); err != nil {
+			return false, false, err
+		}
+		if err := s.auditLog.appendTx(ctx, tx, AuditEntry{
+			Action: AuditMemberRemoved, Actor: username, Target: username, RoomID: roomID,
+		}); err != nil {
+			return false, false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, false, fmt.Errorf("Commit: %w", err)
+	}
+	return deleted, transferred, nil
+}
+
+// deleteRoom deletes the room together with its members, messages and settings,
+// and the bookmarks of its messages.
+func deleteRoom(ctx context.Context, tx *Tx, roomID string) error {
+	queries := []string{
+		"DELETE FROM message_interactions WHERE message_id IN (SELECT id FROM messages WHERE room_id = @room_id)",
+		"DELETE FROM room_pins WHERE room_id = @room_id",
+		"DELETE FROM bookmarks WHERE message_id IN (SELECT id FROM messages WHERE room_id = @room_id)",
+		"DELETE FROM messages WHERE room_id = @room_id",
+		"DELETE FROM room_members WHERE room_id = @room_id",
+		"DELETE FROM room_permissions WHERE room_id = @room_id",
+		"DELETE FROM room_invite_uses WHERE code IN (SELECT code FROM room_invites WHERE room_id = @room_id)",
+		"DELETE FROM room_invites WHERE room_id = @room_id",
+		"DELETE FROM rooms WHERE id = @room_id",
+	}
+	for _, query := range queries {
+		if _, err := tx.ExecContext(ctx, query, sql.Named("room_id", roomID)); err != nil {
+			return fmt.Errorf("ExecContext(delete room): %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package core
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateMemberRole(t *testing.T) {
+	f := NewChatFixture(t)
+	defer f.tearDown()
+	room := seedRoomWithRoles(f)
+
+	require.Nil(t, f.chatStore.UpdateMemberRole(f.ctx, room.ID, member1.Username, Admin))
+	_, role, err := f.chatStore.IsRoomMember(f.ctx, room.ID, member1.Username)
+	require.Nil(t, err)
+	assert.Equal(t, Admin, role)
+
+	assert.Equal(t, ErrDisAllowedOperation, f.chatStore.UpdateMemberRole(f.ctx, room.ID, member1.Username, Owner))
+	assert.Equal(t, ErrDisAllowedOperation, f.chatStore.UpdateMemberRole(f.ctx, room.ID, owner.Username, Member))
+	assert.Equal(t, ErrInvalidMember, f.chatStore.UpdateMemberRole(f.ctx, room.ID, member2.Username, Member))
+	assert.Equal(t, ErrInvalidPermission, f.chatStore.UpdateMemberRole(f.ctx, room.ID, member1.Username, "king"))
+}
+
+func TestTransferOwnership(t *testing.T) {
+	f := NewChatFixture(t)
+	defer f.tearDown()
+	room := seedRoomWithRoles(f)
+
+	assert.Equal(t, ErrDisAllowedOperation, f.chatStore.TransferOwnership(f.ctx, room.ID, admin.Username, member1.Username))
+	assert.Equal(t, ErrInvalidMember, f.chatStore.TransferOwnership(f.ctx, room.ID, owner.Username, member2.Username))
+	assert.Equal(t, ErrInvalidRoom, f.chatStore.TransferOwnership(f.ctx, room.ID, member2.Username, owner.Username))
+
+	require.Nil(t, f.chatStore.TransferOwnership(f.ctx, room.ID, owner.Username, member1.Username))
+
+	_, role, err := f.chatStore.IsRoomMember(f.ctx, room.ID, member1.Username)
+	require.Nil(t, err)
+	assert.Equal(t, Owner, role)
+	_, role, err = f.chatStore.IsRoomMember(f.ctx, room.ID, owner.Username)
+	require.Nil(t, err)
+	assert.Equal(t, Admin, role)
+}
+
+func TestLeaveRoom(t *testing.T) {
+	t.Run("member leaves", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+
+		// the successor of a member who is not the owner is ignored
+		deleted, transferred, err := f.chatStore.LeaveRoom(f.ctx, room.ID, member1.Username, admin.Username)
+		require.Nil(t, err)
+		assert.False(t, deleted)
+		assert.False(t, transferred)
+		ok, _, err := f.chatStore.IsRoomMember(f.ctx, room.ID, member1.Username)
+		require.Nil(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("owner leaves with a successor", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+
+		_, _, err := f.chatStore.LeaveRoom(f.ctx, room.ID, owner.Username, "")
+		assert.Equal(t, ErrSuccessorRequired, err)
+		_, _, err = f.chatStore.LeaveRoom(f.ctx, room.ID, owner.Username, member2.Username)
+		assert.Equal(t, ErrInvalidMember, err)
+
+		deleted, transferred, err := f.chatStore.LeaveRoom(f.ctx, room.ID, owner.Username, admin.Username)
+		require.Nil(t, err)
+		assert.False(t, deleted)
+		assert.True(t, transferred)
+
+		ok, _, err := f.chatStore.IsRoomMember(f.ctx, room.ID, owner.Username)
+		require.Nil(t, err)
+		assert.False(t, ok)
+		_, role, err := f.chatStore.IsRoomMember(f.ctx, room.ID, admin.Username)
+		require.Nil(t, err)
+		assert.Equal(t, Owner, role)
+	})
+
+	t.Run("last member leaves", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, f.t, f.userStore, owner)
+		room := seedRooms(f, owner)[0]
+
+		deleted, _, err := f.chatStore.LeaveRoom(f.ctx, room.ID, owner.Username, "")
+		require.Nil(t, err)
+		assert.True(t, deleted)
+
+		got, err := f.chatStore.GetRoomByID(f.ctx, room.ID)
+		require.Nil(t, err)
+		assert.Nil(t, got)
+	})
+
+	t.Run("not a member", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+
+		_, _, err := f.chatStore.LeaveRoom(f.ctx, room.ID, member2.Username, "")
+		assert.Equal(t, ErrInvalidRoom, err)
+	})
+}
+
+func TestMembershipSystemMessages(t *testing.T) {
+	f := NewChatFixture(t)
+	defer f.tearDown()
+	seedUsers(f.ctx, f.t, f.userStore, owner, admin, member1, member2)
+	room := seedRooms(f, owner)[0]
+
+	ctx := ContextWithActor(f.ctx, owner.Username)
+	require.Nil(t, f.chatStore.AddRoomMember(ctx, room.ID, member1.Username, Member))
+	// adding an existing member records nothing
+	require.Nil(t, f.chatStore.AddRoomMember(ctx, room.ID, member1.Username, Member))
+	require.Nil(t, f.chatStore.AddRoomMember(f.ctx, room.ID, admin.Username, Member))
+	require.Nil(t, f.chatStore.AddRoomMember(ctx, room.ID, member2.Username, Member))
+	require.Nil(t, f.chatStore.UpdateMemberRole(ctx, room.ID, admin.Username, Admin))
+	require.Nil(t, f.chatStore.RemoveRoomMember(ctx, room.ID, member1.Username))
+	require.Nil(t, f.chatStore.RemoveRoomMember(ContextWithActor(f.ctx, admin.Username), room.ID, admin.Username))
+	// a removal without an actor is not attributed to anyone
+	require.Nil(t, f.chatStore.RemoveRoomMember(f.ctx, room.ID, member2.Username))
+
+	messages, err := f.chatStore.GetRoomMessages(f.ctx, room.ID, 0, 0)
+	require.Nil(t, err)
+
+	expected := []SystemMessageBody{
+		{Key: SystemRoomCreated, Actor: owner.Username},
+		{Key: SystemMemberAdded, Actor: owner.Username, Target: member1.Username, Role: Member},
+		{Key: SystemMemberAdded, Actor: admin.Username, Target: admin.Username, Role: Member},
+		{Key: SystemMemberAdded, Actor: owner.Username, Target: member2.Username, Role: Member},
+		{Key: SystemMemberRoleChanged, Actor: owner.Username, Target: admin.Username, Role: Admin},
+		{Key: SystemMemberRemoved, Actor: owner.Username, Target: member1.Username},
+		{Key: SystemMemberLeft, Actor: admin.Username},
+		{Key: SystemMemberRemoved, Target: member2.Username},
+	}
+	require.Len(t, messages, len(expected))
+	for i, message := range messages {
+		assert.Equal(t, SystemMessage, message.Type)
+		sender := expected[i].Actor
+		if sender == "" {
+			sender = expected[i].Target
+		}
+		assert.Equal(t, sender, message.Sender)
+		var body SystemMessageBody
+		require.Nil(t, json.Unmarshal([]byte(message.Data), &body))
+		assert.Equal(t, expected[i], body)
+	}
+
+	got, err := f.chatStore.GetRoomByID(f.ctx, room.ID)
+	require.Nil(t, err)
+	assert.Equal(t, SystemMessage, got.LastMessageSentType)
+	assert.Equal(t, messages[len(messages)-1].Data, got.LastMessageSentData)
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/pressly/goose/v3"
+)
+
+// ErrSchemaTooNew is returned when the database has migrations applied that the binary does not have,
+// such as after going back to an older release. The binary refuses to use such a database.
+var ErrSchemaTooNew = errors.New("database schema is newer than the binary")
+
+// ErrNoMigration is returned when there is no applied migration to roll back.
+var ErrNoMigration = errors.New("no migration to roll back")
+
+// Migrator applies and rolls back the schema migrations of a database.
+type Migrator struct {
+	provider *goose.Provider
+	// backup copies the database before migrations are applied or rolled back and returns where the copy is.
+	// It is nil if the database is not backed up automatically.
+	backup func(ctx context.Context, version int64) (string, error)
+}
+
+// NewMigrator returns a migrator of the database with the migration files of migrations.
+// The backup function is optional.
+func NewMigrator(db *sql.DB, dialect Dialect, migrations fs.FS,
+	backup func(ctx context.Context, version int64) (string, error)) (*Migrator, error) {
+	provider, err := goose.NewProvider(goose.Dialect(dialect.Goose()), db, migrations)
+	if err != nil {
+		return nil, fmt.Errorf("NewProvider: %w", err)
+	}
+	return &Migrator{provider: provider, backup: backup}, nil
+}
+
+// Status returns the state of every migration, oldest first.
+func (m *Migrator) Status(ctx context.Context) ([]*goose.MigrationStatus, error) {
+	return m.provider.Status(ctx)
+}
+
+// Version returns the version of the database schema and the latest version the binary has.
+func (m *Migrator) Version(ctx context.Context) (current, latest int64, err error) {
+	current, err = m.provider.GetDBVersion(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("GetDBVersion: %w", err)
+	}
+	if sources := m.provider.ListSources(); len(sources) > 0 {
+		latest = sources[len(sources)-1].Version
+	}
+	return current, latest, nil
+}
+
+// Check returns ErrSchemaTooNew if the database schema is newer than the binary.
+func (m *Migrator) Check(ctx context.Context) error {
+	current, latest, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if current > latest {
+		return fmt.Errorf("%w: the database is at version %d and the binary at %d", ErrSchemaTooNew, current, latest)
+	}
+	return nil
+}
+
+// Up applies the pending migrations, backing up the database first if there are any.
+// It returns where the backup is, which is empty if nothing was pending, the database is new
+// or it is not backed up automatically.
+// If the database schema is newer than the binary, it returns ErrSchemaTooNew.
+func (m *Migrator) Up(ctx context.Context) ([]*goose.MigrationResult, string, error) {
+	if err := m.Check(ctx); err != nil {
+		return nil, "", err
+	}
+	pending, err := m.provider.HasPending(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("HasPending: %w", err)
+	}
+	if !pending {
+		return nil, "", nil
+	}
+	backup, err := m.backupFirst(ctx)
+	// a new database has nothing to back up
+	if err != nil && !errors.Is(err, ErrNoMigration) {
+		return nil, "", err
+	}
+	results, err := m.provider.Up(ctx)
+	return results, backup, err
+}
+
+// Down rolls back the most recently applied migration, backing up the database first.
+// It returns where the backup is, which is empty if the database is not backed up automatically.
+// If there is no migration to roll back, it returns ErrNoMigration.
+func (m *Migrator) Down(ctx context.Context) (*goose.MigrationResult, string, error) {
+	if err := m.Check(ctx); err != nil {
+		return nil, "", err
+	}
+	backup, err := m.backupFirst(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	result, err := m.provider.Down(ctx)
+	if errors.Is(err, goose.ErrNoNextVersion) {
+		return nil, backup, ErrNoMigration
+	}
+	return result, backup, err
+}
+
+// Redo rolls back the most recently applied migration and applies it again,
+// backing up the database first as Down does.
+func (m *Migrator) Redo(ctx context.Context) ([]*goose.MigrationResult, string, error) {
+	down, backup, err := m.Down(ctx)
+	if err != nil {
+		return nil, backup, err
+	}
+	up, err := m.provider.ApplyVersion(ctx, down.Source.Version, true)
+	if err != nil {
+		return []*goose.MigrationResult{down}, backup, err
+	}
+	return []*goose.MigrationResult{down, up}, backup, nil
+}
+
+func (m *Migrator) backupFirst(ctx context.Context) (string, error) {
+	if m.backup == nil {
+		return "", nil
+	}
+	current, err := m.provider.GetDBVersion(ctx)
+	if err != nil {
+		return "", fmt.Errorf("GetDBVersion: %w", err)
+	}
+	if current == 0 {
+		return "", ErrNoMigration
+	}
+	path, err := m.backup(ctx, current)
+	if err != nil {
+		return "", fmt.Errorf("backup: %w", err)
+	}
+	return path, nil
+}
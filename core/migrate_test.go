@@ -0,0 +1,107 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pressly/goose/v3"
+	"github.com/putto11262002/chatter/migrations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "chatter.db")
+	db, err := NewSQLiteDB(file, migrations.SQLite, &SQLiteDBOption{Mode: "rwc"})
+	require.Nil(t, err)
+	defer db.Close()
+	migrator, err := db.Migrator()
+	require.Nil(t, err)
+
+	// a new database has nothing to back up
+	results, backup, err := migrator.Up(ctx)
+	require.Nil(t, err)
+	require.NotEmpty(t, results)
+	assert.Empty(t, backup)
+	current, latest, err := migrator.Version(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, latest, current)
+	statuses, err := migrator.Status(ctx)
+	require.Nil(t, err)
+	for _, status := range statuses {
+		assert.Equal(t, goose.StateApplied, status.State)
+	}
+
+	t.Run("down backs up the database", func(t *testing.T) {
+		result, backup, err := migrator.Down(ctx)
+		require.Nil(t, err)
+		assert.Equal(t, latest, result.Source.Version)
+		current, _, err := migrator.Version(ctx)
+		require.Nil(t, err)
+		assert.Less(t, current, latest)
+
+		// the backup is of the database before the rollback
+		_, err = os.Stat(backup)
+		require.Nil(t, err)
+		copied, err := NewSQLiteDB(backup, migrations.SQLite, &SQLiteDBOption{Mode: "ro"})
+		require.Nil(t, err)
+		defer copied.Close()
+		copiedMigrator, err := copied.Migrator()
+		require.Nil(t, err)
+		version, _, err := copiedMigrator.Version(ctx)
+		require.Nil(t, err)
+		assert.Equal(t, latest, version)
+
+	})
+
+	t.Run("up backs up the database", func(t *testing.T) {
+		before, _, err := migrator.Version(ctx)
+		require.Nil(t, err)
+		results, backup, err := migrator.Up(ctx)
+		require.Nil(t, err)
+		require.Len(t, results, 1)
+		require.NotEmpty(t, backup)
+
+		// the backup is of the database before the pending migration
+		copied, err := NewSQLiteDB(backup, migrations.SQLite, &SQLiteDBOption{Mode: "ro"})
+		require.Nil(t, err)
+		defer copied.Close()
+		copiedMigrator, err := copied.Migrator()
+		require.Nil(t, err)
+		version, _, err := copiedMigrator.Version(ctx)
+		require.Nil(t, err)
+		assert.Equal(t, before, version)
+
+		// nothing is backed up when nothing is pending
+		results, backup, err = migrator.Up(ctx)
+		require.Nil(t, err)
+		assert.Empty(t, results)
+		assert.Empty(t, backup)
+	})
+
+	t.Run("redo", func(t *testing.T) {
+		results, backup, err := migrator.Redo(ctx)
+		require.Nil(t, err)
+		assert.NotEmpty(t, backup)
+		require.Len(t, results, 2)
+		assert.Equal(t, "down", results[0].Direction)
+		assert.Equal(t, "up", results[1].Direction)
+		current, _, err := migrator.Version(ctx)
+		require.Nil(t, err)
+		assert.Equal(t, latest, current)
+	})
+
+	t.Run("schema newer than the binary", func(t *testing.T) {
+		_, err := db.ExecContext(ctx,
+			"INSERT INTO goose_db_version (version_id, is_applied) VALUES (?, TRUE)", latest+1)
+		require.Nil(t, err)
+
+		assert.ErrorIs(t, migrator.Check(ctx), ErrSchemaTooNew)
+		assert.ErrorIs(t, db.Migrate(), ErrSchemaTooNew)
+		_, _, err = migrator.Down(ctx)
+		assert.ErrorIs(t, err, ErrSchemaTooNew)
+	})
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Notification is a message delivered to a user outside of the chat, such as a password reset link.
+type Notification struct {
+	Username string    `json:"username"`
+	Subject  string    `json:"subject"`
+	Body     string    `json:"body"`
+	SentAt   time.Time `json:"sent_at"`
+}
+
+// Notifier delivers notifications to users.
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// LogNotifier writes notifications to a logger. It is meant for local development.
+type LogNotifier struct {
+	logger *slog.Logger
+}
+
+func NewLogNotifier(logger *slog.Logger) *LogNotifier {
+	return &LogNotifier{logger: logger}
+}
+
+func (n *LogNotifier) Notify(ctx context.Context, notification Notification) error {
+	n.logger.InfoContext(ctx, "notification",
+		slog.String("username", notification.Username),
+		slog.String("subject", notification.Subject),
+		slog.String("body", notification.Body))
+	return nil
+}
+
+// FileNotifier appends notifications to a file as JSON lines.
+type FileNotifier struct {
+	mu   sync.Mutex
+	file string
+}
+
+func NewFileNotifier(file string) *FileNotifier {
+	return &FileNotifier{file: file}
+}
+
+func (n *FileNotifier) Notify(_ context.Context, notification Notification) error {
+	line, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open notification file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write notification: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var (
+	// ErrInvalidOIDCState is returned when the state of an OIDC callback is unknown or expired.
+	ErrInvalidOIDCState = errors.New("invalid oidc state")
+	// ErrInvalidOIDCIdentity is returned when the identity provider does not return a usable identity.
+	ErrInvalidOIDCIdentity = errors.New("invalid oidc identity")
+)
+
+const (
+	defaultOIDCProviderName  = "oidc"
+	defaultOIDCUsernameClaim = "preferred_username"
+	defaultOIDCNameClaim     = "name"
+	// oidcAuthRequestTTL is how long a user has to complete the login at the identity provider.
+	oidcAuthRequestTTL = 10 * time.Minute
+)
+
+type OIDCConfig struct {
+	// Name identifies the provider in linked identities. The default is "oidc".
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes are requested in addition to the openid scope.
+	Scopes []string
+	// UsernameClaim is the ID token claim used as the username of provisioned users.
+	// The default is "preferred_username".
+	UsernameClaim string
+	// NameClaim is the ID token claim used as the name of provisioned users.
+	// The default is "name".
+	NameClaim string
+	// LinkExistingUsers links an identity to an existing local user with the same username
+	// on first login. If false, such a login fails with ErrConflictedUser.
+	LinkExistingUsers bool
+}
+
+// oidcAuthRequest holds the secrets of an authorization request that is waiting for its callback.
+type oidcAuthRequest struct {
+	nonce     string
+	verifier  string
+	expiresAt time.Time
+}
+
+// OIDCProvider signs users in through an OpenID Connect identity provider using the
+// authorization code flow with PKCE. Users are provisioned on their first login.
+type OIDCProvider struct {
+	config    OIDCConfig
+	oauth2    oauth2.Config
+	verifier  *oidc.IDTokenVerifier
+	userStore UserStore
+	requests  *SyncMap[string, oidcAuthRequest]
+	now       func() time.Time
+}
+
+// NewOIDCProvider discovers the identity provider configuration from the issuer.
+func NewOIDCProvider(ctx context.Context, config OIDCConfig, userStore UserStore) (*OIDCProvider, error) {
+	if config.Name == "" {
+		config.Name = defaultOIDCProviderName
+	}
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = defaultOIDCUsernameClaim
+	}
+	if config.NameClaim == "" {
+		config.NameClaim = defaultOIDCNameClaim
+	}
+
+	provider, err := oidc.NewProvider(ctx, config.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("discover provider: %w", err)
+	}
+
+	return &OIDCProvider{
+		config: config,
+		oauth2: oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       append([]string{oidc.ScopeOpenID}, config.Scopes...),
+		},
+		verifier:  provider.Verifier(&oidc.Config{ClientID: config.ClientID}),
+		userStore: userStore,
+		requests:  NewSyncMap[string, oidcAuthRequest](),
+		now:       time.Now,
+	}, nil
+}
+
+// AuthCodeURL starts a new authorization request and returns the URL of the identity provider's
+// login page. The returned state must be passed back to Exchange along with the code.
+func (p *OIDCProvider) AuthCodeURL() (string, string, error) {
+	state, err := randomString(32)
+	if err != nil {
+		return "", "", fmt.Errorf("generate state: %w", err)
+	}
+	nonce, err := randomString(32)
+	if err != nil {
+		return "", "", fmt.Errorf("generate nonce: %w", err)
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	now := p.now()
+	// drop abandoned requests
+	p.requests.DeleteFunc(func(_ string, req oidcAuthRequest) bool {
+		return now.After(req.expiresAt)
+	})
+	p.requests.Store(state, oidcAuthRequest{
+		nonce:     nonce,
+		verifier:  verifier,
+		expiresAt: now.Add(oidcAuthRequestTTL),
+	})
+
+	url := p.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	return url, state, nil
+}
+
+// Exchange completes the authorization request identified by state.
+// It returns the local user that the identity is linked to, provisioning one if needed.
+// If the state is unknown or expired, it returns ErrInvalidOIDCState.
+func (p *OIDCProvider) Exchange(ctx context.Context, state, code string) (*UserWithoutSecrets, error) {
+	// the state is used up even if the exchange fails, so a replayed callback is refused
+	req, ok := p.requests.LoadAndDelete(state)
+	if !ok {
+		return nil, ErrInvalidOIDCState
+	}
+	if p.now().After(req.expiresAt) {
+		return nil, ErrInvalidOIDCState
+	}
+
+	token, err := p.oauth2.Exchange(ctx, code, oauth2.VerifierOption(req.verifier))
+	if err != nil {
+		return nil, fmt.Errorf("exchange code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, ErrInvalidOIDCIdentity
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("verify id token: %w", err)
+	}
+	if idToken.Nonce != req.nonce {
+		return nil, ErrInvalidOIDCIdentity
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("decode claims: %w", err)
+	}
+
+	return p.provision(ctx, idToken.Subject, claims)
+}
+
+// provision returns the user linked to the subject.
+// If no user is linked yet, the identity is linked to the user with the claimed username,
+// which is created if it does not exist.
+func (p *OIDCProvider) provision(ctx context.Context, subject string, claims map[string]interface{}) (*UserWithoutSecrets, error) {
+	user, err := p.userStore.GetUserByIdentity(ctx, p.config.Name, subject)
+	if err != nil {
+		return nil, fmt.Errorf("GetUserByIdentity: %w", err)
+	}
+	if user != nil {
+		return user, nil
+	}
+
+	username := sanitizeUsername(stringClaim(claims, p.config.UsernameClaim))
+	if len(username) < 3 {
+		return nil, ErrInvalidOIDCIdentity
+	}
+
+	user, err = p.userStore.GetUserByUsername(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("GetUserByUsername: %w", err)
+	}
+	if user != nil && !p.config.LinkExistingUsers {
+		return nil, ErrConflictedUser
+	}
+
+	if user == nil {
+		name := stringClaim(claims, p.config.NameClaim)
+		if len(name) < 3 {
+			name = username
+		}
+		// Provisioned users sign in through the identity provider,
+		// so the local password is random and never handed out.
+		password, err := randomString(32)
+		if err != nil {
+			return nil, fmt.Errorf("generate password: %w", err)
+		}
+		newUser := User{Name: name, Username: username, Password: password}
+		if err := p.userStore.CreateUser(ctx, newUser); err != nil {
+			return nil, fmt.Errorf("CreateUser: %w", err)
+		}
+		user = &UserWithoutSecrets{Name: name, Username: username}
+	}
+
+	identity := Identity{Provider: p.config.Name, Subject: subject, Username: user.Username}
+	if err := p.userStore.LinkIdentity(ctx, identity); err != nil {
+		return nil, fmt.Errorf("LinkIdentity: %w", err)
+	}
+
+	return user, nil
+}
+
+func stringClaim(claims map[string]interface{}, name string) string {
+	v, _ := claims[name].(string)
+	return v
+}
+
+var invalidUsernameChars = regexp.MustCompile(`[^a-z0-9._-]`)
+
+// sanitizeUsername turns an external username or email into a local username.
+func sanitizeUsername(username string) string {
+	username = strings.ToLower(strings.TrimSpace(username))
+	if i := strings.Index(username, "@"); i > 0 {
+		username = username[:i]
+	}
+	return invalidUsernameChars.ReplaceAllString(username, "")
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,342 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockOIDCServer is a minimal OpenID Connect provider that issues an ID token
+// for whichever claims were registered with the authorization code.
+type mockOIDCServer struct {
+	*httptest.Server
+	key   *rsa.PrivateKey
+	mu    sync.Mutex
+	codes map[string]mockAuthorization
+}
+
+type mockAuthorization struct {
+	claims        jwt.MapClaims
+	codeChallenge string
+}
+
+func newMockOIDCServer(t *testing.T) *mockOIDCServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+
+	s := &mockOIDCServer{key: key, codes: make(map[string]mockAuthorization)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                s.URL,
+			"authorization_endpoint":                s.URL + "/authorize",
+			"token_endpoint":                        s.URL + "/token",
+			"jwks_uri":                              s.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "test",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		s.mu.Lock()
+		auth, ok := s.codes[r.Form.Get("code")]
+		delete(s.codes, r.Form.Get("code"))
+		s.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != auth.codeChallenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, auth.claims)
+		token.Header["kid"] = "test"
+		idToken, err := token.SignedString(key)
+		require.Nil(t, err)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     idToken,
+			"expires_in":   3600,
+		})
+	})
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// authorize plays the part of the user logging in at the identity provider
+// and returns the code that the provider would redirect back with.
+func (s *mockOIDCServer) authorize(t *testing.T, authCodeURL string, subject string, claims jwt.MapClaims) string {
+	u, err := url.Parse(authCodeURL)
+	require.Nil(t, err)
+	query := u.Query()
+	require.Equal(t, "S256", query.Get("code_challenge_method"))
+
+	all := jwt.MapClaims{
+		"iss":   s.URL,
+		"sub":   subject,
+		"aud":   query.Get("client_id"),
+		"nonce": query.Get("nonce"),
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range claims {
+		all[k] = v
+	}
+
+	code := subject + "-code"
+	s.mu.Lock()
+	s.codes[code] = mockAuthorization{claims: all, codeChallenge: query.Get("code_challenge")}
+	s.mu.Unlock()
+	return code
+}
+
+type OIDCFixture struct {
+	*BaseFixture
+	userStore UserStore
+	server    *mockOIDCServer
+}
+
+func NewOIDCFixture(t *testing.T) *OIDCFixture {
+	base := NewBaseFixture(t)
+	server := newMockOIDCServer(t)
+	tearDown := base.tearDown
+	base.tearDown = func() {
+		server.Close()
+		tearDown()
+	}
+	return &OIDCFixture{
+		BaseFixture: base,
+		userStore:   NewSQLUserStore(base.db),
+		server:      server,
+	}
+}
+
+func (f *OIDCFixture) provider(linkExistingUsers bool) *OIDCProvider {
+	provider, err := NewOIDCProvider(f.ctx, OIDCConfig{
+		Issuer:            f.server.URL,
+		ClientID:          "chatter",
+		ClientSecret:      "secret",
+		RedirectURL:       "http://localhost/api/auth/oidc/callback",
+		LinkExistingUsers: linkExistingUsers,
+	}, f.userStore)
+	require.Nil(f.t, err)
+	return provider
+}
+
+func TestOIDCExchange(t *testing.T) {
+	t.Run("provision new user", func(t *testing.T) {
+		f := NewOIDCFixture(t)
+		defer f.tearDown()
+		p := f.provider(false)
+
+		authURL, state, err := p.AuthCodeURL()
+		require.Nil(t, err)
+		code := f.server.authorize(t, authURL, "sub-1", jwt.MapClaims{
+			"preferred_username": "Alice@example.com",
+			"name":               "Alice Liddell",
+		})
+
+		user, err := p.Exchange(f.ctx, state, code)
+		require.Nil(t, err)
+		require.NotNil(t, user)
+		assert.Equal(t, "alice", user.Username)
+		assert.Equal(t, "Alice Liddell", user.Name)
+
+		linked, err := f.userStore.GetUserByIdentity(f.ctx, "oidc", "sub-1")
+		require.Nil(t, err)
+		require.NotNil(t, linked)
+		assert.Equal(t, "alice", linked.Username)
+	})
+
+	t.Run("returning user signs in as the linked user", func(t *testing.T) {
+		f := NewOIDCFixture(t)
+		defer f.tearDown()
+		p := f.provider(false)
+
+		authURL, state, err := p.AuthCodeURL()
+		require.Nil(t, err)
+		code := f.server.authorize(t, authURL, "sub-1", jwt.MapClaims{"preferred_username": "alice"})
+		_, err = p.Exchange(f.ctx, state, code)
+		require.Nil(t, err)
+
+		// the username claim changed at the provider but the subject is the same
+		authURL, state, err = p.AuthCodeURL()
+		require.Nil(t, err)
+		code = f.server.authorize(t, authURL, "sub-1", jwt.MapClaims{"preferred_username": "alice2"})
+		user, err := p.Exchange(f.ctx, state, code)
+		require.Nil(t, err)
+		assert.Equal(t, "alice", user.Username)
+	})
+
+	t.Run("existing username without linking", func(t *testing.T) {
+		f := NewOIDCFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, user)
+		p := f.provider(false)
+
+		authURL, state, err := p.AuthCodeURL()
+		require.Nil(t, err)
+		code := f.server.authorize(t, authURL, "sub-1", jwt.MapClaims{"preferred_username": user.Username})
+
+		linked, err := p.Exchange(f.ctx, state, code)
+		require.Nil(t, linked)
+		assert.ErrorIs(t, err, ErrConflictedUser)
+	})
+
+	t.Run("existing username with linking", func(t *testing.T) {
+		f := NewOIDCFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, user)
+		p := f.provider(true)
+
+		authURL, state, err := p.AuthCodeURL()
+		require.Nil(t, err)
+		code := f.server.authorize(t, authURL, "sub-1", jwt.MapClaims{"preferred_username": user.Username})
+
+		linked, err := p.Exchange(f.ctx, state, code)
+		require.Nil(t, err)
+		require.NotNil(t, linked)
+		assert.Equal(t, user.Username, linked.Username)
+		assert.Equal(t, user.Name, linked.Name)
+	})
+
+	t.Run("unknown state", func(t *testing.T) {
+		f := NewOIDCFixture(t)
+		defer f.tearDown()
+		p := f.provider(false)
+
+		authURL, _, err := p.AuthCodeURL()
+		require.Nil(t, err)
+		code := f.server.authorize(t, authURL, "sub-1", jwt.MapClaims{"preferred_username": "alice"})
+
+		u, err := p.Exchange(f.ctx, "random", code)
+		require.Nil(t, u)
+		assert.Equal(t, ErrInvalidOIDCState, err)
+	})
+
+	t.Run("state can only be used once", func(t *testing.T) {
+		f := NewOIDCFixture(t)
+		defer f.tearDown()
+		p := f.provider(false)
+
+		authURL, state, err := p.AuthCodeURL()
+		require.Nil(t, err)
+		code := f.server.authorize(t, authURL, "sub-1", jwt.MapClaims{"preferred_username": "alice"})
+		_, err = p.Exchange(f.ctx, state, code)
+		require.Nil(t, err)
+
+		_, err = p.Exchange(f.ctx, state, code)
+		assert.Equal(t, ErrInvalidOIDCState, err)
+	})
+
+	t.Run("concurrent callbacks with the same state", func(t *testing.T) {
+		f := NewOIDCFixture(t)
+		defer f.tearDown()
+		p := f.provider(true)
+		// the user exists up front, so that the callbacks do not race to provision it
+		seedUsers(f.ctx, t, f.userStore, User{Username: "alice", Password: "password", Name: "Alice"})
+
+		authURL, state, err := p.AuthCodeURL()
+		require.Nil(t, err)
+		code := f.server.authorize(t, authURL, "sub-1", jwt.MapClaims{"preferred_username": "alice"})
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 4)
+		for i := 0; i < cap(errs); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := p.Exchange(f.ctx, state, code)
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		exchanged := 0
+		for err := range errs {
+			if err == nil {
+				exchanged++
+				continue
+			}
+			assert.Equal(t, ErrInvalidOIDCState, err)
+		}
+		assert.Equal(t, 1, exchanged)
+	})
+
+	t.Run("abandoned requests are dropped", func(t *testing.T) {
+		f := NewOIDCFixture(t)
+		defer f.tearDown()
+		p := f.provider(false)
+
+		_, abandoned, err := p.AuthCodeURL()
+		require.Nil(t, err)
+		now := time.Now()
+		p.now = func() time.Time { return now.Add(oidcAuthRequestTTL + time.Minute) }
+		_, state, err := p.AuthCodeURL()
+		require.Nil(t, err)
+
+		_, ok := p.requests.Load(abandoned)
+		assert.False(t, ok)
+		_, ok = p.requests.Load(state)
+		assert.True(t, ok)
+	})
+}
+
+func TestNewSessionAuthenticators(t *testing.T) {
+	f := NewAuthFixture(t)
+	defer f.tearDown()
+	seedUsers(f.ctx, t, f.userStore, user)
+
+	authStore := NewSQLAuthStore(f.db, f.userStore, secret,
+		WithAuthenticators(stubAuthenticator{username: user.Username, password: "external"}))
+
+	session, err := authStore.NewSession(f.ctx, user.Username, "external")
+	require.Nil(t, err)
+	assert.Equal(t, user.Username, session.Username)
+
+	// the password authenticator is replaced
+	session, err = authStore.NewSession(f.ctx, user.Username, user.Password)
+	require.Nil(t, session)
+	assert.Equal(t, ErrBadCredentials, err)
+}
+
+type stubAuthenticator struct {
+	username string
+	password string
+}
+
+func (a stubAuthenticator) Authenticate(_ context.Context, username, password string) (*UserWithoutSecrets, error) {
+	if username != a.username || password != a.password {
+		return nil, ErrBadCredentials
+	}
+	return &UserWithoutSecrets{Username: username}, nil
+}
@@ -0,0 +1,78 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const defaultPasswordResetExp = 30 * time.Minute
+
+// PasswordResetter runs the self-service password reset flow.
+// A reset token is sent to the user through the notifier and exchanged for a new password.
+type PasswordResetter struct {
+	userStore UserStore
+	notifier  Notifier
+	tokenExp  time.Duration
+	resetURL  string
+}
+
+// NewPasswordResetter creates a PasswordResetter. Tokens expire after tokenExp, which defaults to 30 minutes.
+// If resetURL is set, the notification links to it with the token in the token query parameter,
+// otherwise it only contains the token.
+func NewPasswordResetter(userStore UserStore, notifier Notifier, tokenExp time.Duration, resetURL string) *PasswordResetter {
+	if tokenExp <= 0 {
+		tokenExp = defaultPasswordResetExp
+	}
+	return &PasswordResetter{
+		userStore: userStore,
+		notifier:  notifier,
+		tokenExp:  tokenExp,
+		resetURL:  resetURL,
+	}
+}
+
+// RequestReset sends a reset token to the user.
+// Unknown and deactivated users are ignored so that callers cannot tell which usernames exist.
+func (r *PasswordResetter) RequestReset(ctx context.Context, username string) error {
+	token, exp, err := r.userStore.CreatePasswordResetToken(ctx, username, r.tokenExp)
+	if err != nil {
+		if errors.Is(err, ErrInvalidUser) {
+			return nil
+		}
+		return fmt.Errorf("CreatePasswordResetToken: %w", err)
+	}
+
+	body := fmt.Sprintf("Your password reset token is %s", token)
+	if r.resetURL != "" {
+		link, err := url.Parse(r.resetURL)
+		if err != nil {
+			return fmt.Errorf("parse reset url: %w", err)
+		}
+		query := link.Query()
+		query.Set("token", token)
+		link.RawQuery = query.Encode()
+		body = fmt.Sprintf("Reset your password at %s", link)
+	}
+	body += fmt.Sprintf("\nIt expires at %s. If you did not ask to reset your password, ignore this message.",
+		exp.Format(time.RFC1123))
+
+	notification := Notification{
+		Username: username,
+		Subject:  "Reset your password",
+		Body:     body,
+		SentAt:   time.Now().UTC(),
+	}
+	if err := r.notifier.Notify(ctx, notification); err != nil {
+		return fmt.Errorf("Notify: %w", err)
+	}
+	return nil
+}
+
+// Reset sets a new password with a token sent by RequestReset.
+// If the token is unknown, expired or already used, it returns ErrInvalidResetToken.
+func (r *PasswordResetter) Reset(ctx context.Context, token, newPassword string) error {
+	return r.userStore.ResetPassword(ctx, token, newPassword)
+}
@@ -0,0 +1,78 @@
+package core
+
+import (
+	"errors"
+	"slices"
+)
+
+// Permission is an action in a room that is granted to members by their role.
+type Permission string
+
+const (
+	PostMessages         Permission = "post_messages"
+	InviteMembers        Permission = "invite_members"
+	RemoveMembers        Permission = "remove_members"
+	PinMessages          Permission = "pin_messages"
+	RenameRoom           Permission = "rename_room"
+	DeleteOthersMessages Permission = "delete_others_messages"
+	ChangeRoles          Permission = "change_roles"
+	// ChangeVisibility allows making the room public or private again. Making a room public
+	// exposes its whole history, so only the owner has it unless a room grants it.
+	ChangeVisibility Permission = "change_visibility"
+	// ArchiveRoom allows archiving and restoring the room. It is the only permission
+	// that can be used while the room is archived.
+	ArchiveRoom Permission = "archive_room"
+	// ManageRetention allows changing how long the messages of the room are kept.
+	// Only the owner has it unless a room grants it.
+	ManageRetention Permission = "manage_retention"
+)
+
+// Permissions lists every permission.
+var Permissions = []Permission{
+	PostMessages, InviteMembers, RemoveMembers, PinMessages, RenameRoom, DeleteOthersMessages, ChangeRoles,
+	ChangeVisibility, ArchiveRoom, ManageRetention,
+}
+
+// DefaultRolePermissions are the permissions of each role unless a room overrides them.
+// The owner always has every permission and cannot be overridden.
+var DefaultRolePermissions = map[MemberRole][]Permission{
+	Owner: Permissions,
+	Admin: {PostMessages, InviteMembers, RemoveMembers, PinMessages, RenameRoom, DeleteOthersMessages, ChangeRoles,
+		ArchiveRoom},
+	Member: {PostMessages},
+}
+
+// RolePermissions maps each role to the permissions it has in a room.
+type RolePermissions map[MemberRole][]Permission
+
+var (
+	// ErrInvalidPermission is returned when a permission or role is not known.
+	ErrInvalidPermission = errors.New("invalid permission")
+)
+
+func (p Permission) Valid() bool {
+	return slices.Contains(Permissions, p)
+}
+
+func (r MemberRole) Valid() bool {
+	return r == Owner || r == Admin || r == Member
+}
+
+func roleRank(role MemberRole) int {
+	switch role {
+	case Owner:
+		return 3
+	case Admin:
+		return 2
+	case Member:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Outranks reports whether a member with the role can act on a member with the other role,
+// e.g. remove them or change their role. Nobody outranks a member with the same role.
+func (r MemberRole) Outranks(other MemberRole) bool {
+	return roleRank(r) > roleRank(other)
+}
@@ -0,0 +1,175 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+func (s *SQLChatStore) hasPermission(ctx context.Context, roomID string, role MemberRole, permission Permission) (bool, error) {
+	if role == Owner {
+		return true, nil
+	}
+
+	row := s.db.QueryRowContext(ctx, `
+	SELECT allowed FROM room_permissions
+	WHERE room_id = @room_id AND role = @role AND permission = @permission`,
+		sql.Named("room_id", roomID), sql.Named("role", role), sql.Named("permission", permission))
+	var allowed bool
+	if err := row.Scan(&allowed); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return slices.Contains(DefaultRolePermissions[role], permission), nil
+		}
+		return false, fmt.Errorf("row.Scan: %w", err)
+	}
+	return allowed, nil
+}
+
+func (s *SQLChatStore) isArchived(ctx context.Context, roomID string) (bool, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT archived_at IS NOT NULL FROM rooms WHERE id = @room_id",
+		sql.Named("room_id", roomID))
+	var archived bool
+	if err := row.Scan(&archived); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("row.Scan: %w", err)
+	}
+	return archived, nil
+}
+
+func (s *SQLChatStore) Authorize(ctx context.Context, roomID, username string, permission Permission) (MemberRole, error) {
+	ok, role, err := s.IsRoomMember(ctx, roomID, username)
+	if err != nil {
+		return "", fmt.Errorf("IsRoomMember: %w", err)
+	}
+	if !ok {
+		return "", ErrInvalidRoom
+	}
+
+	if permission != ArchiveRoom {
+		archived, err := s.isArchived(ctx, roomID)
+		if err != nil {
+			return "", fmt.Errorf("isArchived: %w", err)
+		}
+		if archived {
+			return role, ErrArchivedRoom
+		}
+	}
+
+	allowed, err := s.hasPermission(ctx, roomID, role, permission)
+	if err != nil {
+		return "", fmt.Errorf("hasPermission: %w", err)
+	}
+	if !allowed {
+		return role, ErrDisAllowedOperation
+	}
+	return role, nil
+}
+
+func (s *SQLChatStore) AuthorizeMember(ctx context.Context, roomID, actor, target string, permission Permission) (MemberRole, error) {
+	actorRole, err := s.Authorize(ctx, roomID, actor, permission)
+	if err != nil {
+		return "", err
+	}
+
+	ok, targetRole, err := s.IsRoomMember(ctx, roomID, target)
+	if err != nil {
+		return "", fmt.Errorf("IsRoomMember: %w", err)
+	}
+	if !ok {
+		return "", ErrInvalidMember
+	}
+	if !actorRole.Outranks(targetRole) {
+		return targetRole, ErrDisAllowedOperation
+	}
+	return targetRole, nil
+}
+
+func (s *SQLChatStore) AuthorizeRoleAssignment(ctx context.Context, roomID, actor string, role MemberRole) error {
+	if !role.Valid() {
+		return ErrInvalidPermission
+	}
+	if role == Member {
+		return nil
+	}
+
+	actorRole, err := s.Authorize(ctx, roomID, actor, ChangeRoles)
+	if err != nil {
+		return err
+	}
+	if !actorRole.Outranks(role) {
+		return ErrDisAllowedOperation
+	}
+	return nil
+}
+
+func (s *SQLChatStore) GetRoomPermissions(ctx context.Context, roomID string) (RolePermissions, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT role, permission, allowed FROM room_permissions WHERE room_id = @room_id",
+		sql.Named("room_id", roomID))
+	if err != nil {
+		return nil, fmt.Errorf("QueryContext: %w", err)
+	}
+	defer rows.Close()
+
+	overrides := make(map[MemberRole]map[Permission]bool)
+	for rows.Next() {
+		var role MemberRole
+		var permission Permission
+		var allowed bool
+		if err := rows.Scan(&role, &permission, &allowed); err != nil {
+			return nil, fmt.Errorf("rows.Scan: %w", err)
+		}
+		if overrides[role] == nil {
+			overrides[role] = make(map[Permission]bool)
+		}
+		overrides[role][permission] = allowed
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err: %w", err)
+	}
+
+	permissions := RolePermissions{Owner: Permissions}
+	for _, role := range []MemberRole{Admin, Member} {
+		granted := []Permission{}
+		for _, permission := range Permissions {
+			allowed, ok := overrides[role][permission]
+			if !ok {
+				allowed = slices.Contains(DefaultRolePermissions[role], permission)
+			}
+			if allowed {
+				granted = append(granted, permission)
+			}
+		}
+		permissions[role] = granted
+	}
+	return permissions, nil
+}
+
+func (s *SQLChatStore) SetRoomPermission(ctx context.Context, roomID string, role MemberRole, permission Permission, allowed bool) error {
+	if !permission.Valid() || !role.Valid() {
+		return ErrInvalidPermission
+	}
+	if role == Owner {
+		return ErrDisAllowedOperation
+	}
+
+	room, err := s.GetRoomByID(ctx, roomID)
+	if err != nil {
+		return fmt.Errorf("GetRoomByID: %w", err)
+	}
+	if room == nil {
+		return ErrInvalidRoom
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+	INSERT INTO room_permissions (room_id, role, permission, allowed)
+	VALUES (@room_id, @role, @permission, @allowed)
+	ON CONFLICT (room_id, role, permission) DO UPDATE SET allowed = excluded.allowed`,
+		sql.Named("room_id", roomID), sql.Named("role", role),
+		sql.Named("permission", permission), sql.Named("allowed", allowed))
+	if err != nil {
+		return fmt.Errorf("ExecContext: %w", err)
+	}
+	return nil
+}
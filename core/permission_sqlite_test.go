@@ -0,0 +1,101 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var admin = User{Username: "admin", Password: "password", Name: "Admin"}
+
+// seedRoomWithRoles creates a room owned by owner with admin as an Admin and member1 as a Member.
+func seedRoomWithRoles(f *ChatFixture) Room {
+	seedUsers(f.ctx, f.t, f.userStore, owner, admin, member1, member2)
+	room := seedRooms(f, owner)[0]
+	require.Nil(f.t, f.chatStore.AddRoomMember(f.ctx, room.ID, admin.Username, Admin))
+	require.Nil(f.t, f.chatStore.AddRoomMember(f.ctx, room.ID, member1.Username, Member))
+	return room
+}
+
+func TestAuthorize(t *testing.T) {
+	f := NewChatFixture(t)
+	defer f.tearDown()
+	room := seedRoomWithRoles(f)
+
+	role, err := f.chatStore.Authorize(f.ctx, room.ID, member1.Username, PostMessages)
+	require.Nil(t, err)
+	assert.Equal(t, Member, role)
+
+	_, err = f.chatStore.Authorize(f.ctx, room.ID, member1.Username, InviteMembers)
+	assert.Equal(t, ErrDisAllowedOperation, err)
+
+	_, err = f.chatStore.Authorize(f.ctx, room.ID, admin.Username, InviteMembers)
+	assert.Nil(t, err)
+
+	_, err = f.chatStore.Authorize(f.ctx, room.ID, member2.Username, PostMessages)
+	assert.Equal(t, ErrInvalidRoom, err)
+}
+
+func TestAuthorizeMember(t *testing.T) {
+	f := NewChatFixture(t)
+	defer f.tearDown()
+	room := seedRoomWithRoles(f)
+
+	_, err := f.chatStore.AuthorizeMember(f.ctx, room.ID, admin.Username, member1.Username, RemoveMembers)
+	assert.Nil(t, err)
+
+	// admins cannot act on their peers or the owner
+	_, err = f.chatStore.AuthorizeMember(f.ctx, room.ID, admin.Username, owner.Username, RemoveMembers)
+	assert.Equal(t, ErrDisAllowedOperation, err)
+	_, err = f.chatStore.AuthorizeMember(f.ctx, room.ID, admin.Username, admin.Username, RemoveMembers)
+	assert.Equal(t, ErrDisAllowedOperation, err)
+
+	_, err = f.chatStore.AuthorizeMember(f.ctx, room.ID, owner.Username, member2.Username, RemoveMembers)
+	assert.Equal(t, ErrInvalidMember, err)
+}
+
+func TestAuthorizeRoleAssignment(t *testing.T) {
+	f := NewChatFixture(t)
+	defer f.tearDown()
+	room := seedRoomWithRoles(f)
+
+	assert.Nil(t, f.chatStore.AuthorizeRoleAssignment(f.ctx, room.ID, admin.Username, Member))
+	assert.Nil(t, f.chatStore.AuthorizeRoleAssignment(f.ctx, room.ID, owner.Username, Admin))
+	// an admin cannot make more admins
+	assert.Equal(t, ErrDisAllowedOperation, f.chatStore.AuthorizeRoleAssignment(f.ctx, room.ID, admin.Username, Admin))
+	assert.Equal(t, ErrDisAllowedOperation, f.chatStore.AuthorizeRoleAssignment(f.ctx, room.ID, owner.Username, Owner))
+	assert.Equal(t, ErrInvalidPermission, f.chatStore.AuthorizeRoleAssignment(f.ctx, room.ID, owner.Username, "king"))
+}
+
+func TestSetRoomPermission(t *testing.T) {
+	f := NewChatFixture(t)
+	defer f.tearDown()
+	room := seedRoomWithRoles(f)
+
+	require.Nil(t, f.chatStore.SetRoomPermission(f.ctx, room.ID, Member, InviteMembers, true))
+	require.Nil(t, f.chatStore.SetRoomPermission(f.ctx, room.ID, Member, PostMessages, false))
+
+	_, err := f.chatStore.Authorize(f.ctx, room.ID, member1.Username, InviteMembers)
+	assert.Nil(t, err)
+
+	// a read-only room
+	_, err = f.chatStore.SendMessageToRoom(f.ctx, MessageCreateInput{
+		Type: TextMessage, Data: "hello", Sender: member1.Username, RoomID: room.ID})
+	assert.Equal(t, ErrDisAllowedOperation, err)
+
+	permissions, err := f.chatStore.GetRoomPermissions(f.ctx, room.ID)
+	require.Nil(t, err)
+	assert.Equal(t, []Permission{InviteMembers}, permissions[Member])
+	assert.Equal(t, Permissions, permissions[Owner])
+	assert.Equal(t, DefaultRolePermissions[Admin], permissions[Admin])
+
+	// overrides are per room
+	other := seedRooms(f, owner, "Other")[0]
+	permissions, err = f.chatStore.GetRoomPermissions(f.ctx, other.ID)
+	require.Nil(t, err)
+	assert.Equal(t, []Permission{PostMessages}, permissions[Member])
+
+	assert.Equal(t, ErrDisAllowedOperation, f.chatStore.SetRoomPermission(f.ctx, room.ID, Owner, PostMessages, false))
+	assert.Equal(t, ErrInvalidPermission, f.chatStore.SetRoomPermission(f.ctx, room.ID, Member, "fly", true))
+}
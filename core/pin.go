@@ -0,0 +1,37 @@
+package core
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrInvalidBookmark is returned when a bookmark does not exist or its note is invalid.
+	ErrInvalidBookmark = errors.New("invalid bookmark")
+)
+
+// Pin is a message pinned to the top of its room.
+// The message is read when the pin is, so edits to it show up in the pin.
+type Pin struct {
+	RoomID  string  `json:"room_id"`
+	Message Message `json:"message"`
+	// PinnedBy is the member who pinned the message.
+	PinnedBy string    `json:"pinned_by"`
+	PinnedAt time.Time `json:"pinned_at"`
+}
+
+// Bookmark is a message a user saved for later with a private note.
+type Bookmark struct {
+	Message   Message   `json:"message"`
+	Note      string    `json:"note"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BookmarkInput represents the input for bookmarking a message.
+type BookmarkInput struct {
+	Note string `json:"note" validate:"max=1000"`
+}
+
+func (b *BookmarkInput) Validate() error {
+	return validate.Struct(b)
+}
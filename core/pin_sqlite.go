@@ -0,0 +1,207 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const joinedMessageColumns = "m.id, m.type, m.data, m.room_id, m.sender, m.sent_at"
+
+func (s *SQLChatStore) PinMessage(ctx context.Context, roomID string, messageID int, actor string) (*Pin, error) {
+	if _, err := s.Authorize(ctx, roomID, actor, PinMessages); err != nil {
+		return nil, err
+	}
+
+	row := s.db.QueryRowContext(ctx, "SELECT count(*) FROM messages WHERE id = @id AND room_id = @room_id",
+		sql.Named("id", messageID), sql.Named("room_id", roomID))
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return nil, fmt.Errorf("row.Scan: %w", err)
+	}
+	if count == 0 {
+		return nil, ErrInvalidMessage
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+	INSERT INTO room_pins (room_id, message_id, pinned_by, pinned_at)
+	VALUES (@room_id, @message_id, @pinned_by, @pinned_at)
+	ON CONFLICT (room_id, message_id) DO NOTHING`,
+		sql.Named("room_id", roomID), sql.Named("message_id", messageID),
+		sql.Named("pinned_by", actor), sql.Named("pinned_at", time.Now().UTC()))
+	if err != nil {
+		return nil, fmt.Errorf("ExecContext: %w", err)
+	}
+
+	row = s.db.QueryRowContext(ctx, `
+	SELECT p.room_id, p.pinned_by, p.pinned_at, `+joinedMessageColumns+`
+	FROM room_pins AS p INNER JOIN messages AS m ON p.message_id = m.id
+	WHERE p.room_id = @room_id AND p.message_id = @message_id`,
+		sql.Named("room_id", roomID), sql.Named("message_id", messageID))
+	pin, err := scanPin(row)
+	if err != nil {
+		return nil, fmt.Errorf("row.Scan: %w", err)
+	}
+	return pin, nil
+}
+
+func scanPin(row scanner) (*Pin, error) {
+	var pin Pin
+	m := &pin.Message
+	if err := row.Scan(&pin.RoomID, &pin.PinnedBy, &pin.PinnedAt,
+		&m.ID, &m.Type, &m.Data, &m.RoomID, &m.Sender, &m.SentAt); err != nil {
+		return nil, err
+	}
+	return &pin, nil
+}
+
+func (s *SQLChatStore) UnpinMessage(ctx context.Context, roomID string, messageID int, actor string) error {
+	if _, err := s.Authorize(ctx, roomID, actor, PinMessages); err != nil {
+		return err
+	}
+
+	result, err := s.db.ExecContext(ctx,
+		"DELETE FROM room_pins WHERE room_id = @room_id AND message_id = @message_id",
+		sql.Named("room_id", roomID), sql.Named("message_id", messageID))
+	if err != nil {
+		return fmt.Errorf("ExecContext: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("RowsAffected: %w", err)
+	}
+	if affected == 0 {
+		return ErrInvalidMessage
+	}
+	return nil
+}
+
+func (s *SQLChatStore) GetRoomPins(ctx context.Context, roomID string) ([]Pin, error) {
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT p.room_id, p.pinned_by, p.pinned_at, `+joinedMessageColumns+`
+	FROM room_pins AS p INNER JOIN messages AS m ON p.message_id = m.id
+	WHERE p.room_id = @room_id
+	ORDER BY p.pinned_at DESC, m.id DESC`,
+		sql.Named("room_id", roomID))
+	if err != nil {
+		return nil, fmt.Errorf("QueryContext: %w", err)
+	}
+	defer rows.Close()
+
+	var pins []Pin
+	for rows.Next() {
+		pin, err := scanPin(rows)
+		if err != nil {
+			return nil, fmt.Errorf("rows.Scan: %w", err)
+		}
+		pins = append(pins, *pin)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err: %w", err)
+	}
+	return pins, nil
+}
+
+func scanBookmark(row scanner) (*Bookmark, error) {
+	var bookmark Bookmark
+	m := &bookmark.Message
+	if err := row.Scan(&bookmark.Note, &bookmark.CreatedAt,
+		&m.ID, &m.Type, &m.Data, &m.RoomID, &m.Sender, &m.SentAt); err != nil {
+		return nil, err
+	}
+	return &bookmark, nil
+}
+
+func (s *SQLChatStore) BookmarkMessage(ctx context.Context, username string, messageID int, input BookmarkInput) (*Bookmark, error) {
+	if err := input.Validate(); err != nil {
+		return nil, ErrInvalidBookmark
+	}
+
+	// only members can read the messages of a room
+	row := s.db.QueryRowContext(ctx, `
+	SELECT count(*) FROM messages AS m
+	INNER JOIN room_members AS rm ON rm.room_id = m.room_id AND rm.username = @username
+	WHERE m.id = @message_id`,
+		sql.Named("username", username), sql.Named("message_id", messageID))
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return nil, fmt.Errorf("row.Scan: %w", err)
+	}
+	if count == 0 {
+		return nil, ErrInvalidMessage
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+	INSERT INTO bookmarks (username, message_id, note, created_at)
+	VALUES (@username, @message_id, @note, @created_at)
+	ON CONFLICT (username, message_id) DO UPDATE SET note = excluded.note`,
+		sql.Named("username", username), sql.Named("message_id", messageID),
+		sql.Named("note", input.Note), sql.Named("created_at", time.Now().UTC()))
+	if err != nil {
+		return nil, fmt.Errorf("ExecContext: %w", err)
+	}
+
+	row = s.db.QueryRowContext(ctx, `
+	SELECT b.note, b.created_at, `+joinedMessageColumns+`
+	FROM bookmarks AS b INNER JOIN messages AS m ON b.message_id = m.id
+	WHERE b.username = @username AND b.message_id = @message_id`,
+		sql.Named("username", username), sql.Named("message_id", messageID))
+	bookmark, err := scanBookmark(row)
+	if err != nil {
+		return nil, fmt.Errorf("row.Scan: %w", err)
+	}
+	return bookmark, nil
+}
+
+func (s *SQLChatStore) RemoveBookmark(ctx context.Context, username string, messageID int) error {
+	result, err := s.db.ExecContext(ctx,
+		"DELETE FROM bookmarks WHERE username = @username AND message_id = @message_id",
+		sql.Named("username", username), sql.Named("message_id", messageID))
+	if err != nil {
+		return fmt.Errorf("ExecContext: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("RowsAffected: %w", err)
+	}
+	if affected == 0 {
+		return ErrInvalidBookmark
+	}
+	return nil
+}
+
+func (s *SQLChatStore) GetBookmarks(ctx context.Context, username string, offset, limit int) ([]Bookmark, error) {
+	if limit == 0 {
+		limit = 50
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT b.note, b.created_at, `+joinedMessageColumns+`
+	FROM bookmarks AS b INNER JOIN messages AS m ON b.message_id = m.id
+	INNER JOIN room_members AS rm ON rm.room_id = m.room_id AND rm.username = b.username
+	WHERE b.username = @username
+	ORDER BY b.created_at DESC, m.id DESC
+	LIMIT @limit OFFSET @offset`,
+		sql.Named("username", username), sql.Named("limit", limit), sql.Named("offset", offset))
+	if err != nil {
+		return nil, fmt.Errorf("QueryContext: %w", err)
+	}
+	defer rows.Close()
+
+	var bookmarks []Bookmark
+	for rows.Next() {
+		bookmark, err := scanBookmark(rows)
+		if err != nil {
+			return nil, fmt.Errorf("rows.Scan: %w", err)
+		}
+		bookmarks = append(bookmarks, *bookmark)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err: %w", err)
+	}
+	return bookmarks, nil
+}
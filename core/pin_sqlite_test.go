@@ -0,0 +1,180 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func seedMessage(f *ChatFixture, roomID, sender, data string) Message {
+	message, err := f.chatStore.SendMessageToRoom(f.ctx, MessageCreateInput{
+		Type: TextMessage, Data: data, Sender: sender, RoomID: roomID})
+	require.Nil(f.t, err)
+	return *message
+}
+
+func TestPinMessage(t *testing.T) {
+	t.Run("pin and unpin", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+		first := seedMessage(f, room.ID, member1.Username, "first")
+		second := seedMessage(f, room.ID, member1.Username, "second")
+
+		pin, err := f.chatStore.PinMessage(f.ctx, room.ID, first.ID, admin.Username)
+		require.Nil(t, err)
+		assert.Equal(t, admin.Username, pin.PinnedBy)
+		assert.Equal(t, first.ID, pin.Message.ID)
+		assert.Equal(t, "first", pin.Message.Data)
+		// pinning again keeps the pin
+		again, err := f.chatStore.PinMessage(f.ctx, room.ID, first.ID, owner.Username)
+		require.Nil(t, err)
+		assert.Equal(t, admin.Username, again.PinnedBy)
+		_, err = f.chatStore.PinMessage(f.ctx, room.ID, second.ID, owner.Username)
+		require.Nil(t, err)
+
+		pins, err := f.chatStore.GetRoomPins(f.ctx, room.ID)
+		require.Nil(t, err)
+		require.Len(t, pins, 2)
+		assert.Equal(t, second.ID, pins[0].Message.ID)
+		assert.Equal(t, first.ID, pins[1].Message.ID)
+
+		require.Nil(t, f.chatStore.UnpinMessage(f.ctx, room.ID, first.ID, admin.Username))
+		assert.Equal(t, ErrInvalidMessage, f.chatStore.UnpinMessage(f.ctx, room.ID, first.ID, admin.Username))
+		pins, err = f.chatStore.GetRoomPins(f.ctx, room.ID)
+		require.Nil(t, err)
+		require.Len(t, pins, 1)
+		assert.Equal(t, second.ID, pins[0].Message.ID)
+	})
+
+	t.Run("not allowed", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+		other := seedRooms(f, owner, "other")[0]
+		message := seedMessage(f, room.ID, member1.Username, "hello")
+		elsewhere := seedMessage(f, other.ID, owner.Username, "elsewhere")
+
+		_, err := f.chatStore.PinMessage(f.ctx, room.ID, message.ID, member1.Username)
+		assert.Equal(t, ErrDisAllowedOperation, err)
+		_, err = f.chatStore.PinMessage(f.ctx, room.ID, message.ID, member2.Username)
+		assert.Equal(t, ErrInvalidRoom, err)
+		_, err = f.chatStore.PinMessage(f.ctx, room.ID, elsewhere.ID, owner.Username)
+		assert.Equal(t, ErrInvalidMessage, err)
+		_, err = f.chatStore.PinMessage(f.ctx, room.ID, 12345, owner.Username)
+		assert.Equal(t, ErrInvalidMessage, err)
+	})
+}
+
+func TestBookmarkMessage(t *testing.T) {
+	t.Run("bookmark across rooms", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+		other := seedRooms(f, member1, "other")[0]
+		first := seedMessage(f, room.ID, owner.Username, "first")
+		second := seedMessage(f, other.ID, member1.Username, "second")
+
+		bookmark, err := f.chatStore.BookmarkMessage(f.ctx, member1.Username, first.ID, BookmarkInput{Note: "read later"})
+		require.Nil(t, err)
+		assert.Equal(t, "read later", bookmark.Note)
+		assert.Equal(t, first.ID, bookmark.Message.ID)
+		_, err = f.chatStore.BookmarkMessage(f.ctx, member1.Username, second.ID, BookmarkInput{})
+		require.Nil(t, err)
+		// bookmarking again updates the note
+		bookmark, err = f.chatStore.BookmarkMessage(f.ctx, member1.Username, first.ID, BookmarkInput{Note: "done"})
+		require.Nil(t, err)
+		assert.Equal(t, "done", bookmark.Note)
+
+		bookmarks, err := f.chatStore.GetBookmarks(f.ctx, member1.Username, 0, 0)
+		require.Nil(t, err)
+		require.Len(t, bookmarks, 2)
+		ids := []int{bookmarks[0].Message.ID, bookmarks[1].Message.ID}
+		assert.ElementsMatch(t, []int{first.ID, second.ID}, ids)
+
+		// bookmarks are private
+		bookmarks, err = f.chatStore.GetBookmarks(f.ctx, owner.Username, 0, 0)
+		require.Nil(t, err)
+		assert.Empty(t, bookmarks)
+
+		require.Nil(t, f.chatStore.RemoveBookmark(f.ctx, member1.Username, second.ID))
+		assert.Equal(t, ErrInvalidBookmark, f.chatStore.RemoveBookmark(f.ctx, member1.Username, second.ID))
+		bookmarks, err = f.chatStore.GetBookmarks(f.ctx, member1.Username, 0, 0)
+		require.Nil(t, err)
+		require.Len(t, bookmarks, 1)
+		assert.Equal(t, first.ID, bookmarks[0].Message.ID)
+
+		// the messages of a room are not readable after leaving it
+		require.Nil(t, f.chatStore.RemoveRoomMember(f.ctx, room.ID, member1.Username))
+		bookmarks, err = f.chatStore.GetBookmarks(f.ctx, member1.Username, 0, 0)
+		require.Nil(t, err)
+		assert.Empty(t, bookmarks)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+		message := seedMessage(f, room.ID, owner.Username, "hello")
+
+		_, err := f.chatStore.BookmarkMessage(f.ctx, member2.Username, message.ID, BookmarkInput{})
+		assert.Equal(t, ErrInvalidMessage, err)
+		_, err = f.chatStore.BookmarkMessage(f.ctx, member1.Username, 12345, BookmarkInput{})
+		assert.Equal(t, ErrInvalidMessage, err)
+		note := make([]byte, 1001)
+		for i := range note {
+			note[i] = 'a'
+		}
+		_, err = f.chatStore.BookmarkMessage(f.ctx, member1.Username, message.ID, BookmarkInput{Note: string(note)})
+		assert.Equal(t, ErrInvalidBookmark, err)
+	})
+
+	t.Run("deleted messages", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+		other := seedRooms(f, owner, "other")[0]
+		require.Nil(t, f.chatStore.AddRoomMember(f.ctx, other.ID, member1.Username, Member))
+		message := seedMessage(f, room.ID, owner.Username, "hello")
+		kept := seedMessage(f, other.ID, owner.Username, "kept")
+
+		_, err := f.chatStore.PinMessage(f.ctx, room.ID, message.ID, owner.Username)
+		require.Nil(t, err)
+		_, err = f.chatStore.BookmarkMessage(f.ctx, member1.Username, message.ID, BookmarkInput{})
+		require.Nil(t, err)
+		_, err = f.chatStore.BookmarkMessage(f.ctx, member1.Username, kept.ID, BookmarkInput{})
+		require.Nil(t, err)
+
+		require.Nil(t, f.chatStore.DeleteRoom(f.ctx, room.ID, owner.Username))
+
+		bookmarks, err := f.chatStore.GetBookmarks(f.ctx, member1.Username, 0, 0)
+		require.Nil(t, err)
+		require.Len(t, bookmarks, 1)
+		assert.Equal(t, kept.ID, bookmarks[0].Message.ID)
+		var count int
+		require.Nil(t, f.db.QueryRowContext(f.ctx, "SELECT count(*) FROM room_pins WHERE room_id = ?", room.ID).Scan(&count))
+		assert.Zero(t, count)
+	})
+
+	t.Run("deleted message", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+		message := seedMessage(f, room.ID, member1.Username, "hello")
+
+		_, err := f.chatStore.PinMessage(f.ctx, room.ID, message.ID, owner.Username)
+		require.Nil(t, err)
+		_, err = f.chatStore.BookmarkMessage(f.ctx, owner.Username, message.ID, BookmarkInput{})
+		require.Nil(t, err)
+
+		require.Nil(t, f.chatStore.DeleteMessage(f.ctx, room.ID, message.ID, member1.Username))
+
+		pins, err := f.chatStore.GetRoomPins(f.ctx, room.ID)
+		require.Nil(t, err)
+		assert.Empty(t, pins)
+		bookmarks, err := f.chatStore.GetBookmarks(f.ctx, owner.Username, 0, 0)
+		require.Nil(t, err)
+		assert.Empty(t, bookmarks)
+	})
+}
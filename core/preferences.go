@@ -0,0 +1,93 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidPreferences is returned when the room preferences of a member are invalid.
+	ErrInvalidPreferences = errors.New("invalid room preferences")
+)
+
+// NotificationLevel controls which messages of a room a member is notified about.
+type NotificationLevel string
+
+const (
+	// NotifyAll notifies the member of every message.
+	NotifyAll NotificationLevel = "all"
+	// NotifyMentions notifies the member only of messages that mention them.
+	NotifyMentions NotificationLevel = "mentions"
+	// NotifyNone never notifies the member.
+	NotifyNone NotificationLevel = "none"
+)
+
+// RoomPreferences are the settings a member has for a room. They are private to the member.
+type RoomPreferences struct {
+	// MutedUntil is nil if the room is not muted.
+	MutedUntil *time.Time `json:"muted_until"`
+	// Pinned rooms are listed before the other rooms.
+	Pinned   bool `json:"pinned"`
+	Favorite bool `json:"favorite"`
+	// SortPosition orders rooms within the pinned and unpinned groups, lowest first.
+	// Zero means no custom position; those rooms are listed after the positioned ones.
+	SortPosition      int               `json:"sort_position"`
+	NotificationLevel NotificationLevel `json:"notification_level"`
+}
+
+// Muted reports whether the room is muted at the time.
+func (p RoomPreferences) Muted(now time.Time) bool {
+	return p.MutedUntil != nil && now.Before(*p.MutedUntil)
+}
+
+// ShouldNotify reports whether the member should be notified of the message at the time.
+// Members are never notified of their own messages.
+func (p RoomPreferences) ShouldNotify(username string, message Message, now time.Time) bool {
+	if message.Sender == username || p.Muted(now) {
+		return false
+	}
+	switch p.NotificationLevel {
+	case NotifyNone:
+		return false
+	case NotifyMentions:
+		return message.Type == TextMessage && Mentions(message.Data, username)
+	}
+	return true
+}
+
+// Mentions reports whether the text mentions the user as @username.
+func Mentions(text, username string) bool {
+	mention := "@" + username
+	for {
+		i := strings.Index(text, mention)
+		if i < 0 {
+			return false
+		}
+		// @username must not be the prefix of a longer username
+		end := i + len(mention)
+		if end == len(text) || !isUsernameChar(text[end]) {
+			return true
+		}
+		text = text[end:]
+	}
+}
+
+func isUsernameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.'
+}
+
+// UpdateRoomPreferencesInput represents the input for updating the room preferences of a member.
+// Only the fields that are not nil are updated.
+type UpdateRoomPreferencesInput struct {
+	// MutedUntil mutes the room until the time. A time that is not in the future unmutes the room.
+	MutedUntil        *time.Time         `json:"muted_until"`
+	Pinned            *bool              `json:"pinned"`
+	Favorite          *bool              `json:"favorite"`
+	SortPosition      *int               `json:"sort_position" validate:"omitnil,min=0"`
+	NotificationLevel *NotificationLevel `json:"notification_level" validate:"omitnil,oneof=all mentions none"`
+}
+
+func (u *UpdateRoomPreferencesInput) Validate() error {
+	return validate.Struct(u)
+}
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const preferenceColumns = "muted_until, pinned, favorite, sort_position, notification_level"
+
+// compareRoomPreferences orders pinned rooms first, then rooms with a sort position, lowest first.
+func compareRoomPreferences(a, b RoomPreferences) int {
+	if a.Pinned != b.Pinned {
+		if a.Pinned {
+			return -1
+		}
+		return 1
+	}
+	if (a.SortPosition == 0) != (b.SortPosition == 0) {
+		if a.SortPosition == 0 {
+			return 1
+		}
+		return -1
+	}
+	return a.SortPosition - b.SortPosition
+}
+
+func scanPreferences(row scanner, preferences *RoomPreferences, dest ...any) error {
+	var mutedUntil sql.NullTime
+	dest = append(dest, &mutedUntil, &preferences.Pinned, &preferences.Favorite,
+		&preferences.SortPosition, &preferences.NotificationLevel)
+	if err := row.Scan(dest...); err != nil {
+		return err
+	}
+	if mutedUntil.Valid {
+		preferences.MutedUntil = &mutedUntil.Time
+	}
+	return nil
+}
+
+func (s *SQLChatStore) GetRoomPreferences(ctx context.Context, roomID, username string) (*RoomPreferences, error) {
+	row := s.db.QueryRowContext(ctx,
+		"SELECT "+preferenceColumns+" FROM room_members WHERE room_id = @room_id AND username = @username",
+		sql.Named("room_id", roomID), sql.Named("username", username))
+
+	var preferences RoomPreferences
+	if err := scanPreferences(row, &preferences); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidRoom
+		}
+		return nil, fmt.Errorf("row.Scan: %w", err)
+	}
+	return &preferences, nil
+}
+
+func (s *SQLChatStore) GetRoomMemberPreferences(ctx context.Context, roomID string) (map[string]RoomPreferences, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT username, "+preferenceColumns+" FROM room_members WHERE room_id = @room_id",
+		sql.Named("room_id", roomID))
+	if err != nil {
+		return nil, fmt.Errorf("QueryContext: %w", err)
+	}
+	defer rows.Close()
+
+	preferences := make(map[string]RoomPreferences)
+	for rows.Next() {
+		var username string
+		var p RoomPreferences
+		if err := scanPreferences(rows, &p, &username); err != nil {
+			return nil, fmt.Errorf("rows.Scan: %w", err)
+		}
+		preferences[username] = p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err: %w", err)
+	}
+	return preferences, nil
+}
+
+func (s *SQLChatStore) UpdateRoomPreferences(ctx context.Context, roomID, username string, input UpdateRoomPreferencesInput) (*RoomPreferences, error) {
+	if err := input.Validate(); err != nil {
+		return nil, ErrInvalidPreferences
+	}
+
+	preferences, err := s.GetRoomPreferences(ctx, roomID, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.MutedUntil != nil {
+		preferences.MutedUntil = nil
+		if input.MutedUntil.After(time.Now()) {
+			mutedUntil := input.MutedUntil.UTC()
+			preferences.MutedUntil = &mutedUntil
+		}
+	}
+	if input.Pinned != nil {
+		preferences.Pinned = *input.Pinned
+	}
+	if input.Favorite != nil {
+		preferences.Favorite = *input.Favorite
+	}
+	if input.SortPosition != nil {
+		preferences.SortPosition = *input.SortPosition
+	}
+	if input.NotificationLevel != nil {
+		preferences.NotificationLevel = *input.NotificationLevel
+	}
+
+	query := `
+	UPDATE room_members SET muted_until = @muted_until, pinned = @pinned, favorite = @favorite,
+	sort_position = @sort_position, notification_level = @notification_level
+	WHERE room_id = @room_id AND username = @username`
+	_, err = s.db.ExecContext(ctx, query,
+		sql.Named("muted_until", preferences.MutedUntil), sql.Named("pinned", preferences.Pinned),
+		sql.Named("favorite", preferences.Favorite), sql.Named("sort_position", preferences.SortPosition),
+		sql.Named("notification_level", preferences.NotificationLevel),
+		sql.Named("room_id", roomID), sql.Named("username", username))
+	if err != nil {
+		return nil, fmt.Errorf("ExecContext: %w", err)
+	}
+	return preferences, nil
+}
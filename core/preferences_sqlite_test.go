@@ -0,0 +1,137 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateRoomPreferences(t *testing.T) {
+	t.Run("update preferences", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+
+		preferences, err := f.chatStore.GetRoomPreferences(f.ctx, room.ID, member1.Username)
+		require.Nil(t, err)
+		assert.Equal(t, RoomPreferences{NotificationLevel: NotifyAll}, *preferences)
+
+		mutedUntil := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+		pinned, level := true, NotifyMentions
+		preferences, err = f.chatStore.UpdateRoomPreferences(f.ctx, room.ID, member1.Username,
+			UpdateRoomPreferencesInput{MutedUntil: &mutedUntil, Pinned: &pinned, NotificationLevel: &level})
+		require.Nil(t, err)
+		assert.True(t, preferences.Pinned)
+		assert.Equal(t, NotifyMentions, preferences.NotificationLevel)
+
+		preferences, err = f.chatStore.GetRoomPreferences(f.ctx, room.ID, member1.Username)
+		require.Nil(t, err)
+		require.NotNil(t, preferences.MutedUntil)
+		assert.True(t, mutedUntil.Equal(*preferences.MutedUntil))
+		assert.True(t, preferences.Pinned)
+		assert.False(t, preferences.Favorite)
+		assert.Equal(t, NotifyMentions, preferences.NotificationLevel)
+
+		// a time in the past unmutes the room
+		past := time.Now().Add(-time.Hour)
+		preferences, err = f.chatStore.UpdateRoomPreferences(f.ctx, room.ID, member1.Username,
+			UpdateRoomPreferencesInput{MutedUntil: &past})
+		require.Nil(t, err)
+		assert.Nil(t, preferences.MutedUntil)
+		assert.True(t, preferences.Pinned)
+
+		// preferences are per member
+		preferences, err = f.chatStore.GetRoomPreferences(f.ctx, room.ID, admin.Username)
+		require.Nil(t, err)
+		assert.False(t, preferences.Pinned)
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+
+		level := NotificationLevel("random")
+		_, err := f.chatStore.UpdateRoomPreferences(f.ctx, room.ID, member1.Username,
+			UpdateRoomPreferencesInput{NotificationLevel: &level})
+		assert.Equal(t, ErrInvalidPreferences, err)
+
+		position := -1
+		_, err = f.chatStore.UpdateRoomPreferences(f.ctx, room.ID, member1.Username,
+			UpdateRoomPreferencesInput{SortPosition: &position})
+		assert.Equal(t, ErrInvalidPreferences, err)
+	})
+
+	t.Run("not a member", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+
+		_, err := f.chatStore.GetRoomPreferences(f.ctx, room.ID, member2.Username)
+		assert.Equal(t, ErrInvalidRoom, err)
+		pinned := true
+		_, err = f.chatStore.UpdateRoomPreferences(f.ctx, room.ID, member2.Username,
+			UpdateRoomPreferencesInput{Pinned: &pinned})
+		assert.Equal(t, ErrInvalidRoom, err)
+	})
+}
+
+func TestGetUserRoomsPreferenceOrder(t *testing.T) {
+	f := NewChatFixture(t)
+	defer f.tearDown()
+	seedUsers(f.ctx, f.t, f.userStore, owner)
+	// the most recently created room is listed first by default
+	rooms := seedRooms(f, owner, "a", "b", "c", "d")
+
+	pinned, first, second := true, 1, 2
+	_, err := f.chatStore.UpdateRoomPreferences(f.ctx, rooms[0].ID, owner.Username,
+		UpdateRoomPreferencesInput{Pinned: &pinned})
+	require.Nil(t, err)
+	_, err = f.chatStore.UpdateRoomPreferences(f.ctx, rooms[2].ID, owner.Username,
+		UpdateRoomPreferencesInput{SortPosition: &second})
+	require.Nil(t, err)
+	_, err = f.chatStore.UpdateRoomPreferences(f.ctx, rooms[1].ID, owner.Username,
+		UpdateRoomPreferencesInput{SortPosition: &first})
+	require.Nil(t, err)
+
+	listed, err := f.chatStore.GetUserRooms(f.ctx, owner.Username, 0, 0)
+	require.Nil(t, err)
+	ids := make([]string, 0, len(listed))
+	for _, room := range listed {
+		ids = append(ids, room.ID)
+	}
+	assert.Equal(t, []string{rooms[0].ID, rooms[1].ID, rooms[2].ID, rooms[3].ID}, ids)
+	require.NotNil(t, listed[0].Preferences)
+	assert.True(t, listed[0].Preferences.Pinned)
+
+	listed, err = f.chatStore.GetUserRooms(f.ctx, owner.Username, 1, 1)
+	require.Nil(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, rooms[1].ID, listed[0].ID)
+}
+
+func TestShouldNotify(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+	message := Message{Type: TextMessage, Sender: owner.Username, Data: "hi @member1, and @member10"}
+
+	assert.True(t, RoomPreferences{NotificationLevel: NotifyAll}.ShouldNotify(member2.Username, message, now))
+	assert.False(t, RoomPreferences{NotificationLevel: NotifyAll}.ShouldNotify(owner.Username, message, now))
+	assert.False(t, RoomPreferences{NotificationLevel: NotifyNone}.ShouldNotify(member1.Username, message, now))
+	assert.False(t, RoomPreferences{NotificationLevel: NotifyAll, MutedUntil: &later}.
+		ShouldNotify(member1.Username, message, now))
+	assert.True(t, RoomPreferences{NotificationLevel: NotifyAll, MutedUntil: &now}.
+		ShouldNotify(member1.Username, message, later))
+	assert.True(t, RoomPreferences{NotificationLevel: NotifyMentions}.ShouldNotify(member1.Username, message, now))
+	assert.False(t, RoomPreferences{NotificationLevel: NotifyMentions}.ShouldNotify(member2.Username, message, now))
+}
+
+func TestMentions(t *testing.T) {
+	assert.True(t, Mentions("@john", "john"))
+	assert.True(t, Mentions("hey @john!", "john"))
+	assert.True(t, Mentions("@johnny and @john", "john"))
+	assert.False(t, Mentions("@johnny", "john"))
+	assert.False(t, Mentions("john", "john"))
+}
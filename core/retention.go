@@ -0,0 +1,130 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ErrInvalidRetention is returned when a retention policy is invalid.
+var ErrInvalidRetention = errors.New("invalid retention policy")
+
+// RetentionPolicy controls how long the messages of a room are kept.
+// A message expires once it is older than Days or is not among the newest Messages of its room.
+// A zero field does not expire messages, so the zero policy keeps everything.
+type RetentionPolicy struct {
+	Days     int `json:"days" validate:"min=0"`
+	Messages int `json:"messages" validate:"min=0"`
+}
+
+func (p *RetentionPolicy) Validate() error {
+	return validate.Struct(p)
+}
+
+// IsZero reports whether the policy keeps every message.
+func (p RetentionPolicy) IsZero() bool {
+	return p.Days == 0 && p.Messages == 0
+}
+
+// RoomRetention is how the retention of a room is configured.
+type RoomRetention struct {
+	RoomID string `json:"room_id"`
+	// Policy overrides the global policy for the room, or is nil if the room uses the global policy.
+	Policy *RetentionPolicy `json:"policy"`
+	// LegalHold exempts the room from purging regardless of its policy.
+	LegalHold bool `json:"legal_hold"`
+}
+
+// Purge is a record of expired messages deleted from a room.
+type Purge struct {
+	ID     int    `json:"id"`
+	RoomID string `json:"room_id"`
+	// Messages is how many messages were deleted.
+	Messages int `json:"messages"`
+	// ThroughID is the newest message that was deleted.
+	ThroughID int       `json:"through_id"`
+	PurgedAt  time.Time `json:"purged_at"`
+}
+
+// RetentionWorker periodically purges expired messages in batches.
+type RetentionWorker struct {
+	store     ChatStore
+	policy    RetentionPolicy
+	interval  time.Duration
+	batchSize int
+	logger    *slog.Logger
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// NewRetentionWorker creates a worker that purges messages expired under policy every interval,
+// deleting at most batchSize messages of a room per transaction.
+func NewRetentionWorker(store ChatStore, policy RetentionPolicy, interval time.Duration, batchSize int, logger *slog.Logger) *RetentionWorker {
+	return &RetentionWorker{
+		store:     store,
+		policy:    policy,
+		interval:  interval,
+		batchSize: batchSize,
+		logger:    logger,
+	}
+}
+
+// Start runs the worker until ctx is done or Stop is called.
+func (w *RetentionWorker) Start(ctx context.Context) {
+	ctx, w.cancel = context.WithCancel(ctx)
+	w.done = make(chan struct{})
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			if _, err := w.Purge(ctx, time.Now()); err != nil && ctx.Err() == nil {
+				w.logger.Error(fmt.Sprintf("purge expired messages: %v", err))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops the worker and waits for the batch in progress to finish or ctx to be done.
+func (w *RetentionWorker) Stop(ctx context.Context) {
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+	}
+}
+
+// Purge deletes every message expired at now, a batch at a time, and returns the purges.
+func (w *RetentionWorker) Purge(ctx context.Context, now time.Time) ([]Purge, error) {
+	var all []Purge
+	for {
+		purges, err := w.store.PurgeExpiredMessages(ctx, w.policy, now, w.batchSize)
+		if err != nil {
+			return all, err
+		}
+		full := false
+		for _, purge := range purges {
+			w.logger.Info(fmt.Sprintf("purged %d messages through %d from room %s",
+				purge.Messages, purge.ThroughID, purge.RoomID))
+			full = full || purge.Messages >= w.batchSize
+		}
+		all = append(all, purges...)
+		// a room with fewer messages than a batch left has none left
+		if !full {
+			return all, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return all, err
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func (s *SQLChatStore) GetRoomRetention(ctx context.Context, roomID string) (*RoomRetention, error) {
+	row := s.db.QueryRowContext(ctx,
+		"SELECT retention_days, retention_messages, legal_hold FROM rooms WHERE id = @room_id",
+		sql.Named("room_id", roomID))
+	var days, messages sql.NullInt64
+	retention := RoomRetention{RoomID: roomID}
+	if err := row.Scan(&days, &messages, &retention.LegalHold); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidRoom
+		}
+		return nil, fmt.Errorf("row.Scan: %w", err)
+	}
+	if days.Valid {
+		retention.Policy = &RetentionPolicy{Days: int(days.Int64), Messages: int(messages.Int64)}
+	}
+	return &retention, nil
+}
+
+func (s *SQLChatStore) SetRoomRetention(ctx context.Context, roomID string, policy *RetentionPolicy) error {
+	var days, messages *int
+	if policy != nil {
+		if err := policy.Validate(); err != nil {
+			return ErrInvalidRetention
+		}
+		days, messages = &policy.Days, &policy.Messages
+	}
+	return s.updateRoomRetention(ctx, `
+	UPDATE rooms SET retention_days = @retention_days, retention_messages = @retention_messages
+	WHERE id = @room_id`,
+		sql.Named("retention_days", days), sql.Named("retention_messages", messages),
+		sql.Named("room_id", roomID))
+}
+
+func (s *SQLChatStore) SetLegalHold(ctx context.Context, roomID string, hold bool) error {
+	return s.updateRoomRetention(ctx, "UPDATE rooms SET legal_hold = @legal_hold WHERE id = @room_id",
+		sql.Named("legal_hold", hold), sql.Named("room_id", roomID))
+}
+
+func (s *SQLChatStore) updateRoomRetention(ctx context.Context, query string, args ...any) error {
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("ExecContext: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("RowsAffected: %w", err)
+	}
+	if affected == 0 {
+		return ErrInvalidRoom
+	}
+	return nil
+}
+
+func (s *SQLChatStore) PurgeExpiredMessages(ctx context.Context, global RetentionPolicy, now time.Time, limit int) ([]Purge, error) {
+	if limit == 0 {
+		limit = 500
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT id, retention_days, retention_messages FROM rooms WHERE legal_hold = FALSE")
+	if err != nil {
+		return nil, fmt.Errorf("QueryContext: %w", err)
+	}
+	policies := make(map[string]RetentionPolicy)
+	var roomIDs []string
+	for rows.Next() {
+		var roomID string
+		var days, messages sql.NullInt64
+		if err := rows.Scan(&roomID, &days, &messages); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("rows.Scan: %w", err)
+		}
+		policy := global
+		if days.Valid {
+			policy = RetentionPolicy{Days: int(days.Int64), Messages: int(messages.Int64)}
+		}
+		if !policy.IsZero() {
+			policies[roomID] = policy
+			roomIDs = append(roomIDs, roomID)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err: %w", err)
+	}
+
+	var purges []Purge
+	for _, roomID := range roomIDs {
+		purge, err := s.purgeRoom(ctx, roomID, policies[roomID], now, limit)
+		if err != nil {
+			return purges, fmt.Errorf("purge room %s: %w", roomID, err)
+		}
+		if purge != nil {
+			purges = append(purges, *purge)
+		}
+	}
+	return purges, nil
+}
+
+// expiredMessages matches the messages of a room that are older than @cutoff
+// or not newer than @threshold, up to @through.
+const expiredMessages = `room_id = @room_id AND id <= @through AND (sent_at < @cutoff OR id <= @threshold)`
+
+// purgeRoom deletes up to limit of the oldest expired messages of the room.
+// It returns nil if none have expired.
+func (s *SQLChatStore) purgeRoom(ctx context.Context, roomID string, policy RetentionPolicy, now time.Time, limit int) (*Purge, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	// messages up to the threshold are beyond the newest policy.Messages
+	var threshold int
+	if policy.Messages > 0 {
+		row := tx.QueryRowContext(ctx,
+			"SELECT id FROM messages WHERE room_id = @room_id ORDER BY id DESC LIMIT 1 OFFSET @offset",
+			sql.Named("room_id", roomID), sql.Named("offset", policy.Messages))
+		if err := row.Scan(&threshold); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("row.Scan(threshold): %w", err)
+		}
+	}
+	var cutoff time.Time
+	if policy.Days > 0 {
+		cutoff = now.UTC().AddDate(0, 0, -policy.Days)
+	}
+
+	row := tx.QueryRowContext(ctx, `
+	SELECT COUNT(*), COALESCE(MAX(id), 0) FROM (
+		SELECT id FROM messages WHERE room_id = @room_id AND (sent_at < @cutoff OR id <= @threshold)
+		ORDER BY id LIMIT @limit
+	) AS batch`,
+		sql.Named("room_id", roomID), sql.Named("cutoff", cutoff),
+		sql.Named("threshold", threshold), sql.Named("limit", limit))
+	purge := Purge{RoomID: roomID, PurgedAt: now.UTC()}
+	if err := row.Scan(&purge.Messages, &purge.ThroughID); err != nil {
+		return nil, fmt.Errorf("row.Scan(batch): %w", err)
+	}
+	if purge.Messages == 0 {
+		return nil, nil
+	}
+
+	queries := []string{
+		"DELETE FROM message_interactions WHERE message_id IN (SELECT id FROM messages WHERE " + expiredMessages + ")",
+		"DELETE FROM room_pins WHERE message_id IN (SELECT id FROM messages WHERE " + expiredMessages + ")",
+		"DELETE FROM bookmarks WHERE message_id IN (SELECT id FROM messages WHERE " + expiredMessages + ")",
+		"DELETE FROM messages WHERE " + expiredMessages,
+	}
+	for _, query := range queries {
+		_, err := tx.ExecContext(ctx, query,
+			sql.Named("room_id", roomID), sql.Named("through", purge.ThroughID),
+			sql.Named("cutoff", cutoff), sql.Named("threshold", threshold))
+		if err != nil {
+			return nil, fmt.Errorf("ExecContext(delete messages): %w", err)
+		}
+	}
+
+	var last Message
+	row = tx.QueryRowContext(ctx,
+		"SELECT id, type, data, sent_at FROM messages WHERE room_id = @room_id ORDER BY id DESC LIMIT 1",
+		sql.Named("room_id", roomID))
+	err = row.Scan(&last.ID, &last.Type, &last.Data, &last.SentAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// the room keeps its place in room lists when all of its messages are gone
+		_, err = tx.ExecContext(ctx, `
+		UPDATE rooms SET last_message_sent = 0, last_message_sent_data = ''
+		WHERE id = @room_id AND last_message_sent <= @through`,
+			sql.Named("room_id", roomID), sql.Named("through", purge.ThroughID))
+	case err != nil:
+		return nil, fmt.Errorf("row.Scan(last message): %w", err)
+	default:
+		_, err = tx.ExecContext(ctx, `
+		UPDATE rooms SET
+		last_message_sent = @last_message_sent,
+		last_message_sent_at = @last_message_sent_at,
+		last_message_sent_data = @last_message_sent_data,
+		last_message_sent_type = @last_message_sent_type
+		WHERE id = @room_id AND last_message_sent <= @through`,
+			sql.Named("last_message_sent", last.ID), sql.Named("last_message_sent_at", last.SentAt),
+			sql.Named("last_message_sent_data", last.Data), sql.Named("last_message_sent_type", last.Type),
+			sql.Named("room_id", roomID), sql.Named("through", purge.ThroughID))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ExecContext(update rooms): %w", err)
+	}
+
+	// members who read a deleted message have read up to the newest message before it
+	_, err = tx.ExecContext(ctx, `
+	UPDATE room_members SET last_message_read = COALESCE((
+		SELECT MAX(m.id) FROM messages AS m
+		WHERE m.room_id = room_members.room_id AND m.id <= room_members.last_message_read
+	), 0)
+	WHERE room_id = @room_id AND last_message_read <= @through`,
+		sql.Named("room_id", roomID), sql.Named("through", purge.ThroughID))
+	if err != nil {
+		return nil, fmt.Errorf("ExecContext(update room_members): %w", err)
+	}
+
+	row = tx.QueryRowContext(ctx, `
+	INSERT INTO retention_purges (room_id, messages, through_id, purged_at)
+	VALUES (@room_id, @messages, @through_id, @purged_at) RETURNING id`,
+		sql.Named("room_id", roomID), sql.Named("messages", purge.Messages),
+		sql.Named("through_id", purge.ThroughID), sql.Named("purged_at", purge.PurgedAt))
+	if err := row.Scan(&purge.ID); err != nil {
+		return nil, fmt.Errorf("row.Scan(insert retention_purges): %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("Commit: %w", err)
+	}
+	return &purge, nil
+}
+
+func (s *SQLChatStore) GetPurges(ctx context.Context, since time.Time) ([]Purge, error) {
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT id, room_id, messages, through_id, purged_at FROM retention_purges
+	WHERE purged_at >= @since ORDER BY purged_at DESC, id DESC`,
+		sql.Named("since", since.UTC()))
+	if err != nil {
+		return nil, fmt.Errorf("QueryContext: %w", err)
+	}
+	defer rows.Close()
+
+	var purges []Purge
+	for rows.Next() {
+		var p Purge
+		if err := rows.Scan(&p.ID, &p.RoomID, &p.Messages, &p.ThroughID, &p.PurgedAt); err != nil {
+			return nil, fmt.Errorf("rows.Scan: %w", err)
+		}
+		purges = append(purges, p)
+	}
+	return purges, rows.Err()
+}
@@ -0,0 +1,199 @@
+package core
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// seedMessageAt sends a message and backdates it to sentAt.
+func seedMessageAt(f *ChatFixture, roomID, sender, data string, sentAt time.Time) Message {
+	message := seedMessage(f, roomID, sender, data)
+	_, err := f.db.ExecContext(f.ctx, "UPDATE messages SET sent_at = @sent_at WHERE id = @id",
+		sql.Named("sent_at", sentAt.UTC()), sql.Named("id", message.ID))
+	require.Nil(f.t, err)
+	message.SentAt = sentAt.UTC()
+	return message
+}
+
+func backdateRoomMessages(f *ChatFixture, roomID string, sentAt time.Time) {
+	_, err := f.db.ExecContext(f.ctx, "UPDATE messages SET sent_at = @sent_at WHERE room_id = @room_id",
+		sql.Named("sent_at", sentAt.UTC()), sql.Named("room_id", roomID))
+	require.Nil(f.t, err)
+}
+
+func getRoomMessageIDs(f *ChatFixture, roomID string) []int {
+	messages, err := f.chatStore.GetRoomMessages(f.ctx, roomID, 0, 100)
+	require.Nil(f.t, err)
+	var ids []int
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+	}
+	return ids
+}
+
+func TestRoomRetention(t *testing.T) {
+	f := NewChatFixture(t)
+	defer f.tearDown()
+	room := seedRoomWithRoles(f)
+
+	retention, err := f.chatStore.GetRoomRetention(f.ctx, room.ID)
+	require.Nil(t, err)
+	assert.Equal(t, &RoomRetention{RoomID: room.ID}, retention)
+
+	require.Nil(t, f.chatStore.SetRoomRetention(f.ctx, room.ID, &RetentionPolicy{Days: 30}))
+	require.Nil(t, f.chatStore.SetLegalHold(f.ctx, room.ID, true))
+	retention, err = f.chatStore.GetRoomRetention(f.ctx, room.ID)
+	require.Nil(t, err)
+	assert.Equal(t, &RetentionPolicy{Days: 30}, retention.Policy)
+	assert.True(t, retention.LegalHold)
+
+	require.Nil(t, f.chatStore.SetRoomRetention(f.ctx, room.ID, nil))
+	retention, err = f.chatStore.GetRoomRetention(f.ctx, room.ID)
+	require.Nil(t, err)
+	assert.Nil(t, retention.Policy)
+
+	assert.Equal(t, ErrInvalidRetention, f.chatStore.SetRoomRetention(f.ctx, room.ID, &RetentionPolicy{Days: -1}))
+	assert.Equal(t, ErrInvalidRoom, f.chatStore.SetRoomRetention(f.ctx, "random", &RetentionPolicy{Days: 1}))
+	assert.Equal(t, ErrInvalidRoom, f.chatStore.SetLegalHold(f.ctx, "random", true))
+	_, err = f.chatStore.GetRoomRetention(f.ctx, "random")
+	assert.Equal(t, ErrInvalidRoom, err)
+
+	// only the owner manages retention unless the room grants it
+	_, err = f.chatStore.Authorize(f.ctx, room.ID, owner.Username, ManageRetention)
+	assert.Nil(t, err)
+	_, err = f.chatStore.Authorize(f.ctx, room.ID, admin.Username, ManageRetention)
+	assert.Equal(t, ErrDisAllowedOperation, err)
+	require.Nil(t, f.chatStore.SetRoomPermission(f.ctx, room.ID, Admin, ManageRetention, true))
+	role, err := f.chatStore.Authorize(f.ctx, room.ID, admin.Username, ManageRetention)
+	require.Nil(t, err)
+	assert.Equal(t, Admin, role)
+}
+
+func TestPurgeExpiredMessages(t *testing.T) {
+	now := time.Now().UTC()
+
+	t.Run("by age", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+		old := seedMessageAt(f, room.ID, member1.Username, "old", now.AddDate(0, 0, -40))
+		_, err := f.chatStore.PinMessage(f.ctx, room.ID, old.ID, owner.Username)
+		require.Nil(t, err)
+		_, err = f.chatStore.BookmarkMessage(f.ctx, admin.Username, old.ID, BookmarkInput{})
+		require.Nil(t, err)
+		recent := seedMessageAt(f, room.ID, member1.Username, "recent", now.AddDate(0, 0, -1))
+
+		purges, err := f.chatStore.PurgeExpiredMessages(f.ctx, RetentionPolicy{Days: 30}, now, 0)
+		require.Nil(t, err)
+		require.Len(t, purges, 1)
+		assert.Equal(t, room.ID, purges[0].RoomID)
+		assert.Equal(t, old.ID, purges[0].ThroughID)
+		ids := getRoomMessageIDs(f, room.ID)
+		assert.NotContains(t, ids, old.ID)
+		assert.Contains(t, ids, recent.ID)
+
+		pins, err := f.chatStore.GetRoomPins(f.ctx, room.ID)
+		require.Nil(t, err)
+		assert.Empty(t, pins)
+		bookmarks, err := f.chatStore.GetBookmarks(f.ctx, admin.Username, 0, 0)
+		require.Nil(t, err)
+		assert.Empty(t, bookmarks)
+
+		recorded, err := f.chatStore.GetPurges(f.ctx, now.Add(-time.Minute))
+		require.Nil(t, err)
+		assert.Equal(t, purges[0].ID, recorded[0].ID)
+		assert.Equal(t, purges[0].Messages, recorded[0].Messages)
+	})
+
+	t.Run("by count in batches", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+		var sent []Message
+		for i := range 5 {
+			sent = append(sent, seedMessage(f, room.ID, member1.Username, fmt.Sprintf("%d", i)))
+		}
+		before := len(getRoomMessageIDs(f, room.ID))
+
+		worker := NewRetentionWorker(f.chatStore, RetentionPolicy{Messages: 2}, time.Hour, 2,
+			slog.New(slog.NewTextHandler(io.Discard, nil)))
+		purges, err := worker.Purge(f.ctx, now)
+		require.Nil(t, err)
+		purged := 0
+		for _, purge := range purges {
+			assert.LessOrEqual(t, purge.Messages, 2)
+			purged += purge.Messages
+		}
+		assert.Equal(t, before-2, purged)
+		assert.Equal(t, []int{sent[3].ID, sent[4].ID}, getRoomMessageIDs(f, room.ID))
+	})
+
+	t.Run("room policy and legal hold", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, owner)
+		rooms := seedRooms(f, owner, "kept", "held", "overridden")
+		var old []int
+		for _, room := range rooms {
+			old = append(old, seedMessageAt(f, room.ID, owner.Username, "old", now.AddDate(0, 0, -10)).ID)
+		}
+		require.Nil(t, f.chatStore.SetLegalHold(f.ctx, rooms[1].ID, true))
+		require.Nil(t, f.chatStore.SetRoomRetention(f.ctx, rooms[1].ID, &RetentionPolicy{Days: 1}))
+		require.Nil(t, f.chatStore.SetRoomRetention(f.ctx, rooms[2].ID, &RetentionPolicy{Days: 1}))
+
+		purges, err := f.chatStore.PurgeExpiredMessages(f.ctx, RetentionPolicy{Days: 30}, now, 0)
+		require.Nil(t, err)
+		require.Len(t, purges, 1)
+		assert.Equal(t, rooms[2].ID, purges[0].RoomID)
+		assert.Contains(t, getRoomMessageIDs(f, rooms[0].ID), old[0])
+		assert.Contains(t, getRoomMessageIDs(f, rooms[1].ID), old[1])
+		assert.NotContains(t, getRoomMessageIDs(f, rooms[2].ID), old[2])
+	})
+
+	t.Run("last messages are moved back", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+		backdateRoomMessages(f, room.ID, now.AddDate(0, 0, -40))
+		kept := seedMessage(f, room.ID, member1.Username, "kept")
+		// imported history can be newer by id than by time
+		seedMessageAt(f, room.ID, admin.Username, "old", now.AddDate(0, 0, -40))
+
+		_, err := f.chatStore.PurgeExpiredMessages(f.ctx, RetentionPolicy{Days: 30}, now, 0)
+		require.Nil(t, err)
+		assert.Equal(t, []int{kept.ID}, getRoomMessageIDs(f, room.ID))
+		got, err := f.chatStore.GetRoomByID(f.ctx, room.ID)
+		require.Nil(t, err)
+		assert.Equal(t, kept.ID, got.LastMessageSent)
+		assert.Equal(t, "kept", got.LastMessageSentData)
+		assert.Equal(t, kept.ID, getRoomMemberByUsername(*got, member1.Username).LastMessageRead)
+		assert.Equal(t, kept.ID, getRoomMemberByUsername(*got, admin.Username).LastMessageRead)
+		assert.Equal(t, 0, getRoomMemberByUsername(*got, owner.Username).LastMessageRead)
+	})
+
+	t.Run("all messages purged", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+		seedMessage(f, room.ID, member1.Username, "old")
+		backdateRoomMessages(f, room.ID, now.AddDate(0, 0, -40))
+
+		_, err := f.chatStore.PurgeExpiredMessages(f.ctx, RetentionPolicy{Days: 30}, now, 0)
+		require.Nil(t, err)
+		assert.Empty(t, getRoomMessageIDs(f, room.ID))
+		got, err := f.chatStore.GetRoomByID(f.ctx, room.ID)
+		require.Nil(t, err)
+		assert.Equal(t, 0, got.LastMessageSent)
+		assert.Equal(t, "", got.LastMessageSentData)
+		for _, member := range got.Members {
+			assert.Equal(t, 0, member.LastMessageRead)
+		}
+	})
+}
@@ -0,0 +1,250 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+func (s *SQLChatStore) UpdateRoom(ctx context.Context, roomID, actor string, input UpdateRoomInput) (*Message, error) {
+	if err := input.Validate(); err != nil {
+		return nil, ErrInvalidRoomInput
+	}
+	if input.Name != nil || input.Topic != nil || input.Description != nil || input.Avatar != nil {
+		if _, err := s.Authorize(ctx, roomID, actor, RenameRoom); err != nil {
+			return nil, err
+		}
+	}
+	if input.Visibility != nil {
+		if _, err := s.Authorize(ctx, roomID, actor, ChangeVisibility); err != nil {
+			return nil, err
+		}
+	}
+
+	room, err := s.GetRoomByID(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("GetRoomByID: %w", err)
+	}
+	if room == nil {
+		return nil, ErrInvalidRoom
+	}
+
+	fields := make(map[string]string)
+	for _, field := range []struct {
+		name    string
+		value   *string
+		current *string
+	}{
+		{"name", input.Name, &room.Name},
+		{"topic", input.Topic, &room.Topic},
+		{"description", input.Description, &room.Description},
+		{"avatar", input.Avatar, &room.Avatar},
+		{"visibility", (*string)(input.Visibility), (*string)(&room.Visibility)},
+	} {
+		if field.value != nil && *field.value != *field.current {
+			*field.current = *field.value
+			fields[field.name] = *field.value
+		}
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE rooms SET name = @name, topic = @topic, description = @description, avatar = @avatar,
+	visibility = @visibility
+	WHERE id = @room_id`
+	_, err = tx.ExecContext(ctx, query,
+		sql.Named("name", room.Name), sql.Named("topic", room.Topic),
+		sql.Named("description", room.Description), sql.Named("avatar", room.Avatar),
+		sql.Named("visibility", room.Visibility), sql.Named("room_id", roomID))
+	if err != nil {
+		return nil, fmt.Errorf("ExecContext(update room): %w", err)
+	}
+
+	message, err := insertSystemMessage(ctx, tx, roomID, SystemMessageBody{
+		Key:    SystemRoomUpdated,
+		Actor:  actor,
+		Fields: fields,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("Commit: %w", err)
+	}
+	return message, nil
+}
+
+func (s *SQLChatStore) GetRoomDirectory(ctx context.Context, search string, offset, limit int) ([]DirectoryEntry, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	query := `
+	SELECT r.id, r.name, r.topic, r.description, r.avatar, r.visibility,
+	(SELECT count(*) FROM room_members AS rm WHERE rm.room_id = r.id) AS member_count
+	FROM rooms AS r
+	WHERE r.visibility IN (@public_listed, @public_join) AND r.archived_at IS NULL
+	AND ` + s.db.Dialect.Contains("lower(r.name)", "lower(@search)") + `
+	ORDER BY member_count DESC, r.name ASC
+	LIMIT @limit OFFSET @offset`
+	rows, err := s.db.QueryContext(ctx, query,
+		sql.Named("public_listed", RoomPublicListed), sql.Named("public_join", RoomPublicJoin),
+		sql.Named("search", search), sql.Named("limit", limit), sql.Named("offset", offset))
+	if err != nil {
+		return nil, fmt.Errorf("QueryContext: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []DirectoryEntry
+	for rows.Next() {
+		var entry DirectoryEntry
+		if err := rows.Scan(&entry.ID, &entry.Name, &entry.Topic, &entry.Description,
+			&entry.Avatar, &entry.Visibility, &entry.MemberCount); err != nil {
+			return nil, fmt.Errorf("rows.Scan: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err: %w", err)
+	}
+	return entries, nil
+}
+
+func (s *SQLChatStore) JoinRoom(ctx context.Context, roomID, username string) error {
+	user, err := s.userStore.GetUserByUsername(ctx, username)
+	if err != nil {
+		return fmt.Errorf("GetUserByUsername: %w", err)
+	}
+	if user == nil {
+		return ErrInvalidUser
+	}
+
+	room, err := s.GetRoomByID(ctx, roomID)
+	if err != nil {
+		return fmt.Errorf("GetRoomByID: %w", err)
+	}
+	if room == nil {
+		return ErrInvalidRoom
+	}
+	if room.Visibility != RoomPublicJoin {
+		return ErrDisAllowedOperation
+	}
+	if room.ArchivedAt != nil {
+		return ErrArchivedRoom
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := s.addRoomMember(ctx, tx, roomID, username, Member, username); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Commit: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLChatStore) ArchiveRoom(ctx context.Context, roomID, actor string, archived bool) error {
+	if _, err := s.Authorize(ctx, roomID, actor, ArchiveRoom); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE rooms SET archived_at = @archived_at WHERE id = @room_id AND archived_at IS NULL"
+	key := SystemRoomArchived
+	var archivedAt *time.Time
+	if archived {
+		now := time.Now().UTC()
+		archivedAt = &now
+	} else {
+		query = "UPDATE rooms SET archived_at = @archived_at WHERE id = @room_id AND archived_at IS NOT NULL"
+		key = SystemRoomUnarchived
+	}
+	result, err := tx.ExecContext(ctx, query, sql.Named("archived_at", archivedAt), sql.Named("room_id", roomID))
+	if err != nil {
+		return fmt.Errorf("ExecContext(update rooms): %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("RowsAffected: %w", err)
+	}
+	// already in the requested state
+	if affected == 0 {
+		return nil
+	}
+
+	if _, err := insertSystemMessage(ctx, tx, roomID, SystemMessageBody{Key: key, Actor: actor}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Commit: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLChatStore) DeleteRoom(ctx context.Context, roomID, actor string) error {
+	ok, role, err := s.IsRoomMember(ctx, roomID, actor)
+	if err != nil {
+		return fmt.Errorf("IsRoomMember: %w", err)
+	}
+	if !ok {
+		return ErrInvalidRoom
+	}
+	if role != Owner {
+		return ErrDisAllowedOperation
+	}
+	return s.ForceDeleteRoom(ctx, roomID)
+}
+
+func (s *SQLChatStore) ForceDeleteRoom(ctx context.Context, roomID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.QueryRowContext(ctx, "SELECT count(*) FROM rooms WHERE id = @room_id",
+		sql.Named("room_id", roomID)).Scan(&count); err != nil {
+		return fmt.Errorf("row.Scan: %w", err)
+	}
+	if count == 0 {
+		return ErrInvalidRoom
+	}
+
+	if err := deleteRoom(ctx, tx, roomID); err != nil {
+		return err
+	}
+	if err := s.auditLog.appendTx(ctx, tx, AuditEntry{Action: AuditRoomDeleted, RoomID: roomID}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Commit: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package core
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateRoom(t *testing.T) {
+	t.Run("update metadata", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+
+		name, topic := "renamed", "release planning"
+		message, err := f.chatStore.UpdateRoom(f.ctx, room.ID, admin.Username,
+			UpdateRoomInput{Name: &name, Topic: &topic, Description: &room.Description})
+		require.Nil(t, err)
+		require.NotNil(t, message)
+		assert.Equal(t, SystemMessage, message.Type)
+		assert.Equal(t, admin.Username, message.Sender)
+
+		var body SystemMessageBody
+		require.Nil(t, json.Unmarshal([]byte(message.Data), &body))
+		assert.Equal(t, SystemMessageBody{
+			Key:    SystemRoomUpdated,
+			Actor:  admin.Username,
+			Fields: map[string]string{"name": name, "topic": topic},
+		}, body)
+
+		updated, err := f.chatStore.GetRoomByID(f.ctx, room.ID)
+		require.Nil(t, err)
+		assert.Equal(t, name, updated.Name)
+		assert.Equal(t, topic, updated.Topic)
+		assert.Equal(t, message.ID, updated.LastMessageSent)
+
+		messages, err := f.chatStore.GetRoomMessages(f.ctx, room.ID, 0, 1)
+		require.Nil(t, err)
+		require.Len(t, messages, 1)
+		assert.Equal(t, message.ID, messages[0].ID)
+	})
+
+	t.Run("nothing changed", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+
+		message, err := f.chatStore.UpdateRoom(f.ctx, room.ID, owner.Username, UpdateRoomInput{Name: &room.Name})
+		require.Nil(t, err)
+		assert.Nil(t, message)
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+
+		empty, long := "", strings.Repeat("a", 251)
+		_, err := f.chatStore.UpdateRoom(f.ctx, room.ID, owner.Username, UpdateRoomInput{Name: &empty})
+		assert.Equal(t, ErrInvalidRoomInput, err)
+		_, err = f.chatStore.UpdateRoom(f.ctx, room.ID, owner.Username, UpdateRoomInput{Topic: &long})
+		assert.Equal(t, ErrInvalidRoomInput, err)
+	})
+
+	t.Run("not allowed", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+
+		name := "renamed"
+		_, err := f.chatStore.UpdateRoom(f.ctx, room.ID, member1.Username, UpdateRoomInput{Name: &name})
+		assert.Equal(t, ErrDisAllowedOperation, err)
+		_, err = f.chatStore.UpdateRoom(f.ctx, room.ID, member2.Username, UpdateRoomInput{Name: &name})
+		assert.Equal(t, ErrInvalidRoom, err)
+	})
+
+	t.Run("only the owner changes the visibility", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+
+		join := RoomPublicJoin
+		_, err := f.chatStore.UpdateRoom(f.ctx, room.ID, admin.Username, UpdateRoomInput{Visibility: &join})
+		assert.Equal(t, ErrDisAllowedOperation, err)
+		updated, err := f.chatStore.GetRoomByID(f.ctx, room.ID)
+		require.Nil(t, err)
+		assert.Equal(t, RoomPrivate, updated.Visibility)
+
+		_, err = f.chatStore.UpdateRoom(f.ctx, room.ID, owner.Username, UpdateRoomInput{Visibility: &join})
+		require.Nil(t, err)
+	})
+}
+
+func TestGetRoomDirectory(t *testing.T) {
+	f := NewChatFixture(t)
+	defer f.tearDown()
+	seedUsers(f.ctx, f.t, f.userStore, owner, member1)
+	rooms := seedRooms(f, owner, "Engineering", "Design", "Secret engineering")
+
+	listed, join := RoomPublicListed, RoomPublicJoin
+	_, err := f.chatStore.UpdateRoom(f.ctx, rooms[0].ID, owner.Username, UpdateRoomInput{Visibility: &join})
+	require.Nil(t, err)
+	_, err = f.chatStore.UpdateRoom(f.ctx, rooms[1].ID, owner.Username, UpdateRoomInput{Visibility: &listed})
+	require.Nil(t, err)
+	require.Nil(t, f.chatStore.AddRoomMember(f.ctx, rooms[0].ID, member1.Username, Member))
+
+	entries, err := f.chatStore.GetRoomDirectory(f.ctx, "", 0, 0)
+	require.Nil(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, rooms[0].ID, entries[0].ID)
+	assert.Equal(t, 2, entries[0].MemberCount)
+	assert.Equal(t, RoomPublicJoin, entries[0].Visibility)
+	assert.Equal(t, rooms[1].ID, entries[1].ID)
+
+	entries, err = f.chatStore.GetRoomDirectory(f.ctx, "ENGINEER", 0, 0)
+	require.Nil(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, rooms[0].ID, entries[0].ID)
+
+	entries, err = f.chatStore.GetRoomDirectory(f.ctx, "", 1, 1)
+	require.Nil(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, rooms[1].ID, entries[0].ID)
+}
+
+func TestJoinRoom(t *testing.T) {
+	f := NewChatFixture(t)
+	defer f.tearDown()
+	seedUsers(f.ctx, f.t, f.userStore, owner, member1)
+	rooms := seedRooms(f, owner, "Open", "Listed", "Private")
+
+	listed, join := RoomPublicListed, RoomPublicJoin
+	_, err := f.chatStore.UpdateRoom(f.ctx, rooms[0].ID, owner.Username, UpdateRoomInput{Visibility: &join})
+	require.Nil(t, err)
+	_, err = f.chatStore.UpdateRoom(f.ctx, rooms[1].ID, owner.Username, UpdateRoomInput{Visibility: &listed})
+	require.Nil(t, err)
+
+	require.Nil(t, f.chatStore.JoinRoom(f.ctx, rooms[0].ID, member1.Username))
+	ok, role, err := f.chatStore.IsRoomMember(f.ctx, rooms[0].ID, member1.Username)
+	require.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Member, role)
+	// joining again does nothing
+	require.Nil(t, f.chatStore.JoinRoom(f.ctx, rooms[0].ID, member1.Username))
+
+	assert.Equal(t, ErrDisAllowedOperation, f.chatStore.JoinRoom(f.ctx, rooms[1].ID, member1.Username))
+	assert.Equal(t, ErrDisAllowedOperation, f.chatStore.JoinRoom(f.ctx, rooms[2].ID, member1.Username))
+	assert.Equal(t, ErrInvalidRoom, f.chatStore.JoinRoom(f.ctx, "random", member1.Username))
+	assert.Equal(t, ErrInvalidUser, f.chatStore.JoinRoom(f.ctx, rooms[0].ID, "random"))
+}
+
+func TestArchiveRoom(t *testing.T) {
+	t.Run("archive and restore", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+		sent, err := f.chatStore.SendMessageToRoom(f.ctx, MessageCreateInput{
+			Type: TextMessage, Data: "hello", Sender: member1.Username, RoomID: room.ID})
+		require.Nil(t, err)
+
+		require.Nil(t, f.chatStore.ArchiveRoom(f.ctx, room.ID, admin.Username, true))
+		archived, err := f.chatStore.GetRoomByID(f.ctx, room.ID)
+		require.Nil(t, err)
+		require.NotNil(t, archived.ArchivedAt)
+		// archiving again does nothing
+		require.Nil(t, f.chatStore.ArchiveRoom(f.ctx, room.ID, admin.Username, true))
+
+		rooms, err := f.chatStore.GetUserRooms(f.ctx, member1.Username, 0, 0)
+		require.Nil(t, err)
+		assert.Empty(t, rooms)
+		rooms, err = f.chatStore.GetArchivedUserRooms(f.ctx, member1.Username, 0, 0)
+		require.Nil(t, err)
+		require.Len(t, rooms, 1)
+		assert.Equal(t, room.ID, rooms[0].ID)
+
+		_, err = f.chatStore.SendMessageToRoom(f.ctx, MessageCreateInput{
+			Type: TextMessage, Data: "hello", Sender: member1.Username, RoomID: room.ID})
+		assert.Equal(t, ErrArchivedRoom, err)
+		name := "renamed"
+		_, err = f.chatStore.UpdateRoom(f.ctx, room.ID, owner.Username, UpdateRoomInput{Name: &name})
+		assert.Equal(t, ErrArchivedRoom, err)
+		assert.Equal(t, ErrArchivedRoom, f.chatStore.DeleteMessage(f.ctx, room.ID, sent.ID, member1.Username))
+
+		require.Nil(t, f.chatStore.ArchiveRoom(f.ctx, room.ID, owner.Username, false))
+		restored, err := f.chatStore.GetRoomByID(f.ctx, room.ID)
+		require.Nil(t, err)
+		assert.Nil(t, restored.ArchivedAt)
+		rooms, err = f.chatStore.GetUserRooms(f.ctx, member1.Username, 0, 0)
+		require.Nil(t, err)
+		assert.Len(t, rooms, 1)
+
+		messages, err := f.chatStore.GetRoomMessages(f.ctx, room.ID, 0, 2)
+		require.Nil(t, err)
+		require.Len(t, messages, 2)
+		keys := make([]string, 0, len(messages))
+		for _, message := range messages {
+			var body SystemMessageBody
+			require.Nil(t, json.Unmarshal([]byte(message.Data), &body))
+			keys = append(keys, body.Key)
+		}
+		assert.ElementsMatch(t, []string{SystemRoomArchived, SystemRoomUnarchived}, keys)
+	})
+
+	t.Run("not allowed", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+
+		assert.Equal(t, ErrDisAllowedOperation, f.chatStore.ArchiveRoom(f.ctx, room.ID, member1.Username, true))
+		assert.Equal(t, ErrInvalidRoom, f.chatStore.ArchiveRoom(f.ctx, room.ID, member2.Username, true))
+	})
+
+	t.Run("cannot join archived room", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		room := seedRoomWithRoles(f)
+
+		join := RoomPublicJoin
+		_, err := f.chatStore.UpdateRoom(f.ctx, room.ID, owner.Username, UpdateRoomInput{Visibility: &join})
+		require.Nil(t, err)
+		invite, err := f.chatStore.CreateInvite(f.ctx, room.ID, owner.Username, CreateInviteInput{Role: Member})
+		require.Nil(t, err)
+		require.Nil(t, f.chatStore.ArchiveRoom(f.ctx, room.ID, owner.Username, true))
+
+		entries, err := f.chatStore.GetRoomDirectory(f.ctx, "", 0, 0)
+		require.Nil(t, err)
+		assert.Empty(t, entries)
+		assert.Equal(t, ErrArchivedRoom, f.chatStore.JoinRoom(f.ctx, room.ID, member2.Username))
+		_, _, err = f.chatStore.AcceptInvite(f.ctx, invite.Code, member2.Username)
+		assert.Equal(t, ErrArchivedRoom, err)
+	})
+}
+
+func TestDeleteRoom(t *testing.T) {
+	f := NewChatFixture(t)
+	defer f.tearDown()
+	room := seedRoomWithRoles(f)
+
+	message, err := f.chatStore.SendMessageToRoom(f.ctx, MessageCreateInput{
+		Type: TextMessage, Data: "hello", Sender: member1.Username, RoomID: room.ID})
+	require.Nil(t, err)
+	_, _, err = f.chatStore.ReadRoomMessages(f.ctx, room.ID, admin.Username)
+	require.Nil(t, err)
+	_, err = f.chatStore.CreateInvite(f.ctx, room.ID, owner.Username, CreateInviteInput{Role: Member})
+	require.Nil(t, err)
+
+	assert.Equal(t, ErrDisAllowedOperation, f.chatStore.DeleteRoom(f.ctx, room.ID, admin.Username))
+	assert.Equal(t, ErrInvalidRoom, f.chatStore.DeleteRoom(f.ctx, room.ID, member2.Username))
+
+	require.Nil(t, f.chatStore.DeleteRoom(f.ctx, room.ID, owner.Username))
+
+	deleted, err := f.chatStore.GetRoomByID(f.ctx, room.ID)
+	require.Nil(t, err)
+	assert.Nil(t, deleted)
+	for _, table := range []string{"messages", "room_members", "room_invites"} {
+		var count int
+		require.Nil(t, f.db.QueryRowContext(f.ctx, "SELECT count(*) FROM "+table+" WHERE room_id = ?", room.ID).Scan(&count))
+		assert.Zero(t, count, table)
+	}
+	var count int
+	require.Nil(t, f.db.QueryRowContext(f.ctx, "SELECT count(*) FROM message_interactions WHERE message_id = ?", message.ID).Scan(&count))
+	assert.Zero(t, count)
+}
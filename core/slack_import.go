@@ -0,0 +1,566 @@
+package core
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// slackProviderName is the identity provider and import source of Slack workspaces.
+const slackProviderName = "slack"
+
+// ErrInvalidImport is returned when an export cannot be read.
+var ErrInvalidImport = errors.New("invalid import")
+
+// ImportStatus is what an import did, or would do, with a user or a room.
+type ImportStatus string
+
+const (
+	// ImportCreated means a new user or room was created.
+	ImportCreated ImportStatus = "created"
+	// ImportLinked means the user was matched to an existing user with the same username,
+	// which only happens if SlackImportConfig.LinkExistingUsers is set.
+	ImportLinked ImportStatus = "linked"
+	// ImportExisting means the user or room was created by an earlier import.
+	ImportExisting ImportStatus = "existing"
+	// ImportSkipped means the channel was not imported.
+	ImportSkipped ImportStatus = "skipped"
+)
+
+// ImportedUser maps a user of the source to a local user.
+type ImportedUser struct {
+	ExternalID   string       `json:"external_id"`
+	ExternalName string       `json:"external_name"`
+	Username     string       `json:"username"`
+	Status       ImportStatus `json:"status"`
+}
+
+// ImportedRoom maps a channel of the source to a local room.
+type ImportedRoom struct {
+	ExternalID   string       `json:"external_id"`
+	ExternalName string       `json:"external_name"`
+	RoomID       string       `json:"room_id"`
+	Status       ImportStatus `json:"status"`
+	// Reason explains why the channel was skipped.
+	Reason string `json:"reason,omitempty"`
+	// Members is how many members were added to the room.
+	Members int `json:"members"`
+	// Messages is how many messages were imported.
+	Messages int `json:"messages"`
+	// Duplicates is how many messages were skipped because they were imported before.
+	Duplicates int `json:"duplicates"`
+	// Unsupported is how many messages were skipped because they cannot be represented,
+	// such as join notices and messages of bots.
+	Unsupported int `json:"unsupported"`
+}
+
+// ImportReport maps what was in the export to what it became.
+// For dry runs it is what the import would do.
+type ImportReport struct {
+	DryRun bool           `json:"dry_run"`
+	Users  []ImportedUser `json:"users"`
+	Rooms  []ImportedRoom `json:"rooms"`
+	// Conversations is how many direct and group messages were skipped.
+	Conversations int `json:"conversations"`
+}
+
+type slackUser struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	IsBot   bool   `json:"is_bot"`
+	Profile struct {
+		RealName    string `json:"real_name"`
+		DisplayName string `json:"display_name"`
+	} `json:"profile"`
+}
+
+type slackChannel struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Creator    string   `json:"creator"`
+	IsArchived bool     `json:"is_archived"`
+	Members    []string `json:"members"`
+	Topic      struct {
+		Value string `json:"value"`
+	} `json:"topic"`
+	Purpose struct {
+		Value string `json:"value"`
+	} `json:"purpose"`
+
+	visibility RoomVisibility
+}
+
+type slackMessage struct {
+	Type    string `json:"type"`
+	Subtype string `json:"subtype"`
+	User    string `json:"user"`
+	Text    string `json:"text"`
+	TS      string `json:"ts"`
+	Files   []struct {
+		Name string `json:"name"`
+	} `json:"files"`
+}
+
+// slackSubtypes are the subtypes of messages that are imported. The others are notices
+// of changes to the channel, which the room records itself, or come from bots.
+var slackSubtypes = map[string]bool{
+	"":                 true,
+	"file_share":       true,
+	"me_message":       true,
+	"thread_broadcast": true,
+}
+
+// SlackImporter imports the users, channels and messages of a Slack workspace export.
+// Users are linked to their Slack accounts and rooms and messages are recorded,
+// so running an import again only adds what is new.
+type SlackImporter struct {
+	userStore UserStore
+	chatStore ChatStore
+	config    SlackImportConfig
+}
+
+// SlackImportConfig configures a SlackImporter.
+type SlackImportConfig struct {
+	// DryRun reads the export and reports what would be imported without changing anything.
+	DryRun bool
+	// LinkExistingUsers links a Slack user to the existing local user with the same username.
+	// If false, the Slack user is created with a numbered username instead, e.g. alice-2.
+	LinkExistingUsers bool
+}
+
+// NewSlackImporter creates an importer.
+func NewSlackImporter(userStore UserStore, chatStore ChatStore, config SlackImportConfig) *SlackImporter {
+	return &SlackImporter{userStore: userStore, chatStore: chatStore, config: config}
+}
+
+// Import imports the export. It stops at the first error, leaving what was imported so far,
+// which is picked up again by the next run.
+func (i *SlackImporter) Import(ctx context.Context, export *zip.Reader) (*ImportReport, error) {
+	report := &ImportReport{DryRun: i.config.DryRun, Users: []ImportedUser{}, Rooms: []ImportedRoom{}}
+
+	var users []slackUser
+	if err := readSlackFile(export, "users.json", &users); err != nil {
+		return nil, err
+	}
+	usernames, err := i.importUsers(ctx, users, report)
+	if err != nil {
+		return nil, err
+	}
+
+	var channels, groups []slackChannel
+	if err := readSlackFile(export, "channels.json", &channels); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err := readSlackFile(export, "groups.json", &groups); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for j := range channels {
+		channels[j].visibility = RoomPublicJoin
+	}
+	for j := range groups {
+		groups[j].visibility = RoomPrivate
+	}
+	channels = append(channels, groups...)
+
+	names := make(map[string]string, len(channels))
+	for _, channel := range channels {
+		names[channel.ID] = channel.Name
+	}
+	days := slackDayFiles(export)
+	for _, channel := range channels {
+		room, err := i.importChannel(ctx, channel, days[channel.Name], usernames, names)
+		if err != nil {
+			return nil, fmt.Errorf("channel %s: %w", channel.Name, err)
+		}
+		report.Rooms = append(report.Rooms, *room)
+	}
+
+	for _, name := range []string{"dms.json", "mpims.json"} {
+		var conversations []json.RawMessage
+		if err := readSlackFile(export, name, &conversations); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		report.Conversations += len(conversations)
+	}
+	return report, nil
+}
+
+// importUsers provisions the users of the export and returns their usernames by Slack ID.
+func (i *SlackImporter) importUsers(ctx context.Context, users []slackUser, report *ImportReport) (map[string]string, error) {
+	usernames := make(map[string]string, len(users))
+	taken := make(map[string]string, len(users))
+	for _, su := range users {
+		if su.IsBot || su.ID == "USLACKBOT" {
+			continue
+		}
+		user, status, err := i.importUser(ctx, su, taken)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %w", su.ID, err)
+		}
+		usernames[su.ID] = user.Username
+		taken[user.Username] = su.ID
+		report.Users = append(report.Users, ImportedUser{
+			ExternalID: su.ID, ExternalName: su.Name, Username: user.Username, Status: status,
+		})
+	}
+	return usernames, nil
+}
+
+func (i *SlackImporter) importUser(ctx context.Context, su slackUser, taken map[string]string) (*UserWithoutSecrets, ImportStatus, error) {
+	user, err := i.userStore.GetUserByIdentity(ctx, slackProviderName, su.ID)
+	if err != nil {
+		return nil, "", fmt.Errorf("GetUserByIdentity: %w", err)
+	}
+	if user != nil {
+		return user, ImportExisting, nil
+	}
+
+	username := sanitizeUsername(su.Name)
+	// another user of the export may sanitize to the same username
+	if _, ok := taken[username]; ok || len(username) < 3 {
+		username = sanitizeUsername(su.ID)
+	}
+	user, err = i.userStore.GetUserByUsername(ctx, username)
+	if err != nil {
+		return nil, "", fmt.Errorf("GetUserByUsername: %w", err)
+	}
+	if user != nil && !i.config.LinkExistingUsers {
+		user = nil
+		if username, err = i.availableUsername(ctx, username, taken); err != nil {
+			return nil, "", err
+		}
+	}
+
+	status := ImportLinked
+	if user == nil {
+		status = ImportCreated
+		name := su.Profile.RealName
+		if name == "" {
+			name = su.Profile.DisplayName
+		}
+		if len(name) < 3 {
+			name = username
+		}
+		user = &UserWithoutSecrets{Name: name, Username: username}
+		if !i.config.DryRun {
+			// imported users sign in through password reset or single sign-on
+			password, err := randomString(32)
+			if err != nil {
+				return nil, "", fmt.Errorf("generate password: %w", err)
+			}
+			if err := i.userStore.CreateUser(ctx, User{Name: name, Username: username, Password: password}); err != nil {
+				return nil, "", fmt.Errorf("CreateUser: %w", err)
+			}
+		}
+	}
+
+	if !i.config.DryRun {
+		identity := Identity{Provider: slackProviderName, Subject: su.ID, Username: user.Username}
+		if err := i.userStore.LinkIdentity(ctx, identity); err != nil {
+			return nil, "", fmt.Errorf("LinkIdentity: %w", err)
+		}
+	}
+	return user, status, nil
+}
+
+// availableUsername numbers the username until it is neither a local user nor taken by the export.
+func (i *SlackImporter) availableUsername(ctx context.Context, username string, taken map[string]string) (string, error) {
+	for n := 2; ; n++ {
+		candidate := username + "-" + strconv.Itoa(n)
+		if _, ok := taken[candidate]; ok {
+			continue
+		}
+		user, err := i.userStore.GetUserByUsername(ctx, candidate)
+		if err != nil {
+			return "", fmt.Errorf("GetUserByUsername: %w", err)
+		}
+		if user == nil {
+			return candidate, nil
+		}
+	}
+}
+
+// importChannel creates the room of the channel, or finds the one of an earlier import,
+// and adds the members and messages it does not have yet.
+func (i *SlackImporter) importChannel(ctx context.Context, channel slackChannel, days []*zip.File,
+	usernames, channelNames map[string]string,
+) (*ImportedRoom, error) {
+	report := &ImportedRoom{ExternalID: channel.ID, ExternalName: channel.Name}
+
+	var members []string
+	for _, id := range channel.Members {
+		if username, ok := usernames[id]; ok {
+			members = append(members, username)
+		}
+	}
+	owner, ok := usernames[channel.Creator]
+	if !ok {
+		if len(members) == 0 {
+			report.Status, report.Reason = ImportSkipped, "no members"
+			return report, nil
+		}
+		owner = members[0]
+	}
+
+	roomID, err := i.chatStore.GetImportedRoom(ctx, slackProviderName, channel.ID)
+	if err != nil {
+		return nil, fmt.Errorf("GetImportedRoom: %w", err)
+	}
+	if roomID != "" {
+		room, err := i.chatStore.GetRoomByID(ctx, roomID)
+		if err != nil {
+			return nil, fmt.Errorf("GetRoomByID: %w", err)
+		}
+		// the room of an earlier import has been deleted
+		if room == nil {
+			roomID = ""
+		}
+	}
+
+	created := roomID == ""
+	report.Status = ImportExisting
+	if created {
+		report.Status = ImportCreated
+		if !i.config.DryRun {
+			if roomID, err = i.createRoom(ctx, channel, owner); err != nil {
+				return nil, err
+			}
+		}
+	}
+	report.RoomID = roomID
+
+	for _, member := range members {
+		if member == owner {
+			continue
+		}
+		if !created {
+			isMember, _, err := i.chatStore.IsRoomMember(ctx, roomID, member)
+			if err != nil {
+				return nil, fmt.Errorf("IsRoomMember: %w", err)
+			}
+			if isMember {
+				continue
+			}
+		}
+		if !i.config.DryRun {
+			if err := i.chatStore.AddRoomMember(ContextWithActor(ctx, owner), roomID, member, Member); err != nil {
+				return nil, fmt.Errorf("AddRoomMember: %w", err)
+			}
+		}
+		report.Members++
+	}
+
+	for _, day := range days {
+		messages, unsupported, err := readSlackDay(day, channel.ID, usernames, channelNames)
+		if err != nil {
+			return nil, err
+		}
+		report.Unsupported += unsupported
+		if len(messages) == 0 {
+			continue
+		}
+
+		if i.config.DryRun {
+			duplicates := 0
+			if !created {
+				ids := make([]string, len(messages))
+				for j, m := range messages {
+					ids[j] = m.ExternalID
+				}
+				if duplicates, err = i.chatStore.CountImportedMessages(ctx, slackProviderName, ids); err != nil {
+					return nil, fmt.Errorf("CountImportedMessages: %w", err)
+				}
+			}
+			report.Messages += len(messages) - duplicates
+			report.Duplicates += duplicates
+			continue
+		}
+
+		imported, err := i.chatStore.ImportMessages(ctx, roomID, slackProviderName, messages)
+		if err != nil {
+			return nil, fmt.Errorf("ImportMessages(%s): %w", day.Name, err)
+		}
+		report.Messages += imported
+		report.Duplicates += len(messages) - imported
+	}
+
+	// archived last, so that the history is in place
+	if created && channel.IsArchived && !i.config.DryRun {
+		if err := i.chatStore.ArchiveRoom(ctx, roomID, owner, true); err != nil {
+			return nil, fmt.Errorf("ArchiveRoom: %w", err)
+		}
+	}
+	return report, nil
+}
+
+func (i *SlackImporter) createRoom(ctx context.Context, channel slackChannel, owner string) (string, error) {
+	roomID, err := i.chatStore.CreateRoom(ctx, channel.Name, owner)
+	if err != nil {
+		return "", fmt.Errorf("CreateRoom: %w", err)
+	}
+	// linked right away, so that a failed import does not create the room again
+	if err := i.chatStore.LinkImportedRoom(ctx, slackProviderName, channel.ID, roomID); err != nil {
+		return "", fmt.Errorf("LinkImportedRoom: %w", err)
+	}
+
+	topic := truncate(channel.Topic.Value, 250)
+	description := truncate(channel.Purpose.Value, 2000)
+	if _, err := i.chatStore.UpdateRoom(ctx, roomID, owner, UpdateRoomInput{
+		Topic:       &topic,
+		Description: &description,
+		Visibility:  &channel.visibility,
+	}); err != nil {
+		return "", fmt.Errorf("UpdateRoom: %w", err)
+	}
+	return roomID, nil
+}
+
+// readSlackFile decodes a JSON file at the root of the export.
+func readSlackFile(export *zip.Reader, name string, v any) error {
+	file, err := export.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := json.NewDecoder(file).Decode(v); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidImport, name, err)
+	}
+	return nil
+}
+
+// slackDayFiles returns the daily message files of the export by channel name, oldest first.
+func slackDayFiles(export *zip.Reader) map[string][]*zip.File {
+	days := make(map[string][]*zip.File)
+	for _, file := range export.File {
+		dir, name := path.Split(file.Name)
+		dir = strings.TrimSuffix(dir, "/")
+		if dir == "" || strings.Contains(dir, "/") || path.Ext(name) != ".json" {
+			continue
+		}
+		days[dir] = append(days[dir], file)
+	}
+	for _, files := range days {
+		// the files are named by date, so they sort chronologically
+		slices.SortFunc(files, func(a, b *zip.File) int { return strings.Compare(a.Name, b.Name) })
+	}
+	return days
+}
+
+// readSlackDay reads the messages of a day of the channel in the order they were sent.
+// It also returns how many messages were skipped because they cannot be imported.
+func readSlackDay(day *zip.File, channelID string, usernames, channelNames map[string]string) ([]ImportMessage, int, error) {
+	file, err := day.Open()
+	if err != nil {
+		return nil, 0, fmt.Errorf("Open(%s): %w", day.Name, err)
+	}
+	defer file.Close()
+	var raw []slackMessage
+	if err := json.NewDecoder(file).Decode(&raw); err != nil {
+		return nil, 0, fmt.Errorf("%w: %s: %v", ErrInvalidImport, day.Name, err)
+	}
+
+	messages := make([]ImportMessage, 0, len(raw))
+	unsupported := 0
+	for _, m := range raw {
+		sender, ok := usernames[m.User]
+		sentAt, err := parseSlackTimestamp(m.TS)
+		if m.Type != "message" || !slackSubtypes[m.Subtype] || !ok || err != nil {
+			unsupported++
+			continue
+		}
+		text := convertSlackText(m.Text, usernames, channelNames)
+		for _, f := range m.Files {
+			text = strings.TrimSpace(text + "\n[file: " + f.Name + "]")
+		}
+		if text == "" {
+			unsupported++
+			continue
+		}
+		messages = append(messages, ImportMessage{
+			ExternalID: channelID + "/" + m.TS,
+			Sender:     sender,
+			Data:       text,
+			SentAt:     sentAt,
+		})
+	}
+	slices.SortStableFunc(messages, func(a, b ImportMessage) int { return a.SentAt.Compare(b.SentAt) })
+	return messages, unsupported, nil
+}
+
+// parseSlackTimestamp parses the ts of a message, seconds since the epoch with a fraction.
+func parseSlackTimestamp(ts string) (time.Time, error) {
+	secs, frac, _ := strings.Cut(ts, ".")
+	s, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var nsec int64
+	if frac != "" {
+		frac = (frac + "000000000")[:9]
+		if nsec, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return time.Unix(s, nsec).UTC(), nil
+}
+
+var slackEntity = regexp.MustCompile(`<([^<>]+)>`)
+
+var slackEscapes = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&")
+
+// convertSlackText turns the markup of Slack into plain text: mentions of users
+// and channels become @username and #channel, and links show their address.
+func convertSlackText(text string, usernames, channelNames map[string]string) string {
+	text = slackEntity.ReplaceAllStringFunc(text, func(entity string) string {
+		target, label, _ := strings.Cut(entity[1:len(entity)-1], "|")
+		switch {
+		case strings.HasPrefix(target, "@"):
+			if username, ok := usernames[target[1:]]; ok {
+				return "@" + username
+			}
+			if label != "" {
+				return "@" + label
+			}
+			return target
+		case strings.HasPrefix(target, "#"):
+			if name, ok := channelNames[target[1:]]; ok {
+				return "#" + name
+			}
+			return "#" + label
+		case strings.HasPrefix(target, "!"):
+			// special mentions like !here, or user groups with their handle as label
+			if label != "" {
+				return label
+			}
+			return "@" + target[1:]
+		default:
+			target = strings.TrimPrefix(target, "mailto:")
+			if label == "" || label == target {
+				return target
+			}
+			return label + " (" + target + ")"
+		}
+	})
+	return slackEscapes.Replace(text)
+}
+
+// truncate cuts s to at most n bytes without splitting a character.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,182 @@
+package core
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slackExport builds an export zip from the files, which are encoded as JSON.
+func slackExport(t *testing.T, files map[string]any) *zip.Reader {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		require.Nil(t, err)
+		require.Nil(t, json.NewEncoder(f).Encode(content))
+	}
+	require.Nil(t, w.Close())
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.Nil(t, err)
+	return r
+}
+
+func testSlackExport(t *testing.T) *zip.Reader {
+	return slackExport(t, map[string]any{
+		"users.json": []map[string]any{
+			{"id": "U1", "name": "Alice.Smith", "profile": map[string]any{"real_name": "Alice Smith"}},
+			{"id": "U2", "name": "member1"},
+			{"id": "B1", "name": "bot", "is_bot": true},
+		},
+		"channels.json": []map[string]any{{
+			"id": "C1", "name": "general", "creator": "U1", "members": []string{"U1", "U2"},
+			"topic": map[string]any{"value": "Company wide"}, "is_archived": true,
+		}},
+		"groups.json": []map[string]any{{"id": "G1", "name": "secret", "creator": "B1"}},
+		"dms.json":    []map[string]any{{"id": "D1"}},
+		"general/2020-01-02.json": []map[string]any{
+			{"type": "message", "user": "U2", "text": "second &amp; <@U1>", "ts": "1577934001.000200"},
+			{"type": "message", "user": "U1", "text": "first <https://example.com|site>", "ts": "1577934000.000100"},
+			{"type": "message", "subtype": "channel_join", "user": "U2", "text": "joined", "ts": "1577933999.000000"},
+			{"type": "message", "user": "B1", "text": "beep", "ts": "1577934002.000000"},
+		},
+		"general/2020-01-01.json": []map[string]any{
+			{"type": "message", "user": "U1", "text": "", "ts": "1577847600.000000",
+				"files": []map[string]any{{"name": "plan.pdf"}}},
+		},
+	})
+}
+
+func TestSlackImport(t *testing.T) {
+	f := NewChatFixture(t)
+	defer f.tearDown()
+	seedUsers(f.ctx, t, f.userStore, member1)
+	export := testSlackExport(t)
+
+	t.Run("dry run", func(t *testing.T) {
+		report, err := NewSlackImporter(f.userStore, f.chatStore, SlackImportConfig{DryRun: true}).Import(f.ctx, export)
+		require.Nil(t, err)
+		assert.Equal(t, []ImportedUser{
+			{ExternalID: "U1", ExternalName: "Alice.Smith", Username: "alice.smith", Status: ImportCreated},
+			// the local member1 is not adopted by the Slack user of the same name
+			{ExternalID: "U2", ExternalName: "member1", Username: "member1-2", Status: ImportCreated},
+		}, report.Users)
+		require.Len(t, report.Rooms, 2)
+		assert.Equal(t, ImportCreated, report.Rooms[0].Status)
+		assert.Equal(t, 1, report.Rooms[0].Members)
+		assert.Equal(t, 3, report.Rooms[0].Messages)
+		assert.Equal(t, 2, report.Rooms[0].Unsupported)
+		assert.Equal(t, ImportSkipped, report.Rooms[1].Status)
+		assert.Equal(t, 1, report.Conversations)
+
+		user, err := f.userStore.GetUserByUsername(f.ctx, "alice.smith")
+		require.Nil(t, err)
+		assert.Nil(t, user)
+	})
+
+	var roomID string
+	t.Run("import", func(t *testing.T) {
+		report, err := NewSlackImporter(f.userStore, f.chatStore, SlackImportConfig{}).Import(f.ctx, export)
+		require.Nil(t, err)
+		assert.Equal(t, ImportCreated, report.Rooms[0].Status)
+		assert.Equal(t, 3, report.Rooms[0].Messages)
+		roomID = report.Rooms[0].RoomID
+
+		user, err := f.userStore.GetUserByIdentity(f.ctx, slackProviderName, "U1")
+		require.Nil(t, err)
+		assert.Equal(t, &UserWithoutSecrets{Name: "Alice Smith", Username: "alice.smith"}, user)
+
+		room, err := f.chatStore.GetRoomByID(f.ctx, roomID)
+		require.Nil(t, err)
+		assert.Equal(t, "general", room.Name)
+		assert.Equal(t, "Company wide", room.Topic)
+		assert.Equal(t, RoomPublicJoin, room.Visibility)
+		assert.NotNil(t, room.ArchivedAt)
+		ok, _, err := f.chatStore.IsRoomMember(f.ctx, roomID, member1.Username)
+		require.Nil(t, err)
+		assert.False(t, ok)
+		ok, role, err := f.chatStore.IsRoomMember(f.ctx, roomID, "member1-2")
+		require.Nil(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, Member, role)
+
+		var texts []Message
+		require.Nil(t, f.chatStore.StreamRoomMessages(f.ctx, roomID, func(m Message) error {
+			if m.Type == TextMessage {
+				texts = append(texts, m)
+			}
+			return nil
+		}))
+		require.Len(t, texts, 3)
+		assert.Equal(t, "[file: plan.pdf]", texts[0].Data)
+		assert.Equal(t, "first site (https://example.com)", texts[1].Data)
+		assert.Equal(t, "second & @alice.smith", texts[2].Data)
+		assert.Equal(t, "member1-2", texts[2].Sender)
+		assert.True(t, time.Unix(1577934001, 200000).Equal(texts[2].SentAt))
+
+		// the history is not unread
+		for _, username := range []string{"alice.smith", "member1-2"} {
+			assert.GreaterOrEqual(t, getRoomMemberByUsername(*room, username).LastMessageRead, texts[2].ID)
+		}
+	})
+
+	t.Run("run again", func(t *testing.T) {
+		report, err := NewSlackImporter(f.userStore, f.chatStore, SlackImportConfig{}).Import(f.ctx, export)
+		require.Nil(t, err)
+		assert.Equal(t, ImportExisting, report.Users[0].Status)
+		assert.Equal(t, ImportExisting, report.Rooms[0].Status)
+		assert.Equal(t, roomID, report.Rooms[0].RoomID)
+		assert.Equal(t, 0, report.Rooms[0].Members)
+		assert.Equal(t, 0, report.Rooms[0].Messages)
+		assert.Equal(t, 3, report.Rooms[0].Duplicates)
+
+		report, err = NewSlackImporter(f.userStore, f.chatStore, SlackImportConfig{DryRun: true}).Import(f.ctx, export)
+		require.Nil(t, err)
+		assert.Equal(t, 0, report.Rooms[0].Messages)
+		assert.Equal(t, 3, report.Rooms[0].Duplicates)
+	})
+
+	t.Run("invalid export", func(t *testing.T) {
+		export := slackExport(t, map[string]any{"users.json": map[string]any{}})
+		_, err := NewSlackImporter(f.userStore, f.chatStore, SlackImportConfig{DryRun: true}).Import(f.ctx, export)
+		assert.ErrorIs(t, err, ErrInvalidImport)
+	})
+}
+
+func TestSlackImportLinkExistingUsers(t *testing.T) {
+	f := NewChatFixture(t)
+	defer f.tearDown()
+	seedUsers(f.ctx, t, f.userStore, member1)
+
+	report, err := NewSlackImporter(f.userStore, f.chatStore, SlackImportConfig{LinkExistingUsers: true}).
+		Import(f.ctx, testSlackExport(t))
+	require.Nil(t, err)
+	assert.Equal(t, ImportedUser{ExternalID: "U2", ExternalName: "member1", Username: member1.Username,
+		Status: ImportLinked}, report.Users[1])
+	user, err := f.userStore.GetUserByIdentity(f.ctx, slackProviderName, "U2")
+	require.Nil(t, err)
+	require.NotNil(t, user)
+	assert.Equal(t, member1.Username, user.Username)
+}
+
+func TestConvertSlackText(t *testing.T) {
+	usernames := map[string]string{"U1": "alice"}
+	channels := map[string]string{"C1": "general"}
+	for text, want := range map[string]string{
+		"hi <@U1>":                     "hi @alice",
+		"hi <@U9|bob>":                 "hi @bob",
+		"see <#C1|old-name>":           "see #general",
+		"<!here> now":                  "@here now",
+		"<mailto:a@b.c|a@b.c>":         "a@b.c",
+		"<https://example.com>":        "https://example.com",
+		"1 &lt; 2 &amp;&amp; 3 &gt; 2": "1 < 2 && 3 > 2",
+		"&lt;@U1&gt; is not a mention": "<@U1> is not a mention",
+	} {
+		assert.Equal(t, want, convertSlackText(text, usernames, channels), text)
+	}
+}
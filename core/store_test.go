@@ -0,0 +1,31 @@
+package core_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/putto11262002/chatter/core"
+	"github.com/putto11262002/chatter/core/storetest"
+)
+
+func TestSQLStores(t *testing.T) {
+	storetest.Run(t, sqlStores(core.NewTestDB))
+}
+
+// TestSQLiteFileStores runs the suite with the reader and writer pools of a database file,
+// which an in memory database does not have.
+func TestSQLiteFileStores(t *testing.T) {
+	storetest.Run(t, sqlStores(core.NewTestSQLiteFile))
+}
+
+func sqlStores(newDB func(t *testing.T) *core.DB) storetest.Factory {
+	return func(t *testing.T, now func() time.Time) storetest.Stores {
+		db := newDB(t)
+		users := core.NewSQLUserStore(db)
+		return storetest.Stores{
+			Users: users,
+			Chats: core.NewSQLChatStore(db, users),
+			Auth:  core.NewSQLAuthStore(db, users, []byte("secret"), core.WithClock(now)),
+		}
+	}
+}
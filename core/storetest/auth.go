@@ -0,0 +1,119 @@
+package storetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/putto11262002/chatter/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RunAuthStore runs the conformance suite of core.AuthStore.
+func RunAuthStore(t *testing.T, newStores Factory) {
+	t.Run("new session", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice)
+
+		session, err := f.Auth.NewSession(f.ctx, alice.Username, alice.Password)
+		require.Nil(t, err)
+		require.NotNil(t, session)
+		assert.Equal(t, alice.Username, session.Username)
+		assert.NotEmpty(t, session.Token)
+		assert.True(t, session.ExpiresAt.After(time.Now()))
+
+		_, err = f.Auth.NewSession(f.ctx, alice.Username, "wrong password")
+		assert.Equal(t, core.ErrBadCredentials, err)
+		_, err = f.Auth.NewSession(f.ctx, "random", "password")
+		assert.Equal(t, core.ErrBadCredentials, err)
+	})
+
+	t.Run("session", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice)
+		issued, err := f.Auth.NewSession(f.ctx, alice.Username, alice.Password)
+		require.Nil(t, err)
+
+		session, err := f.Auth.Session(f.ctx, issued.Token)
+		require.Nil(t, err)
+		require.NotNil(t, session)
+		assert.Equal(t, alice.Username, session.Username)
+
+		_, err = f.Auth.Session(f.ctx, "random")
+		assert.Equal(t, core.ErrUnauthenticated, err)
+	})
+
+	t.Run("destroy session", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice)
+		first, err := f.Auth.NewSession(f.ctx, alice.Username, alice.Password)
+		require.Nil(t, err)
+		// a later session is not the same token
+		f.clock.Advance(time.Second)
+		second, err := f.Auth.NewSession(f.ctx, alice.Username, alice.Password)
+		require.Nil(t, err)
+
+		require.Nil(t, f.Auth.DestroySession(f.ctx, *first))
+		_, err = f.Auth.Session(f.ctx, first.Token)
+		assert.Equal(t, core.ErrUnauthenticated, err)
+		_, err = f.Auth.Session(f.ctx, second.Token)
+		assert.Nil(t, err)
+	})
+
+	t.Run("revoke sessions", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice, bob)
+		aliceSession, err := f.Auth.NewSession(f.ctx, alice.Username, alice.Password)
+		require.Nil(t, err)
+		bobSession, err := f.Auth.NewSession(f.ctx, bob.Username, bob.Password)
+		require.Nil(t, err)
+
+		require.Nil(t, f.Auth.RevokeSessions(f.ctx, alice.Username))
+		_, err = f.Auth.Session(f.ctx, aliceSession.Token)
+		assert.Equal(t, core.ErrUnauthenticated, err)
+		_, err = f.Auth.Session(f.ctx, bobSession.Token)
+		assert.Nil(t, err)
+
+		// sessions issued afterwards are valid
+		session, err := f.Auth.NewSession(f.ctx, alice.Username, alice.Password)
+		require.Nil(t, err)
+		_, err = f.Auth.Session(f.ctx, session.Token)
+		assert.Nil(t, err)
+	})
+
+	t.Run("issue session", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice)
+
+		session, err := f.Auth.IssueSession(f.ctx, alice.Username)
+		require.Nil(t, err)
+		assert.Equal(t, alice.Username, session.Username)
+		_, err = f.Auth.Session(f.ctx, session.Token)
+		assert.Nil(t, err)
+
+		_, err = f.Auth.IssueSession(f.ctx, "random")
+		assert.Equal(t, core.ErrInvalidUser, err)
+		require.Nil(t, f.Users.DeactivateUser(f.ctx, alice.Username))
+		_, err = f.Auth.IssueSession(f.ctx, alice.Username)
+		assert.Equal(t, core.ErrDeactivatedUser, err)
+	})
+
+	t.Run("lockout", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice)
+
+		// repeated failures delay the next attempt, even with the right password
+		var err error
+		for i := 0; i <= core.DefaultLockoutPolicy.FreeAttempts+1; i++ {
+			_, err = f.Auth.NewSession(f.ctx, alice.Username, "wrong password")
+		}
+		var locked *core.LockedError
+		require.ErrorAs(t, err, &locked)
+		_, err = f.Auth.NewSession(f.ctx, alice.Username, alice.Password)
+		require.ErrorAs(t, err, &locked)
+
+		require.Nil(t, f.Auth.Unlock(f.ctx, core.LockoutScopeUsername, alice.Username))
+		_, err = f.Auth.NewSession(f.ctx, alice.Username, alice.Password)
+		assert.Nil(t, err)
+	})
+}
@@ -0,0 +1,810 @@
+package storetest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/putto11262002/chatter/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RunChatStore runs the conformance suite of core.ChatStore.
+func RunChatStore(t *testing.T, newStores Factory) {
+	t.Run("rooms", func(t *testing.T) { testRooms(t, newStores) })
+	t.Run("membership", func(t *testing.T) { testMembership(t, newStores) })
+	t.Run("messages", func(t *testing.T) { testMessages(t, newStores) })
+	t.Run("ordering and pagination", func(t *testing.T) { testOrdering(t, newStores) })
+	t.Run("permissions", func(t *testing.T) { testPermissions(t, newStores) })
+	t.Run("invites", func(t *testing.T) { testInvites(t, newStores) })
+	t.Run("archiving", func(t *testing.T) { testArchiving(t, newStores) })
+	t.Run("concurrency", func(t *testing.T) { testConcurrency(t, newStores) })
+	t.Run("retention", func(t *testing.T) { testRetention(t, newStores) })
+	t.Run("import", func(t *testing.T) { testImport(t, newStores) })
+	t.Run("admin", func(t *testing.T) { testAdmin(t, newStores) })
+}
+
+func testRooms(t *testing.T, newStores Factory) {
+	t.Run("create room", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice)
+
+		roomID, err := f.Chats.CreateRoom(f.ctx, "General", alice.Username)
+		require.Nil(t, err)
+		require.NotEmpty(t, roomID)
+
+		room, err := f.Chats.GetRoomByID(f.ctx, roomID)
+		require.Nil(t, err)
+		require.NotNil(t, room)
+		assert.Equal(t, "General", room.Name)
+		assert.Equal(t, core.RoomPrivate, room.Visibility)
+		assert.Nil(t, room.ArchivedAt)
+		assert.Equal(t, []core.RoomMember{{Role: core.Owner, Username: alice.Username, RoomID: roomID,
+			LastMessageRead: room.LastMessageSent}}, room.Members)
+		// the creation is the first message of the room
+		assert.Equal(t, core.SystemMessage, room.LastMessageSentType)
+		assert.NotZero(t, room.LastMessageSent)
+		assert.False(t, room.LastMessageSentAt.IsZero())
+
+		_, err = f.Chats.CreateRoom(f.ctx, "General", "random")
+		assert.Equal(t, core.ErrInvalidUser, err)
+	})
+
+	t.Run("room does not exist", func(t *testing.T) {
+		f := newFixture(t, newStores)
+
+		room, err := f.Chats.GetRoomByID(f.ctx, "random")
+		require.Nil(t, err)
+		assert.Nil(t, room)
+
+		members, err := f.Chats.GetRoomMembers(f.ctx, "random")
+		require.Nil(t, err)
+		assert.Nil(t, members)
+
+		messages, err := f.Chats.GetRoomMessages(f.ctx, "random", 0, 0)
+		require.Nil(t, err)
+		assert.Nil(t, messages)
+	})
+
+	t.Run("update room", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice, bob)
+		roomID := f.seedRoom("General", alice, bob)
+
+		name := "Random"
+		message, err := f.Chats.UpdateRoom(f.ctx, roomID, alice.Username, core.UpdateRoomInput{Name: &name})
+		require.Nil(t, err)
+		require.NotNil(t, message)
+		assert.Equal(t, core.SystemMessage, message.Type)
+
+		room, err := f.Chats.GetRoomByID(f.ctx, roomID)
+		require.Nil(t, err)
+		assert.Equal(t, name, room.Name)
+
+		// nothing changed
+		message, err = f.Chats.UpdateRoom(f.ctx, roomID, alice.Username, core.UpdateRoomInput{Name: &name})
+		require.Nil(t, err)
+		assert.Nil(t, message)
+
+		_, err = f.Chats.UpdateRoom(f.ctx, roomID, bob.Username, core.UpdateRoomInput{Name: &name})
+		assert.Equal(t, core.ErrDisAllowedOperation, err)
+		_, err = f.Chats.UpdateRoom(f.ctx, roomID, carol.Username, core.UpdateRoomInput{Name: &name})
+		assert.Equal(t, core.ErrInvalidRoom, err)
+		empty := ""
+		_, err = f.Chats.UpdateRoom(f.ctx, roomID, alice.Username, core.UpdateRoomInput{Name: &empty})
+		assert.Equal(t, core.ErrInvalidRoomInput, err)
+	})
+
+	t.Run("delete room", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice, bob, carol)
+		roomID := f.seedRoom("General", alice, bob)
+		f.sendText(roomID, bob, "hello")
+
+		assert.Equal(t, core.ErrDisAllowedOperation, f.Chats.DeleteRoom(f.ctx, roomID, bob.Username))
+		assert.Equal(t, core.ErrInvalidRoom, f.Chats.DeleteRoom(f.ctx, roomID, carol.Username))
+		require.Nil(t, f.Chats.DeleteRoom(f.ctx, roomID, alice.Username))
+
+		room, err := f.Chats.GetRoomByID(f.ctx, roomID)
+		require.Nil(t, err)
+		assert.Nil(t, room)
+		rooms, err := f.Chats.GetUserRooms(f.ctx, bob.Username, 0, 0)
+		require.Nil(t, err)
+		assert.Empty(t, rooms)
+	})
+}
+
+func testMembership(t *testing.T, newStores Factory) {
+	t.Run("add room member", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice, bob, carol)
+		roomID := f.seedRoom("General", alice)
+
+		require.Nil(t, f.Chats.AddRoomMember(f.ctx, roomID, bob.Username, core.Member))
+		// adding a member again does nothing
+		require.Nil(t, f.Chats.AddRoomMember(f.ctx, roomID, bob.Username, core.Admin))
+
+		ok, role, err := f.Chats.IsRoomMember(f.ctx, roomID, bob.Username)
+		require.Nil(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, core.Member, role)
+		members, err := f.Chats.GetRoomMembers(f.ctx, roomID)
+		require.Nil(t, err)
+		assert.Len(t, members, 2)
+
+		assert.Equal(t, core.ErrInvalidUser, f.Chats.AddRoomMember(f.ctx, roomID, "random", core.Member))
+		assert.Equal(t, core.ErrInvalidRoom, f.Chats.AddRoomMember(f.ctx, "random", bob.Username, core.Member))
+		assert.Equal(t, core.ErrDisAllowedOperation, f.Chats.AddRoomMember(f.ctx, roomID, carol.Username, core.Owner))
+	})
+
+	t.Run("remove room member", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice, bob)
+		roomID := f.seedRoom("General", alice, bob)
+
+		assert.Equal(t, core.ErrDisAllowedOperation, f.Chats.RemoveRoomMember(f.ctx, roomID, alice.Username))
+		assert.Equal(t, core.ErrInvalidMember, f.Chats.RemoveRoomMember(f.ctx, roomID, "random"))
+		require.Nil(t, f.Chats.RemoveRoomMember(f.ctx, roomID, bob.Username))
+
+		ok, role, err := f.Chats.IsRoomMember(f.ctx, roomID, bob.Username)
+		require.Nil(t, err)
+		assert.False(t, ok)
+		assert.Zero(t, role)
+	})
+
+	t.Run("leave room", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice, bob, carol)
+		roomID := f.seedRoom("General", alice, bob)
+
+		_, _, err := f.Chats.LeaveRoom(f.ctx, roomID, carol.Username, "")
+		assert.Equal(t, core.ErrInvalidRoom, err)
+		_, _, err = f.Chats.LeaveRoom(f.ctx, roomID, alice.Username, "")
+		assert.Equal(t, core.ErrSuccessorRequired, err)
+		_, _, err = f.Chats.LeaveRoom(f.ctx, roomID, alice.Username, carol.Username)
+		assert.Equal(t, core.ErrInvalidMember, err)
+
+		deleted, transferred, err := f.Chats.LeaveRoom(f.ctx, roomID, alice.Username, bob.Username)
+		require.Nil(t, err)
+		assert.False(t, deleted)
+		assert.True(t, transferred)
+		_, role, err := f.Chats.IsRoomMember(f.ctx, roomID, bob.Username)
+		require.Nil(t, err)
+		assert.Equal(t, core.Owner, role)
+
+		// the last member takes the room with them
+		deleted, transferred, err = f.Chats.LeaveRoom(f.ctx, roomID, bob.Username, "")
+		require.Nil(t, err)
+		assert.True(t, deleted)
+		assert.False(t, transferred)
+		room, err := f.Chats.GetRoomByID(f.ctx, roomID)
+		require.Nil(t, err)
+		assert.Nil(t, room)
+	})
+
+	t.Run("friends", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice, bob, carol, dave)
+		f.seedRoom("General", alice, bob)
+		f.seedRoom("Random", carol, alice)
+
+		friends, err := f.Chats.GetFriends(f.ctx, alice.Username)
+		require.Nil(t, err)
+		assert.ElementsMatch(t, []string{bob.Username, carol.Username}, friends)
+
+		ok, err := f.Chats.AreFriends(f.ctx, bob.Username, alice.Username)
+		require.Nil(t, err)
+		assert.True(t, ok)
+		ok, err = f.Chats.AreFriends(f.ctx, bob.Username, dave.Username)
+		require.Nil(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func testMessages(t *testing.T, newStores Factory) {
+	t.Run("send message", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice, bob)
+		roomID := f.seedRoom("General", alice)
+
+		message := f.sendText(roomID, alice, "hello")
+		assert.NotZero(t, message.ID)
+		assert.Equal(t, core.TextMessage, message.Type)
+		assert.Equal(t, "hello", message.Data)
+		assert.Equal(t, alice.Username, message.Sender)
+		assert.Equal(t, roomID, message.RoomID)
+
+		room, err := f.Chats.GetRoomByID(f.ctx, roomID)
+		require.Nil(t, err)
+		assert.Equal(t, message.ID, room.LastMessageSent)
+		assert.Equal(t, "hello", room.LastMessageSentData)
+		assert.True(t, message.SentAt.Equal(room.LastMessageSentAt))
+		// the sender has read their own message
+		assert.Equal(t, message.ID, room.Members[0].LastMessageRead)
+
+		_, err = f.Chats.SendMessageToRoom(f.ctx, core.MessageCreateInput{
+			Type: core.TextMessage, Data: "hello", Sender: bob.Username, RoomID: roomID})
+		assert.Equal(t, core.ErrInvalidRoom, err)
+		_, err = f.Chats.SendMessageToRoom(f.ctx, core.MessageCreateInput{
+			Type: 100, Data: "hello", Sender: alice.Username, RoomID: roomID})
+		assert.Equal(t, core.ErrInvalidMessageType, err)
+		_, err = f.Chats.SendMessageToRoom(f.ctx, core.MessageCreateInput{})
+		assert.Equal(t, core.ErrInvalidMessage, err)
+	})
+
+	t.Run("delete message", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice, bob, carol)
+		roomID := f.seedRoom("General", alice, bob, carol)
+		first := f.sendText(roomID, bob, "first")
+		second := f.sendText(roomID, bob, "second")
+
+		// members delete their own messages, not those of other members
+		assert.Equal(t, core.ErrDisAllowedOperation, f.Chats.DeleteMessage(f.ctx, roomID, second.ID, carol.Username))
+		require.Nil(t, f.Chats.DeleteMessage(f.ctx, roomID, second.ID, bob.Username))
+		assert.Equal(t, core.ErrInvalidMessage, f.Chats.DeleteMessage(f.ctx, roomID, second.ID, bob.Username))
+
+		room, err := f.Chats.GetRoomByID(f.ctx, roomID)
+		require.Nil(t, err)
+		assert.Equal(t, first.ID, room.LastMessageSent)
+		assert.Equal(t, "first", room.LastMessageSentData)
+
+		// the owner may delete anyone's message
+		require.Nil(t, f.Chats.DeleteMessage(f.ctx, roomID, first.ID, alice.Username))
+		messages, err := f.Chats.GetRoomMessages(f.ctx, roomID, 0, 0)
+		require.Nil(t, err)
+		for _, message := range messages {
+			assert.Equal(t, core.SystemMessage, message.Type)
+		}
+
+		// system messages stay
+		assert.Equal(t, core.ErrDisAllowedOperation,
+			f.Chats.DeleteMessage(f.ctx, roomID, messages[0].ID, alice.Username))
+	})
+
+	t.Run("read messages", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice, bob, carol)
+		roomID := f.seedRoom("General", alice, bob)
+		f.sendText(roomID, alice, "hello")
+		last := f.sendText(roomID, alice, "anyone?")
+
+		read, readAt, err := f.Chats.ReadRoomMessages(f.ctx, roomID, bob.Username)
+		require.Nil(t, err)
+		assert.Equal(t, last.ID, read)
+		assert.False(t, readAt.IsZero())
+
+		_, _, err = f.Chats.ReadRoomMessages(f.ctx, roomID, carol.Username)
+		assert.Equal(t, core.ErrInvalidRoom, err)
+	})
+
+	t.Run("pins and bookmarks", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice, bob, carol)
+		roomID := f.seedRoom("General", alice, bob)
+		message := f.sendText(roomID, bob, "remember this")
+
+		_, err := f.Chats.PinMessage(f.ctx, roomID, message.ID, bob.Username)
+		assert.Equal(t, core.ErrDisAllowedOperation, err)
+		_, err = f.Chats.PinMessage(f.ctx, roomID, message.ID+100, alice.Username)
+		assert.Equal(t, core.ErrInvalidMessage, err)
+		pin, err := f.Chats.PinMessage(f.ctx, roomID, message.ID, alice.Username)
+		require.Nil(t, err)
+		assert.Equal(t, message.ID, pin.Message.ID)
+
+		pins, err := f.Chats.GetRoomPins(f.ctx, roomID)
+		require.Nil(t, err)
+		require.Len(t, pins, 1)
+		require.Nil(t, f.Chats.UnpinMessage(f.ctx, roomID, message.ID, alice.Username))
+		assert.Equal(t, core.ErrInvalidMessage, f.Chats.UnpinMessage(f.ctx, roomID, message.ID, alice.Username))
+
+		_, err = f.Chats.BookmarkMessage(f.ctx, carol.Username, message.ID, core.BookmarkInput{})
+		assert.Equal(t, core.ErrInvalidMessage, err)
+		_, err = f.Chats.BookmarkMessage(f.ctx, bob.Username, message.ID, core.BookmarkInput{Note: "todo"})
+		require.Nil(t, err)
+		bookmarks, err := f.Chats.GetBookmarks(f.ctx, bob.Username, 0, 0)
+		require.Nil(t, err)
+		require.Len(t, bookmarks, 1)
+		assert.Equal(t, "todo", bookmarks[0].Note)
+		require.Nil(t, f.Chats.RemoveBookmark(f.ctx, bob.Username, message.ID))
+		assert.Equal(t, core.ErrInvalidBookmark, f.Chats.RemoveBookmark(f.ctx, bob.Username, message.ID))
+	})
+}
+
+func testOrdering(t *testing.T, newStores Factory) {
+	t.Run("room messages", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice)
+		roomID := f.seedRoom("General", alice)
+		sent := make([]core.Message, 5)
+		for i := range sent {
+			sent[i] = f.sendText(roomID, alice, fmt.Sprintf("message %d", i))
+		}
+
+		// pages go back from the most recent message, and each page is in the order it was sent
+		page1, err := f.Chats.GetRoomMessages(f.ctx, roomID, 0, 2)
+		require.Nil(t, err)
+		assert.Equal(t, []int{sent[3].ID, sent[4].ID}, messageIDs(page1))
+		page2, err := f.Chats.GetRoomMessages(f.ctx, roomID, 2, 2)
+		require.Nil(t, err)
+		assert.Equal(t, []int{sent[1].ID, sent[2].ID}, messageIDs(page2))
+		// the creation of the room is the first message
+		page3, err := f.Chats.GetRoomMessages(f.ctx, roomID, 4, 2)
+		require.Nil(t, err)
+		require.Len(t, page3, 2)
+		assert.Equal(t, core.SystemMessage, page3[0].Type)
+		assert.Equal(t, sent[0].ID, page3[1].ID)
+		page4, err := f.Chats.GetRoomMessages(f.ctx, roomID, 6, 2)
+		require.Nil(t, err)
+		assert.Empty(t, page4)
+
+		for i := 1; i < len(sent); i++ {
+			assert.Greater(t, sent[i].ID, sent[i-1].ID)
+			assert.False(t, sent[i].SentAt.Before(sent[i-1].SentAt))
+		}
+	})
+
+	t.Run("user rooms", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice, bob)
+		first := f.seedRoom("First", alice)
+		second := f.seedRoom("Second", alice)
+		third := f.seedRoom("Third", alice)
+		f.seedRoom("Other", bob)
+
+		rooms, err := f.Chats.GetUserRooms(f.ctx, alice.Username, 0, 0)
+		require.Nil(t, err)
+		assert.Equal(t, []string{third, second, first}, roomIDs(rooms))
+		for _, room := range rooms {
+			require.NotNil(t, room.Preferences)
+			assert.Equal(t, core.NotifyAll, room.Preferences.NotificationLevel)
+		}
+
+		// a new message moves the room to the top
+		f.sendText(first, alice, "hello")
+		rooms, err = f.Chats.GetUserRooms(f.ctx, alice.Username, 0, 0)
+		require.Nil(t, err)
+		assert.Equal(t, []string{first, third, second}, roomIDs(rooms))
+
+		// pinned rooms come before the others
+		pinned := true
+		_, err = f.Chats.UpdateRoomPreferences(f.ctx, second, alice.Username,
+			core.UpdateRoomPreferencesInput{Pinned: &pinned})
+		require.Nil(t, err)
+		page1, err := f.Chats.GetUserRooms(f.ctx, alice.Username, 0, 2)
+		require.Nil(t, err)
+		assert.Equal(t, []string{second, first}, roomIDs(page1))
+		page2, err := f.Chats.GetUserRooms(f.ctx, alice.Username, 2, 2)
+		require.Nil(t, err)
+		assert.Equal(t, []string{third}, roomIDs(page2))
+	})
+
+	t.Run("room directory", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice, bob, carol)
+		small := f.seedRoom("Go Nuts", alice)
+		big := f.seedRoom("golang", alice, bob, carol)
+		f.seedRoom("Gophers", alice, bob)
+		for _, roomID := range []string{small, big} {
+			visibility := core.RoomPublicJoin
+			_, err := f.Chats.UpdateRoom(f.ctx, roomID, alice.Username, core.UpdateRoomInput{Visibility: &visibility})
+			require.Nil(t, err)
+		}
+
+		// private rooms are not listed, the most popular rooms come first, and search ignores case
+		entries, err := f.Chats.GetRoomDirectory(f.ctx, "GO", 0, 0)
+		require.Nil(t, err)
+		require.Len(t, entries, 2)
+		assert.Equal(t, big, entries[0].ID)
+		assert.Equal(t, 3, entries[0].MemberCount)
+		assert.Equal(t, small, entries[1].ID)
+
+		entries, err = f.Chats.GetRoomDirectory(f.ctx, "", 1, 1)
+		require.Nil(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, small, entries[0].ID)
+
+		entries, err = f.Chats.GetRoomDirectory(f.ctx, "nothing", 0, 0)
+		require.Nil(t, err)
+		assert.Nil(t, entries)
+	})
+}
+
+func testPermissions(t *testing.T, newStores Factory) {
+	t.Run("authorize", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice, bob, carol, dave)
+		roomID := f.seedRoom("General", alice, carol)
+		require.Nil(t, f.Chats.AddRoomMember(f.ctx, roomID, bob.Username, core.Admin))
+
+		role, err := f.Chats.Authorize(f.ctx, roomID, bob.Username, core.RemoveMembers)
+		require.Nil(t, err)
+		assert.Equal(t, core.Admin, role)
+		_, err = f.Chats.Authorize(f.ctx, roomID, carol.Username, core.RemoveMembers)
+		assert.Equal(t, core.ErrDisAllowedOperation, err)
+		_, err = f.Chats.Authorize(f.ctx, roomID, dave.Username, core.PostMessages)
+		assert.Equal(t, core.ErrInvalidRoom, err)
+
+		// the target has to be a member that the actor outranks
+		role, err = f.Chats.AuthorizeMember(f.ctx, roomID, bob.Username, carol.Username, core.RemoveMembers)
+		require.Nil(t, err)
+		assert.Equal(t, core.Member, role)
+		_, err = f.Chats.AuthorizeMember(f.ctx, roomID, bob.Username, alice.Username, core.RemoveMembers)
+		assert.Equal(t, core.ErrDisAllowedOperation, err)
+		_, err = f.Chats.AuthorizeMember(f.ctx, roomID, bob.Username, dave.Username, core.RemoveMembers)
+		assert.Equal(t, core.ErrInvalidMember, err)
+
+		assert.Nil(t, f.Chats.AuthorizeRoleAssignment(f.ctx, roomID, carol.Username, core.Member))
+		assert.Equal(t, core.ErrDisAllowedOperation, f.Chats.AuthorizeRoleAssignment(f.ctx, roomID, bob.Username, core.Admin))
+		assert.Equal(t, core.ErrInvalidPermission, f.Chats.AuthorizeRoleAssignment(f.ctx, roomID, alice.Username, "random"))
+	})
+
+	t.Run("room permissions", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice, bob)
+		roomID := f.seedRoom("General", alice, bob)
+
+		permissions, err := f.Chats.GetRoomPermissions(f.ctx, roomID)
+		require.Nil(t, err)
+		assert.ElementsMatch(t, core.DefaultRolePermissions[core.Member], permissions[core.Member])
+
+		require.Nil(t, f.Chats.SetRoomPermission(f.ctx, roomID, core.Member, core.PinMessages, true))
+		require.Nil(t, f.Chats.SetRoomPermission(f.ctx, roomID, core.Member, core.PostMessages, false))
+		_, err = f.Chats.Authorize(f.ctx, roomID, bob.Username, core.PinMessages)
+		assert.Nil(t, err)
+		_, err = f.Chats.SendMessageToRoom(f.ctx, core.MessageCreateInput{
+			Type: core.TextMessage, Data: "hello", Sender: bob.Username, RoomID: roomID})
+		assert.Equal(t, core.ErrDisAllowedOperation, err)
+
+		permissions, err = f.Chats.GetRoomPermissions(f.ctx, roomID)
+		require.Nil(t, err)
+		assert.ElementsMatch(t, []core.Permission{core.PinMessages}, permissions[core.Member])
+
+		assert.Equal(t, core.ErrDisAllowedOperation,
+			f.Chats.SetRoomPermission(f.ctx, roomID, core.Owner, core.PostMessages, false))
+		assert.Equal(t, core.ErrInvalidPermission,
+			f.Chats.SetRoomPermission(f.ctx, roomID, core.Member, "random", true))
+		assert.Equal(t, core.ErrInvalidRoom,
+			f.Chats.SetRoomPermission(f.ctx, "random", core.Member, core.PinMessages, true))
+	})
+
+	t.Run("roles", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice, bob, carol)
+		roomID := f.seedRoom("General", alice, bob)
+
+		require.Nil(t, f.Chats.UpdateMemberRole(f.ctx, roomID, bob.Username, core.Admin))
+		_, role, err := f.Chats.IsRoomMember(f.ctx, roomID, bob.Username)
+		require.Nil(t, err)
+		assert.Equal(t, core.Admin, role)
+
+		assert.Equal(t, core.ErrDisAllowedOperation, f.Chats.UpdateMemberRole(f.ctx, roomID, bob.Username, core.Owner))
+		assert.Equal(t, core.ErrInvalidMember, f.Chats.UpdateMemberRole(f.ctx, roomID, carol.Username, core.Admin))
+		assert.Equal(t, core.ErrInvalidPermission, f.Chats.UpdateMemberRole(f.ctx, roomID, bob.Username, "random"))
+
+		assert.Equal(t, core.ErrDisAllowedOperation, f.Chats.TransferOwnership(f.ctx, roomID, bob.Username, alice.Username))
+		assert.Equal(t, core.ErrInvalidMember, f.Chats.TransferOwnership(f.ctx, roomID, alice.Username, carol.Username))
+		assert.Equal(t, core.ErrInvalidRoom, f.Chats.TransferOwnership(f.ctx, roomID, carol.Username, alice.Username))
+		require.Nil(t, f.Chats.TransferOwnership(f.ctx, roomID, alice.Username, bob.Username))
+		_, role, err = f.Chats.IsRoomMember(f.ctx, roomID, bob.Username)
+		require.Nil(t, err)
+		assert.Equal(t, core.Owner, role)
+		_, role, err = f.Chats.IsRoomMember(f.ctx, roomID, alice.Username)
+		require.Nil(t, err)
+		assert.Equal(t, core.Admin, role)
+	})
+}
+
+func testInvites(t *testing.T, newStores Factory) {
+	f := newFixture(t, newStores)
+	f.seedUsers(alice, bob, carol, dave)
+	roomID := f.seedRoom("General", alice)
+
+	_, err := f.Chats.CreateInvite(f.ctx, roomID, dave.Username, core.CreateInviteInput{})
+	assert.Equal(t, core.ErrInvalidRoom, err)
+	invite, err := f.Chats.CreateInvite(f.ctx, roomID, alice.Username, core.CreateInviteInput{MaxUses: 2})
+	require.Nil(t, err)
+	assert.Equal(t, core.Member, invite.Role)
+
+	accepted, joined, err := f.Chats.AcceptInvite(f.ctx, invite.Code, bob.Username)
+	require.Nil(t, err)
+	assert.True(t, joined)
+	assert.Equal(t, 1, accepted.Uses)
+	// members do not use up the invite
+	accepted, joined, err = f.Chats.AcceptInvite(f.ctx, invite.Code, bob.Username)
+	require.Nil(t, err)
+	assert.False(t, joined)
+	assert.Equal(t, 1, accepted.Uses)
+	_, joined, err = f.Chats.AcceptInvite(f.ctx, invite.Code, carol.Username)
+	require.Nil(t, err)
+	assert.True(t, joined)
+	// the invite is used up
+	_, _, err = f.Chats.AcceptInvite(f.ctx, invite.Code, dave.Username)
+	assert.Equal(t, core.ErrInvalidInvite, err)
+	_, _, err = f.Chats.AcceptInvite(f.ctx, "random", carol.Username)
+	assert.Equal(t, core.ErrInvalidInvite, err)
+
+	uses, err := f.Chats.GetInviteUses(f.ctx, invite.Code)
+	require.Nil(t, err)
+	require.Len(t, uses, 2)
+	assert.Equal(t, bob.Username, uses[0].Username)
+	assert.Equal(t, carol.Username, uses[1].Username)
+
+	require.Nil(t, f.Chats.RevokeInvite(f.ctx, roomID, invite.Code))
+	assert.Equal(t, core.ErrInvalidInvite, f.Chats.RevokeInvite(f.ctx, roomID, invite.Code))
+	invites, err := f.Chats.GetRoomInvites(f.ctx, roomID)
+	require.Nil(t, err)
+	require.Len(t, invites, 1)
+	assert.NotNil(t, invites[0].RevokedAt)
+}
+
+func testArchiving(t *testing.T, newStores Factory) {
+	f := newFixture(t, newStores)
+	f.seedUsers(alice, bob, carol)
+	roomID := f.seedRoom("General", alice, bob)
+
+	assert.Equal(t, core.ErrDisAllowedOperation, f.Chats.ArchiveRoom(f.ctx, roomID, bob.Username, true))
+	require.Nil(t, f.Chats.ArchiveRoom(f.ctx, roomID, alice.Username, true))
+
+	_, err := f.Chats.SendMessageToRoom(f.ctx, core.MessageCreateInput{
+		Type: core.TextMessage, Data: "hello", Sender: bob.Username, RoomID: roomID})
+	assert.Equal(t, core.ErrArchivedRoom, err)
+	_, err = f.Chats.Authorize(f.ctx, roomID, alice.Username, core.ArchiveRoom)
+	assert.Nil(t, err)
+
+	rooms, err := f.Chats.GetUserRooms(f.ctx, bob.Username, 0, 0)
+	require.Nil(t, err)
+	assert.Empty(t, rooms)
+	rooms, err = f.Chats.GetArchivedUserRooms(f.ctx, bob.Username, 0, 0)
+	require.Nil(t, err)
+	assert.Equal(t, []string{roomID}, roomIDs(rooms))
+	require.NotNil(t, rooms[0].ArchivedAt)
+
+	require.Nil(t, f.Chats.ArchiveRoom(f.ctx, roomID, alice.Username, false))
+	f.sendText(roomID, bob, "hello again")
+}
+
+func testConcurrency(t *testing.T, newStores Factory) {
+	t.Run("send messages", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		senders := []core.User{alice, bob, carol, dave}
+		f.seedUsers(senders...)
+		roomID := f.seedRoom("General", alice, bob, carol, dave)
+
+		const perSender = 10
+		var wg sync.WaitGroup
+		errs := make(chan error, len(senders)*perSender)
+		for _, sender := range senders {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < perSender; i++ {
+					_, err := f.Chats.SendMessageToRoom(f.ctx, core.MessageCreateInput{
+						Type: core.TextMessage, Data: fmt.Sprintf("%s %d", sender.Username, i),
+						Sender: sender.Username, RoomID: roomID})
+					errs <- err
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			require.Nil(t, err)
+		}
+
+		messages, err := f.Chats.GetRoomMessages(f.ctx, roomID, 0, len(senders)*perSender)
+		require.Nil(t, err)
+		require.Len(t, messages, len(senders)*perSender)
+		seen := make(map[int]bool)
+		for i, message := range messages {
+			assert.Equal(t, core.TextMessage, message.Type)
+			assert.False(t, seen[message.ID], "duplicate message ID %d", message.ID)
+			seen[message.ID] = true
+			if i > 0 {
+				assert.Greater(t, message.ID, messages[i-1].ID)
+			}
+		}
+
+		room, err := f.Chats.GetRoomByID(f.ctx, roomID)
+		require.Nil(t, err)
+		assert.Equal(t, messages[len(messages)-1].ID, room.LastMessageSent)
+	})
+
+	t.Run("accept invite", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice)
+		roomID := f.seedRoom("General", alice)
+		invite, err := f.Chats.CreateInvite(f.ctx, roomID, alice.Username, core.CreateInviteInput{MaxUses: 2})
+		require.Nil(t, err)
+
+		users := make([]core.User, 6)
+		for i := range users {
+			users[i] = core.User{Username: fmt.Sprintf("user%d", i), Password: "password", Name: "User"}
+		}
+		f.seedUsers(users...)
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		joined := 0
+		for _, user := range users {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, ok, err := f.Chats.AcceptInvite(f.ctx, invite.Code, user.Username)
+				if err != nil {
+					assert.Equal(t, core.ErrInvalidInvite, err)
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				if ok {
+					joined++
+				}
+			}()
+		}
+		wg.Wait()
+
+		// the invite is never used more than it allows
+		assert.Equal(t, 2, joined)
+		members, err := f.Chats.GetRoomMembers(f.ctx, roomID)
+		require.Nil(t, err)
+		assert.Len(t, members, 3)
+		uses, err := f.Chats.GetInviteUses(f.ctx, invite.Code)
+		require.Nil(t, err)
+		assert.Len(t, uses, 2)
+	})
+}
+
+func testRetention(t *testing.T, newStores Factory) {
+	t.Run("purge expired messages", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice, bob)
+		roomID := f.seedRoom("General", alice, bob)
+		heldID := f.seedRoom("Held", alice, bob)
+		var sent []core.Message
+		for i := range 4 {
+			sent = append(sent, f.sendText(roomID, bob, fmt.Sprintf("%d", i)))
+			f.sendText(heldID, bob, fmt.Sprintf("%d", i))
+		}
+		require.Nil(t, f.Chats.SetLegalHold(f.ctx, heldID, true))
+		held, err := f.Chats.GetRoomMessages(f.ctx, heldID, 0, 100)
+		require.Nil(t, err)
+
+		purges, err := f.Chats.PurgeExpiredMessages(f.ctx, core.RetentionPolicy{Messages: 2}, time.Now(), 0)
+		require.Nil(t, err)
+		require.Len(t, purges, 1)
+		assert.Equal(t, roomID, purges[0].RoomID)
+		assert.Equal(t, sent[1].ID, purges[0].ThroughID)
+
+		messages, err := f.Chats.GetRoomMessages(f.ctx, roomID, 0, 100)
+		require.Nil(t, err)
+		assert.Equal(t, []int{sent[2].ID, sent[3].ID}, messageIDs(messages))
+		room, err := f.Chats.GetRoomByID(f.ctx, roomID)
+		require.Nil(t, err)
+		assert.Equal(t, sent[3].ID, room.LastMessageSent)
+		again, err := f.Chats.GetRoomMessages(f.ctx, heldID, 0, 100)
+		require.Nil(t, err)
+		assert.Equal(t, messageIDs(held), messageIDs(again))
+
+		recorded, err := f.Chats.GetPurges(f.ctx, time.Now().Add(-time.Minute))
+		require.Nil(t, err)
+		require.Len(t, recorded, 1)
+		assert.Equal(t, purges[0].ID, recorded[0].ID)
+		assert.Equal(t, purges[0].Messages, recorded[0].Messages)
+	})
+}
+
+func testImport(t *testing.T, newStores Factory) {
+	t.Run("import messages", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice, bob)
+		roomID := f.seedRoom("General", alice, bob)
+		require.Nil(t, f.Chats.LinkImportedRoom(f.ctx, "test", "C1", roomID))
+		imported, err := f.Chats.GetImportedRoom(f.ctx, "test", "C1")
+		require.Nil(t, err)
+		assert.Equal(t, roomID, imported)
+		f.sendText(roomID, alice, "hello")
+
+		sentAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		messages := []core.ImportMessage{
+			{ExternalID: "1", Sender: alice.Username, Data: "first", SentAt: sentAt},
+			{ExternalID: "2", Sender: bob.Username, Data: "second", SentAt: sentAt.Add(time.Second)},
+		}
+		n, err := f.Chats.ImportMessages(f.ctx, roomID, "test", messages)
+		require.Nil(t, err)
+		assert.Equal(t, 2, n)
+		// importing again adds nothing
+		n, err = f.Chats.ImportMessages(f.ctx, roomID, "test", messages)
+		require.Nil(t, err)
+		assert.Equal(t, 0, n)
+		count, err := f.Chats.CountImportedMessages(f.ctx, "test", []string{"1", "2", "3"})
+		require.Nil(t, err)
+		assert.Equal(t, 2, count)
+
+		got, err := f.Chats.GetRoomMessages(f.ctx, roomID, 0, 100)
+		require.Nil(t, err)
+		last := got[len(got)-1]
+		assert.Equal(t, "second", last.Data)
+		assert.Equal(t, bob.Username, last.Sender)
+		assert.True(t, sentAt.Add(time.Second).Equal(last.SentAt))
+
+		// the history is older than what was sent in the room, so it does not become the last message
+		room, err := f.Chats.GetRoomByID(f.ctx, roomID)
+		require.Nil(t, err)
+		assert.Equal(t, "hello", room.LastMessageSentData)
+
+		_, err = f.Chats.ImportMessages(f.ctx, roomID, "test", []core.ImportMessage{
+			{ExternalID: "3", Sender: "nobody", Data: "third", SentAt: sentAt}})
+		assert.Equal(t, core.ErrInvalidUser, err)
+	})
+}
+
+func testAdmin(t *testing.T, newStores Factory) {
+	t.Run("get rooms", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice, bob)
+		// private rooms the caller is not in are listed too
+		general := f.seedRoom("General", alice)
+		random := f.seedRoom("Random", bob)
+
+		rooms, err := f.Chats.GetRooms(f.ctx, "", 0, 0)
+		require.Nil(t, err)
+		require.Len(t, rooms, 2)
+		assert.Equal(t, general, rooms[0].ID)
+		assert.Equal(t, 1, rooms[0].MemberCount)
+		rooms, err = f.Chats.GetRooms(f.ctx, "rand", 0, 0)
+		require.Nil(t, err)
+		require.Len(t, rooms, 1)
+		assert.Equal(t, random, rooms[0].ID)
+	})
+
+	t.Run("force delete room", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice, bob)
+		roomID := f.seedRoom("General", alice, bob)
+		f.sendText(roomID, bob, "hello")
+
+		require.Nil(t, f.Chats.ForceDeleteRoom(f.ctx, roomID))
+		room, err := f.Chats.GetRoomByID(f.ctx, roomID)
+		require.Nil(t, err)
+		assert.Nil(t, room)
+		assert.Equal(t, core.ErrInvalidRoom, f.Chats.ForceDeleteRoom(f.ctx, roomID))
+	})
+
+	t.Run("instance stats", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice, bob)
+		require.Nil(t, f.Users.SetAdmin(f.ctx, alice.Username, true))
+		require.Nil(t, f.Users.DeactivateUser(f.ctx, bob.Username))
+		roomID := f.seedRoom("General", alice)
+		f.sendText(roomID, alice, "hello")
+
+		stats, err := f.Chats.GetInstanceStats(f.ctx)
+		require.Nil(t, err)
+		assert.Equal(t, 2, stats.Users)
+		assert.Equal(t, 1, stats.DeactivatedUsers)
+		assert.Equal(t, 1, stats.Admins)
+		assert.Equal(t, 1, stats.Rooms)
+		// the room was created with a system message
+		assert.Equal(t, 2, stats.Messages)
+		assert.Equal(t, 2, stats.MessagesToday)
+	})
+}
+
+func messageIDs(messages []core.Message) []int {
+	ids := make([]int, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+	}
+	return ids
+}
+
+func roomIDs(rooms []core.Room) []string {
+	ids := make([]string, 0, len(rooms))
+	for _, room := range rooms {
+		ids = append(ids, room.ID)
+	}
+	return ids
+}
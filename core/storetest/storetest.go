@@ -0,0 +1,117 @@
+// Package storetest is a conformance suite for implementations of the stores of package core.
+//
+// Every backend, and every decorator wrapping one, is expected to pass it:
+//
+//	func TestStores(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T, now func() time.Time) storetest.Stores {
+//			db := openEmptyDatabase(t)
+//			users := NewUserStore(db)
+//			return storetest.Stores{Users: users, Chats: NewChatStore(db, users), Auth: NewAuthStore(db, users, now)}
+//		})
+//	}
+package storetest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/putto11262002/chatter/core"
+)
+
+// Stores are the stores under test. They share one database, so that the users
+// of Users are the users of Chats and Auth.
+type Stores struct {
+	Users core.UserStore
+	Chats core.ChatStore
+	// Auth authenticates the users of Users by their password
+	// and throttles failed sign ins with core.DefaultLockoutPolicy.
+	// It tells the time with the clock given to the Factory.
+	Auth core.AuthStore
+}
+
+// Factory returns stores backed by an empty database for a test, whose auth store tells the time
+// with now, e.g. through core.WithClock. The suite moves the clock instead of sleeping.
+// It registers whatever has to be released with t.Cleanup.
+// Factory is called once per test, never concurrently.
+type Factory func(t *testing.T, now func() time.Time) Stores
+
+// Run runs the conformance suite of every store.
+func Run(t *testing.T, newStores Factory) {
+	t.Run("UserStore", func(t *testing.T) { RunUserStore(t, newStores) })
+	t.Run("ChatStore", func(t *testing.T) { RunChatStore(t, newStores) })
+	t.Run("AuthStore", func(t *testing.T) { RunAuthStore(t, newStores) })
+}
+
+var (
+	alice = core.User{Username: "alice", Password: "password", Name: "Alice"}
+	bob   = core.User{Username: "bob", Password: "password", Name: "Bob"}
+	carol = core.User{Username: "carol", Password: "password", Name: "Carol"}
+	dave  = core.User{Username: "dave", Password: "password", Name: "Dave"}
+)
+
+// clock is the time the stores of a test see. It only moves when the test moves it.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// fixture is the state of a single test of the suite.
+type fixture struct {
+	Stores
+	t     *testing.T
+	ctx   context.Context
+	clock *clock
+}
+
+func newFixture(t *testing.T, newStores Factory) *fixture {
+	c := &clock{now: time.Now()}
+	return &fixture{Stores: newStores(t, c.Now), t: t, ctx: context.Background(), clock: c}
+}
+
+func (f *fixture) seedUsers(users ...core.User) {
+	f.t.Helper()
+	for _, user := range users {
+		if err := f.Users.CreateUser(f.ctx, user); err != nil {
+			f.t.Fatalf("CreateUser(%s): %v", user.Username, err)
+		}
+	}
+}
+
+// seedRoom creates a room owned by owner with the members given the Member role.
+func (f *fixture) seedRoom(name string, owner core.User, members ...core.User) string {
+	f.t.Helper()
+	roomID, err := f.Chats.CreateRoom(f.ctx, name, owner.Username)
+	if err != nil {
+		f.t.Fatalf("CreateRoom(%s): %v", name, err)
+	}
+	for _, member := range members {
+		if err := f.Chats.AddRoomMember(f.ctx, roomID, member.Username, core.Member); err != nil {
+			f.t.Fatalf("AddRoomMember(%s): %v", member.Username, err)
+		}
+	}
+	return roomID
+}
+
+func (f *fixture) sendText(roomID string, sender core.User, data string) core.Message {
+	f.t.Helper()
+	message, err := f.Chats.SendMessageToRoom(f.ctx, core.MessageCreateInput{
+		Type: core.TextMessage, Data: data, Sender: sender.Username, RoomID: roomID})
+	if err != nil {
+		f.t.Fatalf("SendMessageToRoom: %v", err)
+	}
+	return *message
+}
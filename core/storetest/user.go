@@ -0,0 +1,240 @@
+package storetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/putto11262002/chatter/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RunUserStore runs the conformance suite of core.UserStore.
+func RunUserStore(t *testing.T, newStores Factory) {
+	t.Run("create user", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice)
+
+		user, err := f.Users.GetUserByUsername(f.ctx, alice.Username)
+		require.Nil(t, err)
+		require.NotNil(t, user)
+		assert.Equal(t, core.UserWithoutSecrets{Username: alice.Username, Name: alice.Name}, *user)
+
+		err = f.Users.CreateUser(f.ctx, alice)
+		assert.Equal(t, core.ErrConflictedUser, err)
+
+		user, err = f.Users.GetUserByUsername(f.ctx, "random")
+		require.Nil(t, err)
+		assert.Nil(t, user)
+	})
+
+	t.Run("get users by usernames", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice, bob, carol)
+
+		users, err := f.Users.GetUsersByUsernames(f.ctx, alice.Username, carol.Username, "random")
+		require.Nil(t, err)
+		assert.ElementsMatch(t, []core.UserWithoutSecrets{
+			{Username: alice.Username, Name: alice.Name},
+			{Username: carol.Username, Name: carol.Name},
+		}, users)
+
+		users, err = f.Users.GetUsersByUsernames(f.ctx)
+		require.Nil(t, err)
+		assert.Empty(t, users)
+	})
+
+	t.Run("compare password", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice)
+
+		ok, err := f.Users.ComparePassword(f.ctx, alice.Username, alice.Password)
+		require.Nil(t, err)
+		assert.True(t, ok)
+
+		ok, err = f.Users.ComparePassword(f.ctx, alice.Username, "wrong password")
+		require.Nil(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("update user", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice)
+
+		updated, err := f.Users.UpdateUser(f.ctx, alice.Username, core.UpdateUserInput{Name: "Alice Doe"})
+		require.Nil(t, err)
+		assert.Equal(t, "Alice Doe", updated.Name)
+
+		user, err := f.Users.GetUserByUsername(f.ctx, alice.Username)
+		require.Nil(t, err)
+		assert.Equal(t, "Alice Doe", user.Name)
+
+		_, err = f.Users.UpdateUser(f.ctx, "random", core.UpdateUserInput{Name: "Nobody"})
+		assert.Equal(t, core.ErrInvalidUser, err)
+	})
+
+	t.Run("link identity", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice, bob)
+		identity := core.Identity{Provider: "oidc", Subject: "1234", Username: alice.Username}
+
+		user, err := f.Users.GetUserByIdentity(f.ctx, identity.Provider, identity.Subject)
+		require.Nil(t, err)
+		assert.Nil(t, user)
+
+		require.Nil(t, f.Users.LinkIdentity(f.ctx, identity))
+		// linking the identity to the same user again does nothing
+		require.Nil(t, f.Users.LinkIdentity(f.ctx, identity))
+
+		user, err = f.Users.GetUserByIdentity(f.ctx, identity.Provider, identity.Subject)
+		require.Nil(t, err)
+		require.NotNil(t, user)
+		assert.Equal(t, alice.Username, user.Username)
+
+		identity.Username = bob.Username
+		assert.Equal(t, core.ErrConflictedIdentity, f.Users.LinkIdentity(f.ctx, identity))
+		identity.Username = "random"
+		assert.Equal(t, core.ErrInvalidUser, f.Users.LinkIdentity(f.ctx, identity))
+	})
+
+	t.Run("reset password", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice)
+
+		older, _, err := f.Users.CreatePasswordResetToken(f.ctx, alice.Username, time.Minute)
+		require.Nil(t, err)
+		token, expiresAt, err := f.Users.CreatePasswordResetToken(f.ctx, alice.Username, time.Minute)
+		require.Nil(t, err)
+		assert.True(t, expiresAt.After(time.Now()))
+
+		require.Nil(t, f.Users.ResetPassword(f.ctx, token, "new password"))
+		ok, err := f.Users.ComparePassword(f.ctx, alice.Username, "new password")
+		require.Nil(t, err)
+		assert.True(t, ok)
+
+		// tokens are single use and a reset uses up the older ones
+		assert.Equal(t, core.ErrInvalidResetToken, f.Users.ResetPassword(f.ctx, token, "another password"))
+		assert.Equal(t, core.ErrInvalidResetToken, f.Users.ResetPassword(f.ctx, older, "another password"))
+
+		expired, _, err := f.Users.CreatePasswordResetToken(f.ctx, alice.Username, -time.Minute)
+		require.Nil(t, err)
+		assert.Equal(t, core.ErrInvalidResetToken, f.Users.ResetPassword(f.ctx, expired, "another password"))
+
+		_, _, err = f.Users.CreatePasswordResetToken(f.ctx, "random", time.Minute)
+		assert.Equal(t, core.ErrInvalidUser, err)
+	})
+
+	t.Run("deactivate user", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice)
+
+		require.Nil(t, f.Users.DeactivateUser(f.ctx, alice.Username))
+		_, err := f.Auth.NewSession(f.ctx, alice.Username, alice.Password)
+		assert.Equal(t, core.ErrDeactivatedUser, err)
+
+		require.Nil(t, f.Users.ReactivateUser(f.ctx, alice.Username))
+		_, err = f.Auth.NewSession(f.ctx, alice.Username, alice.Password)
+		assert.Nil(t, err)
+
+		assert.Equal(t, core.ErrInvalidUser, f.Users.DeactivateUser(f.ctx, "random"))
+		assert.Equal(t, core.ErrInvalidUser, f.Users.ReactivateUser(f.ctx, "random"))
+	})
+
+	t.Run("delete user", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice, bob)
+		shared := f.seedRoom("Shared", alice, bob)
+		solo := f.seedRoom("Solo", bob)
+		f.sendText(shared, bob, "hello")
+
+		// the owner cannot leave the other members of a room behind
+		assert.Equal(t, core.ErrDisAllowedOperation, f.Users.DeleteUser(f.ctx, alice.Username))
+		assert.Equal(t, core.ErrInvalidUser, f.Users.DeleteUser(f.ctx, "random"))
+
+		require.Nil(t, f.Users.DeleteUser(f.ctx, bob.Username))
+
+		user, err := f.Users.GetUserByUsername(f.ctx, bob.Username)
+		require.Nil(t, err)
+		assert.Nil(t, user)
+
+		messages, err := f.Chats.GetRoomMessages(f.ctx, shared, 0, 0)
+		require.Nil(t, err)
+		var sent core.Message
+		for _, message := range messages {
+			assert.NotEqual(t, bob.Username, message.Sender)
+			assert.NotContains(t, message.Data, bob.Username)
+			if message.Type == core.TextMessage {
+				sent = message
+			}
+		}
+		require.Equal(t, "hello", sent.Data)
+		sender, err := f.Users.GetUserByUsername(f.ctx, sent.Sender)
+		require.Nil(t, err)
+		require.NotNil(t, sender)
+		assert.Equal(t, core.DeletedUserName, sender.Name)
+
+		room, err := f.Chats.GetRoomByID(f.ctx, solo)
+		require.Nil(t, err)
+		assert.Nil(t, room)
+
+		// the username can be registered again
+		f.seedUsers(bob)
+	})
+
+	t.Run("get users", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice, bob, carol)
+		require.Nil(t, f.Users.DeactivateUser(f.ctx, carol.Username))
+
+		users, err := f.Users.GetUsers(f.ctx, nil)
+		require.Nil(t, err)
+		require.Len(t, users, 3)
+		assert.Equal(t, alice.Username, users[0].Username)
+		assert.NotNil(t, users[2].DeactivatedAt)
+
+		users, err = f.Users.GetUsers(f.ctx, &core.GetUsersOptions{Query: "BO"})
+		require.Nil(t, err)
+		require.Len(t, users, 1)
+		assert.Equal(t, bob.Username, users[0].Username)
+
+		users, err = f.Users.GetUsers(f.ctx, &core.GetUsersOptions{Offset: 1, Limit: 1})
+		require.Nil(t, err)
+		require.Len(t, users, 1)
+		assert.Equal(t, bob.Username, users[0].Username)
+	})
+
+	t.Run("set admin", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice)
+
+		require.Nil(t, f.Users.SetAdmin(f.ctx, alice.Username, true))
+		user, err := f.Users.GetUserAccount(f.ctx, alice.Username)
+		require.Nil(t, err)
+		assert.True(t, user.Admin)
+		require.Nil(t, f.Users.SetAdmin(f.ctx, alice.Username, false))
+		user, err = f.Users.GetUserAccount(f.ctx, alice.Username)
+		require.Nil(t, err)
+		assert.False(t, user.Admin)
+
+		assert.Equal(t, core.ErrInvalidUser, f.Users.SetAdmin(f.ctx, "random", true))
+		user, err = f.Users.GetUserAccount(f.ctx, "random")
+		require.Nil(t, err)
+		assert.Nil(t, user)
+	})
+
+	t.Run("set password", func(t *testing.T) {
+		f := newFixture(t, newStores)
+		f.seedUsers(alice)
+		session, err := f.Auth.NewSession(f.ctx, alice.Username, alice.Password)
+		require.Nil(t, err)
+
+		require.Nil(t, f.Users.SetPassword(f.ctx, alice.Username, "new password"))
+		ok, err := f.Users.ComparePassword(f.ctx, alice.Username, "new password")
+		require.Nil(t, err)
+		assert.True(t, ok)
+		_, err = f.Auth.Session(f.ctx, session.Token)
+		assert.Equal(t, core.ErrUnauthenticated, err)
+
+		assert.Equal(t, core.ErrInvalidUser, f.Users.SetPassword(f.ctx, "random", "new password"))
+	})
+}
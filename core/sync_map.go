@@ -42,6 +42,26 @@ func (s *SyncMap[K, V]) Delete(key K) {
 	delete(s.m, key)
 }
 
+// LoadAndDelete deletes the value for a key and returns it, so that only one caller gets it.
+func (s *SyncMap[K, V]) LoadAndDelete(key K) (value V, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok = s.m[key]
+	delete(s.m, key)
+	return
+}
+
+// DeleteFunc deletes the entries for which f returns true.
+func (s *SyncMap[K, V]) DeleteFunc(f func(key K, value V) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, v := range s.m {
+		if f(k, v) {
+			delete(s.m, k)
+		}
+	}
+}
+
 func (s *SyncMap[K, V]) RRange(f func(key K, value V) bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -0,0 +1,60 @@
+package core
+
+import "context"
+
+// Keys of system messages, used by clients to render them.
+const (
+	// SystemRoomCreated is recorded when the actor creates the room.
+	SystemRoomCreated = "room_created"
+	// SystemRoomUpdated is recorded when the actor changes the metadata of the room.
+	// Fields holds the new values.
+	SystemRoomUpdated = "room_updated"
+	// SystemRoomArchived is recorded when the actor archives the room.
+	SystemRoomArchived = "room_archived"
+	// SystemRoomUnarchived is recorded when the actor restores the room from the archive.
+	SystemRoomUnarchived = "room_unarchived"
+	// SystemMemberAdded is recorded when the actor adds the target with Role.
+	// The actor and the target are the same when a user joins by themselves.
+	SystemMemberAdded = "member_added"
+	// SystemMemberRemoved is recorded when the actor removes the target.
+	SystemMemberRemoved = "member_removed"
+	// SystemMemberLeft is recorded when the actor leaves the room.
+	SystemMemberLeft = "member_left"
+	// SystemMemberRoleChanged is recorded when the actor gives the target Role.
+	SystemMemberRoleChanged = "member_role_changed"
+	// SystemOwnershipTransferred is recorded when the actor makes the target the owner.
+	SystemOwnershipTransferred = "ownership_transferred"
+)
+
+// SystemMessageBody is the structured content of a SystemMessage.
+type SystemMessageBody struct {
+	// Key identifies what happened.
+	Key string `json:"key"`
+	// Actor is the user who made the change. It is empty if nobody made it,
+	// e.g. when a background sync removes a member.
+	Actor string `json:"actor,omitempty"`
+	// Target is the member the change was made to, if any.
+	Target string `json:"target,omitempty"`
+	// Role is the role the target was given, if any.
+	Role MemberRole `json:"role,omitempty"`
+	// Fields holds the new values of the fields that were changed, keyed by their JSON name.
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+type actorKey struct{}
+
+// ContextWithActor attaches the user on whose behalf the request is made to the context.
+// Store methods that do not take the actor as an argument, such as ChatStore.AddRoomMember,
+// use it to attribute the system messages they record.
+func ContextWithActor(ctx context.Context, username string) context.Context {
+	return context.WithValue(ctx, actorKey{}, username)
+}
+
+// ActorFromContext returns the user attached by ContextWithActor,
+// or fallback if there is none, e.g. for changes made by a background sync.
+func ActorFromContext(ctx context.Context, fallback string) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return fallback
+}
@@ -15,9 +15,21 @@ var (
 
 type AuthClaims struct {
 	Username string
+	// Purpose restricts what the token can be used for.
+	// Session tokens have no purpose.
+	Purpose string `json:"purpose,omitempty"`
+	// Version is the token version of the user when the token was issued.
+	// Bumping the version of a user revokes all of their tokens.
+	Version int `json:"ver,omitempty"`
 	jwt.RegisteredClaims
 }
 
+const totpChallengePurpose = "totp_challenge"
+
+// totpChallengeKeyID is the kid of the key that signs TOTP challenges.
+// Sessions are never verified with that key, so a challenge is never accepted as a session.
+const totpChallengeKeyID = "totp-challenge"
+
 func NewClaim(user UserWithoutSecrets, exp time.Time) *AuthClaims {
 	return &AuthClaims{
 		Username: user.Username,
@@ -30,26 +42,27 @@ func NewClaim(user UserWithoutSecrets, exp time.Time) *AuthClaims {
 
 func NewToken(user UserWithoutSecrets, expiration time.Duration, secret []byte) (string, time.Time, error) {
 	exp := time.Now().Add(expiration)
-	claims := NewClaim(user, exp)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	signed, err := token.SignedString(secret)
-	if err != nil {
-		return signed, exp, err
-	}
-
+	signed, err := signToken(NewClaim(user, exp), secret)
 	return signed, exp, err
 }
 
+func signToken(claims *AuthClaims, secret []byte) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(secret)
+}
+
 func VerifyToken(token string, secret []byte) (*AuthClaims, error) {
+	return verifyToken(token, func(token *jwt.Token) (interface{}, error) {
+		return secret, nil
+	}, jwt.SigningMethodHS256.Name)
+}
 
+func verifyToken(token string, keyFunc jwt.Keyfunc, methods ...string) (*AuthClaims, error) {
 	claims := &AuthClaims{}
-	_token, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		return secret, nil
-	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}))
+	_token, err := jwt.ParseWithClaims(token, claims, keyFunc, jwt.WithValidMethods(methods))
 
 	switch {
-	case _token.Valid:
+	case err == nil && _token.Valid:
 		return claims, nil
 	case errors.Is(err, jwt.ErrTokenMalformed):
 		return nil, ErrTokenInvalid
@@ -0,0 +1,182 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	totpIssuer = "Chatter"
+	totpDigits = 6
+	totpPeriod = 30 * time.Second
+	// totpSkew is the number of periods before and after the current one that are accepted
+	// to allow for clock drift between the server and the authenticator app.
+	totpSkew = 1
+	// totpChallengeExp is how long the user has to enter a code after their password.
+	totpChallengeExp = 5 * time.Minute
+	// maxTOTPChallengeFailures is how many wrong codes a challenge takes before it is revoked.
+	maxTOTPChallengeFailures = 5
+	recoveryCodeCount        = 10
+)
+
+var (
+	// ErrTOTPRequired is returned when a second factor is needed to complete sign in.
+	ErrTOTPRequired = errors.New("totp required")
+	// ErrTOTPEnabled is returned when enrolling a user that already has two-factor authentication.
+	ErrTOTPEnabled = errors.New("totp already enabled")
+	// ErrTOTPNotEnrolled is returned when a user has not started or completed enrollment.
+	ErrTOTPNotEnrolled = errors.New("totp not enrolled")
+	// ErrInvalidTOTPCode is returned when a TOTP or recovery code is wrong, expired or reused.
+	ErrInvalidTOTPCode = errors.New("invalid totp code")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPChallenge is returned as an error by AuthStore.NewSession when the password is correct
+// but the user has two-factor authentication enabled. The token is exchanged for a session
+// together with a TOTP code. It matches ErrTOTPRequired with errors.Is.
+type TOTPChallenge struct {
+	Token     string    `json:"challenge"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (c *TOTPChallenge) Error() string {
+	return ErrTOTPRequired.Error()
+}
+
+func (c *TOTPChallenge) Unwrap() error {
+	return ErrTOTPRequired
+}
+
+// TOTPEnrollment holds what a user needs to set up their authenticator app.
+// It is only returned once, when enrollment starts.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	// URI is the otpauth:// provisioning URI that authenticator apps understand.
+	URI string `json:"uri"`
+	// QRCode is a PNG image of the URI encoded as a data URL.
+	QRCode        string   `json:"qr_code"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func newTOTPEnrollment(username string) (*TOTPEnrollment, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("generate secret: %w", err)
+	}
+	encoded := totpEncoding.EncodeToString(secret)
+
+	uri := TOTPProvisioningURI(totpIssuer, username, encoded)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		return nil, fmt.Errorf("encode qr code: %w", err)
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, fmt.Errorf("generate recovery code: %w", err)
+		}
+		codes = append(codes, code)
+	}
+
+	return &TOTPEnrollment{
+		Secret:        encoded,
+		URI:           uri,
+		QRCode:        "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+		RecoveryCodes: codes,
+	}, nil
+}
+
+// TOTPProvisioningURI returns the otpauth:// URI for the base32 encoded secret.
+func TOTPProvisioningURI(issuer, account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(int(totpPeriod.Seconds())))
+	return (&url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}).String()
+}
+
+// TOTPCode returns the code for the base32 encoded secret at time t as defined by RFC 6238.
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("decode secret: %w", err)
+	}
+	return hotp(key, totpStep(t)), nil
+}
+
+// validateTOTP checks the code against the periods around t and returns the step it matched.
+// Steps at or before lastStep are rejected so that a code cannot be replayed.
+func validateTOTP(secret, code string, t time.Time, lastStep int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+	code = strings.TrimSpace(code)
+	current := totpStep(t)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hotp(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod.Seconds())
+}
+
+func hotp(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1_000_000)
+}
+
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(totpEncoding.EncodeToString(b))
+	return code[:4] + "-" + code[4:], nil
+}
+
+// hashRecoveryCode normalises and hashes a recovery code.
+// The codes are random enough that a fast hash is sufficient.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
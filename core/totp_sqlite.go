@@ -0,0 +1,309 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func (a *SQLAuthStore) isTOTPEnabled(ctx context.Context, username string) (bool, error) {
+	row := a.db.QueryRowContext(ctx,
+		"SELECT enabled FROM user_totp WHERE username = @username", sql.Named("username", username))
+	var enabled bool
+	if err := row.Scan(&enabled); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, fmt.Errorf("row.Scan: %w", err)
+	}
+	return enabled, nil
+}
+
+func (a *SQLAuthStore) EnrollTOTP(ctx context.Context, username string) (*TOTPEnrollment, error) {
+	enabled, err := a.isTOTPEnabled(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("isTOTPEnabled: %w", err)
+	}
+	if enabled {
+		return nil, ErrTOTPEnabled
+	}
+
+	enrollment, err := newTOTPEnrollment(username)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := deleteTOTP(ctx, tx, username); err != nil {
+		return nil, err
+	}
+
+	query := `
+	INSERT INTO user_totp (username, secret, enabled, last_used_step, created_at)
+	VALUES (@username, @secret, FALSE, 0, @created_at)`
+	_, err = tx.ExecContext(ctx, query,
+		sql.Named("username", username), sql.Named("secret", enrollment.Secret),
+		sql.Named("created_at", time.Now().UTC()))
+	if err != nil {
+		return nil, fmt.Errorf("ExecContext(insert user_totp): %w", err)
+	}
+
+	for _, code := range enrollment.RecoveryCodes {
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO user_recovery_codes (username, code_hash) VALUES (@username, @code_hash)",
+			sql.Named("username", username), sql.Named("code_hash", hashRecoveryCode(code)))
+		if err != nil {
+			return nil, fmt.Errorf("ExecContext(insert user_recovery_codes): %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("Commit: %w", err)
+	}
+
+	return enrollment, nil
+}
+
+func (a *SQLAuthStore) ConfirmTOTP(ctx context.Context, username, code string) error {
+	row := a.db.QueryRowContext(ctx,
+		"SELECT secret, enabled FROM user_totp WHERE username = @username", sql.Named("username", username))
+	var secret string
+	var enabled bool
+	if err := row.Scan(&secret, &enabled); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrTOTPNotEnrolled
+		}
+		return fmt.Errorf("row.Scan: %w", err)
+	}
+	if enabled {
+		return ErrTOTPEnabled
+	}
+
+	step, ok := validateTOTP(secret, code, time.Now(), 0)
+	if !ok {
+		return ErrInvalidTOTPCode
+	}
+
+	_, err := a.db.ExecContext(ctx,
+		"UPDATE user_totp SET enabled = TRUE, last_used_step = @step WHERE username = @username",
+		sql.Named("step", step), sql.Named("username", username))
+	if err != nil {
+		return fmt.Errorf("ExecContext: %w", err)
+	}
+	return nil
+}
+
+func (a *SQLAuthStore) DisableTOTP(ctx context.Context, username, code string) error {
+	enabled, err := a.isTOTPEnabled(ctx, username)
+	if err != nil {
+		return fmt.Errorf("isTOTPEnabled: %w", err)
+	}
+	if !enabled {
+		return ErrTOTPNotEnrolled
+	}
+
+	if err := a.verifySecondFactor(ctx, username, code); err != nil {
+		return err
+	}
+
+	return a.ResetTOTP(ctx, username)
+}
+
+func (a *SQLAuthStore) ResetTOTP(ctx context.Context, username string) error {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := deleteTOTP(ctx, tx, username); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Commit: %w", err)
+	}
+	return nil
+}
+
+func (a *SQLAuthStore) VerifyTOTPChallenge(ctx context.Context, challenge, code string) (*Session, error) {
+	claims, err := a.challengeKeys.Verify(challenge)
+	if err != nil {
+		if errors.Is(err, ErrTokenExpired) || errors.Is(err, ErrTokenInvalid) || errors.Is(err, ErrUnrecognizedToken) {
+			return nil, ErrUnauthenticated
+		}
+		return nil, fmt.Errorf("verifying token: %w", err)
+	}
+	if claims.Purpose != totpChallengePurpose {
+		return nil, ErrUnauthenticated
+	}
+
+	session, err := a.verifyTOTPChallenge(ctx, claims, challenge, code)
+	if err := a.recordSignIn(ctx, claims.Username, "totp", session, err); err != nil {
+		return nil, err
+	}
+	return session, err
+}
+
+// verifyTOTPChallenge completes the sign in of a challenge with a valid signature.
+func (a *SQLAuthStore) verifyTOTPChallenge(ctx context.Context, claims *AuthClaims, challenge, code string) (*Session, error) {
+	used, err := a.isBlacklisted(ctx, challenge)
+	if err != nil {
+		return nil, fmt.Errorf("checking blacklist: %w", err)
+	}
+	if used {
+		return nil, ErrUnauthenticated
+	}
+
+	attempt, err := a.beginAttempt(ctx,
+		lockoutSubjects(ctx, LockoutScopeUsername, LockoutScopeIP, claims.Username))
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.verifySecondFactor(ctx, claims.Username, code)
+	if endErr := a.endAttempt(ctx, attempt, errors.Is(err, ErrInvalidTOTPCode)); endErr != nil {
+		return nil, fmt.Errorf("endAttempt: %w", endErr)
+	}
+	if err != nil {
+		if errors.Is(err, ErrInvalidTOTPCode) {
+			if err := a.recordChallengeFailure(ctx, claims, challenge); err != nil {
+				return nil, fmt.Errorf("recordChallengeFailure: %w", err)
+			}
+		}
+		return nil, err
+	}
+
+	if err := a.blacklistToken(ctx, challenge); err != nil {
+		return nil, fmt.Errorf("blacklisting challenge: %w", err)
+	}
+
+	return a.IssueSession(ctx, claims.Username)
+}
+
+// recordChallengeFailure counts a wrong code against the challenge and revokes the challenge
+// once it has taken maxTOTPChallengeFailures, so that it cannot be used to guess codes until it expires.
+func (a *SQLAuthStore) recordChallengeFailure(ctx context.Context, claims *AuthClaims, challenge string) error {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	if _, err := tx.ExecContext(ctx, "DELETE FROM totp_challenge_failures WHERE expires_at < @now",
+		sql.Named("now", now)); err != nil {
+		return fmt.Errorf("ExecContext(delete totp_challenge_failures): %w", err)
+	}
+
+	expiresAt := now.Add(totpChallengeExp)
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time.UTC()
+	}
+	if _, err := tx.ExecContext(ctx, `
+	INSERT INTO totp_challenge_failures (challenge_id, failures, expires_at)
+	VALUES (@challenge_id, 1, @expires_at)
+	ON CONFLICT (challenge_id) DO UPDATE SET failures = totp_challenge_failures.failures + 1`,
+		sql.Named("challenge_id", claims.ID), sql.Named("expires_at", expiresAt)); err != nil {
+		return fmt.Errorf("ExecContext(upsert totp_challenge_failures): %w", err)
+	}
+
+	var failures int
+	if err := tx.QueryRowContext(ctx, "SELECT failures FROM totp_challenge_failures WHERE challenge_id = @challenge_id",
+		sql.Named("challenge_id", claims.ID)).Scan(&failures); err != nil {
+		return fmt.Errorf("row.Scan: %w", err)
+	}
+	if failures >= maxTOTPChallengeFailures {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO blacklists (token) VALUES (@token)",
+			sql.Named("token", challenge)); err != nil {
+			return fmt.Errorf("ExecContext(insert blacklists): %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Commit: %w", err)
+	}
+	return nil
+}
+
+// verifySecondFactor accepts either a TOTP code or an unused recovery code.
+// A TOTP code is consumed by moving last_used_step forward, a recovery code by marking it used.
+// Both are conditional updates so that concurrent requests cannot use the same code twice.
+func (a *SQLAuthStore) verifySecondFactor(ctx context.Context, username, code string) error {
+	if isTOTPCode(code) {
+		row := a.db.QueryRowContext(ctx,
+			"SELECT secret, last_used_step FROM user_totp WHERE username = @username AND enabled = TRUE",
+			sql.Named("username", username))
+		var secret string
+		var lastStep int64
+		if err := row.Scan(&secret, &lastStep); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrTOTPNotEnrolled
+			}
+			return fmt.Errorf("row.Scan: %w", err)
+		}
+
+		step, ok := validateTOTP(secret, code, time.Now(), lastStep)
+		if !ok {
+			return ErrInvalidTOTPCode
+		}
+
+		res, err := a.db.ExecContext(ctx, `
+		UPDATE user_totp SET last_used_step = @step
+		WHERE username = @username AND last_used_step < @step`,
+			sql.Named("step", step), sql.Named("username", username))
+		if err != nil {
+			return fmt.Errorf("ExecContext(update user_totp): %w", err)
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return ErrInvalidTOTPCode
+		}
+		return nil
+	}
+
+	res, err := a.db.ExecContext(ctx, `
+	UPDATE user_recovery_codes SET used_at = @used_at
+	WHERE username = @username AND code_hash = @code_hash AND used_at IS NULL`,
+		sql.Named("used_at", time.Now().UTC()), sql.Named("username", username),
+		sql.Named("code_hash", hashRecoveryCode(code)))
+	if err != nil {
+		return fmt.Errorf("ExecContext(update user_recovery_codes): %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrInvalidTOTPCode
+	}
+	return nil
+}
+
+func isTOTPCode(code string) bool {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func deleteTOTP(ctx context.Context, tx *Tx, username string) error {
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM user_recovery_codes WHERE username = @username", sql.Named("username", username)); err != nil {
+		return fmt.Errorf("ExecContext(delete user_recovery_codes): %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM user_totp WHERE username = @username", sql.Named("username", username)); err != nil {
+		return fmt.Errorf("ExecContext(delete user_totp): %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package core
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// enrollTOTP enables two-factor authentication for the user and returns the enrollment.
+func enrollTOTP(f *AuthFixture, username string) *TOTPEnrollment {
+	enrollment, err := f.authStore.EnrollTOTP(f.ctx, username)
+	require.Nil(f.t, err)
+	code, err := TOTPCode(enrollment.Secret, time.Now())
+	require.Nil(f.t, err)
+	require.Nil(f.t, f.authStore.ConfirmTOTP(f.ctx, username, code))
+	return enrollment
+}
+
+// challenge signs in with a password and returns the TOTP challenge.
+func challenge(f *AuthFixture, u User) *TOTPChallenge {
+	session, err := f.authStore.NewSession(f.ctx, u.Username, u.Password)
+	require.Nil(f.t, session)
+	var c *TOTPChallenge
+	require.True(f.t, errors.As(err, &c))
+	return c
+}
+
+func TestEnrollTOTP(t *testing.T) {
+	t.Run("enrollment", func(t *testing.T) {
+		f := NewAuthFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, user)
+
+		enrollment, err := f.authStore.EnrollTOTP(f.ctx, user.Username)
+		require.Nil(t, err)
+		assert.NotEmpty(t, enrollment.Secret)
+		assert.Len(t, enrollment.RecoveryCodes, recoveryCodeCount)
+		assert.Contains(t, enrollment.QRCode, "data:image/png;base64,")
+		uri, err := url.Parse(enrollment.URI)
+		require.Nil(t, err)
+		assert.Equal(t, "otpauth", uri.Scheme)
+		assert.Equal(t, enrollment.Secret, uri.Query().Get("secret"))
+
+		// sign in does not need a code until enrollment is confirmed
+		session, err := f.authStore.NewSession(f.ctx, user.Username, user.Password)
+		require.Nil(t, err)
+		require.NotNil(t, session)
+	})
+
+	t.Run("confirm with wrong code", func(t *testing.T) {
+		f := NewAuthFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, user)
+
+		_, err := f.authStore.EnrollTOTP(f.ctx, user.Username)
+		require.Nil(t, err)
+		err = f.authStore.ConfirmTOTP(f.ctx, user.Username, "000000")
+		assert.Equal(t, ErrInvalidTOTPCode, err)
+	})
+
+	t.Run("confirm without enrollment", func(t *testing.T) {
+		f := NewAuthFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, user)
+
+		err := f.authStore.ConfirmTOTP(f.ctx, user.Username, "000000")
+		assert.Equal(t, ErrTOTPNotEnrolled, err)
+	})
+
+	t.Run("enroll twice", func(t *testing.T) {
+		f := NewAuthFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, user)
+		enrollTOTP(f, user.Username)
+
+		_, err := f.authStore.EnrollTOTP(f.ctx, user.Username)
+		assert.Equal(t, ErrTOTPEnabled, err)
+	})
+}
+
+func TestVerifyTOTPChallenge(t *testing.T) {
+	t.Run("totp code", func(t *testing.T) {
+		f := NewAuthFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, user)
+		enrollment := enrollTOTP(f, user.Username)
+		c := challenge(f, user)
+
+		// the challenge is not a session
+		_, err := f.authStore.Session(f.ctx, c.Token)
+		assert.Equal(t, ErrUnauthenticated, err)
+
+		// the code used to confirm enrollment cannot be replayed
+		code, err := TOTPCode(enrollment.Secret, time.Now())
+		require.Nil(t, err)
+		_, err = f.authStore.VerifyTOTPChallenge(f.ctx, c.Token, code)
+		assert.Equal(t, ErrInvalidTOTPCode, err)
+
+		code, err = TOTPCode(enrollment.Secret, time.Now().Add(totpPeriod))
+		require.Nil(t, err)
+		session, err := f.authStore.VerifyTOTPChallenge(f.ctx, c.Token, code)
+		require.Nil(t, err)
+		require.NotNil(t, session)
+		assert.Equal(t, user.Username, session.Username)
+
+		_, err = f.authStore.Session(f.ctx, session.Token)
+		require.Nil(t, err)
+
+		// the challenge is single use
+		_, err = f.authStore.VerifyTOTPChallenge(f.ctx, c.Token, code)
+		assert.Equal(t, ErrUnauthenticated, err)
+	})
+
+	t.Run("recovery code", func(t *testing.T) {
+		f := NewAuthFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, user)
+		enrollment := enrollTOTP(f, user.Username)
+
+		session, err := f.authStore.VerifyTOTPChallenge(f.ctx,
+			challenge(f, user).Token, enrollment.RecoveryCodes[0])
+		require.Nil(t, err)
+		require.NotNil(t, session)
+
+		_, err = f.authStore.VerifyTOTPChallenge(f.ctx,
+			challenge(f, user).Token, enrollment.RecoveryCodes[0])
+		assert.Equal(t, ErrInvalidTOTPCode, err)
+	})
+
+	t.Run("challenge is revoked after too many wrong codes", func(t *testing.T) {
+		f := NewAuthFixture(t)
+		defer f.tearDown()
+		// the lockout of the username would stop the guesses first
+		lenient := LockoutPolicy{FreeAttempts: 100, MaxAttempts: 100, LockoutDuration: time.Minute}
+		f.authStore = NewSQLAuthStore(f.db, f.userStore, secret, WithLockoutPolicy(lenient, lenient))
+		seedUsers(f.ctx, t, f.userStore, user)
+		enrollment := enrollTOTP(f, user.Username)
+		c := challenge(f, user)
+
+		for i := 0; i < maxTOTPChallengeFailures; i++ {
+			_, err := f.authStore.VerifyTOTPChallenge(f.ctx, c.Token, "000000")
+			assert.Equal(t, ErrInvalidTOTPCode, err)
+		}
+
+		_, err := f.authStore.VerifyTOTPChallenge(f.ctx, c.Token, enrollment.RecoveryCodes[0])
+		assert.Equal(t, ErrUnauthenticated, err)
+
+		// a new challenge starts counting again
+		session, err := f.authStore.VerifyTOTPChallenge(f.ctx, challenge(f, user).Token, enrollment.RecoveryCodes[0])
+		require.Nil(t, err)
+		assert.Equal(t, user.Username, session.Username)
+	})
+
+	t.Run("session token is not a challenge", func(t *testing.T) {
+		f := NewAuthFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, user)
+		session, err := f.authStore.NewSession(f.ctx, user.Username, user.Password)
+		require.Nil(t, err)
+		enrollment := enrollTOTP(f, user.Username)
+
+		_, err = f.authStore.VerifyTOTPChallenge(f.ctx, session.Token, enrollment.RecoveryCodes[0])
+		assert.Equal(t, ErrUnauthenticated, err)
+	})
+}
+
+func TestDisableTOTP(t *testing.T) {
+	t.Run("with recovery code", func(t *testing.T) {
+		f := NewAuthFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, user)
+		enrollment := enrollTOTP(f, user.Username)
+
+		err := f.authStore.DisableTOTP(f.ctx, user.Username, "0000-0000")
+		assert.Equal(t, ErrInvalidTOTPCode, err)
+
+		err = f.authStore.DisableTOTP(f.ctx, user.Username, enrollment.RecoveryCodes[1])
+		require.Nil(t, err)
+
+		session, err := f.authStore.NewSession(f.ctx, user.Username, user.Password)
+		require.Nil(t, err)
+		require.NotNil(t, session)
+	})
+
+	t.Run("admin reset", func(t *testing.T) {
+		f := NewAuthFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, user)
+		enrollTOTP(f, user.Username)
+
+		require.Nil(t, f.authStore.ResetTOTP(f.ctx, user.Username))
+
+		session, err := f.authStore.NewSession(f.ctx, user.Username, user.Password)
+		require.Nil(t, err)
+		require.NotNil(t, session)
+	})
+}
@@ -0,0 +1,47 @@
+package core
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// test vectors from RFC 6238 appendix B truncated to 6 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	tcs := []struct {
+		at   int64
+		code string
+	}{
+		{at: 59, code: "287082"},
+		{at: 1111111109, code: "081804"},
+		{at: 1234567890, code: "005924"},
+		{at: 2000000000, code: "279037"},
+	}
+
+	for _, tc := range tcs {
+		code, err := TOTPCode(secret, time.Unix(tc.at, 0))
+		require.Nil(t, err)
+		assert.Equal(t, tc.code, code)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1234567890, 0)
+	code, err := TOTPCode(secret, now)
+	require.Nil(t, err)
+
+	step, ok := validateTOTP(secret, code, now.Add(totpPeriod), 0)
+	assert.True(t, ok, "previous period is accepted")
+	assert.Equal(t, totpStep(now), step)
+
+	_, ok = validateTOTP(secret, code, now.Add(3*totpPeriod), 0)
+	assert.False(t, ok, "codes outside the skew are rejected")
+
+	_, ok = validateTOTP(secret, code, now, totpStep(now))
+	assert.False(t, ok, "used codes are rejected")
+}
@@ -3,6 +3,7 @@ package core
 import (
 	"context"
 	"errors"
+	"time"
 )
 
 type User struct {
@@ -20,14 +21,50 @@ type UserWithoutSecrets struct {
 	Username string `json:"username"`
 }
 
+// Identity links a user to an account at an external identity provider.
+type Identity struct {
+	// Provider is the name of the identity provider that issued the subject.
+	Provider string `json:"provider"`
+	// Subject is the identifier of the account at the identity provider.
+	Subject  string `json:"subject"`
+	Username string `json:"username"`
+}
+
+// UpdateUserInput holds the fields of a user that can be changed after sign up.
+type UpdateUserInput struct {
+	Name string `json:"name" validate:"required,min=3"`
+}
+
+func (u UpdateUserInput) Validate() error {
+	return validate.Struct(u)
+}
+
+// DeletedUserName is the name shown in place of users that deleted their account.
+const DeletedUserName = "Deleted user"
+
 var (
 	ErrConflictedUser = errors.New("user already exists")
+	// ErrConflictedIdentity is returned when an external identity is already linked to another user.
+	ErrConflictedIdentity = errors.New("identity already linked")
+	// ErrInvalidResetToken is returned when a password reset token is unknown, expired or used.
+	ErrInvalidResetToken = errors.New("invalid reset token")
 )
 
+// GetUsersOptions filters and paginates GetUsers.
 type GetUsersOptions struct {
-	limit  int
-	offset int
-	q      string
+	// Query matches the users whose username or name contains it, ignoring case.
+	Query string
+	// Limit is set to 20 if it is a zero value.
+	Limit  int
+	Offset int
+}
+
+// UserAccount is a user together with the state of their account, as seen by administrators.
+type UserAccount struct {
+	Name          string     `json:"name"`
+	Username      string     `json:"username"`
+	Admin         bool       `json:"admin"`
+	DeactivatedAt *time.Time `json:"deactivated_at"`
 }
 
 type UserStore interface {
@@ -39,5 +76,60 @@ type UserStore interface {
 
 	ComparePassword(ctx context.Context, username, password string) (bool, error)
 
-	GetUsers(ctx context.Context, opts *GetUsersOptions) ([]UserWithoutSecrets, error)
+	// GetUsers returns the accounts of the users that have not been deleted, ordered by username.
+	GetUsers(ctx context.Context, opts *GetUsersOptions) ([]UserAccount, error)
+
+	// GetUserAccount returns the account of the user.
+	// If the user does not exist or has been deleted, it returns nil.
+	GetUserAccount(ctx context.Context, username string) (*UserAccount, error)
+
+	// SetAdmin grants or revokes the administrator role of the user.
+	// If the user does not exist, it returns ErrInvalidUser.
+	SetAdmin(ctx context.Context, username string, admin bool) error
+
+	// SetPassword replaces the password of the user without checking the current one
+	// and revokes every session of the user. It is meant for administrators.
+	// If the user does not exist, it returns ErrInvalidUser.
+	SetPassword(ctx context.Context, username, password string) error
+
+	// GetUserByIdentity returns the user linked to the external identity.
+	// If the identity is not linked to any user, it returns nil.
+	GetUserByIdentity(ctx context.Context, provider, subject string) (*UserWithoutSecrets, error)
+
+	// LinkIdentity links an external identity to an existing user.
+	// If the user does not exist, it returns ErrInvalidUser.
+	// If the identity is already linked to another user, it returns ErrConflictedIdentity.
+	LinkIdentity(ctx context.Context, identity Identity) error
+
+	// UpdateUser updates the profile of the user and returns the updated user.
+	// If the user does not exist, it returns ErrInvalidUser.
+	UpdateUser(ctx context.Context, username string, input UpdateUserInput) (*UserWithoutSecrets, error)
+
+	// ChangePassword replaces the password of the user after checking the current one.
+	// Every session of the user is revoked.
+	// If the current password is wrong, it returns ErrBadCredentials.
+	ChangePassword(ctx context.Context, username, currentPassword, newPassword string) error
+
+	// CreatePasswordResetToken returns a single use token that ResetPassword accepts until it expires.
+	// If the user does not exist or is deactivated, it returns ErrInvalidUser.
+	CreatePasswordResetToken(ctx context.Context, username string, expiration time.Duration) (string, time.Time, error)
+
+	// ResetPassword sets a new password for the user the token was issued to.
+	// The token and any other outstanding tokens of the user are used up and every session is revoked.
+	// If the token is unknown, expired or already used, it returns ErrInvalidResetToken.
+	ResetPassword(ctx context.Context, token, newPassword string) error
+
+	// DeactivateUser stops the user from signing in and revokes all of their sessions.
+	// If the user does not exist, it returns ErrInvalidUser.
+	DeactivateUser(ctx context.Context, username string) error
+
+	// ReactivateUser lets a deactivated user sign in again.
+	// If the user does not exist, it returns ErrInvalidUser.
+	ReactivateUser(ctx context.Context, username string) error
+
+	// DeleteUser deletes the account of the user. Their messages are kept but attributed to
+	// an anonymous user named DeletedUserName, and rooms where they are the only member are deleted.
+	// If the user owns a room that has other members, it returns ErrDisAllowedOperation.
+	// If the user does not exist, it returns ErrInvalidUser.
+	DeleteUser(ctx context.Context, username string) error
 }
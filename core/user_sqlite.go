@@ -2,25 +2,30 @@ package core
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
-type SQLiteUserStore struct {
-	db *sql.DB
+// SQLUserStore is a UserStore backed by a SQL database.
+type SQLUserStore struct {
+	db *DB
 }
 
-func NewSqlieUserStore(db *sql.DB) *SQLiteUserStore {
-	return &SQLiteUserStore{
+func NewSQLUserStore(db *DB) *SQLUserStore {
+	return &SQLUserStore{
 		db: db,
 	}
 }
 
-func (s *SQLiteUserStore) CreateUser(ctx context.Context, user User) error {
+func (s *SQLUserStore) CreateUser(ctx context.Context, user User) error {
 	eu, err := s.GetUserByUsername(ctx, user.Username)
 	if err != nil {
 		return fmt.Errorf("checking if user exists: %w", err)
@@ -46,7 +51,7 @@ func (s *SQLiteUserStore) CreateUser(ctx context.Context, user User) error {
 	return nil
 }
 
-func (s *SQLiteUserStore) GetUsersByUsernames(ctx context.Context, usernames ...string) ([]UserWithoutSecrets, error) {
+func (s *SQLUserStore) GetUsersByUsernames(ctx context.Context, usernames ...string) ([]UserWithoutSecrets, error) {
 	if len(usernames) == 0 {
 		return nil, nil
 	}
@@ -80,7 +85,7 @@ func (s *SQLiteUserStore) GetUsersByUsernames(ctx context.Context, usernames ...
 	return users, nil
 }
 
-func (s *SQLiteUserStore) GetUserByUsername(ctx context.Context, username string) (*UserWithoutSecrets, error) {
+func (s *SQLUserStore) GetUserByUsername(ctx context.Context, username string) (*UserWithoutSecrets, error) {
 
 	row := s.db.QueryRowContext(ctx, "SELECT name, username FROM users WHERE username = ? LIMIT 1", username)
 
@@ -103,7 +108,7 @@ func (s *SQLiteUserStore) GetUserByUsername(ctx context.Context, username string
 	return user, nil
 }
 
-func (s *SQLiteUserStore) ComparePassword(ctx context.Context, username, password string) (bool, error) {
+func (s *SQLUserStore) ComparePassword(ctx context.Context, username, password string) (bool, error) {
 	row := s.db.QueryRowContext(ctx, "SELECT password FROM users WHERE username = ? LIMIT 1", username)
 
 	var storedPassword string
@@ -123,48 +128,450 @@ func (s *SQLiteUserStore) ComparePassword(ctx context.Context, username, passwor
 	return true, nil
 }
 
-func (s *SQLiteUserStore) GetUsers(ctx context.Context, options *GetUsersOptions) ([]UserWithoutSecrets, error) {
-	template := make([]string, 0)
-	values := make([]interface{}, 0)
-	if options != nil {
-		if options.q != "" {
-			template = append(template, "username LIKE @q")
-			values = append(values, sql.Named("q", "%"+options.q))
+func (s *SQLUserStore) GetUsers(ctx context.Context, options *GetUsersOptions) ([]UserAccount, error) {
+	if options == nil {
+		options = &GetUsersOptions{}
+	}
+	limit, offset := options.Limit, options.Offset
+	if limit <= 0 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	query := `
+	SELECT name, username, is_admin, deactivated_at FROM users
+	WHERE deleted_at IS NULL
+	AND (` + s.db.Dialect.Contains("lower(username)", "lower(@q)") + `
+	OR ` + s.db.Dialect.Contains("lower(name)", "lower(@q)") + `)
+	ORDER BY username
+	LIMIT @limit OFFSET @offset`
+	rows, err := s.db.QueryContext(ctx, query,
+		sql.Named("q", options.Query), sql.Named("limit", limit), sql.Named("offset", offset))
+	if err != nil {
+		return nil, fmt.Errorf("QueryContext: %w", err)
+	}
+	defer rows.Close()
+
+	users := []UserAccount{}
+	for rows.Next() {
+		user, err := scanUserAccount(rows)
+		if err != nil {
+			return nil, fmt.Errorf("rows.Scan: %w", err)
+		}
+		users = append(users, *user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err: %w", err)
+	}
+	return users, nil
+}
+
+func (s *SQLUserStore) GetUserAccount(ctx context.Context, username string) (*UserAccount, error) {
+	row := s.db.QueryRowContext(ctx, `
+	SELECT name, username, is_admin, deactivated_at FROM users
+	WHERE username = @username AND deleted_at IS NULL`,
+		sql.Named("username", username))
+	user, err := scanUserAccount(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
 		}
+		return nil, fmt.Errorf("row.Scan: %w", err)
+	}
+	return user, nil
+}
+
+func scanUserAccount(row scanner) (*UserAccount, error) {
+	var user UserAccount
+	var name sql.NullString
+	var deactivatedAt sql.NullTime
+	if err := row.Scan(&name, &user.Username, &user.Admin, &deactivatedAt); err != nil {
+		return nil, err
+	}
+	user.Name = name.String
+	if deactivatedAt.Valid {
+		user.DeactivatedAt = &deactivatedAt.Time
+	}
+	return &user, nil
+}
+
+func (s *SQLUserStore) SetAdmin(ctx context.Context, username string, admin bool) error {
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE users SET is_admin = @admin WHERE username = @username AND deleted_at IS NULL",
+		sql.Named("admin", admin), sql.Named("username", username))
+	if err != nil {
+		return fmt.Errorf("ExecContext: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("RowsAffected: %w", err)
+	} else if n == 0 {
+		return ErrInvalidUser
+	}
+	return nil
+}
+
+func (s *SQLUserStore) SetPassword(ctx context.Context, username, password string) error {
+	account, err := s.GetUserAccount(ctx, username)
+	if err != nil {
+		return fmt.Errorf("GetUserAccount: %w", err)
+	}
+	if account == nil {
+		return ErrInvalidUser
+	}
+	return s.setPassword(ctx, s.db, username, password)
+}
+
+func (s *SQLUserStore) GetUserByIdentity(ctx context.Context, provider, subject string) (*UserWithoutSecrets, error) {
+	query := `
+	SELECT u.name, u.username
+	FROM user_identities AS ui
+	INNER JOIN users AS u ON ui.username = u.username
+	WHERE ui.provider = @provider AND ui.subject = @subject`
+
+	row := s.db.QueryRowContext(ctx, query,
+		sql.Named("provider", provider), sql.Named("subject", subject))
 
-		template = append(template, "LIMIT @limit")
-		if options.limit > 0 {
-			values = append(values, sql.Named("limit", options.limit))
-		} else {
-			values = append(values, sql.Named("limit", 10))
+	user := new(UserWithoutSecrets)
+	if err := row.Scan(&user.Name, &user.Username); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
 		}
+		return nil, fmt.Errorf("row.Scan: %w", err)
+	}
+
+	return user, nil
+}
+
+func (s *SQLUserStore) LinkIdentity(ctx context.Context, identity Identity) error {
+	user, err := s.GetUserByUsername(ctx, identity.Username)
+	if err != nil {
+		return fmt.Errorf("GetUserByUsername: %w", err)
+	}
+	if user == nil {
+		return ErrInvalidUser
+	}
 
-		template = append(template, "OFFSET @offset")
-		if options.offset > 0 {
-			values = append(values, sql.Named("offset", options.offset))
-		} else {
-			values = append(values, sql.Named("offset", 0))
+	linked, err := s.GetUserByIdentity(ctx, identity.Provider, identity.Subject)
+	if err != nil {
+		return fmt.Errorf("GetUserByIdentity: %w", err)
+	}
+	if linked != nil {
+		if linked.Username == identity.Username {
+			return nil
 		}
+		return ErrConflictedIdentity
 	}
 
-	row, err := s.db.QueryContext(ctx, "SELECT name, username FROM users WHERE "+strings.Join(template, " AND "), values...)
+	query := `
+	INSERT INTO user_identities (provider, subject, username, created_at)
+	VALUES (@provider, @subject, @username, @created_at)`
+	_, err = s.db.ExecContext(ctx, query,
+		sql.Named("provider", identity.Provider), sql.Named("subject", identity.Subject),
+		sql.Named("username", identity.Username), sql.Named("created_at", time.Now().UTC()))
 	if err != nil {
-		return nil, fmt.Errorf("querying users: %w", err)
+		return fmt.Errorf("ExecContext: %w", err)
 	}
-	defer row.Close()
 
-	users := []UserWithoutSecrets{}
+	return nil
+}
+
+func (s *SQLUserStore) UpdateUser(ctx context.Context, username string, input UpdateUserInput) (*UserWithoutSecrets, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
 
-	for row.Next() {
-		var user UserWithoutSecrets
-		if err := row.Scan(&user.Name, &user.Username); err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				break
-			}
-			return nil, fmt.Errorf("scanning user: %w", err)
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE users SET name = @name WHERE username = @username AND deleted_at IS NULL",
+		sql.Named("name", input.Name), sql.Named("username", username))
+	if err != nil {
+		return nil, fmt.Errorf("ExecContext: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, fmt.Errorf("RowsAffected: %w", err)
+	} else if n == 0 {
+		return nil, ErrInvalidUser
+	}
+
+	return &UserWithoutSecrets{Name: input.Name, Username: username}, nil
+}
+
+func (s *SQLUserStore) ChangePassword(ctx context.Context, username, currentPassword, newPassword string) error {
+	user, err := s.GetUserByUsername(ctx, username)
+	if err != nil {
+		return fmt.Errorf("GetUserByUsername: %w", err)
+	}
+	if user == nil {
+		return ErrInvalidUser
+	}
+
+	ok, err := s.ComparePassword(ctx, username, currentPassword)
+	if err != nil {
+		return fmt.Errorf("ComparePassword: %w", err)
+	}
+	if !ok {
+		return ErrBadCredentials
+	}
+
+	return s.setPassword(ctx, s.db, username, newPassword)
+}
+
+// setPassword hashes and stores the password and bumps the token version of the user,
+// which revokes all of their sessions.
+func (s *SQLUserStore) setPassword(ctx context.Context, db execer, username, password string) error {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("hashing password: %w", err)
+	}
+
+	_, err = db.ExecContext(ctx, `
+	UPDATE users SET password = @password, token_version = token_version + 1
+	WHERE username = @username`,
+		sql.Named("password", string(hashed)), sql.Named("username", username))
+	if err != nil {
+		return fmt.Errorf("ExecContext(update users): %w", err)
+	}
+	return nil
+}
+
+func (s *SQLUserStore) CreatePasswordResetToken(ctx context.Context, username string, expiration time.Duration) (string, time.Time, error) {
+	row := s.db.QueryRowContext(ctx,
+		"SELECT count(*) FROM users WHERE username = @username AND deactivated_at IS NULL",
+		sql.Named("username", username))
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return "", time.Time{}, fmt.Errorf("row.Scan: %w", err)
+	}
+	if count == 0 {
+		return "", time.Time{}, ErrInvalidUser
+	}
+
+	token, err := randomString(32)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("generate token: %w", err)
+	}
+
+	now := time.Now().UTC()
+	exp := now.Add(expiration)
+	query := `
+	INSERT INTO password_reset_tokens (token_hash, username, expires_at, created_at)
+	VALUES (@token_hash, @username, @expires_at, @created_at)`
+	_, err = s.db.ExecContext(ctx, query,
+		sql.Named("token_hash", hashResetToken(token)), sql.Named("username", username),
+		sql.Named("expires_at", exp), sql.Named("created_at", now))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("ExecContext: %w", err)
+	}
+
+	return token, exp, nil
+}
+
+func (s *SQLUserStore) ResetPassword(ctx context.Context, token, newPassword string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	row := tx.QueryRowContext(ctx, `
+	SELECT t.username FROM password_reset_tokens AS t
+	INNER JOIN users AS u ON t.username = u.username
+	WHERE t.token_hash = @token_hash AND t.used_at IS NULL AND t.expires_at > @now
+	AND u.deactivated_at IS NULL`,
+		sql.Named("token_hash", hashResetToken(token)), sql.Named("now", now))
+	var username string
+	if err := row.Scan(&username); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidResetToken
 		}
-		users = append(users, user)
+		return fmt.Errorf("row.Scan: %w", err)
 	}
 
-	return users, nil
+	// use up every outstanding token so that an older link cannot undo the reset
+	res, err := tx.ExecContext(ctx, `
+	UPDATE password_reset_tokens SET used_at = @now
+	WHERE username = @username AND used_at IS NULL`,
+		sql.Named("now", now), sql.Named("username", username))
+	if err != nil {
+		return fmt.Errorf("ExecContext(update password_reset_tokens): %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrInvalidResetToken
+	}
+
+	if err := s.setPassword(ctx, tx, username, newPassword); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Commit: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLUserStore) DeactivateUser(ctx context.Context, username string) error {
+	res, err := s.db.ExecContext(ctx, `
+	UPDATE users SET deactivated_at = COALESCE(deactivated_at, @now), token_version = token_version + 1
+	WHERE username = @username AND deleted_at IS NULL`,
+		sql.Named("now", time.Now().UTC()), sql.Named("username", username))
+	if err != nil {
+		return fmt.Errorf("ExecContext: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("RowsAffected: %w", err)
+	} else if n == 0 {
+		return ErrInvalidUser
+	}
+	return nil
+}
+
+func (s *SQLUserStore) ReactivateUser(ctx context.Context, username string) error {
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE users SET deactivated_at = NULL WHERE username = @username AND deleted_at IS NULL",
+		sql.Named("username", username))
+	if err != nil {
+		return fmt.Errorf("ExecContext: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("RowsAffected: %w", err)
+	} else if n == 0 {
+		return ErrInvalidUser
+	}
+	return nil
+}
+
+// DeleteUser renames the user to a random tombstone username instead of removing the row,
+// so that their messages keep a valid sender and the original username can be registered again.
+func (s *SQLUserStore) DeleteUser(ctx context.Context, username string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx,
+		"SELECT count(*) FROM users WHERE username = @username AND deleted_at IS NULL",
+		sql.Named("username", username))
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return fmt.Errorf("row.Scan(users): %w", err)
+	}
+	if count == 0 {
+		return ErrInvalidUser
+	}
+
+	// the owner cannot leave members behind in a room nobody can manage
+	row = tx.QueryRowContext(ctx, `
+	SELECT count(*) FROM room_members AS rm
+	WHERE rm.username = @username AND rm.role = @role
+	AND EXISTS (SELECT 1 FROM room_members AS o WHERE o.room_id = rm.room_id AND o.username != @username)`,
+		sql.Named("username", username), sql.Named("role", Owner))
+	if err := row.Scan(&count); err != nil {
+		return fmt.Errorf("row.Scan(room_members): %w", err)
+	}
+	if count > 0 {
+		return ErrDisAllowedOperation
+	}
+
+	tombstone, err := newTombstoneUsername()
+	if err != nil {
+		return err
+	}
+	// the rows of the user point at the tombstone before the user is renamed to it
+	if query := s.db.Dialect.DeferForeignKeys(); query != "" {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("ExecContext(defer foreign keys): %w", err)
+		}
+	}
+
+	// rooms the user is the only member of go with them
+	rows, err := tx.QueryContext(ctx, `
+	SELECT rm.room_id FROM room_members AS rm
+	WHERE rm.username = @username
+	AND NOT EXISTS (SELECT 1 FROM room_members AS o WHERE o.room_id = rm.room_id AND o.username != @username)`,
+		sql.Named("username", username))
+	if err != nil {
+		return fmt.Errorf("QueryContext(room_members): %w", err)
+	}
+	var soleRooms []string
+	for rows.Next() {
+		var roomID string
+		if err := rows.Scan(&roomID); err != nil {
+			rows.Close()
+			return fmt.Errorf("rows.Scan(room_members): %w", err)
+		}
+		soleRooms = append(soleRooms, roomID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("rows.Err(room_members): %w", err)
+	}
+	for _, roomID := range soleRooms {
+		if err := deleteRoom(ctx, tx, roomID); err != nil {
+			return err
+		}
+	}
+
+	queries := []string{
+		"DELETE FROM room_members WHERE username = @username",
+		"UPDATE messages SET sender = @tombstone WHERE sender = @username",
+		"UPDATE message_interactions SET username = @tombstone WHERE username = @username",
+		"UPDATE room_pins SET pinned_by = @tombstone WHERE pinned_by = @username",
+		"UPDATE room_invites SET created_by = @tombstone WHERE created_by = @username",
+		"UPDATE room_invite_uses SET username = @tombstone WHERE username = @username",
+		"DELETE FROM bookmarks WHERE username = @username",
+		"DELETE FROM user_identities WHERE username = @username",
+		"DELETE FROM user_recovery_codes WHERE username = @username",
+		"DELETE FROM user_totp WHERE username = @username",
+		"DELETE FROM password_reset_tokens WHERE username = @username",
+		`UPDATE users SET username = @tombstone, name = @name, password = '',
+		token_version = token_version + 1, deactivated_at = @now, deleted_at = @now
+		WHERE username = @username`,
+	}
+	// system messages name the users involved in their body, which is JSON only for system messages
+	for _, body := range []struct{ table, data, typ string }{
+		{"messages", "data", "type"},
+		{"rooms", "last_message_sent_data", "last_message_sent_type"},
+	} {
+		for _, key := range []string{"actor", "target"} {
+			queries = append(queries, fmt.Sprintf(
+				"UPDATE %s SET %s = %s WHERE CASE WHEN %s = @system THEN %s = @username ELSE FALSE END",
+				body.table, body.data, s.db.Dialect.JSONSetText(body.data, key, "@tombstone"),
+				body.typ, s.db.Dialect.JSONText(body.data, key)))
+		}
+	}
+	for _, query := range queries {
+		_, err := tx.ExecContext(ctx, query,
+			sql.Named("username", username), sql.Named("tombstone", tombstone),
+			sql.Named("name", DeletedUserName), sql.Named("now", time.Now().UTC()),
+			sql.Named("system", SystemMessage))
+		if err != nil {
+			return fmt.Errorf("ExecContext(%s): %w", strings.Fields(query)[0], err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Commit: %w", err)
+	}
+	return nil
+}
+
+// execer is implemented by both *DB and *Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func newTombstoneUsername() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate tombstone username: %w", err)
+	}
+	return "deleted-" + hex.EncodeToString(b), nil
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
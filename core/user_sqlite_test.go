@@ -1,6 +1,15 @@
 package core
 
-import "testing"
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
 
 type UserFixture struct {
 	*BaseFixture
@@ -9,7 +18,7 @@ type UserFixture struct {
 
 func NewUserFixture(t *testing.T) *UserFixture {
 	base := NewBaseFixture(t)
-	userStore := NewSqlieUserStore(base.db)
+	userStore := NewSQLUserStore(base.db)
 	return &UserFixture{
 		BaseFixture: base,
 		userStore:   userStore,
@@ -18,3 +27,218 @@ func NewUserFixture(t *testing.T) *UserFixture {
 
 func TestCreateUser(t *testing.T) {
 }
+
+func TestUpdateUser(t *testing.T) {
+	f := NewUserFixture(t)
+	defer f.tearDown()
+	seedUsers(f.ctx, t, f.userStore, user)
+
+	updated, err := f.userStore.UpdateUser(f.ctx, user.Username, UpdateUserInput{Name: "New name"})
+	require.Nil(t, err)
+	assert.Equal(t, "New name", updated.Name)
+
+	stored, err := f.userStore.GetUserByUsername(f.ctx, user.Username)
+	require.Nil(t, err)
+	assert.Equal(t, "New name", stored.Name)
+
+	_, err = f.userStore.UpdateUser(f.ctx, "random", UpdateUserInput{Name: "New name"})
+	assert.Equal(t, ErrInvalidUser, err)
+}
+
+func TestChangePassword(t *testing.T) {
+	t.Run("wrong current password", func(t *testing.T) {
+		f := NewAuthFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, user)
+
+		err := f.userStore.ChangePassword(f.ctx, user.Username, "wrong password", "new password")
+		assert.Equal(t, ErrBadCredentials, err)
+	})
+
+	t.Run("revokes existing sessions", func(t *testing.T) {
+		f := NewAuthFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, user)
+		session, err := f.authStore.NewSession(f.ctx, user.Username, user.Password)
+		require.Nil(t, err)
+
+		err = f.userStore.ChangePassword(f.ctx, user.Username, user.Password, "new password")
+		require.Nil(t, err)
+
+		_, err = f.authStore.Session(f.ctx, session.Token)
+		assert.Equal(t, ErrUnauthenticated, err)
+
+		_, err = f.authStore.NewSession(f.ctx, user.Username, user.Password)
+		assert.Equal(t, ErrBadCredentials, err)
+		session, err = f.authStore.NewSession(f.ctx, user.Username, "new password")
+		require.Nil(t, err)
+		_, err = f.authStore.Session(f.ctx, session.Token)
+		assert.Nil(t, err)
+	})
+}
+
+func TestResetPassword(t *testing.T) {
+	t.Run("token can only be used once", func(t *testing.T) {
+		f := NewUserFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, user)
+
+		token, exp, err := f.userStore.CreatePasswordResetToken(f.ctx, user.Username, time.Minute)
+		require.Nil(t, err)
+		assert.Greater(t, exp, time.Now())
+
+		require.Nil(t, f.userStore.ResetPassword(f.ctx, token, "new password"))
+		ok, err := f.userStore.ComparePassword(f.ctx, user.Username, "new password")
+		require.Nil(t, err)
+		assert.True(t, ok)
+
+		err = f.userStore.ResetPassword(f.ctx, token, "another password")
+		assert.Equal(t, ErrInvalidResetToken, err)
+	})
+
+	t.Run("reset uses up older tokens", func(t *testing.T) {
+		f := NewUserFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, user)
+
+		older, _, err := f.userStore.CreatePasswordResetToken(f.ctx, user.Username, time.Minute)
+		require.Nil(t, err)
+		newer, _, err := f.userStore.CreatePasswordResetToken(f.ctx, user.Username, time.Minute)
+		require.Nil(t, err)
+
+		require.Nil(t, f.userStore.ResetPassword(f.ctx, newer, "new password"))
+		assert.Equal(t, ErrInvalidResetToken, f.userStore.ResetPassword(f.ctx, older, "old password"))
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		f := NewUserFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, user)
+
+		token, _, err := f.userStore.CreatePasswordResetToken(f.ctx, user.Username, -time.Minute)
+		require.Nil(t, err)
+		assert.Equal(t, ErrInvalidResetToken, f.userStore.ResetPassword(f.ctx, token, "new password"))
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		f := NewUserFixture(t)
+		defer f.tearDown()
+
+		_, _, err := f.userStore.CreatePasswordResetToken(f.ctx, "random", time.Minute)
+		assert.Equal(t, ErrInvalidUser, err)
+	})
+}
+
+type recordingNotifier struct {
+	notifications []Notification
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, notification Notification) error {
+	n.notifications = append(n.notifications, notification)
+	return nil
+}
+
+func TestPasswordResetter(t *testing.T) {
+	f := NewUserFixture(t)
+	defer f.tearDown()
+	seedUsers(f.ctx, t, f.userStore, user)
+	notifier := &recordingNotifier{}
+	resetter := NewPasswordResetter(f.userStore, notifier, time.Minute, "https://chatter.example.com/reset")
+
+	// unknown users are ignored silently
+	require.Nil(t, resetter.RequestReset(f.ctx, "random"))
+	require.Empty(t, notifier.notifications)
+
+	require.Nil(t, resetter.RequestReset(f.ctx, user.Username))
+	require.Len(t, notifier.notifications, 1)
+	assert.Equal(t, user.Username, notifier.notifications[0].Username)
+
+	match := regexp.MustCompile(`https://chatter.example.com/reset\?token=(\S+)`).
+		FindStringSubmatch(notifier.notifications[0].Body)
+	require.Len(t, match, 2)
+	token, err := url.QueryUnescape(match[1])
+	require.Nil(t, err)
+
+	require.Nil(t, resetter.Reset(f.ctx, token, "new password"))
+	ok, err := f.userStore.ComparePassword(f.ctx, user.Username, "new password")
+	require.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestDeactivateUser(t *testing.T) {
+	f := NewAuthFixture(t)
+	defer f.tearDown()
+	seedUsers(f.ctx, t, f.userStore, user)
+	session, err := f.authStore.NewSession(f.ctx, user.Username, user.Password)
+	require.Nil(t, err)
+
+	require.Nil(t, f.userStore.DeactivateUser(f.ctx, user.Username))
+
+	_, err = f.authStore.Session(f.ctx, session.Token)
+	assert.Equal(t, ErrUnauthenticated, err)
+	_, err = f.authStore.NewSession(f.ctx, user.Username, user.Password)
+	assert.Equal(t, ErrDeactivatedUser, err)
+
+	require.Nil(t, f.userStore.ReactivateUser(f.ctx, user.Username))
+	_, err = f.authStore.NewSession(f.ctx, user.Username, user.Password)
+	assert.Nil(t, err)
+
+	assert.Equal(t, ErrInvalidUser, f.userStore.DeactivateUser(f.ctx, "random"))
+}
+
+func TestDeleteUser(t *testing.T) {
+	t.Run("owner of a room with other members", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, owner, member1)
+		rooms := seedRooms(f, owner)
+		require.Nil(t, f.chatStore.AddRoomMember(f.ctx, rooms[0].ID, member1.Username, Member))
+
+		err := f.userStore.DeleteUser(f.ctx, owner.Username)
+		assert.Equal(t, ErrDisAllowedOperation, err)
+	})
+
+	t.Run("messages are anonymized", func(t *testing.T) {
+		f := NewChatFixture(t)
+		defer f.tearDown()
+		seedUsers(f.ctx, t, f.userStore, owner, member1)
+		shared := seedRooms(f, owner, "Shared")[0]
+		solo := seedRooms(f, member1, "Solo")[0]
+		require.Nil(t, f.chatStore.AddRoomMember(f.ctx, shared.ID, member1.Username, Member))
+		_, err := f.chatStore.SendMessageToRoom(f.ctx, MessageCreateInput{
+			Type: TextMessage, Data: "hello", Sender: member1.Username, RoomID: shared.ID})
+		require.Nil(t, err)
+
+		require.Nil(t, f.userStore.DeleteUser(f.ctx, member1.Username))
+
+		deleted, err := f.userStore.GetUserByUsername(f.ctx, member1.Username)
+		require.Nil(t, err)
+		assert.Nil(t, deleted)
+
+		messages, err := f.chatStore.GetRoomMessages(f.ctx, shared.ID, 0, 1)
+		require.Nil(t, err)
+		require.Len(t, messages, 1)
+		assert.NotEqual(t, member1.Username, messages[0].Sender)
+		// system messages do not give away the username either
+		all, err := f.chatStore.GetRoomMessages(f.ctx, shared.ID, 0, 0)
+		require.Nil(t, err)
+		for _, message := range all {
+			assert.NotContains(t, message.Data, member1.Username)
+		}
+		sender, err := f.userStore.GetUserByUsername(f.ctx, messages[0].Sender)
+		require.Nil(t, err)
+		require.NotNil(t, sender)
+		assert.Equal(t, DeletedUserName, sender.Name)
+
+		ok, _, err := f.chatStore.IsRoomMember(f.ctx, shared.ID, member1.Username)
+		require.Nil(t, err)
+		assert.False(t, ok)
+
+		room, err := f.chatStore.GetRoomByID(f.ctx, solo.ID)
+		require.Nil(t, err)
+		assert.Nil(t, room)
+
+		// the username can be registered again
+		seedUsers(f.ctx, t, f.userStore, member1)
+	})
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"context"
 	"embed"
+	"io"
 	"io/fs"
 	"log"
+	"os"
 	"os/signal"
 	"syscall"
 
@@ -20,6 +22,29 @@ func main() {
 		log.Fatalf("load config: %v", err)
 	}
 
+	commands := map[string]func(context.Context, *chatter.Config, []string, io.Writer) error{
+		"migrate": chatter.Migrate,
+		"backup":  chatter.Backup,
+		"restore": chatter.Restore,
+		"import":  chatter.Import,
+		"admin":   chatter.Admin,
+	}
+
+	context, _ := signal.NotifyContext(
+		context.Background(),
+		syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
+
+	if len(os.Args) > 1 {
+		command, ok := commands[os.Args[1]]
+		if !ok {
+			log.Fatalf("unknown command %q, expected one of migrate, backup, restore, import or admin", os.Args[1])
+		}
+		if err := command(context, config, os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("%s: %v", os.Args[1], err)
+		}
+		return
+	}
+
 	sub, err := fs.Sub(static, "web/dist")
 	if err != nil {
 		log.Fatalf("sub static: %v", err)
@@ -33,10 +58,6 @@ func main() {
 		log.Fatalf("create static fs: %v", err)
 	}
 
-	context, _ := signal.NotifyContext(
-		context.Background(),
-		syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
-
 	app := chatter.New(context, config, staticFS)
 
 	app.Start()
@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE user_identities (
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    username TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (provider, subject),
+    FOREIGN KEY (username) REFERENCES users(username)
+);
+
+CREATE INDEX user_identities_username_idx ON user_identities (username);
+
+-- +goose Down
+DROP INDEX user_identities_username_idx;
+DROP TABLE user_identities;
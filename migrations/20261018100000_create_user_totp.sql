@@ -0,0 +1,21 @@
+-- +goose Up
+CREATE TABLE user_totp (
+    username TEXT PRIMARY KEY,
+    secret TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    last_used_step INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (username) REFERENCES users(username)
+);
+
+CREATE TABLE user_recovery_codes (
+    username TEXT NOT NULL,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMP,
+    PRIMARY KEY (username, code_hash),
+    FOREIGN KEY (username) REFERENCES users(username)
+);
+
+-- +goose Down
+DROP TABLE user_recovery_codes;
+DROP TABLE user_totp;
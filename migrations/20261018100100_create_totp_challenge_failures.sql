@@ -0,0 +1,10 @@
+-- +goose Up
+-- wrong codes entered against a TOTP challenge, which is revoked after too many
+CREATE TABLE totp_challenge_failures (
+    challenge_id TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE totp_challenge_failures;
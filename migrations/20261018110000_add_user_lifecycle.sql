@@ -0,0 +1,21 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN token_version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN deactivated_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE TABLE password_reset_tokens (
+    token_hash TEXT PRIMARY KEY,
+    username TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (username) REFERENCES users(username)
+);
+
+CREATE INDEX password_reset_tokens_username_idx ON password_reset_tokens (username);
+
+-- +goose Down
+DROP TABLE password_reset_tokens;
+ALTER TABLE users DROP COLUMN deleted_at;
+ALTER TABLE users DROP COLUMN deactivated_at;
+ALTER TABLE users DROP COLUMN token_version;
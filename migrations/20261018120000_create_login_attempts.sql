@@ -0,0 +1,24 @@
+-- +goose Up
+CREATE TABLE login_attempts (
+    scope TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP NOT NULL,
+    blocked_until TIMESTAMP,
+    PRIMARY KEY (scope, subject)
+);
+
+CREATE TABLE login_lockouts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    scope TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    failures INTEGER NOT NULL,
+    locked_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP NOT NULL
+);
+
+CREATE INDEX login_lockouts_locked_at_idx ON login_lockouts (locked_at);
+
+-- +goose Down
+DROP TABLE login_lockouts;
+DROP TABLE login_attempts;
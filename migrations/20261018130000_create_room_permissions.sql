@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE room_permissions (
+    room_id TEXT NOT NULL,
+    role TEXT NOT NULL,
+    permission TEXT NOT NULL,
+    allowed BOOLEAN NOT NULL,
+    PRIMARY KEY (room_id, role, permission),
+    FOREIGN KEY (room_id) REFERENCES rooms(id)
+);
+
+-- +goose Down
+DROP TABLE room_permissions;
@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE rooms ADD COLUMN topic TEXT NOT NULL DEFAULT '';
+ALTER TABLE rooms ADD COLUMN description TEXT NOT NULL DEFAULT '';
+ALTER TABLE rooms ADD COLUMN avatar TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE rooms DROP COLUMN avatar;
+ALTER TABLE rooms DROP COLUMN description;
+ALTER TABLE rooms DROP COLUMN topic;
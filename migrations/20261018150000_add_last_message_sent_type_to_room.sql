@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE rooms ADD COLUMN last_message_sent_type INTEGER NOT NULL DEFAULT 1;
+
+-- +goose Down
+ALTER TABLE rooms DROP COLUMN last_message_sent_type;
@@ -0,0 +1,31 @@
+-- +goose Up
+CREATE TABLE room_invites (
+    code TEXT PRIMARY KEY,
+    room_id TEXT NOT NULL,
+    role TEXT NOT NULL,
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP,
+    max_uses INTEGER NOT NULL DEFAULT 0,
+    uses INTEGER NOT NULL DEFAULT 0,
+    revoked_at TIMESTAMP,
+    FOREIGN KEY (room_id) REFERENCES rooms(id),
+    FOREIGN KEY (created_by) REFERENCES users(username)
+);
+
+CREATE INDEX room_invites_room_id ON room_invites (room_id);
+
+CREATE TABLE room_invite_uses (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    code TEXT NOT NULL,
+    username TEXT NOT NULL,
+    used_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (code) REFERENCES room_invites(code),
+    FOREIGN KEY (username) REFERENCES users(username)
+);
+
+CREATE INDEX room_invite_uses_code ON room_invite_uses (code);
+
+-- +goose Down
+DROP TABLE room_invite_uses;
+DROP TABLE room_invites;
@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE rooms ADD COLUMN visibility TEXT NOT NULL DEFAULT 'private';
+
+CREATE INDEX rooms_visibility ON rooms (visibility);
+
+-- +goose Down
+DROP INDEX rooms_visibility;
+ALTER TABLE rooms DROP COLUMN visibility;
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE rooms ADD COLUMN archived_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE rooms DROP COLUMN archived_at;
@@ -0,0 +1,13 @@
+-- +goose Up
+ALTER TABLE room_members ADD COLUMN muted_until TIMESTAMP;
+ALTER TABLE room_members ADD COLUMN pinned BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE room_members ADD COLUMN favorite BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE room_members ADD COLUMN sort_position INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE room_members ADD COLUMN notification_level TEXT NOT NULL DEFAULT 'all';
+
+-- +goose Down
+ALTER TABLE room_members DROP COLUMN notification_level;
+ALTER TABLE room_members DROP COLUMN sort_position;
+ALTER TABLE room_members DROP COLUMN favorite;
+ALTER TABLE room_members DROP COLUMN pinned;
+ALTER TABLE room_members DROP COLUMN muted_until;
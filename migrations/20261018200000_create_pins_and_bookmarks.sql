@@ -0,0 +1,27 @@
+-- +goose Up
+CREATE TABLE room_pins (
+    room_id TEXT NOT NULL,
+    message_id INTEGER NOT NULL,
+    pinned_by TEXT NOT NULL,
+    pinned_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (room_id, message_id),
+    FOREIGN KEY (room_id) REFERENCES rooms(id),
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
+
+CREATE TABLE bookmarks (
+    username TEXT NOT NULL,
+    message_id INTEGER NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (username, message_id),
+    FOREIGN KEY (username) REFERENCES users(username),
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
+
+CREATE INDEX bookmarks_message_id ON bookmarks (message_id);
+
+-- +goose Down
+DROP INDEX bookmarks_message_id;
+DROP TABLE bookmarks;
+DROP TABLE room_pins;
@@ -0,0 +1,45 @@
+-- +goose Up
+-- last_message_read is 0 until a member has read a message, which the foreign key
+-- rejects now that foreign keys are enforced. Nothing references room_members,
+-- so the table is rebuilt without it.
+CREATE TABLE room_members_new (
+	room_id TEXT NOT NULL,
+	username TEXT NOT NULL,
+	last_message_read INTEGER NOT NULL DEFAULT 0,
+	role TEXT NOT NULL,
+	muted_until TIMESTAMP,
+	pinned BOOLEAN NOT NULL DEFAULT FALSE,
+	favorite BOOLEAN NOT NULL DEFAULT FALSE,
+	sort_position INTEGER NOT NULL DEFAULT 0,
+	notification_level TEXT NOT NULL DEFAULT 'all',
+	PRIMARY KEY (room_id, username),
+	FOREIGN KEY (room_id) REFERENCES rooms(id),
+	FOREIGN KEY (username) REFERENCES users(username)
+);
+INSERT INTO room_members_new (room_id, username, last_message_read, role,
+	muted_until, pinned, favorite, sort_position, notification_level)
+SELECT room_id, username, last_message_read, role,
+	muted_until, pinned, favorite, sort_position, notification_level FROM room_members;
+DROP TABLE room_members;
+ALTER TABLE room_members_new RENAME TO room_members;
+
+-- +goose Down
+CREATE TABLE room_members_old (
+	room_id TEXT NOT NULL,
+	username TEXT NOT NULL,
+	last_message_read INTEGER NOT NULL DEFAULT 0,
+	role TEXT NOT NULL,
+	muted_until TIMESTAMP,
+	pinned BOOLEAN NOT NULL DEFAULT FALSE,
+	favorite BOOLEAN NOT NULL DEFAULT FALSE,
+	sort_position INTEGER NOT NULL DEFAULT 0,
+	notification_level TEXT NOT NULL DEFAULT 'all',
+	PRIMARY KEY (room_id, username),
+	FOREIGN KEY (room_id) REFERENCES rooms(id),
+	FOREIGN KEY (username) REFERENCES users(username),
+	FOREIGN KEY (last_message_read) REFERENCES messages(id) ON DELETE SET NULL
+);
+INSERT INTO room_members_old SELECT room_id, username, last_message_read, role,
+	muted_until, pinned, favorite, sort_position, notification_level FROM room_members;
+DROP TABLE room_members;
+ALTER TABLE room_members_old RENAME TO room_members;
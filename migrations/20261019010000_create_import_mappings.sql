@@ -0,0 +1,13 @@
+-- +goose Up
+-- Rooms and messages imported from other chat services, so that imports can be re-run.
+-- Mappings outlive what they point to, so that purged or deleted messages are not imported again.
+CREATE TABLE import_mappings (
+    source TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    external_id TEXT NOT NULL,
+    local_id TEXT NOT NULL,
+    PRIMARY KEY (source, kind, external_id)
+);
+
+-- +goose Down
+DROP TABLE import_mappings;
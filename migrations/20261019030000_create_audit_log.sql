@@ -0,0 +1,35 @@
+-- +goose Up
+-- Entries outlive the users and rooms they name, so nothing references other tables.
+-- hash and prev_hash are empty unless the entries are chained.
+CREATE TABLE audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at TIMESTAMP NOT NULL,
+    action TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    target TEXT NOT NULL,
+    room_id TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    user_agent TEXT NOT NULL,
+    details TEXT NOT NULL,
+    prev_hash TEXT NOT NULL,
+    hash TEXT NOT NULL
+);
+
+CREATE INDEX audit_log_created_at ON audit_log (created_at);
+CREATE INDEX audit_log_actor ON audit_log (actor);
+CREATE INDEX audit_log_target ON audit_log (target);
+
+-- the log is append-only, entries are only ever deleted by retention
+-- +goose StatementBegin
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit log is append-only');
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TRIGGER audit_log_append_only;
+DROP INDEX audit_log_target;
+DROP INDEX audit_log_actor;
+DROP INDEX audit_log_created_at;
+DROP TABLE audit_log;
@@ -0,0 +1,31 @@
+// Package migrations embeds the schema migrations, so that the binary does not depend on them at runtime.
+//
+// New migrations are created with chatter migrate create and are embedded the next time the binary is built.
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+)
+
+var (
+	//go:embed *.sql
+	sqlite embed.FS
+	//go:embed postgres/*.sql
+	postgres embed.FS
+)
+
+var (
+	// SQLite are the migrations of the SQLite schema.
+	SQLite fs.FS = sqlite
+	// Postgres are the migrations of the PostgreSQL schema.
+	Postgres fs.FS = mustSub(postgres, "postgres")
+)
+
+func mustSub(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
@@ -0,0 +1,196 @@
+-- +goose Up
+-- The schema matches the SQLite migrations in the parent directory up to 20261018200000.
+-- Foreign keys are checked at commit, since the stores rewrite a deleted user's rows
+-- to their tombstone username before renaming the user.
+CREATE TABLE users (
+    username TEXT PRIMARY KEY,
+    password TEXT NOT NULL,
+    name TEXT,
+    token_version INTEGER NOT NULL DEFAULT 0,
+    deactivated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ
+);
+
+CREATE TABLE blacklists (
+    token TEXT PRIMARY KEY
+);
+
+CREATE TABLE rooms (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    last_message_sent_at TIMESTAMPTZ NOT NULL,
+    last_message_sent BIGINT NOT NULL DEFAULT 0,
+    last_message_sent_data TEXT DEFAULT '',
+    last_message_sent_type INTEGER NOT NULL DEFAULT 1,
+    topic TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    avatar TEXT NOT NULL DEFAULT '',
+    visibility TEXT NOT NULL DEFAULT 'private',
+    archived_at TIMESTAMPTZ
+);
+
+CREATE INDEX rooms_visibility ON rooms (visibility);
+
+CREATE TABLE messages (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    type INTEGER NOT NULL,
+    room_id TEXT NOT NULL REFERENCES rooms(id) DEFERRABLE INITIALLY DEFERRED,
+    sender TEXT NOT NULL REFERENCES users(username) DEFERRABLE INITIALLY DEFERRED,
+    data TEXT NOT NULL,
+    sent_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX messages_room_id_sent_at ON messages (room_id, sent_at);
+
+-- last_message_read is 0 until the member reads a message, so it does not reference messages
+CREATE TABLE room_members (
+    room_id TEXT NOT NULL REFERENCES rooms(id) DEFERRABLE INITIALLY DEFERRED,
+    username TEXT NOT NULL REFERENCES users(username) DEFERRABLE INITIALLY DEFERRED,
+    last_message_read BIGINT NOT NULL DEFAULT 0,
+    role TEXT NOT NULL,
+    muted_until TIMESTAMPTZ,
+    pinned BOOLEAN NOT NULL DEFAULT FALSE,
+    favorite BOOLEAN NOT NULL DEFAULT FALSE,
+    sort_position INTEGER NOT NULL DEFAULT 0,
+    notification_level TEXT NOT NULL DEFAULT 'all',
+    PRIMARY KEY (room_id, username)
+);
+
+CREATE INDEX room_members_username ON room_members (username);
+
+CREATE TABLE message_interactions (
+    message_id BIGINT NOT NULL REFERENCES messages(id) DEFERRABLE INITIALLY DEFERRED,
+    username TEXT NOT NULL REFERENCES users(username) DEFERRABLE INITIALLY DEFERRED,
+    read_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (message_id, username)
+);
+
+CREATE TABLE user_identities (
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    username TEXT NOT NULL REFERENCES users(username) DEFERRABLE INITIALLY DEFERRED,
+    created_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX user_identities_username_idx ON user_identities (username);
+
+CREATE TABLE user_totp (
+    username TEXT PRIMARY KEY REFERENCES users(username) DEFERRABLE INITIALLY DEFERRED,
+    secret TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE user_recovery_codes (
+    username TEXT NOT NULL REFERENCES users(username) DEFERRABLE INITIALLY DEFERRED,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMPTZ,
+    PRIMARY KEY (username, code_hash)
+);
+
+CREATE TABLE totp_challenge_failures (
+    challenge_id TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE password_reset_tokens (
+    token_hash TEXT PRIMARY KEY,
+    username TEXT NOT NULL REFERENCES users(username) DEFERRABLE INITIALLY DEFERRED,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX password_reset_tokens_username_idx ON password_reset_tokens (username);
+
+CREATE TABLE login_attempts (
+    scope TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ NOT NULL,
+    blocked_until TIMESTAMPTZ,
+    PRIMARY KEY (scope, subject)
+);
+
+CREATE TABLE login_lockouts (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    scope TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    failures INTEGER NOT NULL,
+    locked_at TIMESTAMPTZ NOT NULL,
+    locked_until TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX login_lockouts_locked_at_idx ON login_lockouts (locked_at);
+
+CREATE TABLE room_permissions (
+    room_id TEXT NOT NULL REFERENCES rooms(id) DEFERRABLE INITIALLY DEFERRED,
+    role TEXT NOT NULL,
+    permission TEXT NOT NULL,
+    allowed BOOLEAN NOT NULL,
+    PRIMARY KEY (room_id, role, permission)
+);
+
+CREATE TABLE room_invites (
+    code TEXT PRIMARY KEY,
+    room_id TEXT NOT NULL REFERENCES rooms(id) DEFERRABLE INITIALLY DEFERRED,
+    role TEXT NOT NULL,
+    created_by TEXT NOT NULL REFERENCES users(username) DEFERRABLE INITIALLY DEFERRED,
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ,
+    max_uses INTEGER NOT NULL DEFAULT 0,
+    uses INTEGER NOT NULL DEFAULT 0,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX room_invites_room_id ON room_invites (room_id);
+
+CREATE TABLE room_invite_uses (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    code TEXT NOT NULL REFERENCES room_invites(code) DEFERRABLE INITIALLY DEFERRED,
+    username TEXT NOT NULL REFERENCES users(username) DEFERRABLE INITIALLY DEFERRED,
+    used_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX room_invite_uses_code ON room_invite_uses (code);
+
+CREATE TABLE room_pins (
+    room_id TEXT NOT NULL REFERENCES rooms(id) DEFERRABLE INITIALLY DEFERRED,
+    message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+    pinned_by TEXT NOT NULL,
+    pinned_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (room_id, message_id)
+);
+
+CREATE TABLE bookmarks (
+    username TEXT NOT NULL REFERENCES users(username) DEFERRABLE INITIALLY DEFERRED,
+    message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (username, message_id)
+);
+
+CREATE INDEX bookmarks_message_id ON bookmarks (message_id);
+
+-- +goose Down
+DROP TABLE bookmarks;
+DROP TABLE room_pins;
+DROP TABLE room_invite_uses;
+DROP TABLE room_invites;
+DROP TABLE room_permissions;
+DROP TABLE login_lockouts;
+DROP TABLE login_attempts;
+DROP TABLE password_reset_tokens;
+DROP TABLE totp_challenge_failures;
+DROP TABLE user_recovery_codes;
+DROP TABLE user_totp;
+DROP TABLE user_identities;
+DROP TABLE message_interactions;
+DROP TABLE room_members;
+DROP TABLE messages;
+DROP TABLE rooms;
+DROP TABLE blacklists;
+DROP TABLE users;
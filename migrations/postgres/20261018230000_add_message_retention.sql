@@ -0,0 +1,22 @@
+-- +goose Up
+-- A room without retention_days uses the global policy.
+ALTER TABLE rooms ADD COLUMN retention_days INTEGER;
+ALTER TABLE rooms ADD COLUMN retention_messages INTEGER;
+ALTER TABLE rooms ADD COLUMN legal_hold BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Purges are kept after their room is deleted, so room_id does not reference rooms.
+CREATE TABLE retention_purges (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    room_id TEXT NOT NULL,
+    messages INTEGER NOT NULL,
+    through_id BIGINT NOT NULL,
+    purged_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX retention_purges_purged_at ON retention_purges (purged_at);
+
+-- +goose Down
+DROP TABLE retention_purges;
+ALTER TABLE rooms DROP COLUMN legal_hold;
+ALTER TABLE rooms DROP COLUMN retention_messages;
+ALTER TABLE rooms DROP COLUMN retention_days;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- Jobs are kept after their room is deleted until they expire, so room_id does not reference rooms.
+CREATE TABLE export_jobs (
+    id TEXT PRIMARY KEY,
+    room_id TEXT NOT NULL,
+    username TEXT NOT NULL,
+    format TEXT NOT NULL,
+    timezone TEXT NOT NULL,
+    status TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    file TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL,
+    completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX export_jobs_created_at ON export_jobs (created_at);
+
+-- +goose Down
+DROP TABLE export_jobs;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- Administrators manage the whole instance through /api/admin.
+ALTER TABLE users ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN is_admin;
@@ -0,0 +1,28 @@
+package router
+
+import "net/http"
+
+// ErrForbidden is the response to requests that an authorizer denies.
+var ErrForbidden = NewJsonError(http.StatusForbidden, "forbidden")
+
+// Authorizer reports whether the request is allowed.
+// It runs after authentication, so it can rely on what earlier middlewares attached to the request.
+type Authorizer func(r *http.Request) (bool, error)
+
+// Authorize returns a middleware that passes the requests that the authorizer allows on to the next handler.
+// Denied requests get ErrForbidden and errors of the authorizer are handled like errors of handlers.
+func Authorize(authorize Authorizer) Middleware {
+	return func(next http.Handler) HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) error {
+			ok, err := authorize(r)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return ErrForbidden
+			}
+			next.ServeHTTP(w, r)
+			return nil
+		}
+	}
+}
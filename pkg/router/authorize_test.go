@@ -0,0 +1,47 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorize(t *testing.T) {
+	tcs := []struct {
+		name      string
+		authorize Authorizer
+		exp       int
+	}{
+		{
+			name:      "allowed",
+			authorize: func(r *http.Request) (bool, error) { return true, nil },
+			exp:       http.StatusNoContent,
+		},
+		{
+			name:      "denied",
+			authorize: func(r *http.Request) (bool, error) { return false, nil },
+			exp:       http.StatusForbidden,
+		},
+		{
+			name:      "error",
+			authorize: func(r *http.Request) (bool, error) { return true, errors.New("store down") },
+			exp:       http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			router := New()
+			router.With(Authorize(tc.authorize)).Get("/", func(w http.ResponseWriter, r *http.Request) error {
+				w.WriteHeader(http.StatusNoContent)
+				return nil
+			})
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			assert.Equal(t, tc.exp, w.Code)
+		})
+	}
+}
@@ -118,6 +118,10 @@ func (a *Router) Put(path string, h HandlerFunc) {
 	a.Router.Put(path, a.handleWithErr(h))
 }
 
+func (a *Router) Patch(path string, h HandlerFunc) {
+	a.Router.Patch(path, a.handleWithErr(h))
+}
+
 func (a *Router) Delete(path string, h HandlerFunc) {
 	a.Router.Delete(path, a.handleWithErr(h))
 }
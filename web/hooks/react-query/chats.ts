@@ -5,6 +5,9 @@ import {
   CreateRoomResponse,
   Message,
   Room,
+  RoomPreferences,
+  UpdateRoomPayload,
+  UpdateRoomPreferencesPayload,
 } from "@/types/chat";
 import { api } from "@/lib/api";
 import useSWR from "swr";
@@ -52,6 +55,48 @@ export const useRemoveRoomMember = ({ roomID }: { roomID: string }) => {
   });
 };
 
+export const useUpdateRoom = ({ roomID }: { roomID: string }) => {
+  const queryClient = useQueryClient();
+  return useMutation({
+    mutationFn: async (arg: UpdateRoomPayload) => {
+      const res = await api.put(`/rooms/${roomID}`, arg);
+      return res.data as Room;
+    },
+    onSuccess: () => {
+      queryClient.invalidateQueries({ queryKey: ["rooms", roomID] });
+      queryClient.invalidateQueries({ queryKey: ["users", "me", "rooms"] });
+    },
+  });
+};
+
+export const useUpdateRoomPreferences = ({ roomID }: { roomID: string }) => {
+  const queryClient = useQueryClient();
+  return useMutation({
+    mutationFn: async (arg: UpdateRoomPreferencesPayload) => {
+      const res = await api.patch(`/rooms/${roomID}/me`, arg);
+      return res.data as RoomPreferences;
+    },
+    onSuccess: () => {
+      queryClient.invalidateQueries({ queryKey: ["users", "me", "rooms"] });
+    },
+  });
+};
+
+export const useUploadRoomAvatar = ({ roomID }: { roomID: string }) => {
+  const queryClient = useQueryClient();
+  return useMutation({
+    mutationFn: async (file: File) => {
+      const res = await api.put(`/rooms/${roomID}/avatar`, file, {
+        headers: { "Content-Type": file.type },
+      });
+      return res.data as Room;
+    },
+    onSuccess: () => {
+      queryClient.invalidateQueries({ queryKey: ["rooms", roomID] });
+    },
+  });
+};
+
 export const useRoom = (roomID?: string | null) => {
   return useQuery({
     queryKey: ["rooms", roomID],
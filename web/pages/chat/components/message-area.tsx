@@ -10,7 +10,10 @@ import { useMessageScroll } from "./message-scroll-context";
 import { UserRealtimeInfo } from "@/stores/user";
 import { useSession } from "@/context/session";
 import Message from "./message";
-import { Message as MessageType } from "@/types/chat";
+import {
+  Message as MessageType,
+  MessageType as MessageKind,
+} from "@/types/chat";
 
 export default function MessageArea({ roomID }: { roomID: string }) {
   const session = useSession();
@@ -139,6 +142,14 @@ export default function MessageArea({ roomID }: { roomID: string }) {
     const endOfGroup = lastFromSameSender || lastOfTheMinute;
     const shouldDisplaySender = !myMessage && endOfGroup;
 
+    if (message.type === MessageKind.System) {
+      return (
+        <div key={message.id} className="w-full my-2">
+          <Message message={message} />
+        </div>
+      );
+    }
+
     return (
       <div
         key={message.id}
@@ -1,6 +1,7 @@
 import { cn } from "@/lib/utils";
 import { Message as _Message, MessageType } from "@/types/chat";
 import { forwardRef } from "react";
+import { describeSystemMessage } from "@/utils/chat";
 
 const Message = forwardRef<
   HTMLDivElement,
@@ -21,6 +22,17 @@ const Message = forwardRef<
     );
   }
 
+  if (message.type === MessageType.System) {
+    return (
+      <div
+        ref={ref}
+        className={cn("text-center text-xs text-muted-foreground", className)}
+      >
+        {describeSystemMessage(message.data)}
+      </div>
+    );
+  }
+
   return (
     <div ref={ref} className={className}>
       Unsupported message type
@@ -2,6 +2,7 @@ import { Room } from "@/types/chat";
 import { cn } from "@/lib/utils";
 import { formatDistance } from "date-fns";
 import { useParams } from "react-router-dom";
+import { describeLastMessage } from "@/utils/chat";
 
 const RoomListItem = ({ room }: { room: Room }) => {
   const params = useParams();
@@ -18,7 +19,7 @@ const RoomListItem = ({ room }: { room: Room }) => {
       {room.last_message_sent !== 0 && (
         <div className="flex items-center gap-1">
           <p className="text-xs text-muted-foreground grow max-w-2/3 overflow-hidden text-ellipsis whitespace-nowrap">
-            {room.last_message_sent_data}
+            {describeLastMessage(room)}
           </p>
           <p className="whitespace-nowrap text-xs">
             {formatDistance(room.last_message_sent_at, new Date(), {
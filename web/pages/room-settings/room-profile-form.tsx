@@ -1,22 +1,95 @@
-import { Form, FormControl, FormItem, FormLabel } from "../../components/ui/form";
+import {
+  Form,
+  FormControl,
+  FormField,
+  FormItem,
+  FormLabel,
+  FormMessage,
+} from "../../components/ui/form";
 import { Input } from "../../components/ui/input";
-import { Room } from "@/types/chat";
+import { Textarea } from "../../components/ui/textarea";
+import { Room, UpdateRoomPayload, updateRoomSchema } from "@/types/chat";
 import { Button } from "../../components/ui/button";
 import { useForm } from "react-hook-form";
+import { zodResolver } from "@hookform/resolvers/zod";
+import Alert from "@/components/alert";
+import { useUpdateRoom, useUploadRoomAvatar } from "@/hooks/react-query/chats";
 
 export default function RoomProfileForm({ room }: { room: Room }) {
-  const form = useForm();
+  const form = useForm<UpdateRoomPayload>({
+    resolver: zodResolver(updateRoomSchema),
+    defaultValues: {
+      name: room.name,
+      topic: room.topic,
+      description: room.description,
+    },
+  });
+  const { isPending, mutate, error } = useUpdateRoom({ roomID: room.id });
+  const avatar = useUploadRoomAvatar({ roomID: room.id });
+
   return (
     <Form {...form}>
-      <form className="grid gap-4">
+      {(error || avatar.error) && (
+        <Alert message={(error || avatar.error)!.message} />
+      )}
+      <form
+        onSubmit={form.handleSubmit((data) => mutate(data))}
+        className="grid gap-4"
+      >
+        <FormField
+          name="name"
+          render={({ field }) => (
+            <FormItem>
+              <FormLabel>Name</FormLabel>
+              <FormControl>
+                <Input {...field} type="text" />
+              </FormControl>
+              <FormMessage />
+            </FormItem>
+          )}
+        />
+        <FormField
+          name="topic"
+          render={({ field }) => (
+            <FormItem>
+              <FormLabel>Topic</FormLabel>
+              <FormControl>
+                <Input {...field} type="text" />
+              </FormControl>
+              <FormMessage />
+            </FormItem>
+          )}
+        />
+        <FormField
+          name="description"
+          render={({ field }) => (
+            <FormItem>
+              <FormLabel>Description</FormLabel>
+              <FormControl>
+                <Textarea {...field} />
+              </FormControl>
+              <FormMessage />
+            </FormItem>
+          )}
+        />
         <FormItem>
-          <FormLabel>Name</FormLabel>
+          <FormLabel>Avatar</FormLabel>
           <FormControl>
-            <Input disabled={true} value={room.name} />
+            <Input
+              type="file"
+              accept="image/png,image/jpeg,image/gif,image/webp"
+              disabled={avatar.isPending}
+              onChange={(e) => {
+                const file = e.target.files?.[0];
+                if (file) avatar.mutate(file);
+              }}
+            />
           </FormControl>
         </FormItem>
         <div className="flex justify-end">
-          <Button type="submit">Save</Button>
+          <Button disabled={isPending} type="submit">
+            Save
+          </Button>
         </div>
       </form>
     </Form>
@@ -16,15 +16,52 @@ export type Room = {
   id: string;
   members: RoomMember[];
   name: string;
+  topic: string;
+  description: string;
+  avatar: string;
+  visibility: "private" | "public_listed" | "public_join";
+  archived_at: string | null;
   last_message_sent_at: string;
   last_message_sent: number;
   last_message_sent_data: string;
+  last_message_sent_type: MessageType;
+  preferences?: RoomPreferences;
 };
 
+export type NotificationLevel = "all" | "mentions" | "none";
+
+export type RoomPreferences = {
+  muted_until: string | null;
+  pinned: boolean;
+  favorite: boolean;
+  sort_position: number;
+  notification_level: NotificationLevel;
+};
+
+export type UpdateRoomPreferencesPayload = Partial<RoomPreferences>;
+
 export enum MessageType {
   Text = 1,
+  System = 2,
 }
 
+export type SystemMessageBody = {
+  key:
+    | "room_created"
+    | "room_updated"
+    | "room_archived"
+    | "room_unarchived"
+    | "member_added"
+    | "member_removed"
+    | "member_left"
+    | "member_role_changed"
+    | "ownership_transferred";
+  actor?: string;
+  target?: string;
+  role?: MemberRole;
+  fields?: Record<string, string>;
+};
+
 export type Message = {
   id: number;
   type: MessageType;
@@ -44,6 +81,14 @@ export type CreateRoomResponse = {
   id: string;
 };
 
+export const updateRoomSchema = z.object({
+  name: z.string().min(1).max(100),
+  topic: z.string().max(250),
+  description: z.string().max(2000),
+});
+
+export type UpdateRoomPayload = z.infer<typeof updateRoomSchema>;
+
 export const addRoomMemberSchema = z.object({
   username: z.string(),
   role: z.nativeEnum(MemberRole),
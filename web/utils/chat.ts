@@ -1,4 +1,9 @@
-import { Room, RoomMember } from "@/types/chat";
+import {
+  MessageType,
+  Room,
+  RoomMember,
+  SystemMessageBody,
+} from "@/types/chat";
 
 export function getMemberByUsername(
   room: Room,
@@ -6,3 +11,47 @@ export function getMemberByUsername(
 ): RoomMember | null {
   return room.members.find((member) => member.username === username) ?? null
 }
+
+// describeSystemMessage renders the JSON body of a system message as a sentence.
+export function describeSystemMessage(data: string): string {
+  let body: SystemMessageBody;
+  try {
+    body = JSON.parse(data);
+  } catch {
+    return "";
+  }
+  switch (body.key) {
+    case "room_created":
+      return `${body.actor} created the room`;
+    case "room_updated":
+      if (body.fields?.name !== undefined) {
+        return `${body.actor} renamed the room to ${body.fields.name}`;
+      }
+      return `${body.actor} updated the room ${Object.keys(body.fields ?? {}).join(", ")}`;
+    case "room_archived":
+      return `${body.actor} archived the room`;
+    case "room_unarchived":
+      return `${body.actor} restored the room`;
+    case "member_added":
+      if (body.actor === body.target) return `${body.actor} joined`;
+      return `${body.actor} added ${body.target}`;
+    case "member_removed":
+      if (!body.actor) return `${body.target} was removed`;
+      return `${body.actor} removed ${body.target}`;
+    case "member_left":
+      return `${body.actor} left`;
+    case "member_role_changed":
+      return `${body.actor} made ${body.target} ${body.role}`;
+    case "ownership_transferred":
+      return `${body.actor} made ${body.target} the owner`;
+    default:
+      return "";
+  }
+}
+
+export function describeLastMessage(room: Room): string {
+  if (room.last_message_sent_type === MessageType.System) {
+    return describeSystemMessage(room.last_message_sent_data);
+  }
+  return room.last_message_sent_data;
+}